@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func NewDecryptCmd() *cobra.Command {
+	var (
+		output         string
+		identities     []string
+		passphraseFile string
+		signature      signatureFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "decrypt <bundle-dir>",
+		Short: "Write a decrypted copy of an encrypted bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := signature.verify(cmd, args[0]); err != nil {
+				return err
+			}
+			keys, err := bundle.LoadKeys(nil, identities, passphraseFile)
+			if err != nil {
+				return err
+			}
+			manifest, err := bundle.Decrypt(args[0], output, keys)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Decrypted bundle %s to %s\n", manifest.Name(), output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Directory to write the decrypted bundle to (required)")
+	cmd.Flags().StringSliceVar(&identities, "identity", nil,
+		"X25519 private key file decrypting the bundle, may be repeated (optional)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "",
+		"File holding the passphrase of the bundle, defaults to $"+bundle.PassphraseEnv+" (optional)")
+	_ = cmd.MarkFlagRequired("output") // Error handling: ignoring error for required flag
+	signature.register(cmd)
+
+	return cmd
+}
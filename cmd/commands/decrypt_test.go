@@ -0,0 +1,36 @@
+package commands_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewDecryptCmd(t *testing.T) {
+	cmd := commands.NewDecryptCmd()
+
+	assert.Equal(t, "decrypt <bundle-dir>", cmd.Use)
+	assert.NotNil(t, cmd.RunE)
+	require.Error(t, cmd.Args(cmd, []string{}))
+
+	outputFlag := cmd.Flag("output")
+	require.NotNil(t, outputFlag)
+	assert.Equal(t, "o", outputFlag.Shorthand)
+	assert.NotNil(t, cmd.Flag("identity"))
+	assert.NotNil(t, cmd.Flag("passphrase-file"))
+}
+
+func TestDecryptCmd_MissingBundle(t *testing.T) {
+	cmd := commands.NewDecryptCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{t.TempDir(), "-o", t.TempDir()})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "manifest")
+}
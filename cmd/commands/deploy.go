@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/release"
+	"github.com/sunpia/docker-deliver/internal/target"
+)
+
+func NewDeployCmd() *cobra.Command {
+	var (
+		logLevel  string
+		detach    bool
+		wait      bool
+		timeout   time.Duration
+		stateDir  string
+		signature signatureFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "deploy <bundle-dir>",
+		Short: "Load the images of a saved bundle and start the project",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := target.Config{
+				BundleDir:   args[0],
+				LogLevel:    logLevel,
+				Detach:      detach,
+				Wait:        wait,
+				WaitTimeout: timeout,
+			}
+			ctx := cmd.Context()
+
+			client, err := target.NewClient(ctx, config)
+			if err != nil {
+				return err
+			}
+			client.Out = cmd.OutOrStdout()
+			if client.Manifest.Encryption != nil {
+				return errors.Errorf("bundle %s is encrypted, decrypt it with 'docker-deliver decrypt' before deploying",
+					client.Manifest.Name())
+			}
+			if err = signature.verify(cmd, args[0]); err != nil {
+				return err
+			}
+
+			var store *release.Store
+			if stateDir != "" {
+				if store, err = recordingStore(cmd, stateDir); err != nil {
+					return err
+				}
+			}
+
+			result, err := client.Load(ctx)
+			if err != nil {
+				return err
+			}
+			result.Write(cmd.OutOrStdout())
+
+			if err = client.Up(ctx); err != nil {
+				return err
+			}
+			if store == nil {
+				return nil
+			}
+			rel, err := store.Record(config.BundleDir)
+			if err != nil {
+				return err
+			}
+			client.Logger.Infof("Recorded release %s of project %s", rel.Name, rel.Project)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&logLevel, "loglevel", "l", "info", "Log level: debug, info, warn, error (optional)")
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run containers in the background (optional)")
+	cmd.Flags().BoolVar(&wait, "wait", false,
+		"Wait for services to be running and healthy, implies --detach (optional)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum time to wait with --wait, e.g. 5m, 0 waits forever (optional)")
+	cmd.Flags().StringVar(&stateDir, "state-dir", release.DefaultRoot,
+		"Directory recording the release history of deploys, empty disables recording (optional)")
+	signature.register(cmd)
+
+	return cmd
+}
+
+// recordingStore returns the store in stateDir recording the release of a
+// deploy. It is checked to be writable before any image is loaded, so that a
+// deploy does not fail once its services are started.
+func recordingStore(cmd *cobra.Command, stateDir string) (*release.Store, error) {
+	store, err := openStore(cmd, stateDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = store.Writable(); err != nil {
+		return nil, errors.Wrap(err, "cannot record the release, pass a writable --state-dir or an empty one")
+	}
+	return store, nil
+}
@@ -0,0 +1,95 @@
+package commands_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewDeployCmd(t *testing.T) {
+	cmd := commands.NewDeployCmd()
+
+	assert.Equal(t, "deploy <bundle-dir>", cmd.Use)
+	assert.Equal(t, "Load the images of a saved bundle and start the project", cmd.Short)
+	assert.NotNil(t, cmd.RunE)
+
+	logLevelFlag := cmd.Flag("loglevel")
+	require.NotNil(t, logLevelFlag)
+	assert.Equal(t, "l", logLevelFlag.Shorthand)
+	assert.Equal(t, "info", logLevelFlag.DefValue)
+
+	detachFlag := cmd.Flag("detach")
+	require.NotNil(t, detachFlag)
+	assert.Equal(t, "d", detachFlag.Shorthand)
+	assert.Equal(t, "false", detachFlag.DefValue)
+
+	waitFlag := cmd.Flag("wait")
+	require.NotNil(t, waitFlag)
+	assert.Equal(t, "false", waitFlag.DefValue)
+
+	timeoutFlag := cmd.Flag("timeout")
+	require.NotNil(t, timeoutFlag)
+	assert.Equal(t, "0s", timeoutFlag.DefValue)
+
+	stateDirFlag := cmd.Flag("state-dir")
+	require.NotNil(t, stateDirFlag)
+	assert.Equal(t, "/var/lib/docker-deliver", stateDirFlag.DefValue)
+}
+
+func TestDeployCmd_RequiresBundleDir(t *testing.T) {
+	cmd := commands.NewDeployCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "accepts 1 arg")
+}
+
+func TestDeployCmd_InvalidTimeout(t *testing.T) {
+	cmd := commands.NewDeployCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{t.TempDir(), "--wait", "--timeout", "soon"})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid argument")
+}
+
+func TestDeployCmd_MissingManifest(t *testing.T) {
+	cmd := commands.NewDeployCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{t.TempDir()})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error reading bundle")
+}
+
+func TestDeployCmd_RecordsWithoutWait(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"schema_version": 1, "project": "example", "tag": "v1", "services": {}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0600))
+	stateDir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.WriteFile(stateDir, nil, 0600))
+
+	// A plain deploy records its release too, so its store is checked before
+	// anything is loaded.
+	cmd := commands.NewDeployCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{dir, "--skip-signature-check", "--state-dir", stateDir})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot record the release")
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/target"
+)
+
+func NewInventoryCmd() *cobra.Command {
+	var (
+		output string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Short: "Write the images and layers present on this host, for use with save --inventory",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			inventory, err := target.Inventory(cmd.Context(), target.DefaultDependencies())
+			if err != nil {
+				return err
+			}
+			if output == "-" {
+				return inventory.Encode(cmd.OutOrStdout())
+			}
+
+			file, err := os.Create(output)
+			if err != nil {
+				return errors.Wrap(err, "failed to create inventory file")
+			}
+			if encodeErr := inventory.Encode(file); encodeErr != nil {
+				_ = file.Close()
+				return encodeErr
+			}
+			return file.Close()
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "inventory.json", "Inventory file, - writes to stdout (optional)")
+
+	return cmd
+}
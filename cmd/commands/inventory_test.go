@@ -0,0 +1,22 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewInventoryCmd(t *testing.T) {
+	cmd := commands.NewInventoryCmd()
+
+	assert.Equal(t, "inventory", cmd.Use)
+	assert.NotNil(t, cmd.RunE)
+
+	outputFlag := cmd.Flag("output")
+	require.NotNil(t, outputFlag)
+	assert.Equal(t, "o", outputFlag.Shorthand)
+	assert.Equal(t, "inventory.json", outputFlag.DefValue)
+}
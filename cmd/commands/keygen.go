@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func NewKeygenCmd() *cobra.Command {
+	var (
+		output  string
+		keyType string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a key pair for encrypting or signing bundles",
+		Long: "Generate a key pair for encrypting or signing bundles.\n\n" +
+			"The private key is written to <output>.key and the public key to <output>.pub: x25519 keys in the " +
+			"key file format of age, ed25519 keys PEM encoded. " +
+			"Bundles saved with --encrypt --recipient <output>.pub are decrypted with --identity <output>.key, " +
+			"bundles saved with --sign-key <output>.key are verified with --trusted-key <output>.pub.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			private, public, keyID, err := generateKeyPair(keyType)
+			if err != nil {
+				return err
+			}
+			if err = writeKeyPair(output, private, public); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s.key and %s.pub (%s)\n", output, output, keyID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "docker-deliver", "Path of the key files without extension (optional)")
+	cmd.Flags().StringVar(&keyType, "type", "x25519",
+		"Key type: x25519 to encrypt bundles, ed25519 to sign them (optional)")
+
+	return cmd
+}
+
+// generateKeyPair returns a new key pair of keyType, encoded for its key files,
+// and the ID of its public key.
+func generateKeyPair(keyType string) ([]byte, []byte, string, error) {
+	switch keyType {
+	case "x25519":
+		key, err := bundle.GenerateX25519Key()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		recipient := key.Recipient()
+		return bundle.MarshalIdentity(key), bundle.MarshalRecipient(recipient), bundle.KeyID(recipient), nil
+	case "ed25519":
+		key, err := bundle.GenerateEd25519Key()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		signingKey, _ := key.Public().(ed25519.PublicKey)
+		privatePEM, err := bundle.MarshalPrivateKey(key)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		publicPEM, err := bundle.MarshalPublicKey(signingKey)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return privatePEM, publicPEM, bundle.SigningKeyID(signingKey), nil
+	default:
+		return nil, nil, "", errors.Errorf("unsupported key type %q (expected x25519 or ed25519)", keyType)
+	}
+}
+
+// writeKeyPair writes the private key readable by its owner only, refusing to overwrite existing keys.
+func writeKeyPair(output string, private, public []byte) error {
+	const (
+		privatePermissions = 0600
+		publicPermissions  = 0644
+	)
+	files := []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{output + ".key", private, privatePermissions},
+		{output + ".pub", public, publicPermissions},
+	}
+	for _, file := range files {
+		f, err := os.OpenFile(file.name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.perm)
+		if err != nil {
+			return errors.Wrap(err, "failed to create key file")
+		}
+		if _, err = f.Write(file.data); err != nil {
+			_ = f.Close()
+			return errors.Wrap(err, "failed to write key file")
+		}
+		if err = f.Close(); err != nil {
+			return errors.Wrap(err, "failed to write key file")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package commands_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func TestKeygenCmd_WritesKeyPair(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "ops")
+	var out bytes.Buffer
+	cmd := commands.NewKeygenCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"-o", prefix})
+	require.NoError(t, cmd.Execute())
+
+	identity, err := bundle.ReadIdentity(prefix + ".key")
+	require.NoError(t, err)
+	recipient, err := bundle.ReadRecipient(prefix + ".pub")
+	require.NoError(t, err)
+	assert.Equal(t, identity.Recipient().String(), recipient.String())
+	assert.Contains(t, out.String(), bundle.KeyID(recipient))
+
+	info, err := os.Stat(prefix + ".key")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestKeygenCmd_RefusesToOverwrite(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "ops")
+	require.NoError(t, os.WriteFile(prefix+".key", []byte("existing"), 0600))
+
+	cmd := commands.NewKeygenCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"-o", prefix})
+	require.Error(t, cmd.Execute())
+
+	data, err := os.ReadFile(prefix + ".key")
+	require.NoError(t, err)
+	assert.Equal(t, "existing", string(data))
+}
+
+func TestKeygenCmd_SigningKey(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "release")
+	var out bytes.Buffer
+	cmd := commands.NewKeygenCmd()
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"-o", prefix, "--type", "ed25519"})
+	require.NoError(t, cmd.Execute())
+
+	key, err := bundle.ReadSigningKey(prefix + ".key")
+	require.NoError(t, err)
+	trusted, err := bundle.LoadTrustedKeys([]string{prefix + ".pub"})
+	require.NoError(t, err)
+	require.Len(t, trusted, 1)
+	assert.True(t, trusted[0].Equal(key.Public()))
+	assert.Contains(t, out.String(), bundle.SigningKeyID(trusted[0]))
+}
+
+func TestKeygenCmd_UnsupportedType(t *testing.T) {
+	cmd := commands.NewKeygenCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"-o", filepath.Join(t.TempDir(), "key"), "--type", "rsa"})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported key type")
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/target"
+)
+
+func NewLoadCmd() *cobra.Command {
+	var (
+		logLevel       string
+		identities     []string
+		passphraseFile string
+		signature      signatureFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "load <bundle-dir>",
+		Short: "Load the images of a saved bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config := target.Config{
+				BundleDir: args[0],
+				LogLevel:  logLevel,
+			}
+			ctx := cmd.Context()
+
+			client, err := target.NewClient(ctx, config)
+			if err != nil {
+				return err
+			}
+			client.Out = cmd.OutOrStdout()
+			if err = signature.verify(cmd, args[0]); err != nil {
+				return err
+			}
+			if client.Manifest.Encryption != nil {
+				if client.Keys, err = bundle.LoadKeys(nil, identities, passphraseFile); err != nil {
+					return err
+				}
+			}
+
+			result, err := client.Load(ctx)
+			if err != nil {
+				return err
+			}
+			result.Write(cmd.OutOrStdout())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&logLevel, "loglevel", "l", "info", "Log level: debug, info, warn, error (optional)")
+	cmd.Flags().StringSliceVar(&identities, "identity", nil,
+		"X25519 private key file decrypting an encrypted bundle, may be repeated (optional)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "",
+		"File holding the passphrase of an encrypted bundle, defaults to $"+bundle.PassphraseEnv+" (optional)")
+	signature.register(cmd)
+
+	return cmd
+}
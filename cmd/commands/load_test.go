@@ -0,0 +1,64 @@
+package commands_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewLoadCmd(t *testing.T) {
+	cmd := commands.NewLoadCmd()
+
+	assert.Equal(t, "load <bundle-dir>", cmd.Use)
+	assert.Equal(t, "Load the images of a saved bundle", cmd.Short)
+	assert.NotNil(t, cmd.RunE)
+
+	logLevelFlag := cmd.Flag("loglevel")
+	require.NotNil(t, logLevelFlag)
+	assert.Equal(t, "l", logLevelFlag.Shorthand)
+	assert.Equal(t, "info", logLevelFlag.DefValue)
+}
+
+func TestLoadCmd_RequiresBundleDir(t *testing.T) {
+	cmd := commands.NewLoadCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "accepts 1 arg")
+}
+
+func TestLoadCmd_MissingManifest(t *testing.T) {
+	cmd := commands.NewLoadCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{t.TempDir()})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error reading bundle")
+}
+
+func TestLoadCmd_RefusesUnsignedBundle(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"schema_version": 1, "project": "example", "services": {}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte(manifest), 0600))
+
+	cmd := commands.NewLoadCmd()
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{dir})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bundle is not signed")
+	assert.Contains(t, err.Error(), "--skip-signature-check")
+}
@@ -0,0 +1,54 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/registry"
+)
+
+func NewPushBundleCmd() *cobra.Command {
+	const argCount = 2 // Bundle directory and registry
+
+	var (
+		logLevel  string
+		plainHTTP bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "push-bundle <bundle-dir> <registry>",
+		Short: "Push the images of a saved bundle to a registry without a Docker daemon",
+		Long: "Push the images of a saved bundle to a registry without a Docker daemon.\n\n" +
+			"Images are pushed under <registry>, a host with an optional path such as " +
+			"registry.site.local:5000/team, keeping their repository path and tag. Credentials " +
+			"stored by `docker login` are used when the registry asks for them.",
+		Args: cobra.ExactArgs(argCount),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			level, err := logrus.ParseLevel(logLevel)
+			if err != nil {
+				return err
+			}
+			logger := logrus.New()
+			logger.SetLevel(level)
+
+			pusher, err := registry.NewPusher(args[1], plainHTTP, registry.DockerCredentials, logger)
+			if err != nil {
+				return err
+			}
+			pushed, err := pusher.PushBundle(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			for _, image := range pushed {
+				fmt.Fprintf(cmd.OutOrStdout(), "Pushed   %s@%s\n", image.Target, image.Digest)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&logLevel, "loglevel", "l", "info", "Log level: debug, info, warn, error (optional)")
+	cmd.Flags().BoolVar(&plainHTTP, "insecure", false, "Talk to the registry over plain HTTP (optional)")
+
+	return cmd
+}
@@ -0,0 +1,38 @@
+package commands_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewPushBundleCmd(t *testing.T) {
+	cmd := commands.NewPushBundleCmd()
+
+	assert.Equal(t, "push-bundle <bundle-dir> <registry>", cmd.Use)
+	assert.NotNil(t, cmd.RunE)
+	require.Error(t, cmd.Args(cmd, []string{"bundle"}))
+
+	logLevelFlag := cmd.Flag("loglevel")
+	require.NotNil(t, logLevelFlag)
+	assert.Equal(t, "l", logLevelFlag.Shorthand)
+	assert.Equal(t, "info", logLevelFlag.DefValue)
+
+	insecureFlag := cmd.Flag("insecure")
+	require.NotNil(t, insecureFlag)
+	assert.Equal(t, "false", insecureFlag.DefValue)
+}
+
+func TestPushBundleCmd_MissingBundle(t *testing.T) {
+	cmd := commands.NewPushBundleCmd()
+	cmd.SetArgs([]string{t.TempDir(), "registry.site.local:5000"})
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error reading bundle")
+}
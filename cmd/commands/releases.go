@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/release"
+)
+
+func NewReleasesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "releases",
+		Short: "Inspect the release history of this host",
+	}
+	cmd.AddCommand(newReleasesListCmd())
+	return cmd
+}
+
+func newReleasesListCmd() *cobra.Command {
+	var (
+		project  string
+		stateDir string
+	)
+
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the deployed releases, the current one marked with *",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openStore(cmd, stateDir)
+			if err != nil {
+				return err
+			}
+			name, err := resolveProject(store, project)
+			if err != nil {
+				return err
+			}
+			releases, err := store.List(name)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "  RELEASE\tTAG\tDEPLOYED")
+			for _, rel := range releases {
+				marker := " "
+				if rel.Current {
+					marker = "*"
+				}
+				fmt.Fprintf(w, "%s %s\t%s\t%s\n", marker, rel.Name, rel.Tag, rel.DeployedAt.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+
+	addReleaseFlags(cmd, &project, &stateDir)
+
+	return cmd
+}
+
+func addReleaseFlags(cmd *cobra.Command, project, stateDir *string) {
+	cmd.Flags().StringVarP(project, "project", "p", "",
+		"Project name, required when releases of several projects are recorded (optional)")
+	cmd.Flags().StringVar(stateDir, "state-dir", release.DefaultRoot, "Directory recording the release history (optional)")
+}
+
+// openStore returns the release store in stateDir. When --state-dir is left at
+// release.DefaultRoot and this user cannot write it, the store in
+// release.UserRoot is used instead, so that operators without root record and
+// find their releases in their own store.
+func openStore(cmd *cobra.Command, stateDir string) (*release.Store, error) {
+	store := release.NewStore(stateDir)
+	if cmd.Flags().Changed("state-dir") || store.Writable() == nil {
+		return store, nil
+	}
+	root, err := release.UserRoot()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "%s is not writable, using the release history in %s\n", stateDir, root)
+	return release.NewStore(root), nil
+}
+
+// resolveProject returns project, or the only project recorded in store when project is empty.
+func resolveProject(store *release.Store, project string) (string, error) {
+	if project != "" {
+		return project, nil
+	}
+	projects, err := store.Projects()
+	if err != nil {
+		return "", err
+	}
+	switch len(projects) {
+	case 0:
+		return "", errors.Errorf("no releases recorded in %s", store.Root)
+	case 1:
+		return projects[0], nil
+	default:
+		return "", errors.Errorf("releases of several projects are recorded (%v), select one with --project", projects)
+	}
+}
@@ -0,0 +1,71 @@
+package commands_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/release"
+)
+
+// recordRelease records a minimal bundle of project and tag in the store at stateDir.
+func recordRelease(t *testing.T, stateDir, project, tag string) {
+	t.Helper()
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, (&bundle.Manifest{SchemaVersion: bundle.SchemaVersion, Project: project, Tag: tag}).Encode(file))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ComposeFile), []byte(tag), 0o600))
+	_, err = release.NewStore(stateDir).Record(dir)
+	require.NoError(t, err)
+}
+
+func TestReleasesListCmd(t *testing.T) {
+	stateDir := t.TempDir()
+	recordRelease(t, stateDir, "example", "v1")
+	recordRelease(t, stateDir, "example", "v2")
+
+	var out bytes.Buffer
+	cmd := commands.NewReleasesCmd()
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"list", "--state-dir", stateDir})
+
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, out.String(), "RELEASE")
+	assert.Contains(t, out.String(), "  v1 ")
+	assert.Contains(t, out.String(), "* v2 ")
+}
+
+func TestReleasesListCmd_SeveralProjects(t *testing.T) {
+	stateDir := t.TempDir()
+	recordRelease(t, stateDir, "web", "v1")
+	recordRelease(t, stateDir, "api", "v1")
+
+	cmd := commands.NewReleasesCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"list", "--state-dir", stateDir})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--project")
+}
+
+func TestReleasesListCmd_Empty(t *testing.T) {
+	cmd := commands.NewReleasesCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"list", "--state-dir", t.TempDir()})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no releases recorded")
+}
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/release"
+	"github.com/sunpia/docker-deliver/internal/target"
+)
+
+func NewRollbackCmd() *cobra.Command {
+	var (
+		project  string
+		stateDir string
+		to       string
+		logLevel string
+		wait     bool
+		timeout  time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Restart the project from a previously deployed release",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			store, err := openStore(cmd, stateDir)
+			if err != nil {
+				return err
+			}
+			name, err := resolveProject(store, project)
+			if err != nil {
+				return err
+			}
+			var rel *release.Release
+			if to != "" {
+				rel, err = store.Get(name, to)
+			} else {
+				rel, err = store.Previous(name)
+			}
+			if err != nil {
+				return err
+			}
+
+			config := target.Config{
+				BundleDir:   rel.Dir,
+				LogLevel:    logLevel,
+				Detach:      true,
+				Wait:        wait,
+				WaitTimeout: timeout,
+			}
+			ctx := cmd.Context()
+
+			client, err := target.NewClient(ctx, config)
+			if err != nil {
+				return err
+			}
+			client.Out = cmd.OutOrStdout()
+
+			missing, err := client.MissingImages(ctx)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return errors.Errorf("images of release %s are no longer present, deploy its bundle instead: %s",
+					rel.Name, strings.Join(missing, ", "))
+			}
+
+			if upErr := client.Up(ctx); upErr != nil {
+				return upErr
+			}
+			if setErr := store.SetCurrent(name, rel.Name); setErr != nil {
+				return setErr
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Rolled back %s to release %s\n", name, rel.Name)
+			return nil
+		},
+	}
+
+	addReleaseFlags(cmd, &project, &stateDir)
+	cmd.Flags().StringVar(&to, "to", "", "Release to roll back to (default: the release deployed before the current one)")
+	cmd.Flags().StringVarP(&logLevel, "loglevel", "l", "info", "Log level: debug, info, warn, error (optional)")
+	cmd.Flags().BoolVar(&wait, "wait", false, "Wait for services to be running and healthy (optional)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "Maximum time to wait with --wait, e.g. 5m, 0 waits forever (optional)")
+
+	return cmd
+}
@@ -0,0 +1,59 @@
+package commands_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewRollbackCmd(t *testing.T) {
+	cmd := commands.NewRollbackCmd()
+
+	assert.Equal(t, "rollback", cmd.Use)
+	assert.NotNil(t, cmd.RunE)
+
+	for name, def := range map[string]string{
+		"to":        "",
+		"project":   "",
+		"state-dir": "/var/lib/docker-deliver",
+		"loglevel":  "info",
+		"wait":      "false",
+		"timeout":   "0s",
+	} {
+		flag := cmd.Flag(name)
+		require.NotNil(t, flag, name)
+		assert.Equal(t, def, flag.DefValue, name)
+	}
+}
+
+func TestRollbackCmd_OldestRelease(t *testing.T) {
+	stateDir := t.TempDir()
+	recordRelease(t, stateDir, "example", "v1")
+
+	cmd := commands.NewRollbackCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--state-dir", stateDir})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "oldest release")
+}
+
+func TestRollbackCmd_UnknownRelease(t *testing.T) {
+	stateDir := t.TempDir()
+	recordRelease(t, stateDir, "example", "v1")
+
+	cmd := commands.NewRollbackCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--state-dir", stateDir, "--to", "v0"})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `release "v0" of project "example" not found`)
+}
@@ -1,29 +1,92 @@
 package commands
 
 import (
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
 	Compose "github.com/sunpia/docker-deliver/internal/compose"
 )
 
-func NewSaveCmd() *cobra.Command {
+func NewSaveCmd() *cobra.Command { //nolint:funlen // registers every save option
 	var (
 		tag               string
 		logLevel          string
 		outputDir         string
 		dockerComposePath []string
 		workDir           string
+		compress          string
+		compressLevel     int
+		splitSize         string
+		base              string
+		inventory         string
+		pull              string
+		pinImages         string
+		tagTemplate       string
+		tagAll            bool
+		registry          string
+		registryPrefix    string
+		toRegistry        string
+		format            string
+		layerCache        string
+		perService        bool
+		skipCombined      bool
+		installer         bool
+		encrypt           bool
+		recipients        []string
+		passphraseFile    string
+		signKey           string
+		sbom              string
+		provenance        bool
+		scanDB            string
+		scanFailOn        string
+		secrets           string
+		secretsIgnore     []string
+		policy            string
 	)
 
 	cmd := &cobra.Command{
 		Use:   "save",
 		Short: "Save docker compose project",
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			splitBytes, err := parseSplitSize(splitSize)
+			if err != nil {
+				return err
+			}
 			config := Compose.Config{
 				DockerComposePath: dockerComposePath,
 				WorkDir:           workDir,
 				OutputDir:         outputDir,
 				Tag:               tag,
 				LogLevel:          logLevel,
+				Compress:          compress,
+				CompressLevel:     compressLevel,
+				SplitSize:         splitBytes,
+				Base:              base,
+				Inventory:         inventory,
+				Pull:              pull,
+				PinImages:         pinImages,
+				TagTemplate:       tagTemplate,
+				TagAll:            tagAll,
+				Registry:          registry,
+				RegistryPrefix:    registryPrefix,
+				ToRegistry:        toRegistry,
+				Format:            format,
+				LayerCache:        layerCache,
+				PerService:        perService,
+				SkipCombined:      skipCombined,
+				Installer:         installer,
+				Encrypt:           encrypt,
+				Recipients:        recipients,
+				PassphraseFile:    passphraseFile,
+				SignKey:           signKey,
+				SBOM:              sbom,
+				Provenance:        provenance,
+				ScanDB:            scanDB,
+				ScanFailOn:        scanFailOn,
+				Secrets:           secrets,
+				SecretsIgnore:     secretsIgnore,
+				Policy:            policy,
 			}
 			ctx := cmd.Context()
 
@@ -43,7 +106,76 @@ func NewSaveCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&workDir, "workdir", "w", "", "Working directory (optional)")
 	cmd.Flags().StringVarP(&tag, "tag", "t", "latest", "Default tag for images (optional)")
 	cmd.Flags().StringVarP(&logLevel, "loglevel", "l", "info", "Log level: debug, info, warn, error (optional)")
+	cmd.Flags().StringVar(&tagTemplate, "tag-template", "",
+		"Go template naming service images, e.g. {{.Registry}}/{{.Project}}/{{.Service}}:{{.GitTag}} (optional)")
+	cmd.Flags().BoolVar(&tagAll, "tag-all", false,
+		"Apply --tag-template to services that already declare an image (optional)")
+	cmd.Flags().StringVar(&registry, "registry", "", "Registry available to --tag-template as {{.Registry}} (optional)")
+	cmd.Flags().StringVar(&registryPrefix, "registry-prefix", "",
+		"Move every image under this registry, e.g. registry.site.local:5000 (optional)")
+	cmd.Flags().StringVar(&toRegistry, "to-registry", "",
+		"Push images to this registry instead of writing an image archive (optional)")
+	cmd.Flags().StringVar(&format, "format", "docker-archive",
+		"Image archive format: docker-archive, oci (OCI image layout directory) or oci-archive (optional)")
+	cmd.Flags().StringVar(&layerCache, "layer-cache", "",
+		"Directory caching image blobs so repeated saves skip exporting unchanged images (optional)")
+	cmd.Flags().BoolVar(&perService, "per-service", false,
+		"Also write an image archive per service to images/<service>.tar (optional)")
+	cmd.Flags().BoolVar(&skipCombined, "skip-combined", false,
+		"With --per-service, do not write the combined image archive (optional)")
+	cmd.Flags().BoolVar(&installer, "installer", false,
+		"Also write the bundle as a self-extracting installer, install.sh (optional)")
+	cmd.Flags().BoolVar(&encrypt, "encrypt", false,
+		"Encrypt the image archives and compose file of the bundle (optional)")
+	cmd.Flags().StringSliceVar(&recipients, "recipient", nil,
+		"X25519 public key file to encrypt the bundle to, may be repeated (optional)")
+	cmd.Flags().StringVar(&passphraseFile, "passphrase-file", "",
+		"File holding a passphrase to encrypt the bundle with, defaults to $"+bundle.PassphraseEnv+" (optional)")
+	cmd.Flags().StringVar(&signKey, "sign-key", "",
+		"ed25519 private key file signing the bundle manifest (optional)")
+	cmd.Flags().StringVar(&sbom, "sbom", "",
+		"Write an SBOM of each service image to sbom/: spdx or cyclonedx (optional)")
+	cmd.Flags().BoolVar(&provenance, "provenance", false,
+		"Write a provenance attestation of every built service to provenance/, signed with --sign-key (optional)")
+	cmd.Flags().StringVar(&scanDB, "scan-db", "",
+		"OSV advisory database, a JSON file, directory or zip, to scan the image packages against (optional)")
+	cmd.Flags().StringVar(&scanFailOn, "scan-fail-on", "high",
+		"With --scan-db, fail on findings of this severity or higher: unknown, low, medium, high, critical, none (optional)")
+	cmd.Flags().StringVar(&secrets, "secrets", Compose.SecretsFail,
+		"Likely secrets in the image layers: fail, warn or off to skip the scan (optional)")
+	cmd.Flags().StringSliceVar(&secretsIgnore, "secrets-ignore", nil,
+		"Image path pattern left out of the secret scan, in .dockerignore syntax, may be repeated (optional)")
+	cmd.Flags().StringVar(&policy, "policy", "",
+		"YAML policy file the services must follow, violations fail the save (optional)")
+	cmd.Flags().StringVar(&compress, "compress", "none", "Image archive compression: gzip, zstd, none (optional)")
+	cmd.Flags().IntVar(&compressLevel, "compress-level", 0, "Compression level, 0 selects the default (optional)")
+	cmd.Flags().StringVar(&splitSize, "split-size", "",
+		"Split the image archive into parts of at most this size, e.g. 3900M or 4G (optional)")
+	cmd.Flags().StringVar(&base, "base", "",
+		"manifest.json of a previous delivery; layers it already holds are left out of the image archive (optional)")
+	cmd.Flags().StringVar(&inventory, "inventory", "",
+		"Inventory written by 'docker-deliver inventory' on the target; layers it holds are left out (optional)")
+	cmd.Flags().StringVar(&pull, "pull", "missing",
+		"Pull images of services without a build section: missing, always, never (optional)")
+	cmd.Flags().StringVar(&pinImages, "pin-images", "",
+		"Pin images in the generated compose file: digest (name:tag@sha256:..., with --to-registry) or id (optional)")
 	_ = cmd.MarkFlagRequired("file") // Error handling: ignoring error for required flag
 
 	return cmd
 }
+
+// parseSplitSize parses a human readable size using binary units (1K = 1024 bytes).
+// An empty size disables splitting.
+func parseSplitSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	parsed, err := units.RAMInBytes(size)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid --split-size")
+	}
+	if parsed <= 0 {
+		return 0, errors.Errorf("invalid --split-size %q: must be greater than zero", size)
+	}
+	return parsed, nil
+}
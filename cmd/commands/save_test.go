@@ -345,3 +345,107 @@ func TestSaveCmd_HelpText(t *testing.T) {
 		}
 	}
 }
+
+// TestSaveCmd_OptionFlags checks the default and a parsed value of every
+// option of the save command.
+func TestSaveCmd_OptionFlags(t *testing.T) {
+	tests := []struct {
+		flag     string
+		def      string
+		args     []string
+		expected string
+	}{
+		{flag: "compress", def: "none", args: []string{"--compress", "zstd"}, expected: "zstd"},
+		{flag: "compress-level", def: "0", args: []string{"--compress-level", "19"}, expected: "19"},
+		{flag: "split-size", args: []string{"--split-size", "3900M"}, expected: "3900M"},
+		{flag: "base", args: []string{"--base", "previous/manifest.json"}, expected: "previous/manifest.json"},
+		{flag: "inventory", args: []string{"--inventory", "site-a.json"}, expected: "site-a.json"},
+		{flag: "pull", def: "missing", args: []string{"--pull=never"}, expected: "never"},
+		{flag: "pin-images", args: []string{"--pin-images", "id"}, expected: "id"},
+		{
+			flag:     "tag-template",
+			args:     []string{"--tag-template", "{{.Registry}}/{{.Project}}/{{.Service}}:{{.GitTag}}"},
+			expected: "{{.Registry}}/{{.Project}}/{{.Service}}:{{.GitTag}}",
+		},
+		{flag: "tag-all", def: "false", args: []string{"--tag-all"}, expected: "true"},
+		{flag: "registry", args: []string{"--registry", "registry.local"}, expected: "registry.local"},
+		{
+			flag:     "registry-prefix",
+			args:     []string{"--registry-prefix", "registry.site.local:5000"},
+			expected: "registry.site.local:5000",
+		},
+		{
+			flag:     "to-registry",
+			args:     []string{"--to-registry", "registry.site.local:5000"},
+			expected: "registry.site.local:5000",
+		},
+		{flag: "format", def: "docker-archive", args: []string{"--format", "oci"}, expected: "oci"},
+		{
+			flag:     "layer-cache",
+			args:     []string{"--layer-cache", "/var/cache/docker-deliver"},
+			expected: "/var/cache/docker-deliver",
+		},
+		{flag: "per-service", def: "false", args: []string{"--per-service"}, expected: "true"},
+		{flag: "skip-combined", def: "false", args: []string{"--skip-combined"}, expected: "true"},
+		{flag: "installer", def: "false", args: []string{"--installer"}, expected: "true"},
+		{flag: "encrypt", def: "false", args: []string{"--encrypt"}, expected: "true"},
+		{
+			flag:     "recipient",
+			def:      "[]",
+			args:     []string{"--recipient", "ops.pub", "--recipient", "site.pub"},
+			expected: "[ops.pub,site.pub]",
+		},
+		{flag: "passphrase-file", args: []string{"--passphrase-file", "pass.txt"}, expected: "pass.txt"},
+		{flag: "sign-key", args: []string{"--sign-key", "release.key"}, expected: "release.key"},
+		{flag: "sbom", args: []string{"--sbom", "cyclonedx"}, expected: "cyclonedx"},
+		{flag: "provenance", def: "false", args: []string{"--provenance"}, expected: "true"},
+		{flag: "scan-db", args: []string{"--scan-db", "osv/all.zip"}, expected: "osv/all.zip"},
+		{flag: "scan-fail-on", def: "high", args: []string{"--scan-fail-on", "critical"}, expected: "critical"},
+		{flag: "secrets", def: "fail", args: []string{"--secrets", "warn"}, expected: "warn"},
+		{
+			flag:     "secrets-ignore",
+			def:      "[]",
+			args:     []string{"--secrets-ignore", "etc/ssh/ssh_host_*", "--secrets-ignore", "**/tests"},
+			expected: "[etc/ssh/ssh_host_*,**/tests]",
+		},
+		{flag: "policy", args: []string{"--policy", "policy.yaml"}, expected: "policy.yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			cmd := SaveCmd.NewSaveCmd()
+
+			flag := cmd.Flag(tt.flag)
+			if flag == nil {
+				t.Fatalf("Expected '%s' flag to exist", tt.flag)
+			}
+			if flag.DefValue != tt.def {
+				t.Errorf("Expected default %s to be '%s', got '%s'", tt.flag, tt.def, flag.DefValue)
+			}
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+			if flag.Value.String() != tt.expected {
+				t.Errorf("Expected %s to be '%s', got '%s'", tt.flag, tt.expected, flag.Value.String())
+			}
+		})
+	}
+}
+
+func TestSaveCmd_InvalidSplitSize(t *testing.T) {
+	for _, size := range []string{"bogus", "0", "-1G"} {
+		cmd := SaveCmd.NewSaveCmd()
+
+		var stderr, stdout bytes.Buffer
+		cmd.SetErr(&stderr)
+		cmd.SetOut(&stdout)
+		cmd.SetArgs([]string{"--file", "docker-compose.yml", "--split-size", size})
+
+		err := cmd.Execute()
+		if err == nil {
+			t.Errorf("Expected error for split-size %q", size)
+		} else if !strings.Contains(err.Error(), "split-size") {
+			t.Errorf("Expected error about split-size for %q, got: %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// signatureFlags selects the keys a bundle must be signed with before it is installed.
+type signatureFlags struct {
+	trustedKeys []string
+	skip        bool
+}
+
+func (f *signatureFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&f.trustedKeys, "trusted-key", nil,
+		"ed25519 public key file the bundle must be signed with, may be repeated (optional)")
+	cmd.Flags().BoolVar(&f.skip, "skip-signature-check", false,
+		"Accept unsigned bundles or bundles whose signature does not verify (optional)")
+}
+
+// verify refuses the bundle in dir unless it is signed by a trusted key and
+// every file matches the signed manifest, or the check is skipped.
+func (f *signatureFlags) verify(cmd *cobra.Command, dir string) error {
+	if f.skip {
+		fmt.Fprintln(cmd.ErrOrStderr(), "Warning: the signature of the bundle is not checked")
+		return nil
+	}
+	trusted, err := bundle.LoadTrustedKeys(f.trustedKeys)
+	if err != nil {
+		return err
+	}
+	signature, err := bundle.VerifySignature(dir, trusted)
+	if err != nil {
+		return errors.Wrap(err, "refusing bundle, pass --trusted-key or --skip-signature-check")
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Signature OK, signed by %s\n", signature.KeyID)
+	return nil
+}
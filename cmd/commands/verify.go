@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func NewVerifyCmd() *cobra.Command {
+	var trustedKeys []string
+
+	cmd := &cobra.Command{
+		Use:          "verify <bundle-dir>",
+		Short:        "Verify the integrity of a saved bundle",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := bundle.Verify(args[0])
+			if err != nil {
+				return err
+			}
+			report.Write(cmd.OutOrStdout())
+			if !report.OK() {
+				return errors.New("bundle verification failed")
+			}
+			if len(trustedKeys) == 0 {
+				return nil
+			}
+			trusted, err := bundle.LoadTrustedKeys(trustedKeys)
+			if err != nil {
+				return err
+			}
+			signature, err := bundle.CheckSignature(args[0], trusted)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%-8s %s: signed by %s\n", bundle.StatusOK, bundle.SignatureFile, signature.KeyID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&trustedKeys, "trusted-key", nil,
+		"ed25519 public key file the bundle must be signed with, may be repeated (optional)")
+
+	return cmd
+}
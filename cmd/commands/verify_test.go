@@ -0,0 +1,44 @@
+package commands_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/cmd/commands"
+)
+
+func TestNewVerifyCmd(t *testing.T) {
+	cmd := commands.NewVerifyCmd()
+
+	assert.Equal(t, "verify <bundle-dir>", cmd.Use)
+	assert.Equal(t, "Verify the integrity of a saved bundle", cmd.Short)
+	assert.NotNil(t, cmd.RunE)
+}
+
+func TestVerifyCmd_RequiresBundleDir(t *testing.T) {
+	cmd := commands.NewVerifyCmd()
+
+	var stderr bytes.Buffer
+	cmd.SetErr(&stderr)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "accepts 1 arg")
+}
+
+func TestVerifyCmd_MissingManifest(t *testing.T) {
+	cmd := commands.NewVerifyCmd()
+
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{t.TempDir()})
+
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open manifest")
+}
@@ -11,6 +11,15 @@ func newRootCmd() *cobra.Command {
 		Short: "Docker-deliver is a deployment tool",
 	}
 	rootCmd.AddCommand(commands.NewSaveCmd())
+	rootCmd.AddCommand(commands.NewVerifyCmd())
+	rootCmd.AddCommand(commands.NewLoadCmd())
+	rootCmd.AddCommand(commands.NewDeployCmd())
+	rootCmd.AddCommand(commands.NewReleasesCmd())
+	rootCmd.AddCommand(commands.NewRollbackCmd())
+	rootCmd.AddCommand(commands.NewInventoryCmd())
+	rootCmd.AddCommand(commands.NewPushBundleCmd())
+	rootCmd.AddCommand(commands.NewDecryptCmd())
+	rootCmd.AddCommand(commands.NewKeygenCmd())
 	rootCmd.AddCommand(commands.NewMCPCmd())
 	return rootCmd
 }
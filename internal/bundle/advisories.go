@@ -0,0 +1,361 @@
+package bundle
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Advisory is a vulnerability advisory in the OSV format.
+type Advisory struct {
+	ID               string             `json:"id"`
+	Aliases          []string           `json:"aliases,omitempty"`
+	Summary          string             `json:"summary,omitempty"`
+	Modified         time.Time          `json:"modified"`
+	Withdrawn        *time.Time         `json:"withdrawn,omitempty"`
+	Affected         []Affected         `json:"affected"`
+	Severity         []AdvisorySeverity `json:"severity,omitempty"`
+	DatabaseSpecific json.RawMessage    `json:"database_specific,omitempty"`
+}
+
+// Affected lists the versions of a package an advisory applies to.
+type Affected struct {
+	Package           AffectedPackage    `json:"package"`
+	Ranges            []AffectedRange    `json:"ranges,omitempty"`
+	Versions          []string           `json:"versions,omitempty"`
+	Severity          []AdvisorySeverity `json:"severity,omitempty"`
+	EcosystemSpecific json.RawMessage    `json:"ecosystem_specific,omitempty"`
+	DatabaseSpecific  json.RawMessage    `json:"database_specific,omitempty"`
+}
+
+// AffectedPackage names a package of an ecosystem, such as "Debian:12" or "PyPI".
+type AffectedPackage struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	PURL      string `json:"purl,omitempty"`
+}
+
+// AffectedRange is a range of affected versions, described by the events
+// introducing and fixing the vulnerability.
+type AffectedRange struct {
+	Type   string       `json:"type"`
+	Events []RangeEvent `json:"events"`
+}
+
+// RangeEvent is a version at which a range starts or stops being affected.
+type RangeEvent struct {
+	Introduced   string `json:"introduced,omitempty"`
+	Fixed        string `json:"fixed,omitempty"`
+	LastAffected string `json:"last_affected,omitempty"`
+}
+
+// AdvisorySeverity is a severity score, a CVSS vector or a textual rating depending on Type.
+type AdvisorySeverity struct {
+	Type  string `json:"type"`
+	Score string `json:"score"`
+}
+
+// AdvisoryDB is a local database of advisories, indexed by ecosystem and package name.
+type AdvisoryDB struct {
+	Name       string    // Base name of the file or directory the advisories were read from
+	Modified   time.Time // Latest modification of an advisory
+	advisories int
+	index      map[string][]advisoryEntry
+	severities map[string]Severity // Severities of the advisories and their aliases, when known
+}
+
+type advisoryEntry struct {
+	advisory *Advisory
+	affected *Affected
+}
+
+// ReadAdvisories reads an advisory database: a JSON file holding an OSV
+// advisory or an array of them, a directory of such files or a zip archive of
+// them, as the OSV project exports per ecosystem. Withdrawn advisories are
+// left out.
+func ReadAdvisories(path string) (*AdvisoryDB, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read advisory database")
+	}
+	db := &AdvisoryDB{
+		Name:       filepath.Base(path),
+		index:      make(map[string][]advisoryEntry),
+		severities: make(map[string]Severity),
+	}
+	switch {
+	case info.IsDir():
+		err = db.readDirectory(path)
+	case strings.EqualFold(filepath.Ext(path), ".zip"):
+		err = db.readZip(path)
+	default:
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			err = db.add(data)
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read advisory database %s", path)
+	}
+	return db, nil
+}
+
+// readDirectory adds the advisories of the JSON files under dir.
+func (db *AdvisoryDB) readDirectory(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".json") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(db.add(data), path)
+	})
+}
+
+// readZip adds the advisories of the JSON files of a zip archive.
+func (db *AdvisoryDB) readZip(path string) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !strings.EqualFold(filepath.Ext(file.Name), ".json") {
+			continue
+		}
+		r, openErr := file.Open()
+		if openErr != nil {
+			return openErr
+		}
+		data, readErr := io.ReadAll(r)
+		_ = r.Close()
+		if readErr != nil {
+			return errors.Wrap(readErr, file.Name)
+		}
+		if addErr := db.add(data); addErr != nil {
+			return errors.Wrap(addErr, file.Name)
+		}
+	}
+	return nil
+}
+
+// add indexes the advisory or the array of advisories encoded in data.
+func (db *AdvisoryDB) add(data []byte) error {
+	var advisories []*Advisory
+	if data = bytes.TrimSpace(data); bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &advisories); err != nil {
+			return errors.Wrap(err, "invalid advisories")
+		}
+	} else {
+		var advisory Advisory
+		if err := json.Unmarshal(data, &advisory); err != nil {
+			return errors.Wrap(err, "invalid advisory")
+		}
+		advisories = append(advisories, &advisory)
+	}
+	for _, advisory := range advisories {
+		if advisory.ID == "" || advisory.Withdrawn != nil {
+			continue
+		}
+		db.advisories++
+		if advisory.Modified.After(db.Modified) {
+			db.Modified = advisory.Modified
+		}
+		for i := range advisory.Affected {
+			affected := &advisory.Affected[i]
+			ecosystem, _ := splitEcosystem(affected.Package.Ecosystem)
+			key := advisoryKey(ecosystem, affected.Package.Name)
+			db.index[key] = append(db.index[key], advisoryEntry{advisory: advisory, affected: affected})
+			if severity, _ := advisorySeverity(advisory, affected); severity != SeverityUnknown {
+				for _, id := range append([]string{advisory.ID}, advisory.Aliases...) {
+					db.severities[id] = maxSeverity(db.severities[id], severity)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of advisories in the database.
+func (db *AdvisoryDB) Len() int {
+	return db.advisories
+}
+
+// Match returns a finding for every advisory affecting the installed
+// version of pkg. Advisories without a severity take the severity another
+// advisory of the database records for one of their aliases.
+func (db *AdvisoryDB) Match(pkg Package) []Finding {
+	ecosystem, release, compare := packageEcosystem(pkg)
+	name, version := pkg.Name, pkg.Version
+	if pkg.Source != "" {
+		name = pkg.Source
+	}
+	if pkg.SourceVersion != "" {
+		version = pkg.SourceVersion
+	}
+
+	seen := make(map[string]bool)
+	var findings []Finding
+	for _, entry := range db.index[advisoryKey(ecosystem, name)] {
+		if _, advisoryRelease := splitEcosystem(entry.affected.Package.Ecosystem); release != "" &&
+			advisoryRelease != "" && advisoryRelease != release {
+			continue
+		}
+		fixed, affected := affectedVersion(entry.affected, version, compare)
+		if !affected || seen[entry.advisory.ID] {
+			continue
+		}
+		seen[entry.advisory.ID] = true
+		severity, score := db.severity(entry)
+		findings = append(findings, Finding{
+			Package:  pkg.Name,
+			Version:  pkg.Version,
+			Type:     pkg.Type,
+			PURL:     pkg.PURL(),
+			Location: pkg.Location,
+			Advisory: entry.advisory.ID,
+			Aliases:  entry.advisory.Aliases,
+			Summary:  entry.advisory.Summary,
+			Severity: severity,
+			Score:    score,
+			Fixed:    fixed,
+		})
+	}
+	return findings
+}
+
+// severity returns the severity of the advisory of entry, or the severity the
+// database records for one of its aliases when the advisory has none.
+func (db *AdvisoryDB) severity(entry advisoryEntry) (Severity, float64) {
+	severity, score := advisorySeverity(entry.advisory, entry.affected)
+	if severity != SeverityUnknown {
+		return severity, score
+	}
+	for _, id := range entry.advisory.Aliases {
+		if known, ok := db.severities[id]; ok {
+			return known, score
+		}
+	}
+	return severity, score
+}
+
+// packageEcosystem returns the OSV ecosystem and release advisories of pkg
+// are published under, and how versions of the ecosystem compare. Conda
+// packages are matched against the advisories of the PyPI packages of the
+// same name.
+func packageEcosystem(pkg Package) (string, string, versionCompare) {
+	major, minor, _ := strings.Cut(pkg.DistroVersion, ".")
+	minor, _, _ = strings.Cut(minor, ".")
+	switch pkg.Type {
+	case PackageDeb:
+		if pkg.Distro == "ubuntu" {
+			return "Ubuntu", pkg.DistroVersion, compareDebian
+		}
+		return "Debian", major, compareDebian
+	case PackageApk:
+		switch pkg.Distro {
+		case "wolfi":
+			return "Wolfi", "", compareApk
+		case "chainguard":
+			return "Chainguard", "", compareApk
+		}
+		if major == "" {
+			return "Alpine", "", compareApk
+		}
+		return "Alpine", "v" + major + "." + minor, compareApk
+	case PackagePyPI, PackageConda:
+		return "PyPI", "", comparePython
+	default:
+		return string(pkg.Type), "", strings.Compare
+	}
+}
+
+// splitEcosystem splits an OSV ecosystem such as "Debian:12" or
+// "Ubuntu:Pro:22.04:LTS" into its name and release.
+func splitEcosystem(ecosystem string) (string, string) {
+	name, release, _ := strings.Cut(ecosystem, ":")
+	release = strings.TrimPrefix(release, "Pro:")
+	release = strings.TrimSuffix(release, ":LTS")
+	return name, release
+}
+
+// advisoryKey indexes advisories by ecosystem and package name, normalized
+// as PyPI compares names.
+func advisoryKey(ecosystem, name string) string {
+	if ecosystem == "PyPI" {
+		name = strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(name))
+	}
+	return ecosystem + "/" + name
+}
+
+// affectedVersion reports whether version is affected and returns the
+// version fixing it, if any. Versions are affected when they are listed, or
+// when they fall into an ECOSYSTEM or SEMVER range: at or after an introduced
+// event and before the following fixed event or up to a last_affected one.
+func affectedVersion(affected *Affected, version string, compare versionCompare) (string, bool) {
+	for _, listed := range affected.Versions {
+		if compare(listed, version) == 0 {
+			return "", true
+		}
+	}
+	for _, r := range affected.Ranges {
+		if r.Type != "ECOSYSTEM" && r.Type != "SEMVER" {
+			continue
+		}
+		if fixed, in := inRange(r.Events, version, compare); in {
+			return fixed, true
+		}
+	}
+	return "", false
+}
+
+// inRange reports whether version falls into the range described by events
+// and returns the version fixing it, if any.
+func inRange(events []RangeEvent, version string, compare versionCompare) (string, bool) {
+	events = append([]RangeEvent(nil), events...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return compareEvents(events[i], events[j], compare) < 0
+	})
+	in := false
+	for _, event := range events {
+		switch {
+		case event.Introduced != "":
+			in = in || event.Introduced == "0" || compare(version, event.Introduced) >= 0
+		case event.Fixed != "" && compare(version, event.Fixed) < 0:
+			return event.Fixed, in
+		case event.LastAffected != "" && compare(version, event.LastAffected) <= 0:
+			return "", in
+		case event.Fixed != "" || event.LastAffected != "":
+			in = false
+		}
+	}
+	return "", in
+}
+
+// compareEvents orders the events of a range by version, the introduction of
+// a vulnerability in every version ("0") first.
+func compareEvents(a, b RangeEvent, compare versionCompare) int {
+	va := a.Introduced + a.Fixed + a.LastAffected
+	vb := b.Introduced + b.Fixed + b.LastAffected
+	switch {
+	case a.Introduced == "0" && b.Introduced == "0":
+		return 0
+	case a.Introduced == "0":
+		return -1
+	case b.Introduced == "0":
+		return 1
+	default:
+		return compare(va, vb)
+	}
+}
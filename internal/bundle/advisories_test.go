@@ -0,0 +1,210 @@
+package bundle_test
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// osvAdvisory returns an OSV advisory for package name of ecosystem, affected
+// from its first version until fixed.
+func osvAdvisory(id, ecosystem, name, fixed string, extra string) string {
+	return fmt.Sprintf(`{"id":%q,"modified":"2024-05-01T00:00:00Z","affected":[{"package":{"ecosystem":%q,"name":%q},`+
+		`"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":%q}]}]}]%s}`, id, ecosystem, name, fixed, extra)
+}
+
+func readAdvisories(t *testing.T, advisories ...string) *bundle.AdvisoryDB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "advisories.json")
+	data := "["
+	for i, advisory := range advisories {
+		if i > 0 {
+			data += ","
+		}
+		data += advisory
+	}
+	require.NoError(t, os.WriteFile(path, []byte(data+"]"), 0o644))
+	db, err := bundle.ReadAdvisories(path)
+	require.NoError(t, err)
+	return db
+}
+
+func TestReadAdvisories(t *testing.T) {
+	dsa := osvAdvisory("DSA-5678-1", "Debian:12", "glibc", "2.36-9+deb12u7", "")
+	withdrawn := osvAdvisory("GHSA-xxxx", "PyPI", "requests", "2.32.0", `,"withdrawn":"2024-06-01T00:00:00Z"`)
+	ghsa := osvAdvisory("GHSA-9wx4-h78v-vm56", "PyPI", "requests", "2.32.0", "")
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "debian"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debian", "DSA-5678-1.json"), []byte(dsa), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "PyPI.json"), []byte("["+withdrawn+","+ghsa+"]"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not an advisory"), 0o644))
+	db, err := bundle.ReadAdvisories(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 2, db.Len())
+	assert.Equal(t, filepath.Base(dir), db.Name)
+
+	archive := filepath.Join(t.TempDir(), "all.zip")
+	file, err := os.Create(archive)
+	require.NoError(t, err)
+	zw := zip.NewWriter(file)
+	for name, content := range map[string]string{"DSA-5678-1.json": dsa, "GHSA-9wx4-h78v-vm56.json": ghsa} {
+		w, createErr := zw.Create(name)
+		require.NoError(t, createErr)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, file.Close())
+	db, err = bundle.ReadAdvisories(archive)
+	require.NoError(t, err)
+	assert.Equal(t, 2, db.Len())
+	assert.Equal(t, "2024-05-01", db.Modified.Format("2006-01-02"))
+}
+
+func TestReadAdvisories_Invalid(t *testing.T) {
+	_, err := bundle.ReadAdvisories(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorContains(t, err, "failed to read advisory database")
+
+	path := filepath.Join(t.TempDir(), "advisories.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":`), 0o644))
+	_, err = bundle.ReadAdvisories(path)
+	assert.ErrorContains(t, err, "invalid advisory")
+}
+
+func TestAdvisoryDB_MatchSourcePackage(t *testing.T) {
+	db := readAdvisories(t,
+		osvAdvisory("DSA-5678-1", "Debian:12", "glibc", "2.36-9+deb12u7", `,"summary":"glibc - security update"`),
+		osvAdvisory("DSA-1234-1", "Debian:11", "glibc", "2.31-13+deb11u9", ""),
+		osvAdvisory("DSA-9999-1", "Debian:12", "libc6", "9.0", ""),
+	)
+	libc := bundle.Package{
+		Type: bundle.PackageDeb, Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64",
+		Distro: "debian", DistroVersion: "12", Source: "glibc", Location: "var/lib/dpkg/status",
+	}
+
+	findings := db.Match(libc)
+	require.Len(t, findings, 1)
+	assert.Equal(t, bundle.Finding{
+		Package:  "libc6",
+		Version:  "2.36-9+deb12u4",
+		Type:     bundle.PackageDeb,
+		PURL:     libc.PURL(),
+		Location: "var/lib/dpkg/status",
+		Advisory: "DSA-5678-1",
+		Summary:  "glibc - security update",
+		Severity: bundle.SeverityUnknown,
+		Fixed:    "2.36-9+deb12u7",
+	}, findings[0])
+
+	libc.Version = "2.36-9+deb12u7"
+	assert.Empty(t, db.Match(libc))
+
+	// The version of the source package is matched when it differs from the binary package
+	libc.SourceVersion = "2.36-9+deb12u4"
+	assert.Len(t, db.Match(libc), 1)
+}
+
+func TestAdvisoryDB_MatchVersions(t *testing.T) {
+	tests := []struct {
+		ecosystem string
+		pkg       bundle.Package
+		fixed     string
+		affected  bool
+	}{
+		{"Debian:12", bundle.Package{Type: bundle.PackageDeb, Version: "1:1.2.13.dfsg-0.1"}, "1:1.2.13.dfsg-1", true},
+		{"Debian:12", bundle.Package{Type: bundle.PackageDeb, Version: "2:1.0-1"}, "1:9.9-1", false},
+		{"Debian:12", bundle.Package{Type: bundle.PackageDeb, Version: "1.0~rc1-1"}, "1.0-1", true},
+		{"Debian:12", bundle.Package{Type: bundle.PackageDeb, Version: "1.0+b1-1"}, "1.0-1", false},
+		{"Debian:12", bundle.Package{Type: bundle.PackageDeb, Version: "1.10-1"}, "1.9-1", false},
+		{"Ubuntu:22.04:LTS", bundle.Package{Type: bundle.PackageDeb, Version: "3.0.2-0ubuntu1.14"}, "3.0.2-0ubuntu1.15",
+			true},
+		{"Alpine:v3.19", bundle.Package{Type: bundle.PackageApk, Version: "1.2.4_git20230717-r4"}, "1.2.4_git20230717-r5",
+			true},
+		{"Alpine:v3.19", bundle.Package{Type: bundle.PackageApk, Version: "1.2.4-r0"}, "1.2.4_rc1-r0", false},
+		{"Alpine:v3.19", bundle.Package{Type: bundle.PackageApk, Version: "3.1.4-r5"}, "3.1.10-r0", true},
+		{"Alpine:v3.19", bundle.Package{Type: bundle.PackageApk, Version: "1.36.1_p2-r0"}, "1.36.1-r0", false},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "2.31.0"}, "2.32.0", true},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "2.32.0rc1"}, "2.32.0", true},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "2.32.0.dev1"}, "2.32.0a1", true},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "2.32"}, "2.32.0", false},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "2.32.0.post1"}, "2.32.0", false},
+		{"PyPI", bundle.Package{Type: bundle.PackagePyPI, Version: "1!1.0"}, "2.0", false},
+		{"PyPI", bundle.Package{Type: bundle.PackageConda, Version: "1.25.2"}, "1.26.0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ecosystem+" "+tt.pkg.Version+" "+tt.fixed, func(t *testing.T) {
+			tt.pkg.Name = "pkg"
+			switch tt.ecosystem {
+			case "Debian:12":
+				tt.pkg.Distro, tt.pkg.DistroVersion = "debian", "12"
+			case "Ubuntu:22.04:LTS":
+				tt.pkg.Distro, tt.pkg.DistroVersion = "ubuntu", "22.04"
+			case "Alpine:v3.19":
+				tt.pkg.Distro, tt.pkg.DistroVersion = "alpine", "3.19.1"
+			}
+			db := readAdvisories(t, osvAdvisory("ADV-1", tt.ecosystem, "pkg", tt.fixed, ""))
+			assert.Equal(t, tt.affected, len(db.Match(tt.pkg)) == 1)
+		})
+	}
+}
+
+func TestAdvisoryDB_MatchRangesAndVersions(t *testing.T) {
+	db := readAdvisories(t, `{"id":"GHSA-1","modified":"2024-05-01T00:00:00Z","affected":[{
+		"package":{"ecosystem":"PyPI","name":"Flask"},
+		"ranges":[{"type":"ECOSYSTEM","events":[
+			{"introduced":"2.0"},{"fixed":"2.2.5"},{"introduced":"2.3.0"},{"fixed":"2.3.2"}]},
+			{"type":"GIT","events":[{"introduced":"0"}]}],
+		"versions":["1.0.3"]}]}`)
+	for version, affected := range map[string]bool{
+		"1.0.3": true, "1.1.0": false, "2.1.0": true, "2.2.5": false, "2.3.1": true, "2.3.2": false,
+	} {
+		findings := db.Match(bundle.Package{Type: bundle.PackagePyPI, Name: "flask", Version: version})
+		assert.Equal(t, affected, len(findings) == 1, version)
+	}
+}
+
+func TestAdvisoryDB_MatchSeverity(t *testing.T) {
+	db := readAdvisories(t,
+		osvAdvisory("GHSA-1", "PyPI", "critical-pkg", "2.0",
+			`,"severity":[{"type":"CVSS_V3","score":"CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H"}]`),
+		osvAdvisory("GHSA-2", "PyPI", "medium-pkg", "2.0",
+			`,"severity":[{"type":"CVSS_V3","score":"CVSS:3.1/AV:N/AC:L/PR:N/UI:R/S:C/C:L/I:L/A:N"}]`),
+		osvAdvisory("GHSA-3", "PyPI", "moderate-pkg", "2.0",
+			`,"severity":[{"type":"CVSS_V4","score":"CVSS:4.0/AV:N"}],"database_specific":{"severity":"MODERATE"}`),
+		osvAdvisory("DEBIAN-CVE-2024-0001", "Debian:12", "openssl", "3.0.13-1", `,"aliases":["CVE-2024-0001"]`),
+		osvAdvisory("UBUNTU-CVE-2024-0001", "Ubuntu:22.04:LTS", "openssl", "3.0.2-0ubuntu1.15",
+			`,"aliases":["CVE-2024-0001"],"severity":[{"type":"Ubuntu","score":"high"}]`),
+	)
+
+	findings := db.Match(bundle.Package{Type: bundle.PackagePyPI, Name: "critical_pkg", Version: "1.0"})
+	require.Len(t, findings, 1)
+	assert.Equal(t, bundle.SeverityCritical, findings[0].Severity)
+	assert.InDelta(t, 9.8, findings[0].Score, 0.001)
+
+	findings = db.Match(bundle.Package{Type: bundle.PackagePyPI, Name: "medium-pkg", Version: "1.0"})
+	require.Len(t, findings, 1)
+	assert.Equal(t, bundle.SeverityMedium, findings[0].Severity)
+	assert.InDelta(t, 6.1, findings[0].Score, 0.001)
+
+	findings = db.Match(bundle.Package{Type: bundle.PackagePyPI, Name: "moderate-pkg", Version: "1.0"})
+	require.Len(t, findings, 1)
+	assert.Equal(t, bundle.SeverityMedium, findings[0].Severity)
+	assert.Zero(t, findings[0].Score)
+
+	// The Debian advisory records no severity, the Ubuntu advisory of the same CVE does
+	findings = db.Match(bundle.Package{
+		Type: bundle.PackageDeb, Name: "libssl3", Source: "openssl", Version: "3.0.11-1~deb12u2",
+		Distro: "debian", DistroVersion: "12",
+	})
+	require.Len(t, findings, 1)
+	assert.Equal(t, "DEBIAN-CVE-2024-0001", findings[0].Advisory)
+	assert.Equal(t, bundle.SeverityHigh, findings[0].Severity)
+}
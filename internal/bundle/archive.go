@@ -0,0 +1,197 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ociImageNameAnnotation is set by containerd and docker save on index.json entries.
+	ociImageNameAnnotation = "io.containerd.image.name"
+	// ociRefNameAnnotation is the standard OCI annotation for the image reference.
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+	dockerHubDomain = "docker.io"
+)
+
+// ArchiveManifestEntry is an entry of the manifest.json written by `docker save`.
+type ArchiveManifestEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+type ociIndex struct {
+	Manifests []struct {
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"manifests"`
+}
+
+// OpenArchive opens the image archive of the bundle stored in dir and returns
+// the uncompressed `docker save` stream. Split archives are reassembled by
+// reading their parts in order and OCI layout directories are streamed as a tar archive.
+func OpenArchive(dir string, archive Archive) (io.ReadCloser, error) {
+	return OpenArchiveWithKeys(dir, archive, nil)
+}
+
+// OpenArchiveWithKeys opens an image archive like OpenArchive, decrypting it
+// with keys when it is encrypted.
+func OpenArchiveWithKeys(dir string, archive Archive, keys *Keys) (io.ReadCloser, error) {
+	if archive.Directory() {
+		layout := filepath.Join(dir, archive.Name)
+		if _, err := os.Stat(filepath.Join(layout, archiveIndexFile)); err != nil {
+			return nil, errors.Wrap(err, "failed to open image archive")
+		}
+		return TarDirectory(layout), nil
+	}
+
+	names := archive.FileNames()
+	files := make([]io.Closer, 0, len(names))
+	readers := make([]io.Reader, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			_ = closeAll(files)
+			return nil, errors.Wrap(err, "failed to open image archive")
+		}
+		files = append(files, file)
+		readers = append(readers, file)
+	}
+
+	src := io.MultiReader(readers...)
+	if archive.Encrypted {
+		if keys == nil {
+			_ = closeAll(files)
+			return nil, errors.Errorf("image archive %s is encrypted, an identity or passphrase is needed to read it", archive.Name)
+		}
+		decrypted, err := NewDecryptReader(src, keys)
+		if err != nil {
+			_ = closeAll(files)
+			return nil, errors.Wrapf(err, "failed to decrypt %s", archive.Name)
+		}
+		src = decrypted
+	}
+	reader, err := NewDecompressReader(src, archive.Compression)
+	if err != nil {
+		_ = closeAll(files)
+		return nil, err
+	}
+	return &archiveReader{ReadCloser: reader, files: files}, nil
+}
+
+type archiveReader struct {
+	io.ReadCloser
+	files []io.Closer
+}
+
+func (r *archiveReader) Close() error {
+	err := r.ReadCloser.Close()
+	if closeErr := closeAll(r.files); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func closeAll(closers []io.Closer) error {
+	var err error
+	for _, closer := range closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// ArchiveImages lists the image references contained in an image archive
+// produced by `docker save`, using both its manifest.json and index.json.
+// References are returned in normalized form, see NormalizeReference.
+func ArchiveImages(r io.Reader) (map[string]bool, error) {
+	images := make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+
+		switch header.Name {
+		case "manifest.json":
+			var entries []ArchiveManifestEntry
+			if decodeErr := json.NewDecoder(tr).Decode(&entries); decodeErr != nil {
+				return nil, errors.Wrap(decodeErr, "failed to decode archive manifest.json")
+			}
+			for _, entry := range entries {
+				for _, tag := range entry.RepoTags {
+					images[NormalizeReference(tag)] = true
+				}
+			}
+		case "index.json":
+			var index ociIndex
+			if decodeErr := json.NewDecoder(tr).Decode(&index); decodeErr != nil {
+				return nil, errors.Wrap(decodeErr, "failed to decode archive index.json")
+			}
+			for _, desc := range index.Manifests {
+				if name := desc.Annotations[ociImageNameAnnotation]; name != "" {
+					images[NormalizeReference(name)] = true
+				}
+			}
+		}
+	}
+	return images, nil
+}
+
+// NormalizeReference returns the fully qualified form of an image reference,
+// e.g. "nginx" becomes "docker.io/library/nginx:latest". References that
+// cannot be parsed are returned unchanged.
+func NormalizeReference(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// PrefixReference moves the image reference ref under a registry prefix, such as
+// "registry.site.local:5000" or "registry.site.local:5000/team", keeping its repository path and tag:
+// nginx:1.27 becomes registry.site.local:5000/nginx:1.27 and ghcr.io/org/app:v1
+// becomes registry.site.local:5000/org/app:v1. References already under the
+// prefix are returned unchanged.
+func PrefixReference(prefix, ref string) (string, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image name %q", ref)
+	}
+	if strings.HasPrefix(named.Name(), prefix+"/") {
+		return ref, nil
+	}
+	tagged, ok := reference.TagNameOnly(named).(reference.Tagged)
+	if !ok {
+		return "", errors.Errorf("image %q is referenced by digest only and cannot be tagged under %s", ref, prefix)
+	}
+
+	path := reference.Path(named)
+	if reference.Domain(named) == dockerHubDomain {
+		path = strings.TrimPrefix(path, "library/")
+	}
+	prefixed, err := reference.ParseNormalizedNamed(prefix + "/" + path)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid registry prefix %q", prefix)
+	}
+	prefixedTagged, err := reference.WithTag(prefixed, tagged.Tag())
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid registry prefix %q", prefix)
+	}
+	return prefixedTagged.String(), nil
+}
@@ -0,0 +1,204 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const dockerIgnoreFile = ".dockerignore"
+
+// ContextDigest returns a digest of the files of the build context dir sent to
+// the builder: every file not excluded by the .dockerignore of the context, or
+// by <dockerfile>.dockerignore next to the Dockerfile when there is one. It
+// covers the path, type and contents of each file, so it changes when any of
+// them does, but not when a file is only touched.
+func ContextDigest(dir, dockerfile string) (digest.Digest, error) {
+	excludes, err := readIgnoreFile(dir, dockerfile)
+	if err != nil {
+		return "", err
+	}
+	matcher, err := patternmatcher.New(excludes)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid .dockerignore")
+	}
+
+	digester := digest.Canonical.Digester()
+	walkErr := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		excluded, err := matcher.MatchesOrParentMatches(rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if entry.IsDir() && !matcher.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		line, err := contextEntry(path, entry)
+		if err != nil {
+			return err
+		}
+		if line != "" {
+			_, _ = digester.Hash().Write([]byte(line + " " + rel + "\n"))
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return "", errors.Wrapf(walkErr, "failed to hash build context %s", dir)
+	}
+	return digester.Digest(), nil
+}
+
+// contextEntry describes a file of a build context by its type and contents,
+// and returns an empty string for directories.
+func contextEntry(path string, entry fs.DirEntry) (string, error) {
+	switch {
+	case entry.IsDir():
+		return "", nil
+	case entry.Type()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		return "link " + target, err
+	case entry.Type().IsRegular():
+		file, err := HashFile(path, path)
+		return "file " + file.SHA256, err
+	default:
+		return "other", nil
+	}
+}
+
+// readIgnoreFile returns the exclude patterns of the build context dir.
+func readIgnoreFile(dir, dockerfile string) ([]string, error) {
+	candidates := []string{filepath.Join(dir, dockerIgnoreFile)}
+	if dockerfile != "" {
+		candidates = append([]string{dockerfile + dockerIgnoreFile}, candidates...)
+	}
+	for _, path := range candidates {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open .dockerignore")
+		}
+		excludes, err := ignorefile.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", path)
+		}
+		return excludes, nil
+	}
+	return nil, nil
+}
+
+// BaseImages returns the images the stages of a Dockerfile start from, in
+// order and without duplicates, leaving out scratch and references to earlier
+// stages. Variables in FROM instructions are expanded from the ARG instructions
+// before the first FROM, overridden by the build args.
+func BaseImages(dockerfile []byte, args map[string]string) []string {
+	vars := make(map[string]string)
+	stages := make(map[string]bool)
+	seen := make(map[string]bool)
+	var images []string
+	for _, line := range dockerfileInstructions(dockerfile) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "ARG":
+			if len(stages) > 0 {
+				continue
+			}
+			for _, arg := range fields[1:] {
+				name, value, _ := strings.Cut(arg, "=")
+				if override, ok := args[name]; ok {
+					value = override
+				}
+				vars[name] = strings.Trim(value, `"'`)
+			}
+		case "FROM":
+			ref, stage := fromInstruction(fields[1:], vars)
+			if ref != "" && !stages[strings.ToLower(ref)] && ref != "scratch" && !seen[ref] {
+				seen[ref] = true
+				images = append(images, ref)
+			}
+			stages[strings.ToLower(stage)] = true
+		}
+	}
+	return images
+}
+
+// fromInstruction returns the image and the stage name of the arguments of a FROM instruction.
+func fromInstruction(args []string, vars map[string]string) (string, string) {
+	var ref, stage string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case strings.HasPrefix(args[i], "--"):
+			continue
+		case ref == "":
+			ref = os.Expand(args[i], func(name string) string { return expandArg(name, vars) })
+		case strings.EqualFold(args[i], "AS") && i+1 < len(args):
+			stage = args[i+1]
+			i++
+		}
+	}
+	return ref, stage
+}
+
+// expandArg expands a variable reference of the form NAME, NAME:-default or NAME-default.
+func expandArg(expr string, vars map[string]string) string {
+	if name, fallback, found := strings.Cut(expr, ":-"); found {
+		if value := vars[name]; value != "" {
+			return value
+		}
+		return fallback
+	}
+	if name, fallback, found := strings.Cut(expr, "-"); found {
+		if value, ok := vars[name]; ok {
+			return value
+		}
+		return fallback
+	}
+	return vars[expr]
+}
+
+// dockerfileInstructions returns the instructions of a Dockerfile with line
+// continuations joined and comments removed.
+func dockerfileInstructions(dockerfile []byte) []string {
+	var instructions []string
+	var current strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(dockerfile))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\") + " ")
+			continue
+		}
+		current.WriteString(line)
+		if instruction := strings.TrimSpace(current.String()); instruction != "" {
+			instructions = append(instructions, instruction)
+		}
+		current.Reset()
+	}
+	return instructions
+}
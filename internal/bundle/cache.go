@@ -0,0 +1,248 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const cacheImagesDir = "images"
+
+// archiveMetadataFiles are the files of a `docker save` stream describing its
+// images, which are not kept in the layer cache.
+var archiveMetadataFiles = map[string]bool{
+	archiveManifestFile:     true,
+	archiveIndexFile:        true,
+	ocispec.ImageLayoutFile: true,
+	"repositories":          true,
+}
+
+// LayerCache is a content-addressable store of image blobs shared by repeated
+// saves. Blobs are stored by digest under blobs/sha256, as in an OCI image
+// layout, and every cached image is recorded under images/ by image ID with
+// the descriptor of its manifest.
+type LayerCache struct {
+	Dir string
+}
+
+// CachedImage is an image of the layer cache.
+type CachedImage struct {
+	Descriptor ocispec.Descriptor // Descriptor of the image manifest
+	Blobs      []ocispec.Descriptor
+}
+
+// CacheStats counts the blobs added to the layer cache by Store.
+type CacheStats struct {
+	Added  int
+	Reused int
+	Size   int64 // Size in bytes of the blobs added
+}
+
+// OpenLayerCache opens the layer cache stored in dir, creating it if needed.
+func OpenLayerCache(dir string) (*LayerCache, error) {
+	cache := &LayerCache{Dir: dir}
+	for _, sub := range []string{cache.blobs(), filepath.Join(dir, cacheImagesDir)} {
+		if err := os.MkdirAll(sub, dirPermissions); err != nil {
+			return nil, errors.Wrap(err, "failed to create layer cache")
+		}
+	}
+	return cache, nil
+}
+
+func (c *LayerCache) blobs() string {
+	return filepath.Join(c.Dir, ocispec.ImageBlobsDir, digest.Canonical.String())
+}
+
+func (c *LayerCache) record(id string) (string, error) {
+	dgst, err := digest.Parse(id)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image ID %q", id)
+	}
+	return filepath.Join(c.Dir, cacheImagesDir, dgst.Encoded()+".json"), nil
+}
+
+// Image returns the cached image with the image ID id. It reports false when
+// the image, or any of its blobs, is not in the cache.
+func (c *LayerCache) Image(id string) (*CachedImage, bool) {
+	record, err := c.record(id)
+	if err != nil {
+		return nil, false
+	}
+	var desc ocispec.Descriptor
+	data, err := os.ReadFile(record)
+	if err != nil || json.Unmarshal(data, &desc) != nil {
+		return nil, false
+	}
+	data, err = os.ReadFile(blobPath(c.blobs(), desc.Digest))
+	if err != nil || digest.FromBytes(data) != desc.Digest {
+		return nil, false
+	}
+	var manifest ocispec.Manifest
+	if json.Unmarshal(data, &manifest) != nil {
+		return nil, false
+	}
+	img := &CachedImage{Descriptor: desc, Blobs: append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...)}
+	for _, blob := range img.Blobs {
+		if info, statErr := os.Stat(blobPath(c.blobs(), blob.Digest)); statErr != nil || info.Size() != blob.Size {
+			return nil, false
+		}
+	}
+	return img, true
+}
+
+// Store adds the blobs of a `docker save` stream to the cache and records its
+// images. Blobs already in the cache are only read to index the stream.
+func (c *LayerCache) Store(src io.Reader, images []LayoutImage) (CacheStats, error) {
+	var stats CacheStats
+	index := NewArchiveIndex()
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return stats, errors.Wrap(err, "failed to read image archive")
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			index.AddLink(header)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, cached, err := c.storeEntry(tr, header)
+		if err != nil {
+			return stats, err
+		}
+		index.Add(entry)
+		if archiveMetadataFiles[header.Name] {
+			continue
+		}
+		if cached {
+			stats.Reused++
+		} else {
+			stats.Added++
+			stats.Size += header.Size
+		}
+	}
+	if err := index.Decode(); err != nil {
+		return stats, err
+	}
+
+	for _, img := range images {
+		if err := c.storeImage(index, img); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// storeEntry stores a file of the stream as a blob unless it is metadata or,
+// judging by its OCI blob name, already cached.
+func (c *LayerCache) storeEntry(r io.Reader, header *tar.Header) (*ArchiveEntry, bool, error) {
+	if archiveMetadataFiles[header.Name] {
+		entry, err := ReadArchiveEntry(r, header, nil)
+		return entry, false, err
+	}
+	if encoded, ok := strings.CutPrefix(header.Name, "blobs/sha256/"); ok {
+		dgst := digest.NewDigestFromEncoded(digest.Canonical, encoded)
+		if info, statErr := os.Stat(blobPath(c.blobs(), dgst)); statErr == nil && info.Size() == header.Size {
+			entry, err := ReadArchiveEntry(r, header, nil)
+			return entry, true, err
+		}
+	}
+	entry, err := storeBlob(r, header, c.blobs())
+	return entry, false, err
+}
+
+// storeImage records an image of the indexed stream, storing its manifest when it was generated.
+func (c *LayerCache) storeImage(index *ArchiveIndex, img LayoutImage) error {
+	archived, err := index.FindImage(img.ID, img.Ref)
+	if err != nil {
+		return err
+	}
+	manifest := blobPath(c.blobs(), archived.Descriptor.Digest)
+	if err = os.WriteFile(manifest, archived.Manifest, filePermissions); err != nil {
+		return errors.Wrap(err, "failed to write image manifest")
+	}
+	record, err := c.record(img.ID)
+	if err != nil {
+		return err
+	}
+	return writeJSON(record, archived.Descriptor)
+}
+
+// WriteArchive writes the cached images as a tar archive holding an OCI image
+// layout, which `docker load` accepts like the output of `docker save`.
+func (c *LayerCache) WriteArchive(w io.Writer, images []LayoutImage) error {
+	layout := newLayoutWriter(nil, c.blobs())
+	for _, img := range images {
+		cached, ok := c.Image(img.ID)
+		if !ok {
+			return errors.Errorf("image %s (%s) is not in the layer cache", img.Ref, img.ID)
+		}
+		if err := layout.add(img, cached.Descriptor, cached.Blobs); err != nil {
+			return err
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	metadata := []struct {
+		name  string
+		value interface{}
+	}{
+		{ocispec.ImageLayoutFile, ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion}},
+		{ocispec.ImageIndexFile, layout.index},
+		{archiveManifestFile, layout.entries},
+	}
+	for _, file := range metadata {
+		data, err := json.Marshal(file.value)
+		if err != nil {
+			return errors.Wrapf(err, "failed to encode %s", file.name)
+		}
+		if err = writeTarFile(tw, file.name, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+
+	digests := make([]digest.Digest, 0, len(layout.referenced))
+	for dgst := range layout.referenced {
+		digests = append(digests, dgst)
+	}
+	sort.Slice(digests, func(i, j int) bool { return digests[i] < digests[j] })
+	for _, dgst := range digests {
+		if err := c.writeBlob(tw, dgst, layout.referenced[dgst]); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(tw.Close(), "failed to write image archive")
+}
+
+func (c *LayerCache) writeBlob(tw *tar.Writer, dgst digest.Digest, size int64) error {
+	file, err := os.Open(blobPath(c.blobs(), dgst))
+	if err != nil {
+		return errors.Wrap(err, "failed to open cached blob")
+	}
+	defer file.Close()
+	return writeTarFile(tw, blobName(dgst), size, file)
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: filePermissions, Size: size}
+	if err := tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to write %s to image archive", name)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return errors.Wrapf(err, "failed to write %s to image archive", name)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package bundle_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func cacheImages() []bundle.LayoutImage {
+	return []bundle.LayoutImage{
+		{Ref: "web:v1", ID: digest.FromString(webConfig).String()},
+		{Ref: "postgres:13", ID: digest.FromString(dbConfig).String()},
+	}
+}
+
+func TestLayerCache(t *testing.T) {
+	cache, err := bundle.OpenLayerCache(t.TempDir())
+	require.NoError(t, err)
+	images := cacheImages()
+
+	_, cached := cache.Image(images[0].ID)
+	assert.False(t, cached)
+
+	stats, err := cache.Store(bytes.NewReader(legacySave(t)), images)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Added) // The shared layer and both configs
+	assert.Equal(t, 0, stats.Reused)
+
+	img, cached := cache.Image(images[0].ID)
+	require.True(t, cached)
+	require.Len(t, img.Blobs, 2)
+	assert.Equal(t, digest.FromString("shared layer"), img.Blobs[1].Digest)
+
+	var archive bytes.Buffer
+	require.NoError(t, cache.WriteArchive(&archive, images))
+	index, err := bundle.IndexArchive(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, index.Manifest, 2)
+	assert.Equal(t, []string{"web:v1"}, index.Manifest[0].RepoTags)
+	web, err := index.Image(index.Manifest[0])
+	require.NoError(t, err)
+	assert.False(t, web.Generated)
+	assert.Equal(t, img.Descriptor.Digest, web.Descriptor.Digest)
+
+	// Saving the images again only reads blobs already in the cache.
+	stats, err = cache.Store(bytes.NewReader(archive.Bytes()), images)
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Added)
+	assert.Equal(t, 5, stats.Reused)
+}
+
+func TestLayerCache_MissingBlob(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := bundle.OpenLayerCache(dir)
+	require.NoError(t, err)
+	images := cacheImages()
+	_, err = cache.Store(bytes.NewReader(legacySave(t)), images)
+	require.NoError(t, err)
+
+	layer := digest.FromString("shared layer").Encoded()
+	require.NoError(t, os.Remove(filepath.Join(dir, "blobs", "sha256", layer)))
+	_, cached := cache.Image(images[0].ID)
+	assert.False(t, cached)
+
+	err = cache.WriteArchive(&bytes.Buffer{}, images)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not in the layer cache")
+}
@@ -0,0 +1,58 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// File records the size and SHA-256 checksum of a file in the bundle.
+type File struct {
+	Name   string `json:"name"` // Path relative to the bundle directory
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// HashWriter passes writes through to an underlying writer while computing
+// their SHA-256 checksum and size.
+type HashWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+// NewHashWriter returns a HashWriter writing to w.
+func NewHashWriter(w io.Writer) *HashWriter {
+	return &HashWriter{w: w, hash: sha256.New()}
+}
+
+func (h *HashWriter) Write(p []byte) (int, error) {
+	n, err := h.w.Write(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	return n, err
+}
+
+// File returns the checksum record of everything written so far under the given name.
+func (h *HashWriter) File(name string) File {
+	return File{Name: name, Size: h.size, SHA256: hex.EncodeToString(h.hash.Sum(nil))}
+}
+
+// HashFile computes the checksum record of the file at path.
+func HashFile(path, name string) (File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return File{}, errors.Wrapf(err, "failed to open %s", name)
+	}
+	defer file.Close()
+
+	hw := NewHashWriter(io.Discard)
+	if _, copyErr := io.Copy(hw, file); copyErr != nil {
+		return File{}, errors.Wrapf(copyErr, "failed to read %s", name)
+	}
+	return hw.File(name), nil
+}
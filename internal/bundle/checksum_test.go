@@ -0,0 +1,45 @@
+package bundle_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// sha256("hello world").
+const helloWorldSHA256 = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+
+func TestHashWriter(t *testing.T) {
+	var buf bytes.Buffer
+	hw := bundle.NewHashWriter(&buf)
+
+	_, err := hw.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = hw.Write([]byte("world"))
+	require.NoError(t, err)
+
+	file := hw.File("greeting.txt")
+	assert.Equal(t, "hello world", buf.String())
+	assert.Equal(t, bundle.File{Name: "greeting.txt", Size: 11, SHA256: helloWorldSHA256}, file)
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greeting.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello world"), 0600))
+
+	file, err := bundle.HashFile(path, "greeting.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), file.Size)
+	assert.Equal(t, helloWorldSHA256, file.SHA256)
+}
+
+func TestHashFile_Missing(t *testing.T) {
+	_, err := bundle.HashFile(filepath.Join(t.TempDir(), "missing"), "missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,104 @@
+package bundle
+
+import (
+	"compress/gzip"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression is the compression algorithm applied to the image archive.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// ParseCompression validates a compression name. An empty name means no compression.
+func ParseCompression(name string) (Compression, error) {
+	switch Compression(name) {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return Compression(name), nil
+	default:
+		return "", errors.Errorf("unsupported compression %q (expected gzip, zstd or none)", name)
+	}
+}
+
+// Extension returns the file name suffix used for archives compressed with c.
+func (c Compression) Extension() string {
+	switch c {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	case CompressionNone:
+		return ""
+	default:
+		return ""
+	}
+}
+
+// NewCompressWriter returns a writer compressing into w. A level of 0 selects
+// the default level of the algorithm. zstd compression uses all available CPUs.
+// Closing the returned writer flushes it but does not close w.
+func NewCompressWriter(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid gzip compression level")
+		}
+		return gw, nil
+	case CompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0))}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zw, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd writer")
+		}
+		return zw, nil
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, errors.Errorf("unsupported compression %q", c)
+	}
+}
+
+// NewDecompressReader returns a reader decompressing r.
+func NewDecompressReader(r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case CompressionGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read gzip stream")
+		}
+		return gr, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zstd stream")
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, errors.Errorf("unsupported compression %q", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,103 @@
+package bundle_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func TestParseCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    bundle.Compression
+		wantErr bool
+	}{
+		{name: "", want: bundle.CompressionNone},
+		{name: "none", want: bundle.CompressionNone},
+		{name: "gzip", want: bundle.CompressionGzip},
+		{name: "zstd", want: bundle.CompressionZstd},
+		{name: "brotli", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bundle.ParseCompression(tt.name)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompression_Extension(t *testing.T) {
+	assert.Empty(t, bundle.CompressionNone.Extension())
+	assert.Equal(t, ".gz", bundle.CompressionGzip.Extension())
+	assert.Equal(t, ".zst", bundle.CompressionZstd.Extension())
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	payload := strings.Repeat("conda layer payload ", 4096)
+
+	for _, compression := range []bundle.Compression{
+		bundle.CompressionNone, bundle.CompressionGzip, bundle.CompressionZstd,
+	} {
+		for _, level := range []int{0, 3} {
+			t.Run(fmt.Sprintf("%s/level-%d", compression, level), func(t *testing.T) {
+				var buf bytes.Buffer
+				writer, err := bundle.NewCompressWriter(&buf, compression, level)
+				require.NoError(t, err)
+				_, err = io.Copy(writer, strings.NewReader(payload))
+				require.NoError(t, err)
+				require.NoError(t, writer.Close())
+
+				if compression != bundle.CompressionNone {
+					assert.Less(t, buf.Len(), len(payload), "Expected compressed output to be smaller")
+				}
+
+				reader, err := bundle.NewDecompressReader(&buf, compression)
+				require.NoError(t, err)
+				defer reader.Close()
+				decompressed, err := io.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, payload, string(decompressed))
+			})
+		}
+	}
+}
+
+func TestNewCompressWriter_InvalidGzipLevel(t *testing.T) {
+	_, err := bundle.NewCompressWriter(io.Discard, bundle.CompressionGzip, 42)
+	assert.Error(t, err)
+}
+
+func TestVerify_CompressedArchive(t *testing.T) {
+	var archive bytes.Buffer
+	writer, err := bundle.NewCompressWriter(&archive, bundle.CompressionZstd, 0)
+	require.NoError(t, err)
+	_, err = writer.Write(buildImageArchive(t, "web:v1", "postgres:13"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	name := bundle.ImagesFile + bundle.CompressionZstd.Extension()
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		name:               archive.Bytes(),
+	}, func(m *bundle.Manifest) {
+		m.Archive = &bundle.Archive{Name: name, Compression: bundle.CompressionZstd}
+	})
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image web:v1").Status)
+}
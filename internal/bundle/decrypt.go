@@ -0,0 +1,153 @@
+package bundle
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Decrypt writes a decrypted copy of the encrypted bundle in dir to out and
+// returns its manifest. Split image archives are reassembled into a single
+// file, compressed archives stay compressed, and the manifest of the copy
+// records the checksums of the decrypted files.
+func Decrypt(dir, out string, keys *Keys) (*Manifest, error) {
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Encryption == nil {
+		return nil, errors.Errorf("bundle %s is not encrypted", manifest.Name())
+	}
+	if err = os.MkdirAll(out, dirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create output directory")
+	}
+
+	d := &decrypter{dir: dir, out: out, keys: keys, done: make(map[string]bool)}
+	if manifest.Archive != nil {
+		if manifest.Archive, err = d.archive(*manifest.Archive); err != nil {
+			return nil, err
+		}
+	}
+	for name, service := range manifest.Services {
+		if service.Archive == nil {
+			continue
+		}
+		if service.Archive, err = d.archive(*service.Archive); err != nil {
+			return nil, err
+		}
+		manifest.Services[name] = service
+	}
+	if err = d.file([]string{manifest.ComposeFileName()}, ComposeFile); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		if !d.done[file.Name] {
+			if err = d.copy(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	manifest.Encryption = nil
+	manifest.Files = d.files
+	if err = writeManifest(filepath.Join(out, ManifestFile), manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+type decrypter struct {
+	dir   string
+	out   string
+	keys  *Keys
+	files []File          // Checksums of the files written to out
+	done  map[string]bool // Files of dir already written to out
+}
+
+// archive decrypts an image archive and returns the record of the decrypted archive.
+func (d *decrypter) archive(archive Archive) (*Archive, error) {
+	if !archive.Encrypted {
+		return &archive, nil
+	}
+	decrypted := archive
+	decrypted.Name = strings.TrimSuffix(archive.Name, EncryptedExtension)
+	decrypted.Parts = nil
+	decrypted.Encrypted = false
+	if err := d.file(archive.FileNames(), decrypted.Name); err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+// file decrypts the concatenation of the files names of dir to the file name of out.
+func (d *decrypter) file(names []string, name string) error {
+	files := make([]io.Closer, 0, len(names))
+	readers := make([]io.Reader, 0, len(names))
+	defer func() { _ = closeAll(files) }()
+	for _, src := range names {
+		file, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(src)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", src)
+		}
+		files = append(files, file)
+		readers = append(readers, file)
+		d.done[src] = true
+	}
+	reader, err := NewDecryptReader(io.MultiReader(readers...), d.keys)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decrypt %s", names[0])
+	}
+	written, err := d.write(reader, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decrypt %s", names[0])
+	}
+	d.files = append(d.files, written)
+	return nil
+}
+
+// copy copies a file of dir that is not encrypted to out.
+func (d *decrypter) copy(file File) error {
+	src, err := os.Open(filepath.Join(d.dir, filepath.FromSlash(file.Name)))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", file.Name)
+	}
+	defer src.Close()
+	written, err := d.write(src, file.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy %s", file.Name)
+	}
+	d.files = append(d.files, written)
+	return nil
+}
+
+func (d *decrypter) write(r io.Reader, name string) (File, error) {
+	path := filepath.Join(d.out, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return File{}, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+	hw := NewHashWriter(file)
+	if _, err = io.Copy(hw, r); err != nil {
+		return File{}, err
+	}
+	return hw.File(name), file.Close()
+}
+
+func writeManifest(path string, manifest *Manifest) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create manifest file")
+	}
+	defer file.Close()
+	if err = manifest.Encode(file); err != nil {
+		return errors.Wrap(err, "failed to write manifest file")
+	}
+	return file.Close()
+}
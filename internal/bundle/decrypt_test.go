@@ -0,0 +1,103 @@
+package bundle_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// writeEncryptedBundle writes a bundle whose image archive, split in two parts,
+// and compose file are encrypted to key and returns its directory and archive.
+func writeEncryptedBundle(t *testing.T, key *age.X25519Identity) (string, []byte, bundle.Archive) {
+	t.Helper()
+	keys := &bundle.Keys{Recipients: []*age.X25519Recipient{key.Recipient()}}
+	images := buildImageArchive(t, "web:v1", "postgres:13")
+	encrypted := encrypt(t, images, keys)
+	half := len(encrypted) / 2
+	archive := bundle.Archive{
+		Name:        bundle.ImagesFile + bundle.EncryptedExtension,
+		Format:      bundle.FormatDocker,
+		Compression: bundle.CompressionNone,
+		Size:        int64(len(images)),
+		Parts:       []string{"images.tar.enc.001", "images.tar.enc.002"},
+		Encrypted:   true,
+	}
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile + bundle.EncryptedExtension: encrypt(t, []byte(testCompose), keys),
+		archive.Parts[0]: encrypted[:half],
+		archive.Parts[1]: encrypted[half:],
+	}, func(m *bundle.Manifest) {
+		m.Archive = &archive
+		m.Services = map[string]bundle.Service{"web": {Image: "web:v1"}, "db": {Image: "postgres:13"}}
+		m.Encryption = keys.Encryption()
+	})
+	return dir, images, archive
+}
+
+func TestDecrypt(t *testing.T) {
+	key := newTestKey(t)
+	dir, images, _ := writeEncryptedBundle(t, key)
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+
+	out := filepath.Join(t.TempDir(), "plain")
+	manifest, err := bundle.Decrypt(dir, out, &bundle.Keys{Identities: []*age.X25519Identity{key}})
+	require.NoError(t, err)
+	assert.Nil(t, manifest.Encryption)
+	require.NotNil(t, manifest.Archive)
+	assert.Equal(t, bundle.ImagesFile, manifest.Archive.Name)
+	assert.Empty(t, manifest.Archive.Parts)
+	assert.False(t, manifest.Archive.Encrypted)
+
+	data, err := os.ReadFile(filepath.Join(out, bundle.ImagesFile))
+	require.NoError(t, err)
+	assert.Equal(t, images, data)
+	data, err = os.ReadFile(filepath.Join(out, bundle.ComposeFile))
+	require.NoError(t, err)
+	assert.Equal(t, testCompose, string(data))
+
+	report, err = bundle.Verify(out)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image web:v1").Status)
+}
+
+func TestDecrypt_WrongIdentity(t *testing.T) {
+	dir, _, _ := writeEncryptedBundle(t, newTestKey(t))
+
+	_, err := bundle.Decrypt(dir, t.TempDir(), &bundle.Keys{Identities: []*age.X25519Identity{newTestKey(t)}})
+	require.Error(t, err)
+}
+
+func TestDecrypt_NotEncrypted(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{bundle.ComposeFile: []byte(testCompose)})
+
+	_, err := bundle.Decrypt(dir, t.TempDir(), &bundle.Keys{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not encrypted")
+}
+
+func TestOpenArchiveWithKeys_Encrypted(t *testing.T) {
+	key := newTestKey(t)
+	dir, images, archive := writeEncryptedBundle(t, key)
+
+	_, err := bundle.OpenArchive(dir, archive)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is encrypted")
+
+	rc, err := bundle.OpenArchiveWithKeys(dir, archive, &bundle.Keys{Identities: []*age.X25519Identity{key}})
+	require.NoError(t, err)
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, images, data)
+}
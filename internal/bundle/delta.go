@@ -0,0 +1,135 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// blobPrefix is the directory holding content-addressed blobs in a `docker save` archive.
+const blobPrefix = "blobs/sha256/"
+
+// ChainIDs returns the chain ID of every layer of an image, computed from the
+// ordered layer diff IDs as defined by the OCI image specification.
+func ChainIDs(diffIDs []string) []string {
+	chainIDs := make([]string, 0, len(diffIDs))
+	for i, diffID := range diffIDs {
+		if i == 0 {
+			chainIDs = append(chainIDs, diffID)
+			continue
+		}
+		sum := sha256.Sum256([]byte(chainIDs[i-1] + " " + diffID))
+		chainIDs = append(chainIDs, "sha256:"+hex.EncodeToString(sum[:]))
+	}
+	return chainIDs
+}
+
+// LayerSet holds the chain IDs of the layers present on a destination host.
+type LayerSet map[string]bool
+
+// Add records the layers of an image given by its ordered diff IDs.
+func (s LayerSet) Add(diffIDs []string) {
+	for _, chainID := range ChainIDs(diffIDs) {
+		s[chainID] = true
+	}
+}
+
+// Excluded returns the diff IDs of the layers of images that do not need to be
+// shipped: every image using such a layer has the chain up to it in the set.
+// A layer present on the host under a different parent chain is still shipped,
+// because `docker load` only reuses layers by chain ID.
+func (s LayerSet) Excluded(images [][]string) map[string]bool {
+	excluded := make(map[string]bool)
+	needed := make(map[string]bool)
+	for _, diffIDs := range images {
+		for i, chainID := range ChainIDs(diffIDs) {
+			if s[chainID] {
+				excluded[diffIDs[i]] = true
+			} else {
+				needed[diffIDs[i]] = true
+			}
+		}
+	}
+	for diffID := range needed {
+		delete(excluded, diffID)
+	}
+	return excluded
+}
+
+// LayerFilter reads a `docker save` stream with the layer blobs of a set of
+// diff IDs left out. Image configs, manifests and all other entries are kept.
+// Layer blobs are only recognized in the OCI layout written by Docker 25 and
+// later, where a layer is stored uncompressed under its diff ID.
+type LayerFilter struct {
+	reader   *io.PipeReader
+	done     chan struct{}
+	excluded []string
+}
+
+// NewLayerFilter starts filtering src, leaving out the layer blobs in exclude.
+func NewLayerFilter(src io.Reader, exclude map[string]bool) *LayerFilter {
+	reader, writer := io.Pipe()
+	f := &LayerFilter{reader: reader, done: make(chan struct{})}
+	go func() {
+		excluded, err := filterArchive(writer, src, exclude)
+		f.excluded = excluded
+		close(f.done)
+		_ = writer.CloseWithError(err)
+	}()
+	return f
+}
+
+// Read reads the filtered archive.
+func (f *LayerFilter) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Close stops filtering.
+func (f *LayerFilter) Close() error {
+	return f.reader.Close()
+}
+
+// Excluded returns the sorted diff IDs of the layers left out. It must only be
+// called after the filtered archive has been read to the end.
+func (f *LayerFilter) Excluded() []string {
+	<-f.done
+	return f.excluded
+}
+
+func filterArchive(dst io.Writer, src io.Reader, exclude map[string]bool) ([]string, error) {
+	tr := tar.NewReader(src)
+	tw := tar.NewWriter(dst)
+	var excluded []string
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+		if strings.HasPrefix(header.Name, blobPrefix) && header.Typeflag == tar.TypeReg {
+			diffID := "sha256:" + strings.TrimPrefix(header.Name, blobPrefix)
+			if exclude[diffID] {
+				excluded = append(excluded, diffID)
+				continue
+			}
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return nil, errors.Wrap(err, "failed to write image archive")
+		}
+		if _, err = io.Copy(tw, tr); err != nil {
+			return nil, errors.Wrap(err, "failed to write image archive")
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to write image archive")
+	}
+	sort.Strings(excluded)
+	return excluded, nil
+}
@@ -0,0 +1,93 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const (
+	layerA = "sha256:aaaa"
+	layerB = "sha256:bbbb"
+	layerC = "sha256:cccc"
+)
+
+// tarNames returns the entry names of a tar stream.
+func tarNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+}
+
+func TestChainIDs(t *testing.T) {
+	chainIDs := bundle.ChainIDs([]string{layerA, layerB})
+
+	require.Len(t, chainIDs, 2)
+	assert.Equal(t, layerA, chainIDs[0])
+	// sha256("sha256:aaaa sha256:bbbb")
+	assert.Equal(t, "sha256:2c4d8760379f05d0ad1ed610712309f0114e7345e6e6a351494cff030ffdf197", chainIDs[1])
+	assert.NotEqual(t, bundle.ChainIDs([]string{layerC, layerB})[1], chainIDs[1])
+	assert.Empty(t, bundle.ChainIDs(nil))
+}
+
+func TestLayerSet_Excluded(t *testing.T) {
+	present := make(bundle.LayerSet)
+	present.Add([]string{layerA, layerB})
+
+	excluded := present.Excluded([][]string{
+		{layerA, layerB, layerC},
+		{layerA},
+	})
+	assert.Equal(t, map[string]bool{layerA: true, layerB: true}, excluded)
+}
+
+func TestLayerSet_ExcludedRequiresSameChain(t *testing.T) {
+	present := make(bundle.LayerSet)
+	present.Add([]string{layerA, layerB})
+
+	// layerB is also used on top of layerC, a chain the target does not hold.
+	excluded := present.Excluded([][]string{
+		{layerA, layerB},
+		{layerC, layerB},
+	})
+	assert.Equal(t, map[string]bool{layerA: true}, excluded)
+}
+
+func TestLayerFilter(t *testing.T) {
+	archive := buildImageArchive(t, "web:v1")
+
+	filter := bundle.NewLayerFilter(bytes.NewReader(archive), map[string]bool{layerA: true, layerB: true})
+	filtered, err := io.ReadAll(filter)
+	require.NoError(t, err)
+	require.NoError(t, filter.Close())
+
+	assert.Equal(t, []string{layerA}, filter.Excluded())
+	assert.ElementsMatch(t, []string{"blobs/sha256/1111", "manifest.json"}, tarNames(t, filtered))
+
+	images, err := bundle.ArchiveImages(bytes.NewReader(filtered))
+	require.NoError(t, err)
+	assert.Contains(t, images, "docker.io/library/web:v1")
+}
+
+func TestLayerFilter_InvalidArchive(t *testing.T) {
+	filter := bundle.NewLayerFilter(bytes.NewReader([]byte("not a tar archive, long enough to fail header parsing")),
+		map[string]bool{layerA: true})
+	_, err := io.ReadAll(filter)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read image archive")
+}
@@ -0,0 +1,104 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+)
+
+// EncryptedExtension is the file name suffix of encrypted bundle files.
+const EncryptedExtension = ".enc"
+
+// Keys holds the keys bundle files are encrypted to or decrypted with. Files
+// are encrypted in the age format (https://age-encryption.org/v1), so they can
+// also be decrypted with the age command line tool.
+type Keys struct {
+	Passphrase []byte
+	Recipients []*age.X25519Recipient // X25519 public keys files are encrypted to
+	Identities []*age.X25519Identity  // X25519 private keys tried to decrypt files
+}
+
+// Encryption returns the description of the keys recorded in the manifest, or
+// nil when k is nil.
+func (k *Keys) Encryption() *Encryption {
+	if k == nil {
+		return nil
+	}
+	encryption := &Encryption{Passphrase: len(k.Passphrase) > 0}
+	for _, recipient := range k.Recipients {
+		encryption.Recipients = append(encryption.Recipients, KeyID(recipient))
+	}
+	return encryption
+}
+
+// KeyID returns a short fingerprint identifying an X25519 public key.
+func KeyID(key *age.X25519Recipient) string {
+	return keyID("x25519", []byte(key.String()))
+}
+
+// keyID returns the key type followed by the first bytes of the SHA-256 of the encoded public key.
+func keyID(keyType string, raw []byte) string {
+	const keyIDLength = 8
+	sum := sha256.Sum256(raw)
+	return keyType + ":" + hex.EncodeToString(sum[:keyIDLength])
+}
+
+// NewEncryptWriter returns a writer encrypting into w to every recipient or the
+// passphrase of keys. As in age, a passphrase cannot be combined with
+// recipients. Closing the returned writer writes the final chunk but does not
+// close w.
+func NewEncryptWriter(w io.Writer, keys *Keys) (io.WriteCloser, error) {
+	recipients := make([]age.Recipient, 0, len(keys.Recipients)+1)
+	for _, recipient := range keys.Recipients {
+		recipients = append(recipients, recipient)
+	}
+	if len(keys.Passphrase) > 0 {
+		if len(keys.Recipients) > 0 {
+			return nil, errors.New("a passphrase cannot be combined with recipients")
+		}
+		recipient, err := age.NewScryptRecipient(string(keys.Passphrase))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid passphrase")
+		}
+		recipients = append(recipients, recipient)
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipient or passphrase to encrypt to")
+	}
+	encrypted, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to write encryption header")
+	}
+	return encrypted, nil
+}
+
+// NewDecryptReader returns a reader decrypting r with the passphrase or one of
+// the identities of keys. Reads fail when the payload was modified or truncated.
+func NewDecryptReader(r io.Reader, keys *Keys) (io.Reader, error) {
+	identities := make([]age.Identity, 0, len(keys.Identities)+1)
+	for _, identity := range keys.Identities {
+		identities = append(identities, identity)
+	}
+	if len(keys.Passphrase) > 0 {
+		identity, err := age.NewScryptIdentity(string(keys.Passphrase))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid passphrase")
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no identity or passphrase to decrypt with")
+	}
+	decrypted, err := age.Decrypt(r, identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, errors.New("no matching identity or passphrase to decrypt with")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "not a valid encrypted file")
+	}
+	return decrypted, nil
+}
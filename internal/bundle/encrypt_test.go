@@ -0,0 +1,168 @@
+package bundle_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const encryptedChunkSize = 64*1024 + 16 // Chunk size plus the Poly1305 tag
+
+func encrypt(t *testing.T, plain []byte, keys *bundle.Keys) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := bundle.NewEncryptWriter(&out, keys)
+	require.NoError(t, err)
+	_, err = w.Write(plain)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return out.Bytes()
+}
+
+func decrypt(encrypted []byte, keys *bundle.Keys) ([]byte, error) {
+	r, err := bundle.NewDecryptReader(bytes.NewReader(encrypted), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func newTestKey(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	key, err := bundle.GenerateX25519Key()
+	require.NoError(t, err)
+	return key
+}
+
+func randomPayload(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	return data
+}
+
+func TestEncrypt_RoundTrip(t *testing.T) {
+	first, second := newTestKey(t), newTestKey(t)
+	keys := &bundle.Keys{Recipients: []*age.X25519Recipient{first.Recipient(), second.Recipient()}}
+
+	for name, size := range map[string]int{
+		"empty":          0,
+		"small":          100,
+		"exact chunks":   2 * 64 * 1024,
+		"partial chunks": 3*64*1024 + 17,
+	} {
+		t.Run(name, func(t *testing.T) {
+			plain := randomPayload(t, size)
+			encrypted := encrypt(t, plain, keys)
+
+			for _, identity := range []*age.X25519Identity{first, second} {
+				decrypted, err := decrypt(encrypted, &bundle.Keys{Identities: []*age.X25519Identity{identity}})
+				require.NoError(t, err)
+				assert.Equal(t, plain, append([]byte{}, decrypted...))
+			}
+		})
+	}
+}
+
+func TestEncrypt_Passphrase(t *testing.T) {
+	plain := randomPayload(t, 1000)
+	encrypted := encrypt(t, plain, &bundle.Keys{Passphrase: []byte("correct horse")})
+
+	decrypted, err := decrypt(encrypted, &bundle.Keys{Passphrase: []byte("correct horse")})
+	require.NoError(t, err)
+	assert.Equal(t, plain, decrypted)
+
+	_, err = decrypt(encrypted, &bundle.Keys{Passphrase: []byte("wrong horse")})
+	require.Error(t, err)
+}
+
+func TestEncrypt_NoRecipient(t *testing.T) {
+	_, err := bundle.NewEncryptWriter(io.Discard, &bundle.Keys{})
+	require.Error(t, err)
+
+	_, err = bundle.NewEncryptWriter(io.Discard, &bundle.Keys{
+		Passphrase: []byte("secret"), Recipients: []*age.X25519Recipient{newTestKey(t).Recipient()},
+	})
+	assert.ErrorContains(t, err, "cannot be combined with recipients")
+}
+
+// TestEncrypt_AgeFormat checks that encrypted files are age files, decrypted by
+// the age library from the identity string age-keygen writes, and that age
+// files are decrypted.
+func TestEncrypt_AgeFormat(t *testing.T) {
+	key := newTestKey(t)
+	encrypted := encrypt(t, []byte("payload"), &bundle.Keys{Recipients: []*age.X25519Recipient{key.Recipient()}})
+	assert.True(t, bytes.HasPrefix(encrypted, []byte("age-encryption.org/v1\n-> X25519 ")))
+
+	identity, err := age.ParseX25519Identity(key.String())
+	require.NoError(t, err)
+	r, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+	require.NoError(t, err)
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(plain))
+
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, key.Recipient())
+	require.NoError(t, err)
+	_, err = w.Write([]byte("from age"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	decrypted, err := decrypt(out.Bytes(), &bundle.Keys{Identities: []*age.X25519Identity{key}})
+	require.NoError(t, err)
+	assert.Equal(t, "from age", string(decrypted))
+}
+
+func TestDecryptReader_WrongIdentity(t *testing.T) {
+	encrypted := encrypt(t, []byte("payload"), &bundle.Keys{Recipients: []*age.X25519Recipient{newTestKey(t).Recipient()}})
+
+	_, err := decrypt(encrypted, &bundle.Keys{Identities: []*age.X25519Identity{newTestKey(t)}})
+	require.Error(t, err)
+}
+
+func TestDecryptReader_Tampered(t *testing.T) {
+	key := newTestKey(t)
+	keys := &bundle.Keys{Recipients: []*age.X25519Recipient{key.Recipient()}}
+	identity := &bundle.Keys{Identities: []*age.X25519Identity{key}}
+	encrypted := encrypt(t, randomPayload(t, 3*64*1024), keys)
+	headerSize := len(encrypted) - 3*encryptedChunkSize
+
+	tests := map[string][]byte{
+		"truncated at chunk boundary": encrypted[:len(encrypted)-encryptedChunkSize],
+		"truncated in chunk":          encrypted[:len(encrypted)-100],
+		"trailing data":               append(append([]byte{}, encrypted...), 0),
+	}
+	flipped := append([]byte{}, encrypted...)
+	flipped[headerSize+70000] ^= 1
+	tests["modified payload"] = flipped
+	header := append([]byte{}, encrypted...)
+	header[len("age-encryption.org/v1\n")+2] ^= 1
+	tests["modified header"] = header
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decrypt(data, identity)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestKeys_Encryption(t *testing.T) {
+	var keys *bundle.Keys
+	assert.Nil(t, keys.Encryption())
+
+	key := newTestKey(t)
+	keys = &bundle.Keys{Passphrase: []byte("secret"), Recipients: []*age.X25519Recipient{key.Recipient()}}
+	encryption := keys.Encryption()
+	assert.True(t, encryption.Passphrase)
+	assert.Equal(t, []string{bundle.KeyID(key.Recipient())}, encryption.Recipients)
+	assert.Regexp(t, `^x25519:[0-9a-f]{16}$`, encryption.Recipients[0])
+}
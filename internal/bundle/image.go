@@ -0,0 +1,277 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// metadataLimit is the largest archive file kept in memory while indexing,
+	// enough for image configs, manifests and the index.
+	metadataLimit = 1 << 20
+
+	manifestSchemaVersion   = 2
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	archiveManifestFile = "manifest.json"
+	archiveIndexFile    = "index.json"
+)
+
+// ArchiveEntry is a regular file of a `docker save` archive.
+type ArchiveEntry struct {
+	Name        string
+	Descriptor  ocispec.Descriptor // Digest and size of the file
+	Compression Compression        // Compression of the contents, detected from their header
+	Data        []byte             // Contents of files up to 1 MiB
+}
+
+// ReadArchiveEntry digests the contents of the archive file described by
+// header from r, copying them to w unless w is nil.
+func ReadArchiveEntry(r io.Reader, header *tar.Header, w io.Writer) (*ArchiveEntry, error) {
+	digester := digest.Canonical.Digester()
+	var data bytes.Buffer
+	writers := []io.Writer{digester.Hash()}
+	if header.Size <= metadataLimit {
+		writers = append(writers, &data)
+	}
+	if w != nil {
+		writers = append(writers, w)
+	}
+	dst := io.MultiWriter(writers...)
+
+	const magicSize = 4
+	head := make([]byte, magicSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.Wrapf(err, "failed to read %s from image archive", header.Name)
+	}
+	if _, err = dst.Write(head[:n]); err != nil {
+		return nil, errors.Wrapf(err, "failed to copy %s from image archive", header.Name)
+	}
+	if _, err = io.Copy(dst, r); err != nil {
+		return nil, errors.Wrapf(err, "failed to copy %s from image archive", header.Name)
+	}
+
+	entry := &ArchiveEntry{
+		Name:        header.Name,
+		Descriptor:  ocispec.Descriptor{Digest: digester.Digest(), Size: header.Size},
+		Compression: detectCompression(head[:n]),
+	}
+	if header.Size <= metadataLimit {
+		entry.Data = data.Bytes()
+	}
+	return entry, nil
+}
+
+// detectCompression recognizes gzip and zstd streams by their magic number.
+func detectCompression(head []byte) Compression {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return CompressionGzip
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return CompressionZstd
+	default:
+		return CompressionNone
+	}
+}
+
+// ArchiveIndex indexes the files of a `docker save` archive by name and content digest.
+type ArchiveIndex struct {
+	Entries  map[string]*ArchiveEntry
+	Blobs    map[digest.Digest]*ArchiveEntry
+	Manifest []ArchiveManifestEntry
+	Index    ocispec.Index // Present in archives written by Docker 25 and later
+
+	links map[string]string // Symbolic links to the file they point to
+}
+
+// NewArchiveIndex creates an empty archive index.
+func NewArchiveIndex() *ArchiveIndex {
+	return &ArchiveIndex{
+		Entries: make(map[string]*ArchiveEntry),
+		Blobs:   make(map[digest.Digest]*ArchiveEntry),
+		links:   make(map[string]string),
+	}
+}
+
+// Add records a file of the archive.
+func (a *ArchiveIndex) Add(entry *ArchiveEntry) {
+	a.Entries[entry.Name] = entry
+	if _, ok := a.Blobs[entry.Descriptor.Digest]; !ok {
+		a.Blobs[entry.Descriptor.Digest] = entry
+	}
+}
+
+// AddLink records a symbolic link of the archive. Legacy archives link layers
+// shared by several images to a single copy.
+func (a *ArchiveIndex) AddLink(header *tar.Header) {
+	a.links[header.Name] = path.Join(path.Dir(header.Name), header.Linkname)
+}
+
+// Decode resolves the links of the archive and decodes its manifest.json and,
+// when present, its index.json. It must be called once every file has been added.
+func (a *ArchiveIndex) Decode() error {
+	for name, target := range a.links {
+		if entry, ok := a.Entries[target]; ok {
+			a.Entries[name] = entry
+		}
+	}
+	manifest, ok := a.Entries[archiveManifestFile]
+	if !ok || manifest.Data == nil {
+		return errors.New("image archive has no manifest.json")
+	}
+	if err := json.Unmarshal(manifest.Data, &a.Manifest); err != nil {
+		return errors.Wrap(err, "failed to decode archive manifest.json")
+	}
+	if index, found := a.Entries[archiveIndexFile]; found && index.Data != nil {
+		if err := json.Unmarshal(index.Data, &a.Index); err != nil {
+			return errors.Wrap(err, "failed to decode archive index.json")
+		}
+	}
+	return nil
+}
+
+// IndexArchive reads a `docker save` stream and indexes its files.
+func IndexArchive(r io.Reader) (*ArchiveIndex, error) {
+	index := NewArchiveIndex()
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			index.AddLink(header)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := ReadArchiveEntry(tr, header, nil)
+		if err != nil {
+			return nil, err
+		}
+		index.Add(entry)
+	}
+	if err := index.Decode(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// ArchiveImage is an image of an archive together with the OCI manifest describing it.
+type ArchiveImage struct {
+	ArchiveManifestEntry
+	Manifest   []byte
+	Descriptor ocispec.Descriptor   // Descriptor of Manifest
+	Blobs      []ocispec.Descriptor // Config followed by the layers
+	Generated  bool                 // Whether Manifest was generated rather than read from the archive
+}
+
+// Image returns the image of a manifest.json entry. Images saved with an OCI
+// manifest, as written by Docker 25 and later, keep that manifest so that their
+// digest does not change; a manifest is generated for the others.
+func (a *ArchiveIndex) Image(entry ArchiveManifestEntry) (*ArchiveImage, error) {
+	config, ok := a.Entries[entry.Config]
+	if !ok {
+		return nil, errors.Errorf("image config %s is missing from the archive", entry.Config)
+	}
+	for _, desc := range a.Index.Manifests {
+		if desc.MediaType != ocispec.MediaTypeImageManifest && desc.MediaType != mediaTypeDockerManifest {
+			continue
+		}
+		blob, found := a.Blobs[desc.Digest]
+		if !found || blob.Data == nil {
+			continue
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(blob.Data, &manifest); err != nil || manifest.Config.Digest != config.Descriptor.Digest {
+			continue
+		}
+		return &ArchiveImage{
+			ArchiveManifestEntry: entry,
+			Manifest:             blob.Data,
+			Descriptor:           ocispec.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size},
+			Blobs:                append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...),
+		}, nil
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: manifestSchemaVersion},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    config.Descriptor.Digest,
+			Size:      config.Descriptor.Size,
+		},
+	}
+	for _, name := range entry.Layers {
+		layer, found := a.Entries[name]
+		if !found {
+			return nil, errors.Errorf("layer %s is missing from the archive", name)
+		}
+		manifest.Layers = append(manifest.Layers, ocispec.Descriptor{
+			MediaType: layerMediaType(layer.Compression),
+			Digest:    layer.Descriptor.Digest,
+			Size:      layer.Descriptor.Size,
+		})
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode image manifest")
+	}
+	return &ArchiveImage{
+		ArchiveManifestEntry: entry,
+		Manifest:             data,
+		Descriptor: ocispec.Descriptor{
+			MediaType: manifest.MediaType,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		},
+		Blobs:     append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...),
+		Generated: true,
+	}, nil
+}
+
+// FindImage returns the image of the archive whose config has the digest id,
+// which Docker uses as image ID, or else the image tagged ref. Images of the
+// containerd image store are identified by the digest of their index instead.
+func (a *ArchiveIndex) FindImage(id, ref string) (*ArchiveImage, error) {
+	for _, entry := range a.Manifest {
+		if config, ok := a.Entries[entry.Config]; ok && config.Descriptor.Digest.String() == id {
+			return a.Image(entry)
+		}
+	}
+	normalized := NormalizeReference(ref)
+	for _, entry := range a.Manifest {
+		for _, tag := range entry.RepoTags {
+			if NormalizeReference(tag) == normalized {
+				return a.Image(entry)
+			}
+		}
+	}
+	return nil, errors.Errorf("image %s (%s) is missing from the archive", ref, id)
+}
+
+func layerMediaType(compression Compression) string {
+	switch compression {
+	case CompressionGzip:
+		return ocispec.MediaTypeImageLayerGzip
+	case CompressionZstd:
+		return ocispec.MediaTypeImageLayerZstd
+	case CompressionNone:
+		return ocispec.MediaTypeImageLayer
+	default:
+		return ocispec.MediaTypeImageLayer
+	}
+}
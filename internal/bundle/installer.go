@@ -0,0 +1,202 @@
+package bundle
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// installerScript is the header of the self-extracting installer. It must end
+// with the payload marker line, directly followed by the tar payload.
+var installerScript = template.Must(template.New("installer").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(`#!/bin/sh
+# Self-extracting installer of the {{.Project}} bundle, tag {{.Tag}}, written by docker-deliver {{.Version}}.
+# It extracts the bundle, verifies its checksums, loads the images and starts the project.
+set -eu
+
+usage() {
+	echo "Usage: sh $0 [--dir DIR] [--extract-only]"
+	echo "  --dir DIR        Directory to extract the bundle to (default: ./{{.Project}})"
+	echo "  --extract-only   Extract and verify the bundle without loading images or starting the project"
+}
+
+fail() {
+	echo "install: $*" >&2
+	exit 1
+}
+
+dir={{quote (print "./" .Project)}}
+install=1
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--dir)
+		[ $# -ge 2 ] || { usage >&2; exit 2; }
+		dir=$2
+		shift 2
+		;;
+	--extract-only)
+		install=0
+		shift
+		;;
+	-h | --help)
+		usage
+		exit 0
+		;;
+	*)
+		usage >&2
+		exit 2
+		;;
+	esac
+done
+
+if [ "$install" = 1 ]; then
+	command -v docker >/dev/null 2>&1 || fail "docker is not installed"
+	if docker compose version >/dev/null 2>&1; then
+		compose="docker compose"
+	elif command -v docker-compose >/dev/null 2>&1; then
+		compose="docker-compose"
+	else
+		fail "Docker Compose is not installed"
+	fi
+fi
+
+line=$(awk '/^{{.Marker}}$/ { print NR + 1; exit }' "$0")
+[ -n "$line" ] || fail "no payload found in $0"
+mkdir -p "$dir"
+echo "Extracting bundle to $dir"
+tail -n +"$line" "$0" | tar -xf - -C "$dir" || fail "failed to extract the bundle"
+cd "$dir"
+
+echo "Verifying checksums"
+if command -v sha256sum >/dev/null 2>&1; then
+	sum="sha256sum"
+else
+	sum="shasum -a 256"
+fi
+$sum -c <<'CHECKSUMS' || fail "checksums do not match, the installer is damaged"
+{{range .Files}}{{.SHA256}}  {{.Name}}
+{{end}}CHECKSUMS
+
+if [ "$install" = 0 ]; then
+	echo "Extracted bundle to $dir"
+	exit 0
+fi
+{{range .Loads}}
+echo "Loading images from "{{quote .Name}}
+{{.Command}} || fail "failed to load images from "{{quote .Name}}
+{{end}}
+echo "Starting project {{.Project}}"
+$compose -f {{quote .ComposeFile}} up -d --remove-orphans
+echo "Started project {{.Project}} from $dir"
+exit 0
+{{.Marker}}
+`))
+
+const installerMarker = "__PAYLOAD__"
+
+type installerData struct {
+	*Manifest
+	Marker      string
+	ComposeFile string
+	Loads       []installerLoad
+}
+
+// installerLoad is a `docker load` of an image archive of the bundle.
+type installerLoad struct {
+	Name    string
+	Command string
+}
+
+// WriteInstaller writes a self-extracting installer of the bundle in dir to w:
+// a POSIX shell script followed by a tar payload holding the manifest, its signature and every
+// file it records. The script needs nothing on the target host besides Docker,
+// Docker Compose and the tools of a base system, and verifies the extracted
+// files against the checksums of the manifest before loading the images.
+func WriteInstaller(dir string, w io.Writer) error {
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return err
+	}
+	data := installerData{Manifest: manifest, Marker: installerMarker, ComposeFile: ComposeFile}
+	if len(manifest.Services) > 0 && !manifest.Pushed() {
+		for _, archive := range manifest.ImageArchives() {
+			data.Loads = append(data.Loads, installerLoad{Name: archive.Name, Command: loadCommand(archive)})
+		}
+	}
+	if err = installerScript.Execute(w, data); err != nil {
+		return errors.Wrap(err, "failed to write installer script")
+	}
+
+	tw := tar.NewWriter(w)
+	dirs := make(map[string]bool)
+	names := []string{ManifestFile}
+	if _, statErr := os.Stat(filepath.Join(dir, SignatureFile)); statErr == nil {
+		names = append(names, SignatureFile)
+	}
+	for _, file := range manifest.Files {
+		names = append(names, file.Name)
+	}
+	for _, name := range names {
+		if err = addPayloadDirs(tw, path.Dir(name), dirs); err != nil {
+			return err
+		}
+		if err = addPayloadFile(tw, dir, name); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(tw.Close(), "failed to write installer payload")
+}
+
+// loadCommand returns the shell command loading an image archive into Docker.
+func loadCommand(archive Archive) string {
+	switch {
+	case archive.Directory():
+		return "tar -cf - -C " + shellQuote(archive.Name) + " . | docker load"
+	case len(archive.Parts) > 0:
+		parts := make([]string, 0, len(archive.Parts))
+		for _, part := range archive.Parts {
+			parts = append(parts, shellQuote(part))
+		}
+		return "cat " + strings.Join(parts, " ") + " | docker load"
+	default:
+		return "docker load -i " + shellQuote(archive.Name)
+	}
+}
+
+// addPayloadDirs adds the directory dir and its parents to the payload, once.
+func addPayloadDirs(tw *tar.Writer, dir string, added map[string]bool) error {
+	if dir == "." || dir == "/" || added[dir] {
+		return nil
+	}
+	if err := addPayloadDirs(tw, path.Dir(dir), added); err != nil {
+		return err
+	}
+	added[dir] = true
+	header := &tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: dirPermissions}
+	return errors.Wrapf(tw.WriteHeader(header), "failed to write %s to installer payload", dir)
+}
+
+func addPayloadFile(tw *tar.Writer, dir, name string) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", name)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %s", name)
+	}
+	return writeTarFile(tw, name, info.Size(), file)
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
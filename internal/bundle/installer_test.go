@@ -0,0 +1,122 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// splitInstaller returns the script of an installer and the files of its payload.
+func splitInstaller(t *testing.T, installer []byte) (string, map[string][]byte) {
+	t.Helper()
+	const marker = "\n__PAYLOAD__\n"
+	index := bytes.Index(installer, []byte(marker))
+	require.NotEqual(t, -1, index, "no payload marker")
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(bytes.NewReader(installer[index+len(marker):]))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag == tar.TypeDir {
+			files[header.Name] = nil
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+	return string(installer[:index+len(marker)]), files
+}
+
+func TestWriteInstaller(t *testing.T) {
+	archive := buildImageArchive(t, "web:v1", "postgres:13")
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  archive,
+	}, func(m *bundle.Manifest) {
+		m.Services = map[string]bundle.Service{"web": {Image: "web:v1"}, "db": {Image: "postgres:13"}}
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, bundle.WriteInstaller(dir, &out))
+	script, files := splitInstaller(t, out.Bytes())
+
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("#!/bin/sh\n")))
+	assert.Contains(t, script, "docker load -i 'images.tar'")
+	assert.Contains(t, script, "-f 'docker-compose.generated.yaml' up -d --remove-orphans")
+	manifest, err := bundle.ReadManifest(dir + "/" + bundle.ManifestFile)
+	require.NoError(t, err)
+	for _, file := range manifest.Files {
+		assert.Contains(t, script, file.SHA256+"  "+file.Name+"\n")
+	}
+
+	assert.Equal(t, archive, files[bundle.ImagesFile])
+	assert.Equal(t, []byte(testCompose), files[bundle.ComposeFile])
+	assert.Contains(t, files, bundle.ManifestFile)
+}
+
+func TestWriteInstaller_PerServiceArchives(t *testing.T) {
+	web := bundle.Archive{Name: "images/web.tar", Format: bundle.FormatOCIArchive, Compression: bundle.CompressionNone}
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		web.Name:           buildImageArchive(t, "web:v1"),
+	}, func(m *bundle.Manifest) {
+		m.Services = map[string]bundle.Service{"web": {Image: "web:v1", Archive: &web}}
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, bundle.WriteInstaller(dir, &out))
+	script, files := splitInstaller(t, out.Bytes())
+
+	assert.Contains(t, script, "docker load -i 'images/web.tar'")
+	assert.NotContains(t, script, "'images.tar'")
+	assert.Contains(t, files, "images/")
+	assert.Contains(t, files, web.Name)
+}
+
+func TestWriteInstaller_PushedBundle(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+	}, func(m *bundle.Manifest) {
+		m.Services = map[string]bundle.Service{"web": {Image: "registry.site.local:5000/web:v1"}}
+		m.Registry = "registry.site.local:5000"
+	})
+
+	var out bytes.Buffer
+	require.NoError(t, bundle.WriteInstaller(dir, &out))
+	script, _ := splitInstaller(t, out.Bytes())
+	assert.NotContains(t, script, "docker load")
+}
+
+func TestWriteInstaller_MissingFile(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+	}, func(m *bundle.Manifest) {
+		m.Files = append(m.Files, bundle.File{Name: bundle.ImagesFile})
+	})
+
+	err := bundle.WriteInstaller(dir, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open images.tar")
+}
+
+func TestWriteInstaller_Signed(t *testing.T) {
+	key, _ := newSigningKey(t)
+	dir := writeSignedBundle(t, key)
+
+	var out bytes.Buffer
+	require.NoError(t, bundle.WriteInstaller(dir, &out))
+	_, files := splitInstaller(t, out.Bytes())
+	assert.Contains(t, files, bundle.SignatureFile)
+}
@@ -0,0 +1,66 @@
+package bundle
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// InventorySchemaVersion is the current version of the inventory format.
+const InventorySchemaVersion = 1
+
+// Inventory lists the images present on a destination host. Saving against an
+// inventory leaves out every layer the host already holds.
+type Inventory struct {
+	SchemaVersion int              `json:"schema_version"`
+	Host          string           `json:"host"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Images        []InventoryImage `json:"images"`
+}
+
+// InventoryImage describes a single image present on the host.
+type InventoryImage struct {
+	ID       string   `json:"id"`
+	RepoTags []string `json:"repo_tags,omitempty"`
+	Layers   []string `json:"layers"`
+}
+
+// Name returns a description of the inventory used in logs and manifests.
+func (inv *Inventory) Name() string {
+	return "inventory of " + inv.Host
+}
+
+// Encode writes the inventory as indented JSON.
+func (inv *Inventory) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(inv); err != nil {
+		return errors.Wrap(err, "failed to encode inventory")
+	}
+	return nil
+}
+
+// DecodeInventory reads an inventory from r.
+func DecodeInventory(r io.Reader) (*Inventory, error) {
+	var inv Inventory
+	if err := json.NewDecoder(r).Decode(&inv); err != nil {
+		return nil, errors.Wrap(err, "failed to decode inventory")
+	}
+	if inv.SchemaVersion > InventorySchemaVersion {
+		return nil, errors.Errorf("unsupported inventory schema version %d", inv.SchemaVersion)
+	}
+	return &inv, nil
+}
+
+// ReadInventory reads the inventory stored at path.
+func ReadInventory(path string) (*Inventory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open inventory")
+	}
+	defer file.Close()
+	return DecodeInventory(file)
+}
@@ -0,0 +1,61 @@
+package bundle_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func newTestInventory() *bundle.Inventory {
+	return &bundle.Inventory{
+		SchemaVersion: bundle.InventorySchemaVersion,
+		Host:          "site-a",
+		CreatedAt:     time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Images: []bundle.InventoryImage{
+			{ID: "sha256:1111", RepoTags: []string{"web:v1"}, Layers: []string{layerA, layerB}},
+		},
+	}
+}
+
+func TestInventory_EncodeDecodeRoundTrip(t *testing.T) {
+	inventory := newTestInventory()
+
+	var buf bytes.Buffer
+	require.NoError(t, inventory.Encode(&buf))
+	assert.Contains(t, buf.String(), `"repo_tags"`)
+
+	decoded, err := bundle.DecodeInventory(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, inventory, decoded)
+	assert.Equal(t, "inventory of site-a", decoded.Name())
+}
+
+func TestDecodeInventory_UnsupportedSchema(t *testing.T) {
+	_, err := bundle.DecodeInventory(strings.NewReader(`{"schema_version": 99}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported inventory schema version")
+}
+
+func TestReadInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, newTestInventory().Encode(file))
+	require.NoError(t, file.Close())
+
+	inventory, err := bundle.ReadInventory(path)
+	require.NoError(t, err)
+	assert.Len(t, inventory.Images, 1)
+
+	_, err = bundle.ReadInventory(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open inventory")
+}
@@ -0,0 +1,194 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PassphraseEnv is the environment variable read for the passphrase when no passphrase file is given.
+	PassphraseEnv = "DOCKER_DELIVER_PASSPHRASE"
+
+	pemPrivateKey = "PRIVATE KEY"
+	pemPublicKey  = "PUBLIC KEY"
+)
+
+// LoadKeys reads the public keys of recipients, the private keys of identities
+// and the passphrase in passphraseFile, falling back to PassphraseEnv. A
+// trailing newline of the passphrase file is ignored.
+func LoadKeys(recipients, identities []string, passphraseFile string) (*Keys, error) {
+	keys := &Keys{Passphrase: []byte(os.Getenv(PassphraseEnv))}
+	if passphraseFile != "" {
+		data, err := os.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read passphrase file")
+		}
+		keys.Passphrase = bytes.TrimRight(data, "\r\n")
+	}
+	for _, path := range recipients {
+		recipient, err := ReadRecipient(path)
+		if err != nil {
+			return nil, err
+		}
+		keys.Recipients = append(keys.Recipients, recipient)
+	}
+	for _, path := range identities {
+		identity, err := ReadIdentity(path)
+		if err != nil {
+			return nil, err
+		}
+		keys.Identities = append(keys.Identities, identity)
+	}
+	return keys, nil
+}
+
+// GenerateX25519Key generates an X25519 key pair to encrypt bundles to.
+func GenerateX25519Key() (*age.X25519Identity, error) {
+	key, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate X25519 key")
+	}
+	return key, nil
+}
+
+// GenerateEd25519Key generates an ed25519 key pair to sign bundles with.
+func GenerateEd25519Key() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate ed25519 key")
+	}
+	return key, nil
+}
+
+// MarshalPrivateKey encodes a private key as a PKCS #8 PEM block.
+func MarshalPrivateKey(key any) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode private key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+}
+
+// MarshalPublicKey encodes a public key as a PKIX PEM block.
+func MarshalPublicKey(key any) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode public key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+// MarshalIdentity encodes an X25519 private key as an age identity file, in
+// the format written by age-keygen.
+func MarshalIdentity(identity *age.X25519Identity) []byte {
+	return []byte("# public key: " + identity.Recipient().String() + "\n" + identity.String() + "\n")
+}
+
+// MarshalRecipient encodes an X25519 public key as an age recipients file.
+func MarshalRecipient(recipient *age.X25519Recipient) []byte {
+	return []byte(recipient.String() + "\n")
+}
+
+// ReadRecipient reads the X25519 public key in the age recipients file at
+// path, as written by `docker-deliver keygen` or age-keygen.
+func ReadRecipient(path string) (*age.X25519Recipient, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+	defer file.Close()
+	recipients, err := age.ParseRecipients(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse public key %s", path)
+	}
+	for _, recipient := range recipients {
+		if x25519, ok := recipient.(*age.X25519Recipient); ok {
+			return x25519, nil
+		}
+	}
+	return nil, errors.Errorf("public key %s is not an X25519 key", path)
+}
+
+// ReadIdentity reads the X25519 private key in the age identity file at path,
+// as written by `docker-deliver keygen` or age-keygen.
+func ReadIdentity(path string) (*age.X25519Identity, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+	defer file.Close()
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key %s", path)
+	}
+	for _, identity := range identities {
+		if x25519, ok := identity.(*age.X25519Identity); ok {
+			return x25519, nil
+		}
+	}
+	return nil, errors.Errorf("private key %s is not an X25519 key", path)
+}
+
+// ReadSigningKey reads the ed25519 private key in the PEM file at path, as
+// written by `docker-deliver keygen --type ed25519` or `openssl genpkey -algorithm ed25519`.
+func ReadSigningKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, pemPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse private key %s", path)
+	}
+	signingKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("private key %s is not an ed25519 key", path)
+	}
+	return signingKey, nil
+}
+
+// LoadTrustedKeys reads the ed25519 public keys in the PEM files at paths.
+func LoadTrustedKeys(paths []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(paths))
+	for _, path := range paths {
+		der, err := readPEM(path, pemPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse public key %s", path)
+		}
+		trusted, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("public key %s is not an ed25519 key", path)
+		}
+		keys = append(keys, trusted)
+	}
+	return keys, nil
+}
+
+// readPEM returns the contents of the first PEM block of type blockType in the file at path.
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key")
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("no %s found in %s", blockType, path)
+		}
+		if block.Type == blockType {
+			return block.Bytes, nil
+		}
+	}
+}
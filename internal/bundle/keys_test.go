@@ -0,0 +1,85 @@
+package bundle_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func writeKeyPair(t *testing.T, dir, name string, private, public any) (string, string) {
+	t.Helper()
+	privatePEM, err := bundle.MarshalPrivateKey(private)
+	require.NoError(t, err)
+	publicPEM, err := bundle.MarshalPublicKey(public)
+	require.NoError(t, err)
+	privatePath, publicPath := filepath.Join(dir, name+".key"), filepath.Join(dir, name+".pub")
+	require.NoError(t, os.WriteFile(privatePath, privatePEM, 0600))
+	require.NoError(t, os.WriteFile(publicPath, publicPEM, 0600))
+	return privatePath, publicPath
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t)
+	privatePath, publicPath := filepath.Join(dir, "ops.key"), filepath.Join(dir, "ops.pub")
+	require.NoError(t, os.WriteFile(privatePath, bundle.MarshalIdentity(key), 0600))
+	require.NoError(t, os.WriteFile(publicPath, bundle.MarshalRecipient(key.Recipient()), 0600))
+	passphrasePath := filepath.Join(dir, "passphrase")
+	require.NoError(t, os.WriteFile(passphrasePath, []byte("secret\n"), 0600))
+	t.Setenv(bundle.PassphraseEnv, "ignored")
+
+	keys, err := bundle.LoadKeys([]string{publicPath}, []string{privatePath}, passphrasePath)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), keys.Passphrase)
+	require.Len(t, keys.Recipients, 1)
+	assert.Equal(t, key.Recipient().String(), keys.Recipients[0].String())
+	require.Len(t, keys.Identities, 1)
+	assert.Equal(t, key.String(), keys.Identities[0].String())
+}
+
+// TestReadIdentity_AgeKeygen reads a key file as written by age-keygen.
+func TestReadIdentity_AgeKeygen(t *testing.T) {
+	key := newTestKey(t)
+	path := filepath.Join(t.TempDir(), "site.key")
+	keyFile := "# created: 2026-10-16T08:00:00Z\n# public key: " + key.Recipient().String() + "\n" + key.String() + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(keyFile), 0600))
+
+	identity, err := bundle.ReadIdentity(path)
+	require.NoError(t, err)
+	assert.Equal(t, key.Recipient().String(), identity.Recipient().String())
+}
+
+func TestLoadKeys_PassphraseEnv(t *testing.T) {
+	t.Setenv(bundle.PassphraseEnv, "from-env")
+
+	keys, err := bundle.LoadKeys(nil, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from-env"), keys.Passphrase)
+}
+
+func TestReadRecipient_WrongKeyType(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privatePath, publicPath := writeKeyPair(t, t.TempDir(), "signing", private, public)
+
+	_, err = bundle.ReadRecipient(publicPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse public key")
+	_, err = bundle.ReadIdentity(privatePath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse private key")
+
+	key := newTestKey(t)
+	recipientPath := filepath.Join(t.TempDir(), "site.pub")
+	require.NoError(t, os.WriteFile(recipientPath, bundle.MarshalRecipient(key.Recipient()), 0600))
+	_, err = bundle.ReadIdentity(recipientPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse private key")
+}
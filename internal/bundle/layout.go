@@ -0,0 +1,331 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	dirPermissions  = 0o755
+	filePermissions = 0o644
+)
+
+// LayoutImage is an image indexed in an OCI image layout.
+type LayoutImage struct {
+	Ref         string            // Image name, such as "web:v1"
+	ID          string            // Image ID, the digest of the image config
+	Annotations map[string]string // Added to the index entry of the image
+}
+
+// WriteOCILayout converts a `docker save` stream into an OCI image layout in
+// dir. index.json holds one entry per image, annotated with its name and the
+// annotations of the image, so a single image may appear several times. A
+// manifest.json is written as well so that `docker load` accepts the layout.
+// The checksum records of the files written are returned, named relative to dir.
+func WriteOCILayout(src io.Reader, dir string, images []LayoutImage) ([]File, error) {
+	blobs := filepath.Join(dir, ocispec.ImageBlobsDir, digest.Canonical.String())
+	if err := os.MkdirAll(blobs, dirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create OCI layout directory")
+	}
+	archive, err := storeBlobs(src, blobs)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newLayoutWriter(archive, blobs)
+	for _, img := range images {
+		if err = w.addArchived(img); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = removeUnreferenced(blobs, w.referenced); err != nil {
+		return nil, err
+	}
+	if err = writeJSON(filepath.Join(dir, ocispec.ImageLayoutFile), ocispec.ImageLayout{
+		Version: ocispec.ImageLayoutVersion,
+	}); err != nil {
+		return nil, err
+	}
+	if err = writeJSON(filepath.Join(dir, ocispec.ImageIndexFile), w.index); err != nil {
+		return nil, err
+	}
+	if err = writeJSON(filepath.Join(dir, archiveManifestFile), w.entries); err != nil {
+		return nil, err
+	}
+	return layoutFiles(dir, w.referenced)
+}
+
+// layoutWriter builds the index.json and manifest.json of an OCI image layout.
+type layoutWriter struct {
+	archive    *ArchiveIndex
+	blobs      string // Blob directory
+	index      ocispec.Index
+	entries    []ArchiveManifestEntry
+	entryIndex map[string]int          // Position in entries by image ID
+	referenced map[digest.Digest]int64 // Size of every blob of the indexed images
+}
+
+func newLayoutWriter(archive *ArchiveIndex, blobs string) *layoutWriter {
+	return &layoutWriter{
+		archive: archive,
+		blobs:   blobs,
+		index: ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: manifestSchemaVersion},
+			MediaType: ocispec.MediaTypeImageIndex,
+		},
+		entryIndex: make(map[string]int),
+		referenced: make(map[digest.Digest]int64),
+	}
+}
+
+// addArchived indexes an image of the archive, storing its manifest when it was generated.
+func (w *layoutWriter) addArchived(img LayoutImage) error {
+	archived, err := w.archive.FindImage(img.ID, img.Ref)
+	if err != nil {
+		return err
+	}
+	if archived.Generated {
+		name := blobPath(w.blobs, archived.Descriptor.Digest)
+		if err = os.WriteFile(name, archived.Manifest, filePermissions); err != nil {
+			return errors.Wrap(err, "failed to write image manifest")
+		}
+	}
+	return w.add(img, archived.Descriptor, archived.Blobs)
+}
+
+// add indexes the image with the manifest desc referencing blobs, its config followed by its layers.
+func (w *layoutWriter) add(img LayoutImage, desc ocispec.Descriptor, blobs []ocispec.Descriptor) error {
+	desc, tag, err := annotate(desc, img)
+	if err != nil {
+		return err
+	}
+	w.index.Manifests = append(w.index.Manifests, desc)
+
+	w.referenced[desc.Digest] = desc.Size
+	for _, blob := range blobs {
+		w.referenced[blob.Digest] = blob.Size
+	}
+	i, seen := w.entryIndex[img.ID]
+	if !seen {
+		i = len(w.entries)
+		w.entryIndex[img.ID] = i
+		w.entries = append(w.entries, layoutManifestEntry(blobs))
+	}
+	w.entries[i].RepoTags = appendUnique(w.entries[i].RepoTags, tag)
+	return nil
+}
+
+// storeBlobs writes every file of a `docker save` stream to the blob directory
+// under its digest and indexes them.
+func storeBlobs(src io.Reader, blobs string) (*ArchiveIndex, error) {
+	index := NewArchiveIndex()
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			index.AddLink(header)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, err := storeBlob(tr, header, blobs)
+		if err != nil {
+			return nil, err
+		}
+		index.Add(entry)
+	}
+	if err := index.Decode(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func storeBlob(r io.Reader, header *tar.Header, blobs string) (*ArchiveEntry, error) {
+	file, err := os.CreateTemp(blobs, ".blob-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create blob file")
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	entry, err := ReadArchiveEntry(r, header, file)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = errors.Wrap(closeErr, "failed to write blob file")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Rename(file.Name(), blobPath(blobs, entry.Descriptor.Digest)); err != nil {
+		return nil, errors.Wrap(err, "failed to store blob")
+	}
+	return entry, nil
+}
+
+// annotate returns the index entry of an image and the tag it is known by in manifest.json.
+func annotate(desc ocispec.Descriptor, img LayoutImage) (ocispec.Descriptor, string, error) {
+	named, err := reference.ParseNormalizedNamed(img.Ref)
+	if err != nil {
+		return ocispec.Descriptor{}, "", errors.Wrapf(err, "invalid image name %q", img.Ref)
+	}
+	named = reference.TagNameOnly(named)
+	tagged, _ := named.(reference.Tagged)
+
+	annotations := map[string]string{ociImageNameAnnotation: named.String()}
+	if tagged != nil {
+		annotations[ociRefNameAnnotation] = tagged.Tag()
+	}
+	for key, value := range img.Annotations {
+		annotations[key] = value
+	}
+	desc.Annotations = annotations
+	return desc, reference.FamiliarString(named), nil
+}
+
+// layoutManifestEntry returns the manifest.json entry of an image stored in a layout.
+func layoutManifestEntry(blobs []ocispec.Descriptor) ArchiveManifestEntry {
+	entry := ArchiveManifestEntry{Config: blobName(blobs[0].Digest), Layers: make([]string, 0, len(blobs)-1)}
+	for _, layer := range blobs[1:] {
+		entry.Layers = append(entry.Layers, blobName(layer.Digest))
+	}
+	return entry
+}
+
+// removeUnreferenced deletes the stored files that are not part of an indexed
+// image, such as the metadata files of the `docker save` stream.
+func removeUnreferenced(blobs string, referenced map[digest.Digest]int64) error {
+	entries, err := os.ReadDir(blobs)
+	if err != nil {
+		return errors.Wrap(err, "failed to list blobs")
+	}
+	for _, entry := range entries {
+		if _, ok := referenced[digest.NewDigestFromEncoded(digest.Canonical, entry.Name())]; ok {
+			continue
+		}
+		if err = os.Remove(filepath.Join(blobs, entry.Name())); err != nil {
+			return errors.Wrap(err, "failed to remove unreferenced blob")
+		}
+	}
+	return nil
+}
+
+// layoutFiles returns the checksum records of the files of the layout. Blobs
+// are named after their digest and are not read again.
+func layoutFiles(dir string, blobs map[digest.Digest]int64) ([]File, error) {
+	metadata := []string{ocispec.ImageLayoutFile, ocispec.ImageIndexFile, archiveManifestFile}
+	files := make([]File, 0, len(blobs)+len(metadata))
+	for dgst, size := range blobs {
+		files = append(files, File{Name: blobName(dgst), Size: size, SHA256: dgst.Encoded()})
+	}
+	for _, name := range metadata {
+		file, err := HashFile(filepath.Join(dir, name), name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+func blobName(dgst digest.Digest) string {
+	return path.Join(ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func blobPath(blobs string, dgst digest.Digest) string {
+	return filepath.Join(blobs, dgst.Encoded())
+}
+
+func writeJSON(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode %s", filepath.Base(name))
+	}
+	if err = os.WriteFile(name, data, filePermissions); err != nil {
+		return errors.Wrapf(err, "failed to write %s", filepath.Base(name))
+	}
+	return nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// TarDirectory streams the contents of dir as a tar archive, in lexical order,
+// with names relative to dir.
+func TarDirectory(dir string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, walkErr error) error {
+			if walkErr != nil || name == dir {
+				return walkErr
+			}
+			return tarFile(tw, dir, name, entry)
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func tarFile(tw *tar.Writer, dir, name string, entry fs.DirEntry) error {
+	info, err := entry.Info()
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %s", name)
+	}
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive %s", name)
+	}
+	rel, err := filepath.Rel(dir, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive %s", name)
+	}
+	header.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err = tw.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to archive %s", name)
+	}
+	if info.IsDir() {
+		return nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", name)
+	}
+	defer file.Close()
+	if _, err = io.Copy(tw, file); err != nil {
+		return errors.Wrapf(err, "failed to archive %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const (
+	webConfig = `{"architecture":"amd64","os":"linux","tag":"web"}`
+	dbConfig  = `{"architecture":"amd64","os":"linux","tag":"db"}`
+)
+
+// legacySave returns an archive in the format written by `docker save` before
+// Docker 25. Both images share their layer, which db links to.
+func legacySave(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, content []byte) {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write(content)
+		require.NoError(t, err)
+	}
+	manifest, err := json.Marshal([]bundle.ArchiveManifestEntry{
+		{Config: "web.json", RepoTags: []string{"web:v1"}, Layers: []string{"l1/layer.tar"}},
+		{Config: "db.json", RepoTags: []string{"postgres:13"}, Layers: []string{"l2/layer.tar"}},
+	})
+	require.NoError(t, err)
+
+	add("l1/layer.tar", []byte("shared layer"))
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name: "l2/layer.tar", Typeflag: tar.TypeSymlink, Linkname: "../l1/layer.tar",
+	}))
+	add("web.json", []byte(webConfig))
+	add("db.json", []byte(dbConfig))
+	add("repositories", []byte("{}"))
+	add("manifest.json", manifest)
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, v))
+}
+
+func TestWriteOCILayout(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), bundle.LayoutDir)
+	webID := digest.FromString(webConfig).String()
+	files, err := bundle.WriteOCILayout(bytes.NewReader(legacySave(t)), dir, []bundle.LayoutImage{
+		{Ref: "web:v1", ID: webID, Annotations: map[string]string{"com.docker.compose.service": "web"}},
+		{Ref: "web:v1", ID: webID, Annotations: map[string]string{"com.docker.compose.service": "worker"}},
+		{Ref: "postgres:13", ID: digest.FromString(dbConfig).String()},
+	})
+	require.NoError(t, err)
+
+	var layout ocispec.ImageLayout
+	readJSON(t, filepath.Join(dir, ocispec.ImageLayoutFile), &layout)
+	assert.Equal(t, ocispec.ImageLayoutVersion, layout.Version)
+
+	var index ocispec.Index
+	readJSON(t, filepath.Join(dir, ocispec.ImageIndexFile), &index)
+	require.Len(t, index.Manifests, 3)
+	web := index.Manifests[0]
+	assert.Equal(t, "docker.io/library/web:v1", web.Annotations["io.containerd.image.name"])
+	assert.Equal(t, "v1", web.Annotations[ocispec.AnnotationRefName])
+	assert.Equal(t, "web", web.Annotations["com.docker.compose.service"])
+	assert.Equal(t, web.Digest, index.Manifests[1].Digest)
+	assert.Equal(t, "worker", index.Manifests[1].Annotations["com.docker.compose.service"])
+
+	var manifest ocispec.Manifest
+	readJSON(t, filepath.Join(dir, "blobs", "sha256", web.Digest.Encoded()), &manifest)
+	assert.Equal(t, digest.FromString(webConfig), manifest.Config.Digest)
+	require.Len(t, manifest.Layers, 1)
+	assert.Equal(t, digest.FromString("shared layer"), manifest.Layers[0].Digest)
+
+	var entries []bundle.ArchiveManifestEntry
+	readJSON(t, filepath.Join(dir, "manifest.json"), &entries)
+	require.Len(t, entries, 2)
+	assert.Equal(t, []string{"web:v1"}, entries[0].RepoTags)
+	assert.Equal(t, "blobs/sha256/"+digest.FromString(webConfig).Encoded(), entries[0].Config)
+
+	// Two manifests, two configs and the shared layer; repositories is dropped.
+	blobs, err := os.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+	require.NoError(t, err)
+	assert.Len(t, blobs, 5)
+	require.Len(t, files, 8)
+	for _, file := range files {
+		actual, hashErr := bundle.HashFile(filepath.Join(dir, file.Name), file.Name)
+		require.NoError(t, hashErr)
+		assert.Equal(t, actual, file)
+	}
+}
+
+func TestWriteOCILayout_MissingImage(t *testing.T) {
+	_, err := bundle.WriteOCILayout(bytes.NewReader(legacySave(t)), t.TempDir(), []bundle.LayoutImage{
+		{Ref: "api:v1", ID: digest.FromString("other").String()},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing from the archive")
+}
+
+func TestOpenArchive_Layout(t *testing.T) {
+	dir := t.TempDir()
+	_, err := bundle.WriteOCILayout(bytes.NewReader(legacySave(t)), filepath.Join(dir, bundle.LayoutDir),
+		[]bundle.LayoutImage{{Ref: "web:v1", ID: digest.FromString(webConfig).String()}})
+	require.NoError(t, err)
+
+	reader, err := bundle.OpenArchive(dir, bundle.Archive{Name: bundle.LayoutDir, Format: bundle.FormatOCI})
+	require.NoError(t, err)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	index, err := bundle.IndexArchive(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, index.Manifest, 1)
+	img, err := index.Image(index.Manifest[0])
+	require.NoError(t, err)
+	assert.False(t, img.Generated)
+
+	images, err := bundle.ArchiveImages(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, images["docker.io/library/web:v1"])
+}
+
+func TestVerify_Layout(t *testing.T) {
+	dir := t.TempDir()
+	files, err := bundle.WriteOCILayout(bytes.NewReader(legacySave(t)), filepath.Join(dir, bundle.LayoutDir),
+		[]bundle.LayoutImage{
+			{Ref: "web:v1", ID: digest.FromString(webConfig).String()},
+			{Ref: "postgres:13", ID: digest.FromString(dbConfig).String()},
+		})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ComposeFile), []byte(testCompose), 0o600))
+	compose, err := bundle.HashFile(filepath.Join(dir, bundle.ComposeFile), bundle.ComposeFile)
+	require.NoError(t, err)
+
+	manifest := &bundle.Manifest{
+		SchemaVersion: bundle.SchemaVersion,
+		Project:       "example",
+		Archive:       &bundle.Archive{Name: bundle.LayoutDir, Format: bundle.FormatOCI},
+		Files:         []bundle.File{compose},
+	}
+	for _, file := range files {
+		file.Name = bundle.LayoutDir + "/" + file.Name
+		manifest.Files = append(manifest.Files, file)
+	}
+	out, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	require.NoError(t, manifest.Encode(out))
+	require.NoError(t, out.Close())
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image web:v1").Status)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.LayoutDir, "index.json"), []byte("{}"), 0o600))
+	report, err = bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusError, findResult(t, report, "images").Status)
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := bundle.ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, bundle.FormatDocker, format)
+
+	format, err = bundle.ParseFormat("oci")
+	require.NoError(t, err)
+	assert.True(t, bundle.Archive{Format: format}.Directory())
+
+	format, err = bundle.ParseFormat("oci-archive")
+	require.NoError(t, err)
+	assert.False(t, bundle.Archive{Format: format}.Directory())
+
+	_, err = bundle.ParseFormat("tarball")
+	require.Error(t, err)
+}
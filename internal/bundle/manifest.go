@@ -0,0 +1,219 @@
+package bundle
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestFile is the name of the bundle manifest inside the output directory.
+	ManifestFile = "manifest.json"
+	// ComposeFile is the name of the generated compose file inside the output directory.
+	ComposeFile = "docker-compose.generated.yaml"
+	// ImagesFile is the name of the image archive inside the output directory.
+	ImagesFile = "images.tar"
+	// LayoutDir is the name of the OCI image layout directory inside the output directory.
+	LayoutDir = "oci"
+	// ServiceArchiveDir is the directory holding the per-service image archives inside the output directory.
+	ServiceArchiveDir = "images"
+	// InstallerFile is the name of the self-extracting installer inside the output directory.
+	InstallerFile = "install.sh"
+	// SignatureFile is the name of the detached signature of the manifest inside the output directory.
+	SignatureFile = "manifest.sig"
+
+	// SchemaVersion is the current version of the manifest format.
+	SchemaVersion = 1
+)
+
+// Manifest describes every artifact delivered in a bundle.
+type Manifest struct {
+	SchemaVersion int                `json:"schema_version"`
+	Version       string             `json:"docker_deliver_version"`
+	Project       string             `json:"project"`
+	Tag           string             `json:"tag"`
+	CreatedAt     time.Time          `json:"created_at"`
+	ComposeFiles  []string           `json:"compose_files"`
+	Services      map[string]Service `json:"services"`
+	Archive       *Archive           `json:"archive,omitempty"`
+	Registry      string             `json:"registry,omitempty"` // Registry the images were pushed to instead of an archive
+	Encryption    *Encryption        `json:"encryption,omitempty"`
+	Files         []File             `json:"files"`
+	// VulnerabilityReport is the report of the scan of the service images, see VulnerabilityReportFile.
+	VulnerabilityReport string `json:"vulnerability_report,omitempty"`
+	// PolicyReport is the report of the policy check of the services.
+	PolicyReport string `json:"policy_report,omitempty"`
+	// Base names the delivery or host inventory a delta archive builds on, and ExcludedLayers
+	// the diff IDs of the layers left out of the archive because the target already holds them.
+	Base           string   `json:"base,omitempty"`
+	ExcludedLayers []string `json:"excluded_layers,omitempty"`
+}
+
+// Archive describes the image archive of a bundle.
+type Archive struct {
+	Name        string      `json:"name"`
+	Format      Format      `json:"format,omitempty"` // Empty in bundles written before OCI layouts were supported
+	Compression Compression `json:"compression"`
+	Size        int64       `json:"size"`            // Uncompressed size in bytes
+	Parts       []string    `json:"parts,omitempty"` // Ordered part files when the archive is split
+	Encrypted   bool        `json:"encrypted,omitempty"`
+}
+
+// Encryption describes the keys the image archives and the compose file of an
+// encrypted bundle are encrypted to. Encrypted files carry EncryptedExtension.
+type Encryption struct {
+	Passphrase bool     `json:"passphrase,omitempty"` // Files can be decrypted with a passphrase
+	Recipients []string `json:"recipients,omitempty"` // Key IDs of the X25519 recipients, see KeyID
+}
+
+// Format is the layout of the image archive.
+type Format string
+
+const (
+	// FormatDocker is the tar stream written by `docker save`.
+	FormatDocker Format = "docker-archive"
+	// FormatOCI is an OCI image layout directory.
+	FormatOCI Format = "oci"
+	// FormatOCIArchive is an OCI image layout stored in a tar archive.
+	FormatOCIArchive Format = "oci-archive"
+)
+
+// ParseFormat validates an archive format name. An empty name selects the `docker save` format.
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatDocker:
+		return FormatDocker, nil
+	case FormatOCI, FormatOCIArchive:
+		return Format(name), nil
+	default:
+		return "", errors.Errorf("unsupported archive format %q (expected docker-archive, oci or oci-archive)", name)
+	}
+}
+
+// Directory reports whether the archive is a directory rather than a tar file.
+func (a Archive) Directory() bool {
+	return a.Format == FormatOCI
+}
+
+// FileNames returns the files holding the archive: its parts when split, otherwise the archive itself.
+func (a Archive) FileNames() []string {
+	if len(a.Parts) > 0 {
+		return a.Parts
+	}
+	return []string{a.Name}
+}
+
+// Service describes the image delivered for a single compose service.
+type Service struct {
+	Image        string   `json:"image"`
+	ImageID      string   `json:"image_id"`
+	RepoDigests  []string `json:"repo_digests,omitempty"`
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	Size         int64    `json:"size"`
+	Layers       []string `json:"layers"`
+	Archive      *Archive `json:"archive,omitempty"`       // Per-service image archive holding only this image
+	SharedLayers []string `json:"shared_layers,omitempty"` // Diff IDs of the layers also carried by other services
+	SBOM         string   `json:"sbom,omitempty"`          // Software bill of materials of the image, see SBOMDir
+	Provenance   string   `json:"provenance,omitempty"`    // Attestation of the build of the image, see ProvenanceDir
+}
+
+// ImageArchive returns the image archive of the bundle. Bundles that do not
+// record their archive contain an uncompressed images.tar.
+func (m *Manifest) ImageArchive() Archive {
+	if m.Archive != nil {
+		return *m.Archive
+	}
+	return Archive{Name: ImagesFile, Compression: CompressionNone}
+}
+
+// PerServiceOnly reports whether the images of the bundle are only stored in
+// per-service archives, without a combined image archive.
+func (m *Manifest) PerServiceOnly() bool {
+	if m.Archive != nil || m.Pushed() {
+		return false
+	}
+	for _, service := range m.Services {
+		if service.Archive != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ImageArchives returns the archives holding the images of the bundle: the
+// combined image archive or, when there is none, the archive of every service
+// in service order.
+func (m *Manifest) ImageArchives() []Archive {
+	if !m.PerServiceOnly() {
+		return []Archive{m.ImageArchive()}
+	}
+	names := make([]string, 0, len(m.Services))
+	for name, service := range m.Services {
+		if service.Archive != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	archives := make([]Archive, 0, len(names))
+	for _, name := range names {
+		archives = append(archives, *m.Services[name].Archive)
+	}
+	return archives
+}
+
+// ComposeFileName returns the name of the generated compose file of the bundle,
+// which carries EncryptedExtension when the bundle is encrypted.
+func (m *Manifest) ComposeFileName() string {
+	if m.Encryption != nil {
+		return ComposeFile + EncryptedExtension
+	}
+	return ComposeFile
+}
+
+// Name returns the project and tag the bundle was delivered as.
+func (m *Manifest) Name() string {
+	return m.Project + ":" + m.Tag
+}
+
+// Pushed reports whether the images of the bundle were pushed to a registry
+// instead of being written to an image archive.
+func (m *Manifest) Pushed() bool {
+	return m.Registry != ""
+}
+
+// Encode writes the manifest as indented JSON.
+func (m *Manifest) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(m); err != nil {
+		return errors.Wrap(err, "failed to encode manifest")
+	}
+	return nil
+}
+
+// DecodeManifest reads a manifest from r.
+func DecodeManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	if m.SchemaVersion > SchemaVersion {
+		return nil, errors.Errorf("unsupported manifest schema version %d", m.SchemaVersion)
+	}
+	return &m, nil
+}
+
+// ReadManifest reads the manifest stored at path.
+func ReadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open manifest")
+	}
+	defer file.Close()
+	return DecodeManifest(file)
+}
@@ -0,0 +1,89 @@
+package bundle_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func newTestManifest() *bundle.Manifest {
+	return &bundle.Manifest{
+		SchemaVersion: bundle.SchemaVersion,
+		Version:       "v1.2.3",
+		Project:       "example",
+		Tag:           "abc123",
+		CreatedAt:     time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		ComposeFiles:  []string{"docker-compose.base.yaml", "docker-compose.extend.yaml"},
+		Services: map[string]bundle.Service{
+			"web": {
+				Image:        "web:abc123",
+				ImageID:      "sha256:1111",
+				RepoDigests:  []string{"registry.local/web@sha256:2222"},
+				Architecture: "amd64",
+				OS:           "linux",
+				Size:         1024,
+				Layers:       []string{"sha256:aaaa", "sha256:bbbb"},
+			},
+		},
+	}
+}
+
+func TestManifest_EncodeDecodeRoundTrip(t *testing.T) {
+	manifest := newTestManifest()
+
+	var buf bytes.Buffer
+	require.NoError(t, manifest.Encode(&buf))
+
+	decoded, err := bundle.DecodeManifest(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, decoded)
+}
+
+func TestManifest_EncodeFieldNames(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newTestManifest().Encode(&buf))
+
+	for _, field := range []string{
+		`"schema_version"`, `"docker_deliver_version"`, `"compose_files"`,
+		`"image_id"`, `"repo_digests"`, `"architecture"`, `"layers"`,
+	} {
+		assert.Contains(t, buf.String(), field)
+	}
+}
+
+func TestDecodeManifest_UnsupportedSchema(t *testing.T) {
+	_, err := bundle.DecodeManifest(strings.NewReader(`{"schema_version": 99}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported manifest schema version")
+}
+
+func TestDecodeManifest_InvalidJSON(t *testing.T) {
+	_, err := bundle.DecodeManifest(strings.NewReader("not json"))
+	require.Error(t, err)
+}
+
+func TestReadManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), bundle.ManifestFile)
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, newTestManifest().Encode(file))
+	require.NoError(t, file.Close())
+
+	manifest, err := bundle.ReadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "example", manifest.Project)
+	assert.Equal(t, "sha256:1111", manifest.Services["web"].ImageID)
+}
+
+func TestReadManifest_MissingFile(t *testing.T) {
+	_, err := bundle.ReadManifest(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,516 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// packageFileLimit is the largest package database file read from a layer.
+	packageFileLimit = 64 << 20
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+
+	dpkgStatusFile = "var/lib/dpkg/status"
+	dpkgStatusDir  = "var/lib/dpkg/status.d/"
+	apkInstalled   = "lib/apk/db/installed"
+	condaMetaDir   = "conda-meta"
+)
+
+// PackageType is the package manager a package was installed with.
+type PackageType string
+
+const (
+	PackageDeb   PackageType = "deb"
+	PackageApk   PackageType = "apk"
+	PackagePyPI  PackageType = "pypi"
+	PackageConda PackageType = "conda"
+)
+
+// Package is a package installed in an image.
+type Package struct {
+	Type          PackageType `json:"type"`
+	Name          string      `json:"name"`
+	Version       string      `json:"version"`
+	Arch          string      `json:"arch,omitempty"`
+	License       string      `json:"license,omitempty"`
+	Distro        string      `json:"distro,omitempty"`         // ID of the os-release of the image, for OS packages
+	DistroVersion string      `json:"distro_version,omitempty"` // VERSION_ID of the os-release of the image
+	Build         string      `json:"build,omitempty"`          // Build string of conda packages
+	Channel       string      `json:"channel,omitempty"`        // Channel of conda packages
+	Source        string      `json:"source,omitempty"`         // Source package of OS packages built from another one
+	SourceVersion string      `json:"source_version,omitempty"` // Version of the source package when it differs
+	Location      string      `json:"location"`                 // Package database file listing the package
+}
+
+// PURL returns the package URL identifying the package.
+func (p Package) PURL() string {
+	var purl string
+	var qualifiers []string
+	switch p.Type {
+	case PackageDeb, PackageApk:
+		namespace := p.Distro
+		if namespace == "" && p.Type == PackageDeb {
+			namespace = "debian"
+		} else if namespace == "" {
+			namespace = "alpine"
+		}
+		purl = "pkg:" + string(p.Type) + "/" + purlEscape(namespace) + "/" +
+			purlEscape(p.Name) + "@" + purlEscape(p.Version)
+		if p.Arch != "" {
+			qualifiers = append(qualifiers, "arch="+purlEscape(p.Arch))
+		}
+		if p.Distro != "" && p.DistroVersion != "" {
+			qualifiers = append(qualifiers, "distro="+purlEscape(p.Distro+"-"+p.DistroVersion))
+		}
+	case PackagePyPI:
+		name := strings.ToLower(strings.NewReplacer("_", "-", ".", "-").Replace(p.Name))
+		purl = "pkg:pypi/" + purlEscape(name) + "@" + purlEscape(p.Version)
+	case PackageConda:
+		purl = "pkg:conda/" + purlEscape(p.Name) + "@" + purlEscape(p.Version)
+		if p.Build != "" {
+			qualifiers = append(qualifiers, "build="+purlEscape(p.Build))
+		}
+		if p.Channel != "" {
+			qualifiers = append(qualifiers, "channel="+purlEscape(p.Channel))
+		}
+	default:
+		purl = "pkg:generic/" + purlEscape(p.Name) + "@" + purlEscape(p.Version)
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// purlEscape percent-encodes the characters that cannot appear in a package URL component.
+func purlEscape(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(".-_~+", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0xf])
+	}
+	return b.String()
+}
+
+// PackageScanner reads a `docker save` stream while indexing the packages
+// installed in its images. The stream is passed through unchanged.
+type PackageScanner struct {
+	reader io.Reader
+	writer *io.PipeWriter
+	done   chan struct{}
+	index  *PackageIndex
+	err    error
+}
+
+// NewPackageScanner starts scanning src for packages.
+func NewPackageScanner(src io.Reader) *PackageScanner {
+	reader, writer := io.Pipe()
+	s := &PackageScanner{reader: io.TeeReader(src, writer), writer: writer, done: make(chan struct{})}
+	go func() {
+		s.index, s.err = ScanPackages(reader)
+		// Keep consuming the stream so that reading it never blocks on a failed scan.
+		_, _ = io.Copy(io.Discard, reader)
+		close(s.done)
+	}()
+	return s
+}
+
+// Read reads the stream.
+func (s *PackageScanner) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close stops scanning.
+func (s *PackageScanner) Close() error {
+	return s.writer.CloseWithError(errors.New("package scan stopped"))
+}
+
+// Index returns the packages of the images of the stream. It must only be
+// called after the stream has been read to the end.
+func (s *PackageScanner) Index() (*PackageIndex, error) {
+	_ = s.writer.Close()
+	<-s.done
+	return s.index, s.err
+}
+
+// PackageIndex holds the package database files found in the layers of an image archive.
+type PackageIndex struct {
+	archive *ArchiveIndex
+	layers  map[digest.Digest]*layerFiles
+}
+
+// ScanPackages reads a `docker save` stream and indexes the package database
+// files of its layers: dpkg and apk status files, Python distribution metadata
+// and conda-meta records.
+func ScanPackages(r io.Reader) (*PackageIndex, error) {
+	index := &PackageIndex{archive: NewArchiveIndex(), layers: make(map[digest.Digest]*layerFiles)}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			index.archive.AddLink(header)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, files, err := scanEntry(tr, header)
+		if err != nil {
+			return nil, err
+		}
+		index.archive.Add(entry)
+		if files != nil {
+			index.layers[entry.Descriptor.Digest] = files
+		}
+	}
+	if err := index.archive.Decode(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// scanEntry indexes a file of the archive and, when it is a layer, the package database files it holds.
+func scanEntry(r io.Reader, header *tar.Header) (*ArchiveEntry, *layerFiles, error) {
+	reader, writer := io.Pipe()
+	result := make(chan *layerFiles, 1)
+	go func() {
+		files := readLayerFiles(reader)
+		_, _ = io.Copy(io.Discard, reader)
+		result <- files
+	}()
+	entry, err := ReadArchiveEntry(r, header, writer)
+	_ = writer.CloseWithError(err)
+	files := <-result
+	return entry, files, err
+}
+
+// Packages returns the packages installed in the image of the archive whose
+// config has the digest id, or else the image tagged ref, see ArchiveIndex.FindImage.
+func (p *PackageIndex) Packages(id, ref string) ([]Package, error) {
+	img, err := p.archive.FindImage(id, ref)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, layer := range img.Blobs[1:] {
+		if lf, ok := p.layers[layer.Digest]; ok {
+			lf.apply(files)
+		}
+	}
+	return parsePackages(files), nil
+}
+
+// layerFiles holds the package database files of a layer and the files it removes from the layers below.
+type layerFiles struct {
+	files   map[string][]byte
+	deleted []string // Paths removed by whiteout files
+	opaque  []string // Directories whose contents in the layers below are hidden
+}
+
+// readLayerFiles reads a layer tar stream, compressed or not, and returns nil when r is not a layer.
+func readLayerFiles(r io.Reader) *layerFiles {
+	br := bufio.NewReader(r)
+	const magicSize = 4
+	head, _ := br.Peek(magicSize)
+	dr, err := NewDecompressReader(br, detectCompression(head))
+	if err != nil {
+		return nil
+	}
+	defer dr.Close()
+
+	lf := &layerFiles{files: make(map[string][]byte)}
+	tr := tar.NewReader(dr)
+	for first := true; ; first = false {
+		header, nextErr := tr.Next()
+		if errors.Is(nextErr, io.EOF) {
+			return lf
+		}
+		if nextErr != nil {
+			if first {
+				return nil
+			}
+			return lf
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		base := path.Base(name)
+		switch {
+		case base == whiteoutOpaque:
+			lf.opaque = append(lf.opaque, path.Dir(name))
+		case strings.HasPrefix(base, whiteoutPrefix):
+			lf.deleted = append(lf.deleted, path.Join(path.Dir(name), strings.TrimPrefix(base, whiteoutPrefix)))
+		case header.Typeflag == tar.TypeReg && header.Size <= packageFileLimit && isPackageFile(name):
+			data, readErr := io.ReadAll(tr)
+			if readErr != nil {
+				return lf
+			}
+			lf.files[name] = data
+		}
+	}
+}
+
+// apply applies the layer on top of the package database files of the layers below.
+func (l *layerFiles) apply(files map[string][]byte) {
+	for _, dir := range l.opaque {
+		for name := range files {
+			if strings.HasPrefix(name, dir+"/") {
+				delete(files, name)
+			}
+		}
+	}
+	for _, removed := range l.deleted {
+		for name := range files {
+			if name == removed || strings.HasPrefix(name, removed+"/") {
+				delete(files, name)
+			}
+		}
+	}
+	for name, data := range l.files {
+		files[name] = data
+	}
+}
+
+// isPackageFile reports whether the file at name, relative to the root of an image, lists installed packages.
+func isPackageFile(name string) bool {
+	dir, base := path.Dir(name), path.Base(name)
+	switch {
+	case name == dpkgStatusFile || name == apkInstalled || isOSRelease(name):
+		return true
+	case strings.HasPrefix(name, dpkgStatusDir):
+		return !strings.HasSuffix(name, ".md5sums")
+	case path.Base(dir) == condaMetaDir:
+		return strings.HasSuffix(base, ".json")
+	case !strings.Contains(name, "site-packages/") && !strings.Contains(name, "dist-packages/"):
+		return false
+	case strings.HasSuffix(dir, ".dist-info"):
+		return base == "METADATA"
+	case strings.HasSuffix(dir, ".egg-info"):
+		return base == "PKG-INFO"
+	default:
+		return strings.HasSuffix(base, ".egg-info")
+	}
+}
+
+func isOSRelease(name string) bool {
+	return name == "etc/os-release" || name == "usr/lib/os-release"
+}
+
+// parsePackages parses the package database files of an image.
+func parsePackages(files map[string][]byte) []Package {
+	distro, distroVersion := parseOSRelease(files)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var packages []Package
+	for _, name := range names {
+		data := files[name]
+		switch {
+		case isOSRelease(name):
+			continue
+		case name == dpkgStatusFile || strings.HasPrefix(name, dpkgStatusDir):
+			packages = append(packages, parseDpkgStatus(data, name)...)
+		case name == apkInstalled:
+			packages = append(packages, parseApkInstalled(data, name)...)
+		case path.Base(path.Dir(name)) == condaMetaDir:
+			if pkg, ok := parseCondaMeta(data, name); ok {
+				packages = append(packages, pkg)
+			}
+		default:
+			if pkg, ok := parsePythonMetadata(data, name); ok {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+	for i := range packages {
+		if packages[i].Type == PackageDeb || packages[i].Type == PackageApk {
+			packages[i].Distro, packages[i].DistroVersion = distro, distroVersion
+		}
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
+		a, b := packages[i], packages[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return packages
+}
+
+// parseOSRelease returns the ID and VERSION_ID of the os-release file of an image.
+func parseOSRelease(files map[string][]byte) (string, string) {
+	data, ok := files["etc/os-release"]
+	if !ok {
+		data = files["usr/lib/os-release"]
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if found {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	return fields["ID"], fields["VERSION_ID"]
+}
+
+// parseStanzas splits an RFC 822 style file into paragraphs of fields.
+// Continuation lines starting with white space are appended to the previous field.
+func parseStanzas(data []byte) []map[string]string {
+	var stanzas []map[string]string
+	stanza := make(map[string]string)
+	last := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == "":
+			if len(stanza) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = make(map[string]string)
+			}
+			last = ""
+		case (line[0] == ' ' || line[0] == '\t') && last != "":
+			stanza[last] += "\n" + strings.TrimSpace(line)
+		default:
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			last = strings.TrimSpace(key)
+			if _, exists := stanza[last]; !exists {
+				stanza[last] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if len(stanza) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+	return stanzas
+}
+
+// parseDpkgStatus returns the installed packages of a dpkg status file.
+func parseDpkgStatus(data []byte, location string) []Package {
+	var packages []Package
+	for _, stanza := range parseStanzas(data) {
+		status, ok := stanza["Status"]
+		if stanza["Package"] == "" || ok && !strings.HasSuffix(status, " installed") {
+			continue
+		}
+		// Source names the source package, followed by its version when it differs: "glibc (2.36-9)"
+		source, sourceVersion, _ := strings.Cut(stanza["Source"], " ")
+		packages = append(packages, Package{
+			Type:          PackageDeb,
+			Name:          stanza["Package"],
+			Version:       stanza["Version"],
+			Arch:          stanza["Architecture"],
+			Source:        source,
+			SourceVersion: strings.Trim(sourceVersion, "()"),
+			Location:      location,
+		})
+	}
+	return packages
+}
+
+// parseApkInstalled returns the packages of an apk installed database, whose
+// records hold one field per line with single letter keys.
+func parseApkInstalled(data []byte, location string) []Package {
+	var packages []Package
+	for _, record := range bytes.Split(data, []byte("\n\n")) {
+		pkg := Package{Type: PackageApk, Location: location}
+		for _, line := range strings.Split(string(record), "\n") {
+			key, value, found := strings.Cut(line, ":")
+			if !found {
+				continue
+			}
+			switch key {
+			case "P":
+				pkg.Name = value
+			case "V":
+				pkg.Version = value
+			case "A":
+				pkg.Arch = value
+			case "L":
+				pkg.License = value
+			case "o":
+				pkg.Source = value
+			}
+		}
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+// parsePythonMetadata returns the distribution described by a METADATA or PKG-INFO file.
+func parsePythonMetadata(data []byte, location string) (Package, bool) {
+	stanzas := parseStanzas(data)
+	if len(stanzas) == 0 || stanzas[0]["Name"] == "" {
+		return Package{}, false
+	}
+	license := stanzas[0]["License-Expression"]
+	if license == "" && !strings.Contains(stanzas[0]["License"], "\n") {
+		license = stanzas[0]["License"]
+	}
+	if license == "UNKNOWN" {
+		license = ""
+	}
+	return Package{
+		Type:     PackagePyPI,
+		Name:     stanzas[0]["Name"],
+		Version:  stanzas[0]["Version"],
+		License:  license,
+		Location: location,
+	}, true
+}
+
+// parseCondaMeta returns the package described by a conda-meta record.
+func parseCondaMeta(data []byte, location string) (Package, bool) {
+	var record struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Build   string `json:"build"`
+		Channel string `json:"channel"`
+		License string `json:"license"`
+		Subdir  string `json:"subdir"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil || record.Name == "" {
+		return Package{}, false
+	}
+	return Package{
+		Type:     PackageConda,
+		Name:     record.Name,
+		Version:  record.Version,
+		Arch:     record.Subdir,
+		License:  record.License,
+		Build:    record.Build,
+		Channel:  record.Channel,
+		Location: location,
+	}, true
+}
@@ -0,0 +1,222 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const (
+	debianRelease = "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n"
+	dpkgStatus    = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.36-9+deb12u4
+Description: GNU C Library
+ Contains the standard libraries.
+
+Package: vim
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 2:9.0.1378-2
+
+Package: zlib1g
+Status: install ok installed
+Architecture: amd64
+Version: 1:1.2.13.dfsg-1
+`
+	requestsMetadata = "Metadata-Version: 2.1\nName: requests\nVersion: 2.31.0\nLicense: Apache 2.0\n\nLong description\n"
+	flaskMetadata    = "Metadata-Version: 2.3\nName: Flask\nVersion: 3.0.0\nLicense-Expression: BSD-3-Clause\n"
+	numpyCondaMeta   = `{"name":"numpy","version":"1.26.0","build":"py311_0","channel":"conda-forge","subdir":"linux-64"}`
+)
+
+// layerTar returns a layer holding files, gzip compressed when compress is set.
+func layerTar(t *testing.T, files map[string]string, compress bool) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gw != nil {
+		require.NoError(t, gw.Close())
+	}
+	return buf.Bytes()
+}
+
+// layeredImageArchive returns a `docker save` archive of a single image tagged tag made of layers.
+func layeredImageArchive(t *testing.T, tag string, layers ...[]byte) []byte {
+	t.Helper()
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	entry := bundle.ArchiveManifestEntry{
+		Config:   "blobs/sha256/" + digest.FromBytes(config).Encoded(),
+		RepoTags: []string{tag},
+	}
+	files := map[string][]byte{entry.Config: config}
+	for _, layer := range layers {
+		name := "blobs/sha256/" + digest.FromBytes(layer).Encoded()
+		entry.Layers = append(entry.Layers, name)
+		files[name] = layer
+	}
+	manifest, err := json.Marshal([]bundle.ArchiveManifestEntry{entry})
+	require.NoError(t, err)
+	files["manifest.json"] = manifest
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestScanPackages(t *testing.T) {
+	base := layerTar(t, map[string]string{
+		"etc/os-release":      debianRelease,
+		"var/lib/dpkg/status": dpkgStatus,
+		"usr/lib/python3/dist-packages/requests-2.31.0.dist-info/METADATA": requestsMetadata,
+		"opt/conda/conda-meta/numpy-1.26.0-py311_0.json":                   numpyCondaMeta,
+		"usr/bin/python3": "binary",
+	}, false)
+	app := layerTar(t, map[string]string{
+		"opt/conda/conda-meta/.wh.numpy-1.26.0-py311_0.json":                    "",
+		"usr/local/lib/python3.11/site-packages/flask-3.0.0.dist-info/METADATA": flaskMetadata,
+	}, true)
+
+	index, err := bundle.ScanPackages(bytes.NewReader(layeredImageArchive(t, "web:v1", base, app)))
+	require.NoError(t, err)
+	packages, err := index.Packages("", "web:v1")
+	require.NoError(t, err)
+
+	assert.Equal(t, []bundle.Package{
+		{
+			Type: bundle.PackageDeb, Name: "libc6", Version: "2.36-9+deb12u4", Arch: "amd64",
+			Distro: "debian", DistroVersion: "12", Location: "var/lib/dpkg/status",
+		},
+		{
+			Type: bundle.PackageDeb, Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Arch: "amd64",
+			Distro: "debian", DistroVersion: "12", Location: "var/lib/dpkg/status",
+		},
+		{
+			Type: bundle.PackagePyPI, Name: "Flask", Version: "3.0.0", License: "BSD-3-Clause",
+			Location: "usr/local/lib/python3.11/site-packages/flask-3.0.0.dist-info/METADATA",
+		},
+		{
+			Type: bundle.PackagePyPI, Name: "requests", Version: "2.31.0", License: "Apache 2.0",
+			Location: "usr/lib/python3/dist-packages/requests-2.31.0.dist-info/METADATA",
+		},
+	}, packages)
+}
+
+func TestScanPackages_ApkAndOpaqueDirectory(t *testing.T) {
+	base := layerTar(t, map[string]string{
+		"etc/os-release": "ID=alpine\nVERSION_ID=3.19.1\n",
+		"lib/apk/db/installed": "P:musl\nV:1.2.4_git20230717-r4\nA:x86_64\nL:MIT\n\n" +
+			"P:busybox\nV:1.36.1-r15\nA:x86_64\nL:GPL-2.0-only\n",
+		"opt/conda/conda-meta/numpy-1.26.0-py311_0.json": numpyCondaMeta,
+	}, true)
+	app := layerTar(t, map[string]string{
+		"opt/conda/conda-meta/.wh..wh..opq": "",
+	}, false)
+
+	index, err := bundle.ScanPackages(bytes.NewReader(layeredImageArchive(t, "alpine:3.19", base, app)))
+	require.NoError(t, err)
+	packages, err := index.Packages("", "alpine:3.19")
+	require.NoError(t, err)
+
+	require.Len(t, packages, 2)
+	assert.Equal(t, "busybox", packages[0].Name)
+	assert.Equal(t, "GPL-2.0-only", packages[0].License)
+	assert.Equal(t, "pkg:apk/alpine/musl@1.2.4_git20230717-r4?arch=x86_64&distro=alpine-3.19.1", packages[1].PURL())
+}
+
+func TestScanPackages_MissingImage(t *testing.T) {
+	index, err := bundle.ScanPackages(bytes.NewReader(layeredImageArchive(t, "web:v1")))
+	require.NoError(t, err)
+	_, err = index.Packages("", "db:v1")
+	assert.ErrorContains(t, err, "missing from the archive")
+}
+
+func TestPackageScanner(t *testing.T) {
+	layer := layerTar(t, map[string]string{
+		"opt/conda/conda-meta/numpy-1.26.0-py311_0.json": numpyCondaMeta,
+	}, false)
+	archive := layeredImageArchive(t, "web:v1", layer)
+
+	scanner := bundle.NewPackageScanner(bytes.NewReader(archive))
+	defer scanner.Close()
+	passed, err := io.ReadAll(scanner)
+	require.NoError(t, err)
+	assert.Equal(t, archive, passed)
+
+	index, err := scanner.Index()
+	require.NoError(t, err)
+	packages, err := index.Packages("", "web:v1")
+	require.NoError(t, err)
+	assert.Equal(t, []bundle.Package{{
+		Type: bundle.PackageConda, Name: "numpy", Version: "1.26.0", Arch: "linux-64",
+		Build: "py311_0", Channel: "conda-forge", Location: "opt/conda/conda-meta/numpy-1.26.0-py311_0.json",
+	}}, packages)
+}
+
+func TestPackageScanner_InvalidArchive(t *testing.T) {
+	scanner := bundle.NewPackageScanner(bytes.NewReader([]byte("not an archive")))
+	defer scanner.Close()
+	_, err := io.ReadAll(scanner)
+	require.NoError(t, err)
+	_, err = scanner.Index()
+	assert.Error(t, err)
+}
+
+func TestPackage_PURL(t *testing.T) {
+	tests := []struct {
+		pkg  bundle.Package
+		want string
+	}{
+		{
+			pkg: bundle.Package{
+				Type: bundle.PackageDeb, Name: "zlib1g", Version: "1:1.2.13.dfsg-1", Arch: "amd64",
+				Distro: "debian", DistroVersion: "12",
+			},
+			want: "pkg:deb/debian/zlib1g@1%3A1.2.13.dfsg-1?arch=amd64&distro=debian-12",
+		},
+		{
+			pkg:  bundle.Package{Type: bundle.PackageDeb, Name: "libc6", Version: "2.36"},
+			want: "pkg:deb/debian/libc6@2.36",
+		},
+		{
+			pkg:  bundle.Package{Type: bundle.PackagePyPI, Name: "Typing_Extensions", Version: "4.9.0"},
+			want: "pkg:pypi/typing-extensions@4.9.0",
+		},
+		{
+			pkg:  bundle.Package{Type: bundle.PackageConda, Name: "numpy", Version: "1.26.0", Build: "py311_0"},
+			want: "pkg:conda/numpy@1.26.0?build=py311_0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.pkg.PURL())
+		})
+	}
+}
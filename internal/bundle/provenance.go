@@ -0,0 +1,178 @@
+package bundle
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ProvenanceDir is the directory holding the provenance attestations inside the output directory.
+	ProvenanceDir = "provenance"
+	// ProvenanceExtension is the file name suffix of provenance attestations.
+	ProvenanceExtension = ".intoto.json"
+
+	// StatementType is the in-toto statement version of provenance attestations.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// ProvenancePredicateType is the SLSA provenance version of provenance attestations.
+	ProvenancePredicateType = "https://slsa.dev/provenance/v1"
+	// ProvenanceBuildType identifies the build parameters recorded by docker-deliver.
+	ProvenanceBuildType = "https://github.com/sunpia/docker-deliver/compose-build/v1"
+	// BuilderID identifies docker-deliver as the builder of provenance attestations.
+	BuilderID = "https://github.com/sunpia/docker-deliver"
+	// PayloadType is the DSSE payload type of in-toto statements.
+	PayloadType = "application/vnd.in-toto+json"
+)
+
+// Statement is an in-toto statement attesting the provenance of the images in Subject.
+type Statement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     Provenance           `json:"predicate"`
+}
+
+// ResourceDescriptor identifies an artifact by name or URI and digests keyed by algorithm.
+type ResourceDescriptor struct {
+	Name        string            `json:"name,omitempty"`
+	URI         string            `json:"uri,omitempty"`
+	Digest      map[string]string `json:"digest,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Provenance is a SLSA provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes the inputs of a build.
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   BuildParameters      `json:"externalParameters"`
+	InternalParameters   BuilderParameters    `json:"internalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies"`
+}
+
+// BuildParameters are the build section of a compose service.
+type BuildParameters struct {
+	ComposeFiles       []string          `json:"composeFiles"`
+	Project            string            `json:"project"`
+	Service            string            `json:"service"`
+	Context            string            `json:"context"`
+	Dockerfile         string            `json:"dockerfile"`
+	Target             string            `json:"target,omitempty"`
+	Args               map[string]string `json:"args,omitempty"`
+	AdditionalContexts map[string]string `json:"additionalContexts,omitempty"`
+	Platforms          []string          `json:"platforms,omitempty"`
+}
+
+// BuilderParameters describe the host that ran the build.
+type BuilderParameters struct {
+	Host string `json:"builderHost"`
+}
+
+// RunDetails describes the builder and the time of the build.
+type RunDetails struct {
+	Builder  Builder       `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+// Builder identifies the tool that ran the build.
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// BuildMetadata records when a build ran.
+type BuildMetadata struct {
+	InvocationID string    `json:"invocationId"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// Envelope is a DSSE envelope holding a statement and its signatures.
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     []byte              `json:"payload"`
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is a signature of the payload of an envelope.
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid"` // SigningKeyID of the public key verifying the signature
+	Sig   []byte `json:"sig"`
+}
+
+// NewEnvelope wraps statement in an envelope, signed with key unless key is nil.
+func NewEnvelope(statement *Statement, key ed25519.PrivateKey) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode provenance statement")
+	}
+	envelope := &Envelope{PayloadType: PayloadType, Payload: payload, Signatures: []EnvelopeSignature{}}
+	if key != nil {
+		signature := Sign(key, preAuthEncoding(envelope.PayloadType, payload))
+		envelope.Signatures = append(envelope.Signatures, EnvelopeSignature{KeyID: signature.KeyID, Sig: signature.Value})
+	}
+	return envelope, nil
+}
+
+// Encode writes the envelope as indented JSON.
+func (e *Envelope) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(e)
+}
+
+// Statement decodes the statement of the envelope without checking its signatures.
+func (e *Envelope) Statement() (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, errors.Errorf("unsupported payload type %q", e.PayloadType)
+	}
+	var statement Statement
+	if err := json.Unmarshal(e.Payload, &statement); err != nil {
+		return nil, errors.Wrap(err, "failed to decode provenance statement")
+	}
+	return &statement, nil
+}
+
+// Verify checks that the envelope was signed by one of the trusted keys and returns its statement.
+func (e *Envelope) Verify(trusted []ed25519.PublicKey) (*Statement, error) {
+	if len(e.Signatures) == 0 {
+		return nil, errors.New("provenance is not signed")
+	}
+	data := preAuthEncoding(e.PayloadType, e.Payload)
+	var err error
+	for _, sig := range e.Signatures {
+		signature := Signature{Algorithm: signatureEd25519, KeyID: sig.KeyID, Value: sig.Sig}
+		if err = signature.Verify(data, trusted); err == nil {
+			return e.Statement()
+		}
+	}
+	return nil, err
+}
+
+// ReadEnvelope reads the envelope stored at path.
+func ReadEnvelope(path string) (*Envelope, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read provenance")
+	}
+	var envelope Envelope
+	if err = json.Unmarshal(data, &envelope); err != nil {
+		return nil, errors.Wrap(err, "failed to decode provenance")
+	}
+	return &envelope, nil
+}
+
+// preAuthEncoding returns the DSSE pre-authentication encoding of a payload, which is what gets signed.
+func preAuthEncoding(payloadType string, payload []byte) []byte {
+	encoded := "DSSEv1 " + strconv.Itoa(len(payloadType)) + " " + payloadType + " " + strconv.Itoa(len(payload)) + " "
+	return append([]byte(encoded), payload...)
+}
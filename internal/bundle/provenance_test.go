@@ -0,0 +1,141 @@
+package bundle_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func writeContext(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestContextDigest(t *testing.T) {
+	files := map[string]string{
+		"Dockerfile":        "FROM alpine:3.19\n",
+		"app/main.go":       "package main\n",
+		"node_modules/a.js": "ignored",
+		".dockerignore":     "node_modules\n*.log\n",
+	}
+	dir := writeContext(t, files)
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	want, err := bundle.ContextDigest(dir, dockerfile)
+	require.NoError(t, err)
+
+	// Files excluded by .dockerignore do not change the digest
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "node_modules", "a.js"), []byte("changed"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "debug.log"), []byte("log"), 0o644))
+	got, err := bundle.ContextDigest(dir, dockerfile)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// The same files elsewhere have the same digest
+	got, err = bundle.ContextDigest(writeContext(t, files), "")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app", "main.go"), []byte("package app\n"), 0o644))
+	got, err = bundle.ContextDigest(dir, dockerfile)
+	require.NoError(t, err)
+	assert.NotEqual(t, want, got)
+}
+
+func TestContextDigest_DockerfileIgnoreFile(t *testing.T) {
+	dir := writeContext(t, map[string]string{
+		"Dockerfile":              "FROM alpine:3.19\n",
+		"Dockerfile.dockerignore": "secret.txt\n",
+		".dockerignore":           "",
+	})
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	want, err := bundle.ContextDigest(dir, dockerfile)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644))
+	got, err := bundle.ContextDigest(dir, dockerfile)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestBaseImages(t *testing.T) {
+	dockerfile := []byte(`# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.22
+ARG RUNTIME
+FROM --platform=$BUILDPLATFORM golang:${GO_VERSION}-alpine AS build
+RUN go build \
+    -o /app .
+
+FROM build AS test
+FROM ${RUNTIME:-gcr.io/distroless/static} AS runtime
+COPY --from=build /app /app
+FROM scratch
+from golang:${GO_VERSION}-alpine
+`)
+	assert.Equal(t, []string{"golang:1.22-alpine", "gcr.io/distroless/static"}, bundle.BaseImages(dockerfile, nil))
+	assert.Equal(t, []string{"golang:1.23-alpine", "alpine:3.19"},
+		bundle.BaseImages(dockerfile, map[string]string{"GO_VERSION": "1.23", "RUNTIME": "alpine:3.19"}))
+}
+
+func newStatement() *bundle.Statement {
+	return &bundle.Statement{
+		Type:          bundle.StatementType,
+		Subject:       []bundle.ResourceDescriptor{{Name: "web:v1", Digest: map[string]string{"sha256": "1111"}}},
+		PredicateType: bundle.ProvenancePredicateType,
+		Predicate: bundle.Provenance{BuildDefinition: bundle.BuildDefinition{
+			BuildType:          bundle.ProvenanceBuildType,
+			ExternalParameters: bundle.BuildParameters{Service: "web", Args: map[string]string{"VERSION": "1"}},
+		}},
+	}
+}
+
+func TestEnvelope_Signed(t *testing.T) {
+	key, public := newSigningKey(t)
+	envelope, err := bundle.NewEnvelope(newStatement(), key)
+	require.NoError(t, err)
+	require.Len(t, envelope.Signatures, 1)
+	assert.Equal(t, bundle.SigningKeyID(public), envelope.Signatures[0].KeyID)
+
+	path := filepath.Join(t.TempDir(), "web"+bundle.ProvenanceExtension)
+	var buf bytes.Buffer
+	require.NoError(t, envelope.Encode(&buf))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	read, err := bundle.ReadEnvelope(path)
+	require.NoError(t, err)
+
+	statement, err := read.Verify([]ed25519.PublicKey{public})
+	require.NoError(t, err)
+	assert.Equal(t, newStatement(), statement)
+
+	_, other := newSigningKey(t)
+	_, err = read.Verify([]ed25519.PublicKey{other})
+	assert.ErrorContains(t, err, "not a trusted key")
+
+	read.Payload = bytes.Replace(read.Payload, []byte(`"VERSION":"1"`), []byte(`"VERSION":"2"`), 1)
+	_, err = read.Verify([]ed25519.PublicKey{public})
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestEnvelope_Unsigned(t *testing.T) {
+	envelope, err := bundle.NewEnvelope(newStatement(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, envelope.Signatures)
+
+	statement, err := envelope.Statement()
+	require.NoError(t, err)
+	assert.Equal(t, "web", statement.Predicate.BuildDefinition.ExternalParameters.Service)
+	_, err = envelope.Verify(nil)
+	assert.ErrorContains(t, err, "not signed")
+}
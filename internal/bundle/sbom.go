@@ -0,0 +1,270 @@
+package bundle
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// SBOMDir is the directory holding the software bills of materials inside the output directory.
+const SBOMDir = "sbom"
+
+// SBOMFormat is the document format of a software bill of materials.
+type SBOMFormat string
+
+const (
+	// SBOMSPDX is an SPDX 2.3 JSON document.
+	SBOMSPDX SBOMFormat = "spdx"
+	// SBOMCycloneDX is a CycloneDX 1.5 JSON document.
+	SBOMCycloneDX SBOMFormat = "cyclonedx"
+)
+
+const (
+	spdxVersion      = "SPDX-2.3"
+	spdxNoAssertion  = "NOASSERTION"
+	spdxNamespace    = "https://github.com/sunpia/docker-deliver/spdx/"
+	cycloneDXVersion = "1.5"
+)
+
+// ParseSBOMFormat validates an SBOM format name. An empty name means no SBOM.
+func ParseSBOMFormat(name string) (SBOMFormat, error) {
+	switch SBOMFormat(name) {
+	case "", SBOMSPDX, SBOMCycloneDX:
+		return SBOMFormat(name), nil
+	default:
+		return "", errors.Errorf("unsupported SBOM format %q (expected spdx or cyclonedx)", name)
+	}
+}
+
+// Extension returns the file name suffix of SBOMs written in format f.
+func (f SBOMFormat) Extension() string {
+	switch f {
+	case SBOMCycloneDX:
+		return ".cdx.json"
+	case SBOMSPDX:
+		return ".spdx.json"
+	default:
+		return ".json"
+	}
+}
+
+// SBOMSubject describes the image an SBOM is written for.
+type SBOMSubject struct {
+	Project     string
+	Service     string
+	Image       string
+	ImageID     string
+	Created     time.Time
+	ToolVersion string // docker-deliver version recorded as the creator of the document
+}
+
+// WriteSBOM writes the packages installed in the image of subject to w as an SBOM in format f.
+func WriteSBOM(w io.Writer, f SBOMFormat, subject SBOMSubject, packages []Package) error {
+	var doc any
+	switch f {
+	case SBOMSPDX:
+		doc = spdxDocument(subject, packages)
+	case SBOMCycloneDX:
+		doc = cycloneDXDocument(subject, packages)
+	default:
+		return errors.Errorf("unsupported SBOM format %q", f)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return errors.Wrap(err, "failed to write SBOM")
+	}
+	return nil
+}
+
+type spdxDoc struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	Supplier              string            `json:"supplier"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	LicenseConcluded      string            `json:"licenseConcluded"`
+	LicenseDeclared       string            `json:"licenseDeclared"`
+	LicenseComments       string            `json:"licenseComments,omitempty"`
+	SourceInfo            string            `json:"sourceInfo,omitempty"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdxDocument describes the image as a CONTAINER package that contains the
+// installed packages. Licenses are not validated as SPDX expressions, so the
+// declared license is kept as a comment.
+func spdxDocument(subject SBOMSubject, packages []Package) spdxDoc {
+	const imageID = "SPDXRef-Image"
+	name := subject.Project + "-" + subject.Service
+	doc := spdxDoc{
+		SPDXVersion:       spdxVersion,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: spdxNamespace + name + "-" + uuid.NewString(),
+		CreationInfo: spdxCreationInfo{
+			Created:  subject.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: docker-deliver-" + subject.ToolVersion},
+		},
+		Packages: []spdxPackage{{
+			Name:                  subject.Image,
+			SPDXID:                imageID,
+			VersionInfo:           subject.ImageID,
+			Supplier:              spdxNoAssertion,
+			DownloadLocation:      spdxNoAssertion,
+			LicenseConcluded:      spdxNoAssertion,
+			LicenseDeclared:       spdxNoAssertion,
+			PrimaryPackagePurpose: "CONTAINER",
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: imageID,
+		}},
+	}
+	for i, pkg := range packages {
+		id := "SPDXRef-Package-" + strconv.Itoa(i+1)
+		spdxPkg := spdxPackage{
+			Name:             pkg.Name,
+			SPDXID:           id,
+			VersionInfo:      pkg.Version,
+			Supplier:         spdxNoAssertion,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			SourceInfo:       "acquired package info from " + pkg.Location,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  pkg.PURL(),
+			}},
+		}
+		if pkg.License != "" {
+			spdxPkg.LicenseComments = "Declared license: " + pkg.License
+		}
+		doc.Packages = append(doc.Packages, spdxPkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      imageID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return doc
+}
+
+type cycloneDXDoc struct {
+	BOMFormat    string               `json:"bomFormat"`
+	SpecVersion  string               `json:"specVersion"`
+	SerialNumber string               `json:"serialNumber"`
+	Version      int                  `json:"version"`
+	Metadata     cycloneDXMetadata    `json:"metadata"`
+	Components   []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	BOMRef     string              `json:"bom-ref,omitempty"`
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties,omitempty"`
+}
+
+type cycloneDXLicense struct {
+	License cycloneDXLicenseName `json:"license"`
+}
+
+type cycloneDXLicenseName struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// cycloneDXDocument describes the image as the container component of the
+// metadata and the installed packages as library components.
+func cycloneDXDocument(subject SBOMSubject, packages []Package) cycloneDXDoc {
+	doc := cycloneDXDoc{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  cycloneDXVersion,
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: subject.Created.UTC().Format(time.RFC3339),
+			Tools: cycloneDXTools{Components: []cycloneDXComponent{{
+				Type:    "application",
+				Name:    "docker-deliver",
+				Version: subject.ToolVersion,
+			}}},
+			Component: cycloneDXComponent{
+				BOMRef:  subject.ImageID,
+				Type:    "container",
+				Name:    subject.Image,
+				Version: subject.ImageID,
+			},
+		},
+		Components: make([]cycloneDXComponent, 0, len(packages)),
+	}
+	for i, pkg := range packages {
+		component := cycloneDXComponent{
+			BOMRef:     "package-" + strconv.Itoa(i+1),
+			Type:       "library",
+			Name:       pkg.Name,
+			Version:    pkg.Version,
+			PURL:       pkg.PURL(),
+			Properties: []cycloneDXProperty{{Name: "docker-deliver:location", Value: pkg.Location}},
+		}
+		if pkg.License != "" {
+			component.Licenses = []cycloneDXLicense{{License: cycloneDXLicenseName{Name: pkg.License}}}
+		}
+		doc.Components = append(doc.Components, component)
+	}
+	return doc
+}
@@ -0,0 +1,150 @@
+package bundle_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func sbomSubject() bundle.SBOMSubject {
+	return bundle.SBOMSubject{
+		Project:     "example",
+		Service:     "web",
+		Image:       "example/web:v1",
+		ImageID:     "sha256:1111",
+		Created:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		ToolVersion: "v1.2.3",
+	}
+}
+
+func sbomPackages() []bundle.Package {
+	return []bundle.Package{
+		{Type: bundle.PackageApk, Name: "musl", Version: "1.2.4-r4", License: "MIT", Location: "lib/apk/db/installed"},
+		{Type: bundle.PackagePyPI, Name: "requests", Version: "2.31.0", Location: "usr/lib/python3/METADATA"},
+	}
+}
+
+func TestParseSBOMFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    bundle.SBOMFormat
+		wantErr bool
+	}{
+		{name: ""},
+		{name: "spdx", want: bundle.SBOMSPDX},
+		{name: "cyclonedx", want: bundle.SBOMCycloneDX},
+		{name: "swid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bundle.ParseSBOMFormat(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.Equal(t, ".spdx.json", bundle.SBOMSPDX.Extension())
+	assert.Equal(t, ".cdx.json", bundle.SBOMCycloneDX.Extension())
+}
+
+func TestWriteSBOM_SPDX(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, bundle.WriteSBOM(&buf, bundle.SBOMSPDX, sbomSubject(), sbomPackages()))
+
+	var doc struct {
+		SPDXVersion       string `json:"spdxVersion"`
+		DocumentNamespace string `json:"documentNamespace"`
+		CreationInfo      struct {
+			Created  string   `json:"created"`
+			Creators []string `json:"creators"`
+		} `json:"creationInfo"`
+		Packages []struct {
+			Name                  string `json:"name"`
+			SPDXID                string `json:"SPDXID"`
+			VersionInfo           string `json:"versionInfo"`
+			LicenseComments       string `json:"licenseComments"`
+			PrimaryPackagePurpose string `json:"primaryPackagePurpose"`
+			ExternalRefs          []struct {
+				ReferenceType    string `json:"referenceType"`
+				ReferenceLocator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+		Relationships []struct {
+			SPDXElementID      string `json:"spdxElementId"`
+			RelationshipType   string `json:"relationshipType"`
+			RelatedSPDXElement string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Contains(t, doc.DocumentNamespace, "example-web-")
+	assert.Equal(t, "2024-01-02T03:04:05Z", doc.CreationInfo.Created)
+	assert.Equal(t, []string{"Tool: docker-deliver-v1.2.3"}, doc.CreationInfo.Creators)
+	require.Len(t, doc.Packages, 3)
+	assert.Equal(t, "example/web:v1", doc.Packages[0].Name)
+	assert.Equal(t, "CONTAINER", doc.Packages[0].PrimaryPackagePurpose)
+	assert.Equal(t, "musl", doc.Packages[1].Name)
+	assert.Equal(t, "Declared license: MIT", doc.Packages[1].LicenseComments)
+	require.Len(t, doc.Packages[2].ExternalRefs, 1)
+	assert.Equal(t, "pkg:pypi/requests@2.31.0", doc.Packages[2].ExternalRefs[0].ReferenceLocator)
+	require.Len(t, doc.Relationships, 3)
+	assert.Equal(t, "DESCRIBES", doc.Relationships[0].RelationshipType)
+	assert.Equal(t, "CONTAINS", doc.Relationships[2].RelationshipType)
+	assert.Equal(t, doc.Packages[2].SPDXID, doc.Relationships[2].RelatedSPDXElement)
+}
+
+func TestWriteSBOM_CycloneDX(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, bundle.WriteSBOM(&buf, bundle.SBOMCycloneDX, sbomSubject(), sbomPackages()))
+
+	var doc struct {
+		BOMFormat    string `json:"bomFormat"`
+		SpecVersion  string `json:"specVersion"`
+		SerialNumber string `json:"serialNumber"`
+		Metadata     struct {
+			Component struct {
+				Type    string `json:"type"`
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"component"`
+		} `json:"metadata"`
+		Components []struct {
+			Type     string `json:"type"`
+			Name     string `json:"name"`
+			PURL     string `json:"purl"`
+			Licenses []struct {
+				License struct {
+					Name string `json:"name"`
+				} `json:"license"`
+			} `json:"licenses"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+
+	assert.Equal(t, "CycloneDX", doc.BOMFormat)
+	assert.Equal(t, "1.5", doc.SpecVersion)
+	assert.Regexp(t, `^urn:uuid:[0-9a-f-]{36}$`, doc.SerialNumber)
+	assert.Equal(t, "container", doc.Metadata.Component.Type)
+	assert.Equal(t, "sha256:1111", doc.Metadata.Component.Version)
+	require.Len(t, doc.Components, 2)
+	assert.Equal(t, "library", doc.Components[0].Type)
+	assert.Equal(t, "pkg:apk/alpine/musl@1.2.4-r4", doc.Components[0].PURL)
+	require.Len(t, doc.Components[0].Licenses, 1)
+	assert.Equal(t, "MIT", doc.Components[0].Licenses[0].License.Name)
+	assert.Empty(t, doc.Components[1].Licenses)
+}
+
+func TestWriteSBOM_UnsupportedFormat(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Error(t, bundle.WriteSBOM(&buf, bundle.SBOMFormat("swid"), sbomSubject(), nil))
+}
@@ -0,0 +1,419 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/DefangLabs/secret-detector/pkg/detectors/generic"
+	"github.com/DefangLabs/secret-detector/pkg/detectors/privatekey"
+	"github.com/DefangLabs/secret-detector/pkg/scanner"
+	"github.com/DefangLabs/secret-detector/pkg/secrets"
+	"github.com/moby/patternmatcher"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// secretFileLimit is the largest file of a layer searched for private keys.
+	secretFileLimit = 64 << 10
+
+	secretGitRepository = "git repository"
+	secretPrivateKey    = "private key"
+	secretHistoryToken  = "token in shell history"
+)
+
+// secretFiles are the paths of credential files, relative to any directory,
+// and the kind of secret they hold.
+var secretFiles = map[string]string{
+	".aws/credentials":                                    "aws credentials",
+	".config/gcloud/credentials.db":                       "gcloud credentials",
+	".config/gcloud/access_tokens.db":                     "gcloud credentials",
+	".config/gcloud/application_default_credentials.json": "gcloud credentials",
+	".azure/accessTokens.json":                            "azure credentials",
+	".azure/msal_token_cache.json":                        "azure credentials",
+	".kube/config":                                        "kubeconfig",
+	".docker/config.json":                                 "docker credentials",
+	".netrc":                                              "netrc credentials",
+	".npmrc":                                              "npm credentials",
+	".pypirc":                                             "pypi credentials",
+	".git-credentials":                                    "git credentials",
+	".pgpass":                                             "postgres password file",
+	".ssh/id_rsa":                                         "ssh private key",
+	".ssh/id_dsa":                                         "ssh private key",
+	".ssh/id_ecdsa":                                       "ssh private key",
+	".ssh/id_ed25519":                                     "ssh private key",
+}
+
+// historyFiles are the names of the shell and REPL history files searched for tokens.
+var historyFiles = map[string]bool{
+	".bash_history":      true,
+	".zsh_history":       true,
+	".sh_history":        true,
+	".ash_history":       true,
+	".python_history":    true,
+	".mysql_history":     true,
+	".psql_history":      true,
+	".node_repl_history": true,
+}
+
+// packageDirs are the directories of third-party packages. Private keys found
+// in them are only warnings: package test fixtures commonly hold throwaway ones.
+var packageDirs = map[string]bool{
+	"node_modules":  true,
+	"site-packages": true,
+	"dist-packages": true,
+}
+
+// privateKeyMarkers are the strings a file must hold to be searched for a private key.
+var privateKeyMarkers = [][]byte{[]byte("PRIVATE KEY"), []byte("PuTTY-User-Key-File-")}
+
+// SecretFinding is a file of an image layer likely holding a secret. It never
+// holds the secret itself.
+type SecretFinding struct {
+	Images  []string      `json:"images"`            // Tags of the images holding the layer
+	Layer   digest.Digest `json:"layer"`             // Diff ID of the layer
+	Path    string        `json:"path"`              // Path of the file, relative to the root of the image
+	Kind    string        `json:"kind"`              // Kind of secret, such as "private key" or "aws credentials"
+	Detail  string        `json:"detail,omitempty"`  // Detectors matching the contents of the file
+	Warning bool          `json:"warning,omitempty"` // Reported without failing the scan: keys under package directories
+}
+
+// SecretScanner searches the layers of image archives for files likely
+// holding secrets: private keys, .env files, cloud, registry and package
+// index credentials, tokens in shell history and .git directories.
+type SecretScanner struct {
+	ignore  *patternmatcher.PatternMatcher
+	keys    secrets.Scanner
+	history secrets.Scanner
+}
+
+// NewSecretScanner creates a scanner leaving out the paths matching the
+// ignore patterns, which follow the .dockerignore syntax.
+func NewSecretScanner(ignore []string) (*SecretScanner, error) {
+	matcher, err := patternmatcher.New(ignore)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid secret ignore pattern")
+	}
+	keys, err := scanner.NewScannerFromConfig(scanner.Config{
+		Detectors:        []string{privatekey.Name},
+		ThresholdInBytes: secretFileLimit,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create private key scanner")
+	}
+	// History lines are free text: no transformer applies, and high entropy
+	// strings such as hashes and container IDs are too common to report.
+	config := scanner.NewConfigWithDefaults()
+	config.Transformers = nil
+	config = scanner.NewConfigBuilderFrom(config).RemoveDetectors(generic.HighEntropyStringDetectorName).Build()
+	history, err := scanner.NewScannerFromConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create history scanner")
+	}
+	return &SecretScanner{ignore: matcher, keys: keys, history: history}, nil
+}
+
+// Scan reads a `docker save` stream and returns the secrets found in the
+// layers of its images, ordered by path. Layers are searched one by one, so
+// files a later layer removes are still found: they ship with the archive.
+func (s *SecretScanner) Scan(r io.Reader) ([]SecretFinding, error) {
+	archive := NewArchiveIndex()
+	layers := make(map[digest.Digest]*layerSecrets)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read image archive")
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			archive.AddLink(header)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		entry, found, err := s.scanEntry(tr, header)
+		if err != nil {
+			return nil, err
+		}
+		archive.Add(entry)
+		if found != nil && len(found.findings) > 0 {
+			layers[entry.Descriptor.Digest] = found
+		}
+	}
+	if err := archive.Decode(); err != nil {
+		return nil, err
+	}
+	return secretFindings(archive, layers), nil
+}
+
+// SecretStream reads a `docker save` stream while searching its layers for
+// secrets, as PackageScanner does for packages. The stream is passed through
+// unchanged.
+type SecretStream struct {
+	reader   io.Reader
+	writer   *io.PipeWriter
+	done     chan struct{}
+	findings []SecretFinding
+	err      error
+}
+
+// Stream starts searching src for secrets.
+func (s *SecretScanner) Stream(src io.Reader) *SecretStream {
+	reader, writer := io.Pipe()
+	stream := &SecretStream{reader: io.TeeReader(src, writer), writer: writer, done: make(chan struct{})}
+	go func() {
+		stream.findings, stream.err = s.Scan(reader)
+		// Keep consuming the stream so that reading it never blocks on a failed scan.
+		_, _ = io.Copy(io.Discard, reader)
+		close(stream.done)
+	}()
+	return stream
+}
+
+// Read reads the stream.
+func (s *SecretStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close stops scanning.
+func (s *SecretStream) Close() error {
+	return s.writer.CloseWithError(errors.New("secret scan stopped"))
+}
+
+// Findings returns the secrets found in the layers of the stream, see
+// SecretScanner.Scan. It must only be called after the stream has been read
+// to the end.
+func (s *SecretStream) Findings() ([]SecretFinding, error) {
+	_ = s.writer.Close()
+	<-s.done
+	return s.findings, s.err
+}
+
+// secretFindings returns the secrets found in the layers of the archive,
+// together with the images holding each layer.
+func secretFindings(archive *ArchiveIndex, layers map[digest.Digest]*layerSecrets) []SecretFinding {
+	for _, img := range archive.Manifest {
+		tags := img.RepoTags
+		if len(tags) == 0 {
+			tags = []string{img.Config}
+		}
+		for _, name := range img.Layers {
+			if entry, ok := archive.Entries[name]; ok && layers[entry.Descriptor.Digest] != nil {
+				found := layers[entry.Descriptor.Digest]
+				found.images = append(found.images, tags...)
+			}
+		}
+	}
+	var findings []SecretFinding
+	for _, found := range layers {
+		images := uniqueSorted(found.images)
+		for _, finding := range found.findings {
+			finding.Images = images
+			finding.Layer = found.diffID
+			findings = append(findings, finding)
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Layer < findings[j].Layer
+	})
+	return findings
+}
+
+// scanEntry indexes a file of the archive and, when it is a layer, searches it for secrets.
+func (s *SecretScanner) scanEntry(r io.Reader, header *tar.Header) (*ArchiveEntry, *layerSecrets, error) {
+	reader, writer := io.Pipe()
+	result := make(chan *layerSecrets, 1)
+	go func() {
+		found := s.readLayer(reader)
+		_, _ = io.Copy(io.Discard, reader)
+		result <- found
+	}()
+	entry, err := ReadArchiveEntry(r, header, writer)
+	_ = writer.CloseWithError(err)
+	found := <-result
+	return entry, found, err
+}
+
+// layerSecrets holds the secrets found in a layer.
+type layerSecrets struct {
+	diffID   digest.Digest
+	images   []string
+	findings []SecretFinding
+}
+
+// readLayer reads a layer tar stream, compressed or not, and returns nil when r is not a layer.
+func (s *SecretScanner) readLayer(r io.Reader) *layerSecrets {
+	br := bufio.NewReader(r)
+	const magicSize = 4
+	head, _ := br.Peek(magicSize)
+	dr, err := NewDecompressReader(br, detectCompression(head))
+	if err != nil {
+		return nil
+	}
+	defer dr.Close()
+
+	digester := digest.Canonical.Digester()
+	tee := io.TeeReader(dr, digester.Hash())
+	found := &layerSecrets{}
+	repositories := make(map[string]bool)
+	tr := tar.NewReader(tee)
+	for first := true; ; first = false {
+		header, nextErr := tr.Next()
+		if nextErr != nil && first && !errors.Is(nextErr, io.EOF) {
+			return nil
+		}
+		if nextErr != nil {
+			break
+		}
+		name := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if strings.HasPrefix(path.Base(name), whiteoutPrefix) || s.ignored(name) {
+			continue
+		}
+		if root := gitRepository(name, header.Typeflag == tar.TypeDir); root != "" {
+			if !repositories[root] && !s.ignored(root) {
+				repositories[root] = true
+				found.findings = append(found.findings, SecretFinding{Path: root, Kind: secretGitRepository})
+			}
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if kind, detail := s.match(name, header.Size, tr); kind != "" {
+			found.findings = append(found.findings, SecretFinding{
+				Path:    name,
+				Kind:    kind,
+				Detail:  detail,
+				Warning: kind == secretPrivateKey && inPackageDir(name),
+			})
+		}
+	}
+	// The diff ID covers the whole uncompressed layer, including the end of archive padding.
+	_, _ = io.Copy(io.Discard, tee)
+	found.diffID = digester.Digest()
+	return found
+}
+
+// ignored reports whether name matches an ignore pattern.
+func (s *SecretScanner) ignored(name string) bool {
+	ignored, err := s.ignore.MatchesOrParentMatches(name)
+	return err == nil && ignored
+}
+
+// match returns the kind of secret the regular file name of the given size
+// likely holds, reading its contents from r when its name is not enough,
+// together with the detectors matching them.
+func (s *SecretScanner) match(name string, size int64, r io.Reader) (string, string) {
+	base := path.Base(name)
+	for suffix, kind := range secretFiles {
+		if name == suffix || strings.HasSuffix(name, "/"+suffix) {
+			return kind, ""
+		}
+	}
+	if isDotenv(base) {
+		return "dotenv file", ""
+	}
+	if historyFiles[base] {
+		data, err := io.ReadAll(io.LimitReader(r, int64(scanner.DefaultThreshold)))
+		if err != nil {
+			return "", ""
+		}
+		if detected, _ := s.history.Scan(string(data)); len(detected) > 0 {
+			return secretHistoryToken, detectedTypes(detected)
+		}
+		return "", ""
+	}
+	if size > secretFileLimit {
+		return "", ""
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || !containsAny(data, privateKeyMarkers) {
+		return "", ""
+	}
+	if detected, _ := s.keys.Scan(string(data)); len(detected) > 0 {
+		return secretPrivateKey, ""
+	}
+	return "", ""
+}
+
+// gitRepository returns the .git directory holding the entry name, or name
+// itself when it is a .git directory. Submodules link to their repository
+// with a .git file, which holds no history.
+func gitRepository(name string, dir bool) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part == ".git" && (i < len(parts)-1 || dir) {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+// inPackageDir reports whether name is under a directory of third-party packages.
+func inPackageDir(name string) bool {
+	for _, dir := range strings.Split(path.Dir(name), "/") {
+		if packageDirs[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// isDotenv reports whether base names a .env file, such as .env or
+// .env.production, leaving out the examples meant to be committed.
+func isDotenv(base string) bool {
+	if base == ".env" {
+		return true
+	}
+	if !strings.HasPrefix(base, ".env.") {
+		return false
+	}
+	switch path.Ext(base) {
+	case ".example", ".sample", ".template", ".dist":
+		return false
+	default:
+		return true
+	}
+}
+
+func containsAny(data []byte, markers [][]byte) bool {
+	for _, marker := range markers {
+		if bytes.Contains(data, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectedTypes lists the types of the detected secrets, leaving out their values.
+func detectedTypes(detected []secrets.DetectedSecret) string {
+	types := make([]string, 0, len(detected))
+	for _, secret := range detected {
+		types = append(types, secret.Type)
+	}
+	return strings.Join(uniqueSorted(types), ", ")
+}
+
+func uniqueSorted(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,140 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const signatureEd25519 = "ed25519"
+
+// Signature is a detached signature of a bundle file, stored as JSON.
+type Signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"` // SigningKeyID of the public key verifying the signature
+	Value     []byte `json:"signature"`
+}
+
+// SigningKeyID returns a short fingerprint identifying an ed25519 public key.
+func SigningKeyID(key ed25519.PublicKey) string {
+	return keyID(signatureEd25519, key)
+}
+
+// Sign signs data with key.
+func Sign(key ed25519.PrivateKey, data []byte) *Signature {
+	public, _ := key.Public().(ed25519.PublicKey)
+	return &Signature{
+		Algorithm: signatureEd25519,
+		KeyID:     SigningKeyID(public),
+		Value:     ed25519.Sign(key, data),
+	}
+}
+
+// Verify checks that the signature of data was made by one of the trusted keys.
+func (s *Signature) Verify(data []byte, trusted []ed25519.PublicKey) error {
+	if s.Algorithm != signatureEd25519 {
+		return errors.Errorf("unsupported signature algorithm %q", s.Algorithm)
+	}
+	if len(trusted) == 0 {
+		return errors.Errorf("signed by %s, but no trusted key was given to verify it", s.KeyID)
+	}
+	for _, key := range trusted {
+		if SigningKeyID(key) != s.KeyID {
+			continue
+		}
+		if !ed25519.Verify(key, data, s.Value) {
+			return errors.Errorf("signature by %s does not match, the signed file was modified", s.KeyID)
+		}
+		return nil
+	}
+	return errors.Errorf("signed by %s, which is not a trusted key", s.KeyID)
+}
+
+// SignManifest writes the signature of the manifest of the bundle in dir to SignatureFile.
+func SignManifest(dir string, key ed25519.PrivateKey) (*Signature, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+	signature := Sign(key, data)
+	encoded, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode signature")
+	}
+	const signaturePermissions = 0644
+	if err = os.WriteFile(filepath.Join(dir, SignatureFile), append(encoded, '\n'), signaturePermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to write signature")
+	}
+	return signature, nil
+}
+
+// ReadSignature reads the signature stored at path.
+func ReadSignature(path string) (*Signature, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signature")
+	}
+	var signature Signature
+	if err = json.Unmarshal(data, &signature); err != nil {
+		return nil, errors.Wrap(err, "failed to parse signature")
+	}
+	return &signature, nil
+}
+
+// CheckSignature checks that the manifest of the bundle in dir is signed by
+// one of the trusted keys and returns its signature. The files of the bundle
+// are not checked, see VerifySignature.
+func CheckSignature(dir string, trusted []ed25519.PublicKey) (*Signature, error) {
+	signature, _, err := checkSignature(dir, trusted)
+	return signature, err
+}
+
+// VerifySignature checks that the manifest of the bundle in dir is signed by
+// one of the trusted keys and that every file it records matches its
+// checksum, so that no file of the bundle was altered since it was signed.
+func VerifySignature(dir string, trusted []ed25519.PublicKey) (*Signature, error) {
+	signature, data, err := checkSignature(dir, trusted)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := DecodeManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	verifyFiles(dir, manifest, report)
+	var failed []string
+	for _, result := range report.Results {
+		if result.Status != StatusOK {
+			failed = append(failed, result.Name+" ("+string(result.Status)+")")
+		}
+	}
+	if len(failed) > 0 {
+		return nil, errors.Errorf("bundle files do not match the signed manifest: %s", strings.Join(failed, ", "))
+	}
+	return signature, nil
+}
+
+// checkSignature returns the signature of the manifest of the bundle in dir and the signed manifest.
+func checkSignature(dir string, trusted []ed25519.PublicKey) (*Signature, []byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read manifest")
+	}
+	signature, err := ReadSignature(filepath.Join(dir, SignatureFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, errors.New("bundle is not signed")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = signature.Verify(data, trusted); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid bundle signature")
+	}
+	return signature, data, nil
+}
@@ -0,0 +1,132 @@
+package bundle_test
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func newSigningKey(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	t.Helper()
+	key, err := bundle.GenerateEd25519Key()
+	require.NoError(t, err)
+	public, ok := key.Public().(ed25519.PublicKey)
+	require.True(t, ok)
+	return key, public
+}
+
+func writeSignedBundle(t *testing.T, key ed25519.PrivateKey) string {
+	t.Helper()
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1", "postgres:13"),
+	})
+	_, err := bundle.SignManifest(dir, key)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestVerifySignature(t *testing.T) {
+	key, public := newSigningKey(t)
+	_, other := newSigningKey(t)
+	dir := writeSignedBundle(t, key)
+
+	signature, err := bundle.VerifySignature(dir, []ed25519.PublicKey{other, public})
+	require.NoError(t, err)
+	assert.Equal(t, bundle.SigningKeyID(public), signature.KeyID)
+	assert.Regexp(t, `^ed25519:[0-9a-f]{16}$`, signature.KeyID)
+}
+
+func TestVerifySignature_Refused(t *testing.T) {
+	key, public := newSigningKey(t)
+	_, other := newSigningKey(t)
+
+	tests := []struct {
+		name    string
+		trusted []ed25519.PublicKey
+		modify  func(t *testing.T, dir string)
+		errMsg  string
+	}{
+		{
+			name:    "unsigned",
+			trusted: []ed25519.PublicKey{public},
+			modify: func(t *testing.T, dir string) {
+				require.NoError(t, os.Remove(filepath.Join(dir, bundle.SignatureFile)))
+			},
+			errMsg: "bundle is not signed",
+		},
+		{
+			name:   "no trusted key",
+			errMsg: "no trusted key was given",
+		},
+		{
+			name:    "untrusted key",
+			trusted: []ed25519.PublicKey{other},
+			errMsg:  "not a trusted key",
+		},
+		{
+			name:    "modified manifest",
+			trusted: []ed25519.PublicKey{public},
+			modify: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, bundle.ManifestFile)
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(path, append(data, ' '), 0600))
+			},
+			errMsg: "does not match",
+		},
+		{
+			name:    "modified file",
+			trusted: []ed25519.PublicKey{public},
+			modify: func(t *testing.T, dir string) {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ComposeFile), []byte("services: {}\n"), 0600))
+			},
+			errMsg: "docker-compose.generated.yaml (MISMATCH)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeSignedBundle(t, key)
+			if tt.modify != nil {
+				tt.modify(t, dir)
+			}
+			_, err := bundle.VerifySignature(dir, tt.trusted)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestCheckSignature_SkipsFiles(t *testing.T) {
+	key, public := newSigningKey(t)
+	dir := writeSignedBundle(t, key)
+	require.NoError(t, os.Remove(filepath.Join(dir, bundle.ImagesFile)))
+
+	_, err := bundle.CheckSignature(dir, []ed25519.PublicKey{public})
+	require.NoError(t, err)
+	_, err = bundle.VerifySignature(dir, []ed25519.PublicKey{public})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "images.tar (MISSING)")
+}
+
+func TestReadSigningKey_WrongKeyType(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privatePath, publicPath := writeKeyPair(t, t.TempDir(), "site", key, key.PublicKey())
+
+	_, err = bundle.ReadSigningKey(privatePath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not an ed25519 key")
+	_, err = bundle.LoadTrustedKeys([]string{publicPath})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not an ed25519 key")
+}
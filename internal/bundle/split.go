@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// PartName returns the file name of the index-th part of a split archive,
+// e.g. images.tar.000.
+func PartName(name string, index int) string {
+	return fmt.Sprintf("%s.%03d", name, index)
+}
+
+// ArchiveWriter writes an archive into a directory, either as a single file
+// or split into numbered parts of at most splitSize bytes, and records the
+// checksum of every file it creates.
+type ArchiveWriter struct {
+	dir       string
+	name      string
+	splitSize int64
+
+	current *os.File
+	hw      *HashWriter
+	written int64 // Bytes written to the current file
+	files   []File
+}
+
+// NewArchiveWriter returns a writer creating name inside dir. A splitSize of
+// zero or less disables splitting.
+func NewArchiveWriter(dir, name string, splitSize int64) *ArchiveWriter {
+	return &ArchiveWriter{dir: dir, name: name, splitSize: splitSize}
+}
+
+// Split reports whether the archive is written as numbered parts.
+func (w *ArchiveWriter) Split() bool {
+	return w.splitSize > 0
+}
+
+func (w *ArchiveWriter) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if w.current == nil {
+			if err := w.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if w.Split() && int64(len(chunk)) > w.splitSize-w.written {
+			chunk = chunk[:w.splitSize-w.written]
+		}
+		n, err := w.hw.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, errors.Wrapf(err, "failed to write %s", w.currentName())
+		}
+		p = p[n:]
+		if w.Split() && w.written == w.splitSize {
+			if closeErr := w.closeCurrent(); closeErr != nil {
+				return total, closeErr
+			}
+		}
+	}
+	return total, nil
+}
+
+// Close closes the file being written. An empty stream still produces one (empty) file.
+func (w *ArchiveWriter) Close() error {
+	if w.current == nil && len(w.files) == 0 {
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	return w.closeCurrent()
+}
+
+// Files returns the checksum records of the files written, in order.
+func (w *ArchiveWriter) Files() []File {
+	return w.files
+}
+
+// Parts returns the names of the parts written, or nil when the archive is not split.
+func (w *ArchiveWriter) Parts() []string {
+	if !w.Split() {
+		return nil
+	}
+	parts := make([]string, 0, len(w.files))
+	for _, file := range w.files {
+		parts = append(parts, file.Name)
+	}
+	return parts
+}
+
+func (w *ArchiveWriter) currentName() string {
+	if !w.Split() {
+		return w.name
+	}
+	return PartName(w.name, len(w.files))
+}
+
+func (w *ArchiveWriter) next() error {
+	file, err := os.Create(filepath.Join(w.dir, w.currentName()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", w.currentName())
+	}
+	w.current = file
+	w.hw = NewHashWriter(file)
+	w.written = 0
+	return nil
+}
+
+func (w *ArchiveWriter) closeCurrent() error {
+	if w.current == nil {
+		return nil
+	}
+	name := w.currentName()
+	err := w.current.Close()
+	w.current = nil
+	if err != nil {
+		return errors.Wrapf(err, "failed to close %s", name)
+	}
+	w.files = append(w.files, w.hw.File(name))
+	return nil
+}
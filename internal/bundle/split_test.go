@@ -0,0 +1,137 @@
+package bundle_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func writeArchive(t *testing.T, dir string, splitSize int64, content string) *bundle.ArchiveWriter {
+	t.Helper()
+	aw := bundle.NewArchiveWriter(dir, bundle.ImagesFile, splitSize)
+	// Write in small chunks so that writes straddle part boundaries
+	for _, chunk := range strings.Split(content, "|") {
+		_, err := aw.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	require.NoError(t, aw.Close())
+	return aw
+}
+
+func TestPartName(t *testing.T) {
+	assert.Equal(t, "images.tar.000", bundle.PartName("images.tar", 0))
+	assert.Equal(t, "images.tar.zst.012", bundle.PartName("images.tar.zst", 12))
+}
+
+func TestArchiveWriter_NoSplit(t *testing.T) {
+	dir := t.TempDir()
+	aw := writeArchive(t, dir, 0, "hello| world")
+
+	assert.False(t, aw.Split())
+	assert.Nil(t, aw.Parts())
+	require.Len(t, aw.Files(), 1)
+	assert.Equal(t, bundle.File{Name: bundle.ImagesFile, Size: 11, SHA256: helloWorldSHA256}, aw.Files()[0])
+
+	content, err := os.ReadFile(filepath.Join(dir, bundle.ImagesFile))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+}
+
+func TestArchiveWriter_Split(t *testing.T) {
+	dir := t.TempDir()
+	aw := writeArchive(t, dir, 4, "hel|lo w|orld")
+
+	assert.Equal(t, []string{"images.tar.000", "images.tar.001", "images.tar.002"}, aw.Parts())
+	expected := []string{"hell", "o wo", "rld"}
+	require.Len(t, aw.Files(), len(expected))
+	for i, file := range aw.Files() {
+		content, err := os.ReadFile(filepath.Join(dir, file.Name))
+		require.NoError(t, err)
+		assert.Equal(t, expected[i], string(content))
+		assert.Equal(t, int64(len(expected[i])), file.Size)
+		assert.LessOrEqual(t, file.Size, int64(4))
+
+		hashed, err := bundle.HashFile(filepath.Join(dir, file.Name), file.Name)
+		require.NoError(t, err)
+		assert.Equal(t, hashed, file)
+	}
+}
+
+func TestArchiveWriter_SplitExactMultiple(t *testing.T) {
+	aw := writeArchive(t, t.TempDir(), 4, "abcdefgh")
+	assert.Equal(t, []string{"images.tar.000", "images.tar.001"}, aw.Parts())
+}
+
+func TestArchiveWriter_Empty(t *testing.T) {
+	dir := t.TempDir()
+	aw := writeArchive(t, dir, 4, "")
+
+	assert.Equal(t, []string{"images.tar.000"}, aw.Parts())
+	info, err := os.Stat(filepath.Join(dir, "images.tar.000"))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestOpenArchive_ReassemblesParts(t *testing.T) {
+	dir := t.TempDir()
+	aw := writeArchive(t, dir, 3, "hello| world")
+
+	reader, err := bundle.OpenArchive(dir, bundle.Archive{
+		Name:        bundle.ImagesFile,
+		Compression: bundle.CompressionNone,
+		Parts:       aw.Parts(),
+	})
+	require.NoError(t, err)
+	defer reader.Close()
+
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+}
+
+func TestOpenArchive_MissingPart(t *testing.T) {
+	dir := t.TempDir()
+	aw := writeArchive(t, dir, 3, "hello world")
+	require.NoError(t, os.Remove(filepath.Join(dir, aw.Parts()[1])))
+
+	_, err := bundle.OpenArchive(dir, bundle.Archive{Name: bundle.ImagesFile, Parts: aw.Parts()})
+	assert.Error(t, err)
+}
+
+func TestVerify_SplitArchive(t *testing.T) {
+	archive := buildImageArchive(t, "web:v1", "postgres:13")
+	const partSize = 1000
+	files := map[string][]byte{bundle.ComposeFile: []byte(testCompose)}
+	var parts []string
+	for i := 0; i*partSize < len(archive); i++ {
+		name := bundle.PartName(bundle.ImagesFile, i)
+		files[name] = archive[i*partSize : min((i+1)*partSize, len(archive))]
+		parts = append(parts, name)
+	}
+	require.Greater(t, len(parts), 1)
+
+	withParts := func(m *bundle.Manifest) {
+		m.Archive = &bundle.Archive{Name: bundle.ImagesFile, Compression: bundle.CompressionNone, Parts: parts}
+	}
+
+	dir := writeTestBundle(t, files, withParts)
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image postgres:13").Status)
+
+	// A missing part is reported and skips the image check
+	require.NoError(t, os.Remove(filepath.Join(dir, parts[len(parts)-1])))
+	report, err = bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusMissing, findResult(t, report, parts[len(parts)-1]).Status)
+	assert.Equal(t, bundle.StatusError, findResult(t, report, "images").Status)
+}
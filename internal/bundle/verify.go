@@ -0,0 +1,240 @@
+package bundle
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Status is the outcome of a single verification check.
+type Status string
+
+const (
+	StatusOK       Status = "OK"
+	StatusMismatch Status = "MISMATCH"
+	StatusMissing  Status = "MISSING"
+	StatusError    Status = "ERROR"
+)
+
+// Result is the outcome of verifying one file or image of a bundle.
+type Result struct {
+	Name   string
+	Status Status
+	Detail string
+}
+
+// Report collects the verification results of a bundle.
+type Report struct {
+	Results []Result
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	for _, result := range r.Results {
+		if result.Status != StatusOK {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints one line per check to w.
+func (r *Report) Write(w io.Writer) {
+	for _, result := range r.Results {
+		if result.Detail != "" {
+			fmt.Fprintf(w, "%-8s %s: %s\n", result.Status, result.Name, result.Detail)
+		} else {
+			fmt.Fprintf(w, "%-8s %s\n", result.Status, result.Name)
+		}
+	}
+}
+
+func (r *Report) add(name string, status Status, detail string) {
+	r.Results = append(r.Results, Result{Name: name, Status: status, Detail: detail})
+}
+
+// Verify checks the integrity of the bundle stored in dir. It re-hashes every
+// file recorded in the manifest and checks that every image referenced by the
+// generated compose file, pinned or not, is present in the image archive. The images of a
+// bundle pushed to a registry or encrypted are not checked.
+// An error is returned only when the manifest itself cannot be read.
+func Verify(dir string) (*Report, error) {
+	manifest, err := ReadManifest(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	verified := verifyFiles(dir, manifest, report)
+
+	if manifest.Pushed() {
+		report.add("images", StatusOK, "pushed to registry "+manifest.Registry)
+		return report, nil
+	}
+	if manifest.Encryption != nil {
+		report.add("images", StatusOK, "encrypted, only checksums were verified")
+		return report, nil
+	}
+
+	archives := manifest.ImageArchives()
+	archivesVerified := true
+	for _, archive := range archives {
+		archivesVerified = archivesVerified && archiveVerified(manifest, archive, verified)
+	}
+	if !verified[ComposeFile] || !archivesVerified {
+		report.add("images", StatusError, "skipped image check because the compose file or image archive is invalid")
+		return report, nil
+	}
+	verifyImages(dir, manifest, archives, report)
+	return report, nil
+}
+
+// archiveVerified reports whether every file holding the image archive matched its checksum.
+func archiveVerified(manifest *Manifest, archive Archive, verified map[string]bool) bool {
+	if !archive.Directory() {
+		for _, name := range archive.FileNames() {
+			if !verified[name] {
+				return false
+			}
+		}
+		return true
+	}
+	found := false
+	for _, file := range manifest.Files {
+		if strings.HasPrefix(file.Name, archive.Name+"/") {
+			if !verified[file.Name] {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// verifyFiles re-hashes the files listed in the manifest and returns the set of files that matched.
+func verifyFiles(dir string, manifest *Manifest, report *Report) map[string]bool {
+	verified := make(map[string]bool, len(manifest.Files))
+	for _, expected := range manifest.Files {
+		actual, err := HashFile(filepath.Join(dir, expected.Name), expected.Name)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			report.add(expected.Name, StatusMissing, "")
+		case err != nil:
+			report.add(expected.Name, StatusError, err.Error())
+		case actual.Size != expected.Size:
+			report.add(expected.Name, StatusMismatch,
+				fmt.Sprintf("size %d, expected %d (truncated copy?)", actual.Size, expected.Size))
+		case actual.SHA256 != expected.SHA256:
+			report.add(expected.Name, StatusMismatch,
+				fmt.Sprintf("sha256 %s, expected %s", actual.SHA256, expected.SHA256))
+		default:
+			report.add(expected.Name, StatusOK, "")
+			verified[expected.Name] = true
+		}
+	}
+	return verified
+}
+
+// verifyImages checks that every image of the generated compose file is part of the image archives.
+func verifyImages(dir string, manifest *Manifest, archives []Archive, report *Report) {
+	images, err := composeImages(filepath.Join(dir, ComposeFile))
+	if err != nil {
+		report.add(ComposeFile, StatusError, err.Error())
+		return
+	}
+
+	archived := make(map[string]bool)
+	names := make([]string, 0, len(archives))
+	for _, archive := range archives {
+		found, archiveErr := archiveImages(dir, archive)
+		if archiveErr != nil {
+			report.add(archive.Name, StatusError, archiveErr.Error())
+			return
+		}
+		for image := range found {
+			archived[image] = true
+		}
+		names = append(names, archive.Name)
+	}
+
+	for _, image := range images {
+		name := "image " + image
+		if archived[NormalizeReference(archivedReference(manifest, image))] {
+			report.add(name, StatusOK, "")
+		} else {
+			report.add(name, StatusMissing, "not found in "+strings.Join(names, ", "))
+		}
+	}
+}
+
+// archivedReference returns the reference an image of the generated compose
+// file is tagged with in the image archives. Pinned images are mapped back to
+// the tag they were saved under: the digest of a name:tag@digest reference is
+// dropped and an image ID is looked up in the services of the manifest.
+func archivedReference(manifest *Manifest, image string) string {
+	if strings.HasPrefix(image, string(digest.SHA256)+":") {
+		for _, service := range manifest.Services {
+			if service.ImageID == image {
+				return service.Image
+			}
+		}
+		return image
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	tagged, isTagged := named.(reference.NamedTagged)
+	if _, isCanonical := named.(reference.Canonical); !isTagged || !isCanonical {
+		return image
+	}
+	trimmed, tagErr := reference.WithTag(reference.TrimNamed(named), tagged.Tag())
+	if tagErr != nil {
+		return image
+	}
+	return trimmed.String()
+}
+
+func archiveImages(dir string, archive Archive) (map[string]bool, error) {
+	reader, err := OpenArchive(dir, archive)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ArchiveImages(reader)
+}
+
+// composeImages returns the sorted, de-duplicated images referenced by a compose file.
+func composeImages(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read compose file")
+	}
+	var project struct {
+		Services map[string]struct {
+			Image string `yaml:"image"`
+		} `yaml:"services"`
+	}
+	if unmarshalErr := yaml.Unmarshal(data, &project); unmarshalErr != nil {
+		return nil, errors.Wrap(unmarshalErr, "failed to parse compose file")
+	}
+
+	seen := make(map[string]bool, len(project.Services))
+	images := make([]string, 0, len(project.Services))
+	for _, service := range project.Services {
+		if service.Image != "" && !seen[service.Image] {
+			seen[service.Image] = true
+			images = append(images, service.Image)
+		}
+	}
+	sort.Strings(images)
+	return images, nil
+}
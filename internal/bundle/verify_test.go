@@ -0,0 +1,277 @@
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const testCompose = `name: example
+services:
+  web:
+    image: web:v1
+  db:
+    image: postgres:13
+`
+
+// buildImageArchive returns a minimal `docker save` style archive containing the given tags.
+func buildImageArchive(t *testing.T, tags ...string) []byte {
+	t.Helper()
+	manifest, err := json.Marshal([]bundle.ArchiveManifestEntry{{
+		Config:   "blobs/sha256/1111",
+		RepoTags: tags,
+		Layers:   []string{"blobs/sha256/aaaa"},
+	}})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string][]byte{
+		"blobs/sha256/aaaa": []byte("layer"),
+		"blobs/sha256/1111": []byte("{}"),
+		"manifest.json":     manifest,
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// writeTestBundle writes a bundle with the given files and a manifest recording their checksums.
+// Options may adjust the manifest before it is written.
+func writeTestBundle(t *testing.T, files map[string][]byte, opts ...func(*bundle.Manifest)) string {
+	t.Helper()
+	dir := t.TempDir()
+	manifest := &bundle.Manifest{SchemaVersion: bundle.SchemaVersion, Project: "example"}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		require.NoError(t, os.WriteFile(path, content, 0600))
+		file, err := bundle.HashFile(path, name)
+		require.NoError(t, err)
+		manifest.Files = append(manifest.Files, file)
+	}
+	for _, opt := range opts {
+		opt(manifest)
+	}
+
+	out, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	defer out.Close()
+	require.NoError(t, manifest.Encode(out))
+	return dir
+}
+
+func findResult(t *testing.T, report *bundle.Report, name string) bundle.Result {
+	t.Helper()
+	for _, result := range report.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	t.Fatalf("no result for %s in %+v", name, report.Results)
+	return bundle.Result{}
+}
+
+func TestVerify_Success(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1", "postgres:13"),
+	})
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image web:v1").Status)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image postgres:13").Status)
+}
+
+func TestVerify_PushedBundle(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+	}, func(m *bundle.Manifest) {
+		m.Registry = "registry.site.local:5000"
+	})
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, "pushed to registry registry.site.local:5000", findResult(t, report, "images").Detail)
+}
+
+func TestVerify_PerServiceArchives(t *testing.T) {
+	web := bundle.Archive{Name: "images/web.tar", Format: bundle.FormatOCIArchive, Compression: bundle.CompressionNone}
+	db := bundle.Archive{Name: "images/db.tar", Format: bundle.FormatOCIArchive, Compression: bundle.CompressionNone}
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		web.Name:           buildImageArchive(t, "web:v1"),
+		db.Name:            buildImageArchive(t, "postgres:13"),
+	}, func(m *bundle.Manifest) {
+		m.Services = map[string]bundle.Service{
+			"web": {Image: "web:v1", Archive: &web},
+			"db":  {Image: "postgres:13", Archive: &db},
+		}
+	})
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.True(t, report.OK(), "%+v", report.Results)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image web:v1").Status)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, "image postgres:13").Status)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, db.Name)))
+	report, err = bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusMissing, findResult(t, report, db.Name).Status)
+}
+
+func TestVerify_PinnedImages(t *testing.T) {
+	const repoDigest = "sha256:4bcd1f2d3ec5a1e8f2b1e2c4a3e0c8c9d1c2b3a4f5e6d7c8b9a0f1e2d3c4b5a6"
+	tests := []struct {
+		name   string
+		image  string
+		status bundle.Status
+	}{
+		{name: "digest", image: "web:v1@" + repoDigest, status: bundle.StatusOK},
+		{name: "id", image: "sha256:2222", status: bundle.StatusOK},
+		{name: "unknown id", image: "sha256:9999", status: bundle.StatusMissing},
+		{name: "other tag", image: "web:v2@" + repoDigest, status: bundle.StatusMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeTestBundle(t, map[string][]byte{
+				bundle.ComposeFile: []byte("services:\n  web:\n    image: " + tt.image + "\n"),
+				bundle.ImagesFile:  buildImageArchive(t, "web:v1"),
+			}, func(m *bundle.Manifest) {
+				m.Services = map[string]bundle.Service{"web": {Image: "web:v1", ImageID: "sha256:2222"}}
+			})
+
+			report, err := bundle.Verify(dir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, findResult(t, report, "image "+tt.image).Status)
+		})
+	}
+}
+
+func TestVerify_TruncatedArchive(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1", "postgres:13"),
+	})
+	require.NoError(t, os.Truncate(filepath.Join(dir, bundle.ImagesFile), 100))
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+
+	result := findResult(t, report, bundle.ImagesFile)
+	assert.Equal(t, bundle.StatusMismatch, result.Status)
+	assert.Contains(t, result.Detail, "truncated")
+	assert.Equal(t, bundle.StatusError, findResult(t, report, "images").Status)
+}
+
+func TestVerify_CorruptedComposeFile(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1", "postgres:13"),
+	})
+	corrupted := bytes.Replace([]byte(testCompose), []byte("v1"), []byte("v2"), 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ComposeFile), corrupted, 0600))
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusMismatch, findResult(t, report, bundle.ComposeFile).Status)
+	assert.Equal(t, bundle.StatusOK, findResult(t, report, bundle.ImagesFile).Status)
+}
+
+func TestVerify_MissingFile(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1", "postgres:13"),
+	})
+	require.NoError(t, os.Remove(filepath.Join(dir, bundle.ImagesFile)))
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusMissing, findResult(t, report, bundle.ImagesFile).Status)
+}
+
+func TestVerify_ImageMissingFromArchive(t *testing.T) {
+	dir := writeTestBundle(t, map[string][]byte{
+		bundle.ComposeFile: []byte(testCompose),
+		bundle.ImagesFile:  buildImageArchive(t, "web:v1"),
+	})
+
+	report, err := bundle.Verify(dir)
+	require.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, bundle.StatusMissing, findResult(t, report, "image postgres:13").Status)
+}
+
+func TestVerify_MissingManifest(t *testing.T) {
+	_, err := bundle.Verify(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestReport_Write(t *testing.T) {
+	report := &bundle.Report{Results: []bundle.Result{
+		{Name: bundle.ImagesFile, Status: bundle.StatusOK},
+		{Name: bundle.ComposeFile, Status: bundle.StatusMismatch, Detail: "sha256 differs"},
+	}}
+
+	var buf bytes.Buffer
+	report.Write(&buf)
+	assert.Contains(t, buf.String(), "OK       images.tar")
+	assert.Contains(t, buf.String(), "MISMATCH docker-compose.generated.yaml: sha256 differs")
+}
+
+func TestNormalizeReference(t *testing.T) {
+	assert.Equal(t, "docker.io/library/nginx:latest", bundle.NormalizeReference("nginx"))
+	assert.Equal(t, "docker.io/library/nginx:1.27", bundle.NormalizeReference("docker.io/library/nginx:1.27"))
+	assert.Equal(t, "registry.local:5000/app/web:v1", bundle.NormalizeReference("registry.local:5000/app/web:v1"))
+	assert.Equal(t, "Not A Reference", bundle.NormalizeReference("Not A Reference"))
+}
+
+func TestPrefixReference(t *testing.T) {
+	const digest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	tests := []struct {
+		prefix   string
+		ref      string
+		expected string
+	}{
+		{"registry.site.local:5000", "nginx:1.27", "registry.site.local:5000/nginx:1.27"},
+		{"registry.site.local:5000/", "ghcr.io/org/app:v1", "registry.site.local:5000/org/app:v1"},
+		{"registry.site.local:5000/team", "web", "registry.site.local:5000/team/web:latest"},
+		{"registry.site.local:5000", "bitnami/redis:7@" + digest, "registry.site.local:5000/bitnami/redis:7"},
+		{"registry.site.local:5000", "registry.site.local:5000/web:v1", "registry.site.local:5000/web:v1"},
+	}
+	for _, tt := range tests {
+		name, err := bundle.PrefixReference(tt.prefix, tt.ref)
+		require.NoError(t, err, tt.ref)
+		assert.Equal(t, tt.expected, name, tt.ref)
+	}
+}
+
+func TestPrefixReference_Errors(t *testing.T) {
+	_, err := bundle.PrefixReference("registry.site.local:5000",
+		"nginx@sha256:0123456789012345678901234567890123456789012345678901234567890123")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "digest only")
+
+	_, err = bundle.PrefixReference("Not A Registry", "nginx")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid registry prefix")
+}
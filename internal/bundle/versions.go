@@ -0,0 +1,273 @@
+package bundle
+
+import (
+	"regexp"
+	"strings"
+)
+
+// versionCompare orders two versions of an ecosystem, returning a negative
+// number, zero or a positive number like strings.Compare.
+type versionCompare func(a, b string) int
+
+// compareNumeric orders two strings of decimal digits by value, whatever their length.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// leadingDigits splits s after its leading decimal digits.
+func leadingDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareDebian orders two Debian package versions, [epoch:]upstream[-revision],
+// as dpkg does.
+func compareDebian(a, b string) int {
+	epochA, upstreamA, revisionA := splitDebian(a)
+	epochB, upstreamB, revisionB := splitDebian(b)
+	if c := compareNumeric(epochA, epochB); c != 0 {
+		return c
+	}
+	if c := compareDebianPart(upstreamA, upstreamB); c != 0 {
+		return c
+	}
+	return compareDebianPart(revisionA, revisionB)
+}
+
+// splitDebian splits a Debian version into its epoch, upstream version and revision.
+func splitDebian(version string) (string, string, string) {
+	epoch := "0"
+	if before, after, found := strings.Cut(version, ":"); found {
+		if digits, rest := leadingDigits(before); digits != "" && rest == "" {
+			epoch, version = digits, after
+		}
+	}
+	revision := ""
+	if i := strings.LastIndexByte(version, '-'); i >= 0 {
+		version, revision = version[:i], version[i+1:]
+	}
+	return epoch, version, revision
+}
+
+// compareDebianPart compares upstream versions or revisions: alternating runs
+// of non-digits, compared with letters before other characters and ~ before
+// anything, even the end of the string, and runs of digits compared by value.
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		for a != "" && !isDigit(a[0]) || b != "" && !isDigit(b[0]) {
+			orderA, orderB := debianOrder(a), debianOrder(b)
+			if orderA != orderB {
+				return orderA - orderB
+			}
+			a, b = a[1:], b[1:]
+		}
+		var digitsA, digitsB string
+		digitsA, a = leadingDigits(a)
+		digitsB, b = leadingDigits(b)
+		if c := compareNumeric(digitsA, digitsB); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// debianOrder returns the sort weight of the first character of s.
+func debianOrder(s string) int {
+	const nonLetter = 256
+	switch {
+	case s == "" || isDigit(s[0]):
+		return 0
+	case s[0] == '~':
+		return -1
+	case isLetter(s[0]):
+		return int(s[0])
+	default:
+		return int(s[0]) + nonLetter
+	}
+}
+
+// apkSuffixes ranks the suffixes of apk versions. Suffixes before the empty
+// one mark pre-releases, those after it later snapshots or patches.
+var apkSuffixes = map[string]int{
+	"alpha": -4, "beta": -3, "pre": -2, "rc": -1, "": 0, "cvs": 1, "svn": 2, "git": 3, "hg": 4, "p": 5,
+}
+
+// apkVersion is a parsed apk version: numbers[letter][_suffix[number]]...[-r#].
+type apkVersion struct {
+	numbers  []string
+	letter   string
+	suffixes [][2]string
+	revision string
+}
+
+var apkVersionPattern = regexp.MustCompile(`^(\d+(?:\.\d+)*)([a-z]?)((?:_[a-z]+\d*)*)(?:-r(\d+))?$`)
+
+// parseApkVersion parses an apk version and reports whether it is valid.
+func parseApkVersion(version string) (apkVersion, bool) {
+	match := apkVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return apkVersion{}, false
+	}
+	v := apkVersion{numbers: strings.Split(match[1], "."), letter: match[2], revision: match[4]}
+	for _, suffix := range strings.Split(match[3], "_")[1:] {
+		name := strings.TrimRight(suffix, "0123456789")
+		if _, known := apkSuffixes[name]; !known {
+			return apkVersion{}, false
+		}
+		v.suffixes = append(v.suffixes, [2]string{name, suffix[len(name):]})
+	}
+	return v, true
+}
+
+// compareApk orders two Alpine package versions as apk does, comparing
+// versions it cannot parse as strings.
+func compareApk(a, b string) int {
+	va, okA := parseApkVersion(a)
+	vb, okB := parseApkVersion(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	for i := 0; i < len(va.numbers) && i < len(vb.numbers); i++ {
+		if c := compareNumeric(va.numbers[i], vb.numbers[i]); c != 0 {
+			return c
+		}
+	}
+	if c := len(va.numbers) - len(vb.numbers); c != 0 {
+		return c
+	}
+	if c := strings.Compare(va.letter, vb.letter); c != 0 {
+		return c
+	}
+	for i := 0; i < len(va.suffixes) || i < len(vb.suffixes); i++ {
+		var sa, sb [2]string
+		if i < len(va.suffixes) {
+			sa = va.suffixes[i]
+		}
+		if i < len(vb.suffixes) {
+			sb = vb.suffixes[i]
+		}
+		if c := apkSuffixes[sa[0]] - apkSuffixes[sb[0]]; c != 0 {
+			return c
+		}
+		if c := compareNumeric(sa[1], sb[1]); c != 0 {
+			return c
+		}
+	}
+	return compareNumeric(va.revision, vb.revision)
+}
+
+var pythonVersionPattern = regexp.MustCompile(`^v?(?:(\d+)!)?(\d+(?:\.\d+)*)` +
+	`(?:[-_.]?(a|b|c|rc|alpha|beta|pre|preview)[-_.]?(\d*))?` +
+	`(-(\d+)|[-_.]?(?:post|rev|r)[-_.]?(\d*))?` +
+	`([-_.]?dev[-_.]?(\d*))?` +
+	`(?:\+[a-z0-9]+(?:[-_.][a-z0-9]+)*)?$`)
+
+// pythonPhases ranks the pre-release phases of PEP 440 versions. Final
+// releases rank after every phase, and development releases of a final
+// release before every phase.
+var pythonPhases = map[string]int{"dev": 0, "a": 1, "b": 2, "rc": 3, "": 4}
+
+// pythonVersion is a parsed PEP 440 version.
+type pythonVersion struct {
+	epoch   string
+	release []string
+	phase   string
+	pre     string
+	post    *string // Post-release number, nil when there is none
+	dev     *string // Development release number, nil when there is none
+}
+
+// parsePythonVersion parses a PEP 440 version and reports whether it is valid.
+func parsePythonVersion(version string) (pythonVersion, bool) {
+	match := pythonVersionPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(version)))
+	if match == nil {
+		return pythonVersion{}, false
+	}
+	v := pythonVersion{epoch: match[1], release: strings.Split(match[2], "."), phase: match[3], pre: match[4]}
+	switch v.phase {
+	case "alpha":
+		v.phase = "a"
+	case "beta":
+		v.phase = "b"
+	case "c", "pre", "preview":
+		v.phase = "rc"
+	}
+	if match[5] != "" {
+		post := match[6] + match[7]
+		v.post = &post
+	}
+	if match[8] != "" {
+		v.dev = &match[9]
+	}
+	if v.phase == "" && v.post == nil && v.dev != nil {
+		v.phase = "dev"
+	}
+	return v, true
+}
+
+// comparePython orders two Python package versions as PEP 440 does,
+// comparing versions it cannot parse as strings.
+func comparePython(a, b string) int {
+	va, okA := parsePythonVersion(a)
+	vb, okB := parsePythonVersion(b)
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	if c := compareNumeric(va.epoch, vb.epoch); c != 0 {
+		return c
+	}
+	for i := 0; i < len(va.release) || i < len(vb.release); i++ {
+		if c := compareNumeric(segment(va.release, i), segment(vb.release, i)); c != 0 {
+			return c
+		}
+	}
+	if c := pythonPhases[va.phase] - pythonPhases[vb.phase]; c != 0 {
+		return c
+	}
+	if c := compareNumeric(va.pre, vb.pre); c != 0 {
+		return c
+	}
+	if c := compareOptional(va.post, vb.post, -1); c != 0 {
+		return c
+	}
+	return compareOptional(va.dev, vb.dev, 1)
+}
+
+// segment returns the i-th release segment, 0 past the last one.
+func segment(release []string, i int) string {
+	if i < len(release) {
+		return release[i]
+	}
+	return "0"
+}
+
+// compareOptional orders two optional numbers, a missing number ordering as
+// missing relative to a present one.
+func compareOptional(a, b *string, missing int) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return missing
+	case b == nil:
+		return -missing
+	default:
+		return compareNumeric(*a, *b)
+	}
+}
@@ -0,0 +1,290 @@
+package bundle
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// VulnerabilityReportFile is the name of the vulnerability report inside the output directory.
+const VulnerabilityReportFile = "vulnerabilities.json"
+
+// Severity rates a vulnerability.
+type Severity string
+
+const (
+	SeverityUnknown  Severity = "unknown"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+	// SeverityNone is a threshold no finding reaches.
+	SeverityNone Severity = "none"
+)
+
+// severities orders the severities from the least to the most severe.
+var severities = []Severity{SeverityUnknown, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical, SeverityNone}
+
+// ParseSeverityThreshold validates the lowest severity of the findings failing
+// a scan. An empty name selects high.
+func ParseSeverityThreshold(name string) (Severity, error) {
+	if name == "" {
+		return SeverityHigh, nil
+	}
+	for _, severity := range severities {
+		if string(severity) == name {
+			return severity, nil
+		}
+	}
+	return "", errors.Errorf("unsupported severity threshold %q, use unknown, low, medium, high, critical or none", name)
+}
+
+// AtLeast reports whether s is as severe as threshold or more.
+func (s Severity) AtLeast(threshold Severity) bool {
+	return s.rank() >= threshold.rank()
+}
+
+func (s Severity) rank() int {
+	for i, severity := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return 0
+}
+
+func maxSeverity(a, b Severity) Severity {
+	if b.rank() > a.rank() {
+		return b
+	}
+	return a
+}
+
+// ratingSeverity maps the textual ratings advisory databases use to severities.
+func ratingSeverity(rating string) Severity {
+	switch strings.ToLower(strings.TrimSpace(rating)) {
+	case "critical":
+		return SeverityCritical
+	case "high", "important":
+		return SeverityHigh
+	case "medium", "moderate":
+		return SeverityMedium
+	case "low", "negligible":
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// scoreSeverity maps a CVSS score to its qualitative severity rating.
+func scoreSeverity(score float64) Severity {
+	const critical, high, medium = 9.0, 7.0, 4.0
+	switch {
+	case score >= critical:
+		return SeverityCritical
+	case score >= high:
+		return SeverityHigh
+	case score >= medium:
+		return SeverityMedium
+	case score > 0:
+		return SeverityLow
+	default:
+		return SeverityUnknown
+	}
+}
+
+// advisorySeverity returns the highest severity an advisory records for the
+// affected package, from CVSS v3 vectors, textual ratings such as Ubuntu's,
+// or the severity of its database or ecosystem specific fields, and the
+// highest CVSS v3 base score among them.
+func advisorySeverity(advisory *Advisory, affected *Affected) (Severity, float64) {
+	severity, score := SeverityUnknown, 0.0
+	for _, s := range append(append([]AdvisorySeverity(nil), advisory.Severity...), affected.Severity...) {
+		if !strings.HasPrefix(s.Type, "CVSS_") {
+			severity = maxSeverity(severity, ratingSeverity(s.Score))
+		} else if base, ok := cvss3Score(s.Score); ok {
+			score = math.Max(score, base)
+			severity = maxSeverity(severity, scoreSeverity(base))
+		}
+	}
+	specifics := []json.RawMessage{advisory.DatabaseSpecific, affected.EcosystemSpecific, affected.DatabaseSpecific}
+	for _, raw := range specifics {
+		var specific struct {
+			Severity any `json:"severity"`
+		}
+		if json.Unmarshal(raw, &specific) != nil {
+			continue
+		}
+		if rating, ok := specific.Severity.(string); ok {
+			severity = maxSeverity(severity, ratingSeverity(rating))
+		}
+	}
+	return severity, score
+}
+
+// cvss3Weights are the weights of the base metric values of CVSS v3.
+var cvss3Weights = map[string]map[string]float64{
+	"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+	"AC": {"L": 0.77, "H": 0.44},
+	"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+	"UI": {"N": 0.85, "R": 0.62},
+	"C":  {"H": 0.56, "L": 0.22, "N": 0},
+	"I":  {"H": 0.56, "L": 0.22, "N": 0},
+	"A":  {"H": 0.56, "L": 0.22, "N": 0},
+}
+
+// cvss3ChangedPrivileges are the weights of privileges required when the scope changes.
+var cvss3ChangedPrivileges = map[string]float64{"N": 0.85, "L": 0.68, "H": 0.5}
+
+// cvss3Score computes the base score of a CVSS v3 vector and reports whether the vector is valid.
+func cvss3Score(vector string) (float64, bool) {
+	const (
+		unchangedImpact   = 6.42
+		changedImpact     = 7.52
+		changedOffset     = 0.029
+		changedPenalty    = 3.25
+		changedPenaltyAt  = 0.02
+		changedExponent   = 15
+		exploitability    = 8.22
+		changedMultiplier = 1.08
+		maxScore          = 10
+	)
+	parts := strings.Split(vector, "/")
+	if !strings.HasPrefix(parts[0], "CVSS:3.") {
+		return 0, false
+	}
+	metrics := make(map[string]string, len(parts))
+	for _, part := range parts[1:] {
+		name, value, _ := strings.Cut(part, ":")
+		metrics[name] = value
+	}
+	weights := make(map[string]float64, len(cvss3Weights))
+	for name, values := range cvss3Weights {
+		weight, ok := values[metrics[name]]
+		if !ok {
+			return 0, false
+		}
+		weights[name] = weight
+	}
+	changed := metrics["S"] == "C"
+	if !changed && metrics["S"] != "U" {
+		return 0, false
+	}
+	if changed {
+		weights["PR"] = cvss3ChangedPrivileges[metrics["PR"]]
+	}
+
+	iss := 1 - (1-weights["C"])*(1-weights["I"])*(1-weights["A"])
+	impact := unchangedImpact * iss
+	if changed {
+		impact = changedImpact*(iss-changedOffset) - changedPenalty*math.Pow(iss-changedPenaltyAt, changedExponent)
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	base := impact + exploitability*weights["AV"]*weights["AC"]*weights["PR"]*weights["UI"]
+	if changed {
+		base *= changedMultiplier
+	}
+	return roundUp(math.Min(base, maxScore)), true
+}
+
+// roundUp rounds a score up to one decimal as CVSS v3.1 specifies, ignoring
+// floating point errors below the fifth decimal.
+func roundUp(score float64) float64 {
+	const precision, step = 100000, 10000
+	scaled := int(math.Round(score * precision))
+	if scaled%step == 0 {
+		return float64(scaled) / precision
+	}
+	return float64(scaled/step+1) / (precision / step)
+}
+
+// Finding is a package of a service image affected by an advisory.
+type Finding struct {
+	Service  string      `json:"service"`
+	Image    string      `json:"image"`
+	Package  string      `json:"package"`
+	Version  string      `json:"version"`
+	Type     PackageType `json:"type"`
+	PURL     string      `json:"purl"`
+	Location string      `json:"location"` // Package database file listing the package
+	Advisory string      `json:"advisory"`
+	Aliases  []string    `json:"aliases,omitempty"`
+	Summary  string      `json:"summary,omitempty"`
+	Severity Severity    `json:"severity"`
+	Score    float64     `json:"cvss_score,omitempty"`    // Highest CVSS v3 base score of the advisory
+	Fixed    string      `json:"fixed_version,omitempty"` // Version fixing the vulnerability, when one is known
+}
+
+// VulnerabilityReport lists the findings of a scan of the service images.
+type VulnerabilityReport struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Database  ScannedDatabase  `json:"database"`
+	Threshold Severity         `json:"threshold"` // Lowest severity failing the scan
+	Failed    int              `json:"failed"`    // Findings at or above the threshold
+	Summary   map[Severity]int `json:"summary"`   // Findings by severity
+	Findings  []Finding        `json:"findings"`
+}
+
+// ScannedDatabase describes the advisory database of a scan.
+type ScannedDatabase struct {
+	Name       string    `json:"name"`
+	Advisories int       `json:"advisories"`
+	Modified   time.Time `json:"modified"` // Latest modification of an advisory, telling how current the database is
+}
+
+// NewVulnerabilityReport reports findings of a scan against db, the most
+// severe first.
+func NewVulnerabilityReport(db *AdvisoryDB, threshold Severity, findings []Finding) *VulnerabilityReport {
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Severity != b.Severity {
+			return a.Severity.rank() > b.Severity.rank()
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Advisory < b.Advisory
+	})
+	report := &VulnerabilityReport{
+		CreatedAt: time.Now().UTC(),
+		Database:  ScannedDatabase{Name: db.Name, Advisories: db.Len(), Modified: db.Modified},
+		Threshold: threshold,
+		Summary:   make(map[Severity]int),
+		Findings:  append([]Finding{}, findings...),
+	}
+	for _, finding := range findings {
+		report.Summary[finding.Severity]++
+		if finding.Severity.AtLeast(threshold) {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// Failing returns the findings at or above the threshold of the report.
+func (r *VulnerabilityReport) Failing() []Finding {
+	var failing []Finding
+	for _, finding := range r.Findings {
+		if finding.Severity.AtLeast(r.Threshold) {
+			failing = append(failing, finding)
+		}
+	}
+	return failing
+}
+
+// Encode writes the report as indented JSON.
+func (r *VulnerabilityReport) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
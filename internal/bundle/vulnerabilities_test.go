@@ -0,0 +1,64 @@
+package bundle_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+func TestParseSeverityThreshold(t *testing.T) {
+	threshold, err := bundle.ParseSeverityThreshold("")
+	require.NoError(t, err)
+	assert.Equal(t, bundle.SeverityHigh, threshold)
+
+	threshold, err = bundle.ParseSeverityThreshold("none")
+	require.NoError(t, err)
+	assert.False(t, bundle.SeverityCritical.AtLeast(threshold))
+
+	threshold, err = bundle.ParseSeverityThreshold("unknown")
+	require.NoError(t, err)
+	assert.True(t, bundle.SeverityUnknown.AtLeast(threshold))
+
+	_, err = bundle.ParseSeverityThreshold("severe")
+	assert.ErrorContains(t, err, "unsupported severity threshold")
+}
+
+func TestNewVulnerabilityReport(t *testing.T) {
+	db := readAdvisories(t, osvAdvisory("DSA-5678-1", "Debian:12", "glibc", "2.36-9+deb12u7", ""))
+	findings := []bundle.Finding{
+		{Service: "web", Package: "zlib1g", Advisory: "DSA-2", Severity: bundle.SeverityMedium},
+		{Service: "web", Package: "libc6", Advisory: "DSA-1", Severity: bundle.SeverityCritical},
+		{Service: "db", Package: "libc6", Advisory: "DSA-1", Severity: bundle.SeverityCritical},
+		{Service: "db", Package: "bash", Advisory: "DSA-3", Severity: bundle.SeverityHigh},
+		{Service: "db", Package: "tar", Advisory: "DEBIAN-CVE-1", Severity: bundle.SeverityUnknown},
+	}
+
+	report := bundle.NewVulnerabilityReport(db, bundle.SeverityHigh, findings)
+	assert.Equal(t, bundle.ScannedDatabase{Name: "advisories.json", Advisories: 1, Modified: db.Modified}, report.Database)
+	assert.Equal(t, 3, report.Failed)
+	assert.Equal(t, map[bundle.Severity]int{
+		bundle.SeverityCritical: 2, bundle.SeverityHigh: 1, bundle.SeverityMedium: 1, bundle.SeverityUnknown: 1,
+	}, report.Summary)
+	order := make([]string, 0, len(report.Findings))
+	for _, finding := range report.Findings {
+		order = append(order, finding.Service+"/"+finding.Package)
+	}
+	assert.Equal(t, []string{"db/libc6", "web/libc6", "db/bash", "web/zlib1g", "db/tar"}, order)
+	assert.Len(t, report.Failing(), 3)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Encode(&buf))
+	var decoded bundle.VulnerabilityReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, bundle.SeverityHigh, decoded.Threshold)
+	assert.Len(t, decoded.Findings, 5)
+
+	report = bundle.NewVulnerabilityReport(db, bundle.SeverityNone, nil)
+	assert.Zero(t, report.Failed)
+	assert.NotNil(t, report.Findings)
+}
@@ -0,0 +1,113 @@
+package compose
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// saveFromCache exports the images missing from the layer cache and writes the
+// image archives from the cached blobs. Images are exported whole, cached
+// layers included, since the Docker API cannot export single layers. Without a
+// configured layer cache, as for per-service archives, a temporary cache in the
+// output directory is used.
+func (c *Client) saveFromCache(
+	ctx context.Context, cli *client.Client, format bundle.Format, compression bundle.Compression,
+) error {
+	cache, cleanup, err := c.openLayerCache()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	images := c.layoutImages()
+	var missing []bundle.LayoutImage
+	var refs []string
+	seen := make(map[string]bool)
+	for _, img := range images {
+		if _, cached := cache.Image(img.ID); cached {
+			continue
+		}
+		missing = append(missing, img)
+		if !seen[img.Ref] {
+			seen[img.Ref] = true
+			refs = append(refs, img.Ref)
+		}
+	}
+	if c.Config.LayerCache != "" {
+		c.Logger.Infof("Reusing %d of %d images from the layer cache %s",
+			len(images)-len(missing), len(images), c.Config.LayerCache)
+	}
+	if len(refs) > 0 {
+		if storeErr := c.cacheImages(ctx, cli, cache, refs, missing); storeErr != nil {
+			return storeErr
+		}
+	}
+
+	if c.Config.PerService {
+		if serviceErr := c.writeServiceArchives(cache, compression); serviceErr != nil {
+			return serviceErr
+		}
+	}
+	if c.Config.SkipCombined {
+		return nil
+	}
+	reader := cachedArchive(cache, images)
+	defer reader.Close()
+	return c.writeImages(reader, format, compression)
+}
+
+// openLayerCache opens the configured layer cache, or creates a temporary one
+// removed by the returned cleanup function.
+func (c *Client) openLayerCache() (*bundle.LayerCache, func(), error) {
+	if c.Config.LayerCache != "" {
+		cache, err := bundle.OpenLayerCache(c.Config.LayerCache)
+		return cache, func() {}, err
+	}
+	dir, err := os.MkdirTemp(c.Config.OutputDir, ".layers-*")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create temporary layer cache")
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	cache, err := bundle.OpenLayerCache(dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return cache, cleanup, nil
+}
+
+// cacheImages exports images from the daemon into the layer cache.
+func (c *Client) cacheImages(
+	ctx context.Context, cli *client.Client, cache *bundle.LayerCache, refs []string, images []bundle.LayoutImage,
+) error {
+	reader, err := cli.ImageSave(ctx, refs)
+	if err != nil {
+		return errors.Wrap(err, "failed to save images")
+	}
+	defer reader.Close()
+
+	stats, err := cache.Store(reader, images)
+	if err != nil {
+		return errors.Wrap(err, "failed to store images in the layer cache")
+	}
+	if c.Config.LayerCache != "" {
+		const bytesToGB = 1024 * 1024 * 1024
+		c.Logger.Infof("Exported %d images: %d new blobs (%.2f GB) added to the layer cache, %d already cached",
+			len(refs), stats.Added, float64(stats.Size)/bytesToGB, stats.Reused)
+	}
+	return nil
+}
+
+// cachedArchive streams the cached images as an image archive.
+func cachedArchive(cache *bundle.LayerCache, images []bundle.LayoutImage) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(cache.WriteArchive(writer, images))
+	}()
+	return reader
+}
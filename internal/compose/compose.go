@@ -2,22 +2,32 @@ package compose
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/compose-spec/compose-go/v2/cli"
 	"github.com/compose-spec/compose-go/v2/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
 	"github.com/docker/cli/cli/command"
 	"github.com/docker/cli/cli/flags"
 	"github.com/docker/compose/v2/pkg/api"
 	"github.com/docker/compose/v2/pkg/compose"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	mcp "github.com/modelcontextprotocol/go-sdk/mcp"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/sunpia/docker-deliver/internal/bundle"
 	mcp_internal "github.com/sunpia/docker-deliver/internal/mcp"
+	"github.com/sunpia/docker-deliver/internal/policy"
+	"github.com/sunpia/docker-deliver/internal/version"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,15 +36,72 @@ type Config struct {
 	DockerComposePath []string `json:"docker_compose_path"`
 	WorkDir           string   `json:"work_dir"`
 	OutputDir         string   `json:"output_dir"`
-	Tag               string   `json:"tag"`      // Default tag for images
-	LogLevel          string   `json:"loglevel"` // Log level: "debug", "info", "warn", "error"
+	Tag               string   `json:"tag"`                       // Default tag for images
+	LogLevel          string   `json:"loglevel"`                  // Log level: "debug", "info", "warn", "error"
+	Compress          string   `json:"compress,omitempty"`        // Image archive compression: "gzip", "zstd", "none"
+	CompressLevel     int      `json:"compress_level,omitempty"`  // Compression level, 0 selects the default
+	SplitSize         int64    `json:"split_size,omitempty"`      // Maximum size in bytes of each archive part, 0 disables splitting
+	Base              string   `json:"base,omitempty"`            // Manifest of a previous delivery, only layers it lacks are archived
+	Inventory         string   `json:"inventory,omitempty"`       // Inventory of the target host, only layers it lacks are archived
+	Pull              string   `json:"pull,omitempty"`            // Pull images not built locally: "missing" (default), "always", "never"
+	PinImages         string   `json:"pin_images,omitempty"`      // Pin images in the generated compose file: "digest", "id"
+	TagTemplate       string   `json:"tag_template,omitempty"`    // Go template naming service images, see TagData
+	TagAll            bool     `json:"tag_all,omitempty"`         // Apply TagTemplate to services that declare an image
+	Registry          string   `json:"registry,omitempty"`        // Registry available to TagTemplate as {{.Registry}}
+	RegistryPrefix    string   `json:"registry_prefix,omitempty"` // Move every image under this registry prefix
+	ToRegistry        string   `json:"to_registry,omitempty"`     // Push images to this registry instead of writing an image archive
+	Format            string   `json:"format,omitempty"`          // Image archive format: "docker-archive" (default), "oci", "oci-archive"
+	LayerCache        string   `json:"layer_cache,omitempty"`     // Directory caching image blobs between saves
+	PerService        bool     `json:"per_service,omitempty"`     // Also write an image archive per service under images/
+	SkipCombined      bool     `json:"skip_combined,omitempty"`   // Write only the per-service archives, without images.tar
+	Installer         bool     `json:"installer,omitempty"`       // Also write the bundle as a self-extracting install.sh
+	Encrypt           bool     `json:"encrypt,omitempty"`         // Encrypt the image archives and the generated compose file
+	Recipients        []string `json:"recipients,omitempty"`      // X25519 public key files to encrypt to
+	PassphraseFile    string   `json:"passphrase_file,omitempty"` // File holding the passphrase to encrypt with
+	SignKey           string   `json:"sign_key,omitempty"`        // ed25519 private key file signing the manifest
+	SBOM              string   `json:"sbom,omitempty"`            // Write an SBOM per service: "spdx", "cyclonedx"
+	Provenance        bool     `json:"provenance,omitempty"`      // Write a provenance attestation of every built service
+	ScanDB            string   `json:"scan_db,omitempty"`         // OSV advisory database the image packages are scanned against
+	ScanFailOn        string   `json:"scan_fail_on,omitempty"`    // Lowest finding severity failing the scan, see bundle.ParseSeverityThreshold
+	Secrets           string   `json:"secrets,omitempty"`         // Secrets found in the image layers: "fail" (default), "warn", "off"
+	SecretsIgnore     []string `json:"secrets_ignore,omitempty"`  // Image paths left out of the secret scan, in .dockerignore syntax
+	Policy            string   `json:"policy,omitempty"`          // YAML policy file the services must follow
 }
 
+const (
+	// PinDigest pins images pushed with ToRegistry as name:tag@sha256:<repo digest>,
+	// falling back to the image ID for images without one. Images saved to an
+	// archive are pinned by ID: `docker load` restores no registry digest.
+	PinDigest = "digest"
+	// PinID pins images by image ID, which resolves after any `docker load`.
+	PinID = "id"
+)
+
+const (
+	// SecretsFail fails the save when the image layers hold likely secrets.
+	SecretsFail = "fail"
+	// SecretsWarn only logs the likely secrets of the image layers.
+	SecretsWarn = "warn"
+	// SecretsOff skips the secret scan.
+	SecretsOff = "off"
+)
+
 // Interface defines the main Compose actions.
 type Interface interface {
 	SaveImages(ctx context.Context) error
+	ScanSecrets(ctx context.Context) error
+	CheckServicePolicy(ctx context.Context) error
+	CheckPolicy(ctx context.Context) (string, error)
 	SaveComposeFile(ctx context.Context) (string, error)
+	WriteSBOMs(ctx context.Context) ([]string, error)
+	WriteProvenance(ctx context.Context) ([]string, error)
+	ScanVulnerabilities(ctx context.Context) (string, error)
+	WriteManifest(ctx context.Context) (string, error)
+	SignManifest(ctx context.Context) (string, error)
+	WriteInstaller(ctx context.Context) (string, error)
+	Pull(ctx context.Context) error
 	Build(ctx context.Context) error
+	Push(ctx context.Context) error
 	Run(ctx context.Context) (string, error)
 }
 
@@ -47,6 +114,7 @@ type Dependencies struct {
 	ProjectFromOptions func(context.Context, *cli.ProjectOptions) (*types.Project, error)
 	NewDockerClient    func() (*client.Client, error)
 	NewDockerCli       func(client.APIClient) (*command.DockerCli, error)
+	GitOutput          func(ctx context.Context, dir string, args ...string) (string, error)
 }
 
 // DefaultDependencies returns the default production dependencies.
@@ -65,6 +133,7 @@ func DefaultDependencies() *Dependencies {
 		NewDockerCli: func(apiClient client.APIClient) (*command.DockerCli, error) {
 			return command.NewDockerCli(command.WithAPIClient(apiClient))
 		},
+		GitOutput: gitOutput,
 	}
 }
 
@@ -73,10 +142,30 @@ type Client struct {
 	Interface // Interface embedding
 	mcp_internal.RegisterInterface
 
-	Config  Config
-	Project *types.Project
-	Logger  *logrus.Logger
-	Deps    *Dependencies
+	Config          Config
+	Project         *types.Project
+	Tag             string                           // Tag of the images named by TagTemplate, recorded in the manifest
+	Images          map[string]image.InspectResponse // Inspected images keyed by service name, filled by SaveImages
+	Files           []bundle.File                    // Checksums of the files written to OutputDir
+	Archive         *bundle.Archive                  // Image archive written by SaveImages
+	ServiceArchives map[string]*bundle.Archive       // Per-service image archives written by SaveImages
+	Base            *bundle.Manifest                 // Previous delivery the archive is a delta against
+	Inventory       *bundle.Inventory                // Images of the target host the archive is a delta against
+	ExcludedLayers  []string                         // Diff IDs of the layers left out of the archive
+	Keys            *bundle.Keys                     // Keys the bundle is encrypted to, nil when it is not encrypted
+	SigningKey      ed25519.PrivateKey               // Key signing the manifest, nil when it is not signed
+	Packages        map[string][]bundle.Package      // Packages installed in the image of each service, found by SaveImages
+	SBOMs           map[string]string                // SBOM file of each service written by WriteSBOMs
+	Provenance      map[string]*bundle.Statement     // Provenance of each service built by Build
+	Attestations    map[string]string                // Attestation file of each service written by WriteProvenance
+	Advisories      *bundle.AdvisoryDB               // Advisory database loaded from ScanDB
+	Vulnerabilities *bundle.VulnerabilityReport      // Findings of ScanVulnerabilities
+	Policy          *policy.Policy                   // Policy loaded from Config.Policy
+	PolicyReport    *policy.Report                   // Violations found by CheckServicePolicy and CheckPolicy
+	SecretScanner   *bundle.SecretScanner            // Scanner created from Secrets and SecretsIgnore, nil when off
+	Secrets         []bundle.SecretFinding           // Likely secrets found in the layers of the service images
+	Logger          *logrus.Logger
+	Deps            *Dependencies
 }
 
 func DeliverProject(
@@ -138,6 +227,9 @@ func NewComposeClientWithDeps(ctx context.Context, config Config, deps *Dependen
 	if loadErr := c.load(ctx); loadErr != nil {
 		return nil, errors.Wrap(loadErr, "error loading compose file")
 	}
+	if policyErr := c.loadPolicy(); policyErr != nil {
+		return nil, policyErr
+	}
 
 	if _, statErr := os.Stat(c.Config.OutputDir); os.IsNotExist(statErr) {
 		const dirPermissions = 0755
@@ -171,63 +263,271 @@ func (c *Client) SaveComposeFile(_ context.Context) (string, error) {
 	if c.Project == nil {
 		return "", nil
 	}
-	outPath := filepath.Join(c.Config.OutputDir, "docker-compose.generated.yaml")
+	if err := c.loadKeys(); err != nil {
+		return "", err
+	}
+	name := bundle.ComposeFile
+	if c.Keys != nil {
+		name += bundle.EncryptedExtension
+	}
+	outPath := filepath.Join(c.Config.OutputDir, name)
 	file, err := c.Deps.OSCreate(outPath)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to create compose file")
 	}
 	defer file.Close()
 
-	data, err := c.Deps.YAMLMarshal(c.Project)
+	project, err := c.pinnedProject()
+	if err != nil {
+		return "", err
+	}
+	data, err := c.Deps.YAMLMarshal(project)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to marshal compose project")
 	}
 
-	if _, writeErr := file.Write(data); writeErr != nil {
+	hw := bundle.NewHashWriter(file)
+	w, err := c.encryptWriter(hw)
+	if err != nil {
+		return "", err
+	}
+	if _, writeErr := w.Write(data); writeErr != nil {
 		return "", errors.Wrap(writeErr, "failed to write compose file")
 	}
+	if closeErr := w.Close(); closeErr != nil {
+		return "", errors.Wrap(closeErr, "failed to write compose file")
+	}
+	c.recordFile(hw.File(name))
 	return outPath, nil
 }
 
-// Build builds all services in the compose project.
-func (c *Client) Build(ctx context.Context) error {
-	project := c.Project
-	if project == nil {
+// pinnedProject returns the project to write to the generated compose file,
+// with service images pinned to the images inspected by SaveImages if configured.
+// The client project keeps the original references.
+func (c *Client) pinnedProject() (*types.Project, error) {
+	if c.Config.PinImages == "" {
+		return c.Project, nil
+	}
+	if err := validatePinImages(c.Config.PinImages); err != nil {
+		return nil, err
+	}
+	mode := c.Config.PinImages
+	if c.Config.ToRegistry == "" {
+		mode = PinID
+	}
+	project, err := c.Project.WithServicesTransform(func(name string, s types.ServiceConfig) (types.ServiceConfig, error) {
+		inspect, ok := c.Images[name]
+		if !ok {
+			return s, nil
+		}
+		s.Image = pinnedReference(s.Image, inspect, mode)
+		return s, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to pin images")
+	}
+	return project, nil
+}
+
+// validatePinImages checks the image pinning mode of the configuration.
+func validatePinImages(mode string) error {
+	switch mode {
+	case "", PinDigest, PinID:
 		return nil
+	default:
+		return errors.Errorf("unsupported image pinning %q, expected digest or id", mode)
 	}
+}
 
-	for _, s := range project.Services {
-		if s.Image == "" {
-			s.Image = s.Name + ":" + c.Config.Tag
-			project.Services[s.Name] = s
-			c.Logger.Debugf("Tag Service %s image tag: %s", s.Name, s.Image)
+// pinnedReference returns ref pinned to the inspected image.
+func pinnedReference(ref string, inspect image.InspectResponse, mode string) string {
+	if mode != PinDigest {
+		return inspect.ID
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return inspect.ID
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		canonical, parseErr := reference.ParseNormalizedNamed(repoDigest)
+		if parseErr != nil || canonical.Name() != named.Name() {
+			continue
 		}
+		digested, ok := canonical.(reference.Canonical)
+		if !ok {
+			continue
+		}
+		pinned, digestErr := reference.WithDigest(reference.TagNameOnly(named), digested.Digest())
+		if digestErr == nil {
+			return reference.FamiliarString(pinned)
+		}
+	}
+	return inspect.ID
+}
+
+// NewBackend creates the compose backend used to build and run projects.
+// The returned closer releases the underlying Docker client.
+func NewBackend(deps *Dependencies) (api.Service, io.Closer, error) {
+	dockerClient, err := deps.NewDockerClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dockerCli, err := deps.NewDockerCli(dockerClient)
+	if err != nil {
+		_ = dockerClient.Close()
+		return nil, nil, err
+	}
+
+	if initErr := dockerCli.Initialize(flags.NewClientOptions()); initErr != nil {
+		_ = dockerClient.Close()
+		return nil, nil, initErr
+	}
+
+	backend := deps.NewComposeService(dockerCli)
+	if backend == nil {
+		_ = dockerClient.Close()
+		return nil, nil, errors.New("failed to create compose backend")
 	}
+	return backend, dockerClient, nil
+}
 
-	dockerClient, err := c.Deps.NewDockerClient()
+// Pull pulls the images of services without a build section. With the default
+// "missing" policy only images absent from the local daemon are pulled, "always"
+// pulls every such image and "never" fails if any of them is absent.
+// Registry credentials are read from the Docker client configuration.
+func (c *Client) Pull(ctx context.Context) error {
+	if c.Project == nil {
+		return nil
+	}
+	policy, err := parsePullPolicy(c.Config.Pull)
 	if err != nil {
 		return err
 	}
-	defer dockerClient.Close()
 
-	dockerCli, err := c.Deps.NewDockerCli(dockerClient)
+	var services []string
+	for _, s := range c.Project.Services {
+		if s.Build == nil && s.Image != "" {
+			services = append(services, s.Name)
+		}
+	}
+	sort.Strings(services)
+	if len(services) == 0 {
+		return nil
+	}
 
+	if policy != types.PullPolicyAlways {
+		missing, missingErr := c.missingImages(ctx, services)
+		if missingErr != nil {
+			return missingErr
+		}
+		if policy == types.PullPolicyNever && len(missing) > 0 {
+			images := make([]string, 0, len(missing))
+			for _, name := range missing {
+				images = append(images, c.Project.Services[name].Image)
+			}
+			return errors.Errorf("images are not present locally and pulling is disabled: %s",
+				strings.Join(images, ", "))
+		}
+		services = missing
+	}
+	if len(services) == 0 {
+		c.Logger.Debug("All referenced images are present locally")
+		return nil
+	}
+
+	project, err := c.Project.WithSelectedServices(services, types.IgnoreDependencies)
+	if err != nil {
+		return errors.Wrap(err, "failed to select services to pull")
+	}
+	for name, s := range project.Services {
+		s.PullPolicy = types.PullPolicyAlways
+		project.Services[name] = s
+	}
+
+	backend, closer, err := NewBackend(c.Deps)
 	if err != nil {
 		return err
 	}
+	defer closer.Close()
 
-	if initErr := dockerCli.Initialize(flags.NewClientOptions()); initErr != nil {
-		return initErr
+	c.Logger.Infof("Pulling images of services %s", strings.Join(services, ", "))
+	if pullErr := backend.Pull(ctx, project, api.PullOptions{}); pullErr != nil {
+		return errors.Wrap(pullErr, "failed to pull images")
 	}
+	return nil
+}
 
-	backend := c.Deps.NewComposeService(dockerCli)
-	if backend == nil {
+// parsePullPolicy validates the pull policy of the configuration, an empty policy means "missing".
+func parsePullPolicy(policy string) (string, error) {
+	switch policy {
+	case "":
+		return types.PullPolicyMissing, nil
+	case types.PullPolicyMissing, types.PullPolicyAlways, types.PullPolicyNever:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unsupported pull policy %q, expected missing, always or never", policy)
+	}
+}
+
+// missingImages returns the services whose image is not present in the local daemon.
+func (c *Client) missingImages(ctx context.Context, services []string) ([]string, error) {
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	var missing []string
+	for _, name := range services {
+		img := c.Project.Services[name].Image
+		_, inspectErr := cli.ImageInspect(ctx, img)
+		if cerrdefs.IsNotFound(inspectErr) {
+			c.Logger.Debugf("Image %s of service %s is not present locally", img, name)
+			missing = append(missing, name)
+		} else if inspectErr != nil {
+			return nil, errors.Wrapf(inspectErr, "failed to inspect image %s", img)
+		}
+	}
+	return missing, nil
+}
+
+// Build builds all services in the compose project.
+func (c *Client) Build(ctx context.Context) error {
+	project := c.Project
+	if project == nil {
+		return nil
+	}
+
+	retag, err := c.nameImages(ctx)
+	if err != nil {
 		return err
 	}
+	var statements map[string]*bundle.Statement
+	if c.Config.Provenance {
+		if statements, err = c.provenanceStatements(ctx); err != nil {
+			return err
+		}
+	}
 
+	backend, closer, err := NewBackend(c.Deps)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	started := time.Now().UTC()
 	if buildErr := backend.Build(ctx, project, api.BuildOptions{}); buildErr != nil {
 		return errors.Wrap(buildErr, "failed to build project")
 	}
+	if tagErr := c.tagImages(ctx, retag); tagErr != nil {
+		return tagErr
+	}
+	if len(statements) > 0 {
+		if provenanceErr := c.completeProvenance(ctx, statements, started); provenanceErr != nil {
+			return provenanceErr
+		}
+	}
 
 	for _, s := range project.Services {
 		if s.Build != nil {
@@ -239,8 +539,153 @@ func (c *Client) Build(ctx context.Context) error {
 	return nil
 }
 
-// SaveImages saves all images from the compose project to a tar archive.
+// nameImages names the images of services without an image, or of every
+// service with TagAll, using the tag template, and moves every image under the
+// registry prefix. It returns the new names of images that are not built,
+// mapped to the existing images they must be tagged from.
+func (c *Client) nameImages(ctx context.Context) (map[string]string, error) {
+	tmpl, err := ParseTagTemplate(c.Config.TagTemplate)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.tagData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := c.registryPrefix()
+	if err != nil {
+		return nil, err
+	}
+
+	retag := make(map[string]string)
+	tags := make(map[string]bool)
+	for _, s := range c.Project.Services {
+		name := s.Image
+		if name == "" || c.Config.TagAll {
+			data.Service = s.Name
+			if name, err = RenderImageName(tmpl, data); err != nil {
+				return nil, err
+			}
+			tags[imageTag(name)] = true
+		}
+		if prefix != "" {
+			if name, err = bundle.PrefixReference(prefix, name); err != nil {
+				return nil, err
+			}
+		}
+		if name == s.Image {
+			continue
+		}
+		if s.Image != "" && s.Build == nil {
+			retag[name] = s.Image
+		}
+		s.Image = name
+		c.Project.Services[s.Name] = s
+		c.Logger.Debugf("Tag Service %s image tag: %s", s.Name, s.Image)
+	}
+	c.recordTag(tags)
+	return retag, nil
+}
+
+// recordTag sets Tag to the tag shared by the images named by the template.
+// Images rendered with different tags leave the manifest with Config.Tag.
+func (c *Client) recordTag(tags map[string]bool) {
+	c.Tag = ""
+	if len(tags) > 1 {
+		c.Logger.Warnf("Images are named with %d different tags, the manifest records tag %s", len(tags), c.Config.Tag)
+		return
+	}
+	for tag := range tags {
+		c.Tag = tag
+	}
+}
+
+// imageTag returns the tag of an image name, "latest" when it has none.
+func imageTag(name string) string {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return ""
+	}
+	if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+		return tagged.Tag()
+	}
+	return ""
+}
+
+// registryPrefix returns the prefix images are moved under: RegistryPrefix,
+// or ToRegistry when pushing without a prefix.
+func (c *Client) registryPrefix() (string, error) {
+	prefix := strings.TrimSuffix(c.Config.RegistryPrefix, "/")
+	registry := strings.TrimSuffix(c.Config.ToRegistry, "/")
+	switch {
+	case registry == "":
+		return prefix, nil
+	case prefix == "":
+		return registry, nil
+	case !strings.HasPrefix(prefix+"/", registry+"/"):
+		return "", errors.Errorf("registry prefix %s is not on registry %s", prefix, registry)
+	default:
+		return prefix, nil
+	}
+}
+
+// tagImages tags existing images with new names, mapped from new name to source image.
+func (c *Client) tagImages(ctx context.Context, names map[string]string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	for target, source := range names {
+		if tagErr := cli.ImageTag(ctx, source, target); tagErr != nil {
+			return errors.Wrapf(tagErr, "failed to tag image %s as %s", source, target)
+		}
+		c.Logger.Infof("Tagged image %s as %s", source, target)
+	}
+	return nil
+}
+
+// SaveImages saves all images from the compose project to a tar archive or an
+// OCI image layout. Images pushed to a registry are only inspected.
 func (c *Client) SaveImages(ctx context.Context) error {
+	compression, err := bundle.ParseCompression(c.Config.Compress)
+	if err != nil {
+		return err
+	}
+	format, err := c.archiveFormat(compression)
+	if err != nil {
+		return err
+	}
+	if err = c.loadKeys(); err != nil {
+		return err
+	}
+	if err = c.loadSigningKey(); err != nil {
+		return err
+	}
+	if err = c.loadAdvisories(); err != nil {
+		return err
+	}
+	if err = validatePinImages(c.Config.PinImages); err != nil {
+		return err
+	}
+	if err = c.loadSecretScanner(); err != nil {
+		return err
+	}
+	if c.Config.Base != "" {
+		if c.Base, err = bundle.ReadManifest(c.Config.Base); err != nil {
+			return errors.Wrap(err, "failed to read base manifest")
+		}
+	}
+	if c.Config.Inventory != "" {
+		if c.Inventory, err = bundle.ReadInventory(c.Config.Inventory); err != nil {
+			return errors.Wrap(err, "failed to read target inventory")
+		}
+	}
+
 	cli, err := c.Deps.NewDockerClient()
 	if err != nil {
 		return errors.Wrap(err, "error creating Docker client")
@@ -248,59 +693,418 @@ func (c *Client) SaveImages(ctx context.Context) error {
 	defer cli.Close()
 
 	images := make([]string, 0, len(c.Project.Services))
+	c.Images = make(map[string]image.InspectResponse, len(c.Project.Services))
+	c.Packages = nil
 	for _, svc := range c.Project.Services {
-		if svc.Image != "" {
-			images = append(images, svc.Image)
-		} else {
+		if svc.Image == "" {
 			c.Logger.Warnf("Service %s does not have an image specified.", svc.Name)
+			continue
+		}
+		inspect, inspectErr := cli.ImageInspect(ctx, svc.Image)
+		if inspectErr != nil {
+			return errors.Wrapf(inspectErr, "failed to inspect image %s", svc.Image)
 		}
+		c.Images[svc.Name] = inspect
+		images = append(images, svc.Image)
 	}
 
-	if len(images) == 0 {
+	if len(images) == 0 || c.Config.ToRegistry != "" {
 		return nil
 	}
+	c.Secrets = nil
+	if c.Config.LayerCache != "" || c.Config.PerService {
+		err = c.saveFromCache(ctx, cli, format, compression)
+	} else {
+		err = c.saveImageArchive(ctx, cli, images, format, compression)
+	}
+	if err != nil {
+		return err
+	}
+	return c.checkSecrets(len(images))
+}
 
-	imageSaveReader, err := cli.ImageSave(ctx, images)
+// saveImageArchive exports images from the daemon and writes them as the image archive.
+func (c *Client) saveImageArchive(
+	ctx context.Context, cli *client.Client, images []string, format bundle.Format, compression bundle.Compression,
+) error {
+	reader, err := cli.ImageSave(ctx, images)
 	if err != nil {
 		return errors.Wrap(err, "failed to save images")
 	}
-	defer imageSaveReader.Close()
+	defer reader.Close()
+	return c.writeImages(reader, format, compression)
+}
+
+// writeImages writes a `docker save` stream as the image archive in format,
+// leaving out the layers the base delivery or target inventory already holds.
+// The packages of the images are found in the stream when an SBOM or a
+// vulnerability scan is configured, and the layers written are searched for
+// secrets unless the per-service archives already were.
+func (c *Client) writeImages(src io.Reader, format bundle.Format, compression bundle.Compression) error {
+	return c.scanPackages(src, c.serviceNames(), func(r io.Reader) error {
+		return c.writeSavedImages(r, format, compression)
+	})
+}
+
+// writeSavedImages writes the image archive of writeImages.
+func (c *Client) writeSavedImages(src io.Reader, format bundle.Format, compression bundle.Compression) error {
+	scan := c.scanSecrets
+	if c.Config.PerService {
+		scan = func(r io.Reader, write func(io.Reader) error) error { return write(r) }
+	}
+	if format != bundle.FormatDocker {
+		return scan(src, func(r io.Reader) error {
+			return c.writeOCILayout(r, format, compression)
+		})
+	}
+	write := func(r io.Reader) error {
+		return c.writeImageArchive(r, compression)
+	}
+	exclude := c.excludedLayers()
+	if len(exclude) == 0 {
+		return scan(src, write)
+	}
+	filter := bundle.NewLayerFilter(src, exclude)
+	defer filter.Close()
+	if writeErr := scan(filter, write); writeErr != nil {
+		return writeErr
+	}
+	c.ExcludedLayers = filter.Excluded()
+	if len(c.ExcludedLayers) < len(exclude) {
+		c.Logger.Warnf("Only %d of %d layers shared with %s could be left out of the image archive",
+			len(c.ExcludedLayers), len(exclude), c.baseName())
+	} else {
+		c.Logger.Infof("Left %d layers shared with %s out of the image archive", len(c.ExcludedLayers), c.baseName())
+	}
+	return nil
+}
+
+// excludedLayers returns the diff IDs of the layers of the inspected images
+// that the base delivery or the target inventory already holds.
+func (c *Client) excludedLayers() map[string]bool {
+	present := make(bundle.LayerSet)
+	if c.Base != nil {
+		for _, service := range c.Base.Services {
+			present.Add(service.Layers)
+		}
+	}
+	if c.Inventory != nil {
+		for _, img := range c.Inventory.Images {
+			present.Add(img.Layers)
+		}
+	}
+	images := make([][]string, 0, len(c.Images))
+	for _, inspect := range c.Images {
+		images = append(images, inspect.RootFS.Layers)
+	}
+	return present.Excluded(images)
+}
 
-	outPath := c.Config.OutputDir + "/images.tar"
-	outFile, err := os.Create(outPath)
+// baseName describes what the image archive is a delta against.
+func (c *Client) baseName() string {
+	var names []string
+	if c.Base != nil {
+		names = append(names, c.Base.Name())
+	}
+	if c.Inventory != nil {
+		names = append(names, c.Inventory.Name())
+	}
+	return strings.Join(names, " and ")
+}
+
+// writeImageArchive compresses the `docker save` stream into the image archive
+// of the output directory, splitting it into parts when configured, and
+// records the checksum of every file written.
+func (c *Client) writeImageArchive(src io.Reader, compression bundle.Compression) error {
+	archive, err := c.writeArchive(src, bundle.ImagesFile, compression)
 	if err != nil {
-		return errors.Wrap(err, "failed to create tar file for images")
+		return err
 	}
-	defer outFile.Close()
+	c.Archive = archive
+	return nil
+}
 
-	if _, copyErr := io.Copy(outFile, imageSaveReader); copyErr != nil {
-		return errors.Wrap(copyErr, "failed to write image tar")
+// writeArchive compresses a `docker save` stream into the archive name of the
+// output directory like writeImageArchive.
+func (c *Client) writeArchive(src io.Reader, name string, compression bundle.Compression) (*bundle.Archive, error) {
+	archive := bundle.Archive{
+		Name:        name + compression.Extension(),
+		Compression: compression,
+		Encrypted:   c.Keys != nil,
+	}
+	if archive.Encrypted {
+		archive.Name += bundle.EncryptedExtension
+	}
+	aw := bundle.NewArchiveWriter(c.Config.OutputDir, archive.Name, c.Config.SplitSize)
+	ew, err := c.encryptWriter(aw)
+	if err != nil {
+		_ = aw.Close()
+		return nil, err
 	}
-	fi, err := outFile.Stat()
+	cw, err := bundle.NewCompressWriter(ew, compression, c.Config.CompressLevel)
 	if err != nil {
-		c.Logger.Warnf("Could not get file size for %s: %v", outPath, err)
+		_ = aw.Close()
+		return nil, err
+	}
+	size, copyErr := io.Copy(cw, src)
+	if copyErr != nil {
+		_ = aw.Close()
+		return nil, errors.Wrap(copyErr, "failed to write image tar")
+	}
+	if closeErr := cw.Close(); closeErr != nil {
+		_ = aw.Close()
+		return nil, errors.Wrap(closeErr, "failed to flush compressed image tar")
+	}
+	if closeErr := ew.Close(); closeErr != nil {
+		_ = aw.Close()
+		return nil, errors.Wrap(closeErr, "failed to flush encrypted image tar")
+	}
+	if closeErr := aw.Close(); closeErr != nil {
+		return nil, closeErr
+	}
+
+	archive.Size = size
+	archive.Parts = aw.Parts()
+	var written int64
+	for _, file := range aw.Files() {
+		c.recordFile(file)
+		written += file.Size
+	}
+
+	outPath := filepath.Join(c.Config.OutputDir, archive.Name)
+	if archive.Parts != nil {
+		outPath = fmt.Sprintf("%s.* (%d parts)", outPath, len(archive.Parts))
+	}
+	const bytesToGB = 1024 * 1024 * 1024
+	if compression == bundle.CompressionNone {
+		c.Logger.Infof("Saved images to %s (%.2f GB)", outPath, float64(written)/bytesToGB)
 	} else {
-		const bytesToGB = 1024 * 1024 * 1024
-		sizeGB := float64(fi.Size()) / bytesToGB
-		c.Logger.Infof("Saved images to %s (%.2f GB)", outPath, sizeGB)
+		c.Logger.Infof("Saved images to %s (%.2f GB compressed with %s, %.2f GB uncompressed)",
+			outPath, float64(written)/bytesToGB, compression, float64(size)/bytesToGB)
 	}
-	return nil
+	return &archive, nil
+}
+
+// recordFile stores the checksum of an output file, replacing any previous record with the same name.
+func (c *Client) recordFile(file bundle.File) {
+	for i := range c.Files {
+		if c.Files[i].Name == file.Name {
+			c.Files[i] = file
+			return
+		}
+	}
+	c.Files = append(c.Files, file)
+}
+
+// removeImageArchives removes the image archives written by SaveImages and
+// their checksum records, so that a save failing after the images were written
+// leaves no archive without a manifest in the output directory. The manifest
+// and signature of an earlier save are removed too, so the failed output does
+// not look like a valid bundle.
+func (c *Client) removeImageArchives() {
+	c.removePreviousManifest()
+	archives := make([]*bundle.Archive, 0, len(c.ServiceArchives)+1)
+	if c.Archive != nil {
+		archives = append(archives, c.Archive)
+	}
+	for _, name := range c.serviceNames() {
+		if archive := c.ServiceArchives[name]; archive != nil {
+			archives = append(archives, archive)
+		}
+	}
+	removed := make(map[string]bool)
+	for _, archive := range archives {
+		names := archive.Parts
+		if names == nil {
+			names = []string{archive.Name}
+		}
+		for _, name := range names {
+			if err := os.RemoveAll(filepath.Join(c.Config.OutputDir, filepath.FromSlash(name))); err != nil {
+				c.Logger.Warnf("Failed to remove image archive %s: %v", name, err)
+				continue
+			}
+			removed[name] = true
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	files := c.Files[:0]
+	for _, file := range c.Files {
+		dir, _, _ := strings.Cut(file.Name, "/")
+		if !removed[file.Name] && !removed[dir] {
+			files = append(files, file)
+		}
+	}
+	c.Files = files
+	c.Archive = nil
+	c.ServiceArchives = nil
+	c.Logger.Infof("Removed the image archives from %s", c.Config.OutputDir)
+}
+
+// removePreviousManifest removes the manifest and signature an earlier save
+// left in the output directory.
+func (c *Client) removePreviousManifest() {
+	err := os.Remove(filepath.Join(c.Config.OutputDir, bundle.ManifestFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.Logger.Warnf("Failed to remove previous manifest: %v", err)
+	}
+	if err = c.removeSignature(); err != nil {
+		c.Logger.Warnf("%v", err)
+	}
+}
+
+// manifestTag returns the tag recorded in the manifest: the tag rendered by
+// TagTemplate, or Config.Tag when no image was named by it.
+func (c *Client) manifestTag() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return c.Config.Tag
+}
+
+// WriteManifest writes the bundle manifest describing the delivered images.
+// It relies on the images inspected by SaveImages and the checksums recorded
+// by SaveImages and SaveComposeFile.
+func (c *Client) WriteManifest(_ context.Context) (string, error) {
+	if c.Project == nil {
+		return "", nil
+	}
+	manifest := &bundle.Manifest{
+		SchemaVersion:  bundle.SchemaVersion,
+		Version:        version.Get(),
+		Project:        c.Project.Name,
+		Tag:            c.manifestTag(),
+		CreatedAt:      time.Now().UTC(),
+		ComposeFiles:   c.Config.DockerComposePath,
+		Services:       make(map[string]bundle.Service, len(c.Images)),
+		Archive:        c.Archive,
+		Registry:       c.Config.ToRegistry,
+		Encryption:     c.Keys.Encryption(),
+		Files:          c.Files,
+		ExcludedLayers: c.ExcludedLayers,
+	}
+	if len(c.ExcludedLayers) > 0 {
+		manifest.Base = c.baseName()
+	}
+	if c.Vulnerabilities != nil {
+		manifest.VulnerabilityReport = bundle.VulnerabilityReportFile
+	}
+	if c.PolicyReport != nil {
+		manifest.PolicyReport = policy.ReportFile
+	}
+	for name, inspect := range c.Images {
+		manifest.Services[name] = bundle.Service{
+			Image:        c.Project.Services[name].Image,
+			ImageID:      inspect.ID,
+			RepoDigests:  inspect.RepoDigests,
+			Architecture: inspect.Architecture,
+			OS:           inspect.Os,
+			Size:         inspect.Size,
+			Layers:       inspect.RootFS.Layers,
+			Archive:      c.ServiceArchives[name],
+			SBOM:         c.SBOMs[name],
+			Provenance:   c.Attestations[name],
+		}
+	}
+	if len(c.ServiceArchives) > 0 {
+		c.recordSharedLayers(manifest)
+	}
+
+	if removeErr := c.removeSignature(); removeErr != nil {
+		return "", removeErr
+	}
+	outPath := filepath.Join(c.Config.OutputDir, bundle.ManifestFile)
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create manifest file")
+	}
+	defer file.Close()
+
+	if encodeErr := manifest.Encode(file); encodeErr != nil {
+		return "", errors.Wrap(encodeErr, "failed to write manifest file")
+	}
+	return outPath, nil
+}
+
+// WriteInstaller writes the bundle as a self-extracting installer. It relies on
+// the manifest written by WriteManifest.
+func (c *Client) WriteInstaller(_ context.Context) (string, error) {
+	if c.Project == nil {
+		return "", nil
+	}
+	outPath := filepath.Join(c.Config.OutputDir, bundle.InstallerFile)
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create installer")
+	}
+	defer file.Close()
+
+	if writeErr := bundle.WriteInstaller(c.Config.OutputDir, file); writeErr != nil {
+		return "", writeErr
+	}
+	const executablePermissions = 0755
+	if chmodErr := file.Chmod(executablePermissions); chmodErr != nil {
+		return "", errors.Wrap(chmodErr, "failed to make installer executable")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to stat installer")
+	}
+	const bytesToGB = 1024 * 1024 * 1024
+	c.Logger.Infof("Wrote installer %s (%.2f GB)", outPath, float64(info.Size())/bytesToGB)
+	return outPath, nil
 }
 
 func (c *Client) Run(ctx context.Context) (string, error) {
 	if c.Project == nil {
 		return "", nil
 	}
+	if servicePolicyErr := c.CheckServicePolicy(ctx); servicePolicyErr != nil {
+		return "", servicePolicyErr
+	}
+	if pullErr := c.Pull(ctx); pullErr != nil {
+		return "", pullErr
+	}
 	if buildErr := c.Build(ctx); buildErr != nil {
 		return "", buildErr
 	}
+	if _, policyErr := c.CheckPolicy(ctx); policyErr != nil {
+		return "", policyErr
+	}
+	if secretsErr := c.ScanSecrets(ctx); secretsErr != nil {
+		return "", secretsErr
+	}
+	if pushErr := c.Push(ctx); pushErr != nil {
+		return "", pushErr
+	}
 	if saveErr := c.SaveImages(ctx); saveErr != nil {
 		return "", saveErr
 	}
+	if _, sbomErr := c.WriteSBOMs(ctx); sbomErr != nil {
+		return "", sbomErr
+	}
+	if _, scanErr := c.ScanVulnerabilities(ctx); scanErr != nil {
+		return "", scanErr
+	}
+	if _, provenanceErr := c.WriteProvenance(ctx); provenanceErr != nil {
+		return "", provenanceErr
+	}
 	output, composeErr := c.SaveComposeFile(ctx)
 	if composeErr != nil {
 		return "", composeErr
 	}
+	if _, manifestErr := c.WriteManifest(ctx); manifestErr != nil {
+		return "", manifestErr
+	}
+	if _, signErr := c.SignManifest(ctx); signErr != nil {
+		return "", signErr
+	}
+	if c.Config.Installer {
+		if _, installerErr := c.WriteInstaller(ctx); installerErr != nil {
+			return "", installerErr
+		}
+	}
 	return output, nil
 }
 
@@ -2,23 +2,33 @@ package compose_test
 
 import (
 	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/compose-spec/compose-go/v2/cli"
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/docker/cli/cli/command"
 	"github.com/docker/compose/v2/pkg/api"
 	"github.com/docker/compose/v2/pkg/compose"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
 
+	"github.com/sunpia/docker-deliver/internal/bundle"
 	Compose "github.com/sunpia/docker-deliver/internal/compose"
+	"github.com/sunpia/docker-deliver/internal/policy"
 )
 
 // Setup function to create a temporary directory for tests.
@@ -167,6 +177,143 @@ func TestSaveComposeFile_Success(t *testing.T) {
 	assert.Equal(t, "test yaml content", string(content))
 }
 
+func TestSaveComposeFile_RecordsChecksum(t *testing.T) {
+	tempDir := setupTempDir(t)
+
+	deps := setupTestDependencies()
+	deps.YAMLMarshal = func(_ interface{}) ([]byte, error) {
+		return []byte("hello world"), nil
+	}
+
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	// Saving twice must not duplicate the checksum record
+	for range 2 {
+		_, err := client.SaveComposeFile(context.Background())
+		require.NoError(t, err)
+	}
+
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, bundle.File{
+		Name:   bundle.ComposeFile,
+		Size:   11,
+		SHA256: "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	}, client.Files[0])
+}
+
+func TestSaveComposeFile_Encrypted(t *testing.T) {
+	tempDir := setupTempDir(t)
+	identity, err := bundle.GenerateX25519Key()
+	require.NoError(t, err)
+	recipient := filepath.Join(tempDir, "site.pub")
+	require.NoError(t, os.WriteFile(recipient, bundle.MarshalRecipient(identity.Recipient()), 0600))
+
+	deps := setupTestDependencies()
+	deps.YAMLMarshal = func(_ interface{}) ([]byte, error) {
+		return []byte("hello world"), nil
+	}
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir, Encrypt: true, Recipients: []string{recipient}},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	outPath, err := client.SaveComposeFile(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, bundle.ComposeFile+bundle.EncryptedExtension), outPath)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, bundle.ComposeFile+bundle.EncryptedExtension, client.Files[0].Name)
+
+	file, err := os.Open(outPath)
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := bundle.NewDecryptReader(file, &bundle.Keys{Identities: []*age.X25519Identity{identity}})
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestSaveComposeFile_PinImages(t *testing.T) {
+	const repoDigest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	tests := []struct {
+		name       string
+		mode       string
+		toRegistry string
+		expected   map[string]string
+	}{
+		{name: "none", expected: map[string]string{"web": "nginx:1.27", "app": "app:v1"}},
+		{
+			name:       "digest",
+			mode:       Compose.PinDigest,
+			toRegistry: "registry.local",
+			expected:   map[string]string{"web": "nginx:1.27@" + repoDigest, "app": "sha256:2222"},
+		},
+		// Archived images have no registry digest once loaded on the target.
+		{
+			name:     "digest-archived",
+			mode:     Compose.PinDigest,
+			expected: map[string]string{"web": "sha256:1111", "app": "sha256:2222"},
+		},
+		{name: "id", mode: Compose.PinID, expected: map[string]string{"web": "sha256:1111", "app": "sha256:2222"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var marshaled *types.Project
+			deps := setupTestDependencies()
+			deps.YAMLMarshal = func(v interface{}) ([]byte, error) {
+				marshaled, _ = v.(*types.Project)
+				return []byte("services: {}"), nil
+			}
+
+			client := &Compose.Client{
+				Config: Compose.Config{OutputDir: setupTempDir(t), PinImages: tt.mode, ToRegistry: tt.toRegistry},
+				Project: &types.Project{
+					Name: "test",
+					Services: types.Services{
+						"web": {Name: "web", Image: "nginx:1.27"},
+						"app": {Name: "app", Image: "app:v1"},
+					},
+				},
+				Images: map[string]image.InspectResponse{
+					"web": {ID: "sha256:1111", RepoDigests: []string{"nginx@" + repoDigest}},
+					"app": {ID: "sha256:2222"},
+				},
+				Logger: logrus.New(),
+				Deps:   deps,
+			}
+
+			_, err := client.SaveComposeFile(context.Background())
+			require.NoError(t, err)
+			require.NotNil(t, marshaled)
+			for name, expected := range tt.expected {
+				assert.Equal(t, expected, marshaled.Services[name].Image)
+			}
+			// The client project keeps the original references for the manifest
+			assert.Equal(t, "nginx:1.27", client.Project.Services["web"].Image)
+		})
+	}
+}
+
+func TestSaveComposeFile_InvalidPinImages(t *testing.T) {
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: setupTempDir(t), PinImages: "tag"},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+
+	_, err := client.SaveComposeFile(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported image pinning")
+}
+
 func TestSaveComposeFile_NilProject(t *testing.T) {
 	deps := setupTestDependencies()
 	client := &Compose.Client{
@@ -288,192 +435,274 @@ func TestSaveImages_DockerClientError(t *testing.T) {
 	assert.Contains(t, err.Error(), "docker client creation failed")
 }
 
-func TestBuild_ServiceImageTagging(t *testing.T) {
-	mockProject := &types.Project{
-		Name: "test-project",
-		Services: types.Services{
-			"web": types.ServiceConfig{
-				Name: "web",
-				Build: &types.BuildConfig{
-					Context: ".",
-				},
-			},
-			"db": types.ServiceConfig{
-				Name:  "db",
-				Image: "postgres:13",
-			},
-		},
+func TestSaveImages_InvalidCompression(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created for an invalid configuration")
+		return nil, nil
 	}
 
 	client := &Compose.Client{
 		Config: Compose.Config{
-			Tag: "v1.0.0",
+			OutputDir: "/tmp",
+			Compress:  "brotli",
 		},
-		Project: mockProject,
-	}
-
-	// Test the image tagging logic specifically (before Docker operations)
-	project := client.Project
-	for _, s := range project.Services {
-		if s.Image == "" {
-			s.Image = s.Name + ":" + client.Config.Tag
-			project.Services[s.Name] = s
-		}
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
 	}
 
-	// Verify tagging worked correctly
-	assert.Equal(t, "web:v1.0.0", project.Services["web"].Image, "Expected web service image to be 'web:v1.0.0'")
-	assert.Equal(t, "postgres:13", project.Services["db"].Image, "Expected db service image to remain 'postgres:13'")
+	err := client.SaveImages(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported compression")
 }
 
-func TestSaveImages_NoImagesSpecified(t *testing.T) {
-	mockProject := &types.Project{
-		Services: types.Services{
-			"web": types.ServiceConfig{
-				Name: "web",
-				// No image specified
-			},
-		},
+func TestSaveImages_MissingBaseManifest(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created for an invalid configuration")
+		return nil, nil
 	}
 
 	client := &Compose.Client{
 		Config: Compose.Config{
 			OutputDir: "/tmp",
+			Base:      filepath.Join(t.TempDir(), "manifest.json"),
 		},
-		Project: mockProject,
-	}
-
-	// Test the logic that collects images
-	images := make([]string, 0, len(client.Project.Services))
-	for _, svc := range client.Project.Services {
-		if svc.Image != "" {
-			images = append(images, svc.Image)
-		}
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
 	}
 
-	assert.Empty(t, images, "Expected no images to be collected")
+	err := client.SaveImages(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read base manifest")
 }
 
-func TestSaveImages_ImageCollection(t *testing.T) {
-	mockProject := &types.Project{
-		Services: types.Services{
-			"web": types.ServiceConfig{
-				Name:  "web",
-				Image: "nginx:latest",
-			},
-			"db": types.ServiceConfig{
-				Name:  "db",
-				Image: "postgres:13",
-			},
-			"worker": types.ServiceConfig{
-				Name: "worker",
-				// No image specified
-			},
-		},
+func TestSaveImages_MissingInventory(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created for an invalid configuration")
+		return nil, nil
 	}
 
 	client := &Compose.Client{
 		Config: Compose.Config{
 			OutputDir: "/tmp",
+			Inventory: filepath.Join(t.TempDir(), "inventory.json"),
 		},
-		Project: mockProject,
-	}
-
-	// Test the logic that collects images
-	images := make([]string, 0, len(client.Project.Services))
-	for _, svc := range client.Project.Services {
-		if svc.Image != "" {
-			images = append(images, svc.Image)
-		}
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
 	}
 
-	expectedImages := []string{"nginx:latest", "postgres:13"}
-	assert.Len(t, images, len(expectedImages), "Expected same number of images")
+	err := client.SaveImages(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read target inventory")
+}
 
-	// Check if all expected images are present
-	imageMap := make(map[string]bool)
-	for _, img := range images {
-		imageMap[img] = true
+func TestSaveImages_InvalidArchiveOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Compose.Config
+		errMsg string
+	}{
+		{
+			name:   "unknown format",
+			config: Compose.Config{Format: "tarball"},
+			errMsg: "unsupported archive format",
+		},
+		{
+			name:   "compressed layout directory",
+			config: Compose.Config{Format: "oci", Compress: "zstd"},
+			errMsg: "cannot be compressed, split or encrypted",
+		},
+		{
+			name:   "split layout directory",
+			config: Compose.Config{Format: "oci", SplitSize: 1024},
+			errMsg: "cannot be compressed, split or encrypted",
+		},
+		{
+			name:   "delta layout",
+			config: Compose.Config{Format: "oci-archive", Base: "manifest.json"},
+			errMsg: "cannot be delivered as a delta",
+		},
+		{
+			name:   "pushed images",
+			config: Compose.Config{Format: "oci", ToRegistry: "registry.site.local:5000"},
+			errMsg: "images pushed to a registry are not saved",
+		},
+		{
+			name:   "combined archive skipped without per-service archives",
+			config: Compose.Config{SkipCombined: true},
+			errMsg: "only be skipped when writing per-service archives",
+		},
+		{
+			name:   "per-service delta",
+			config: Compose.Config{PerService: true, SkipCombined: true, Inventory: "inventory.json"},
+			errMsg: "a delta needs the combined image archive",
+		},
+		{
+			name:   "pushed per-service archives",
+			config: Compose.Config{PerService: true, ToRegistry: "registry.site.local:5000"},
+			errMsg: "per-service archives do not apply",
+		},
+		{
+			name:   "encrypted layout directory",
+			config: Compose.Config{Format: "oci", Encrypt: true},
+			errMsg: "cannot be compressed, split or encrypted",
+		},
+		{
+			name:   "encrypted pushed images",
+			config: Compose.Config{Encrypt: true, ToRegistry: "registry.site.local:5000"},
+			errMsg: "encryption does not apply",
+		},
+		{
+			name:   "encryption without keys",
+			config: Compose.Config{Encrypt: true},
+			errMsg: "needs a recipient or a passphrase",
+		},
+		{
+			name:   "recipients without encryption",
+			config: Compose.Config{Recipients: []string{"site.pub"}},
+			errMsg: "only apply when the bundle is encrypted",
+		},
+		{
+			name:   "encrypted installer",
+			config: Compose.Config{Encrypt: true, Installer: true},
+			errMsg: "an installer cannot decrypt the bundle",
+		},
+		{
+			name:   "missing signing key",
+			config: Compose.Config{SignKey: "missing.key"},
+			errMsg: "failed to read key",
+		},
+		{
+			name:   "unknown SBOM format",
+			config: Compose.Config{SBOM: "swid"},
+			errMsg: "unsupported SBOM format",
+		},
+		{
+			name:   "SBOM of pushed images",
+			config: Compose.Config{SBOM: "spdx", ToRegistry: "registry.site.local:5000"},
+			errMsg: "SBOMs are generated from the image archive",
+		},
+		{
+			name:   "scan of pushed images",
+			config: Compose.Config{ScanDB: "osv.json", ToRegistry: "registry.site.local:5000"},
+			errMsg: "vulnerability scans read the image archive",
+		},
+		{
+			name:   "unknown scan threshold",
+			config: Compose.Config{ScanDB: "osv.json", ScanFailOn: "severe"},
+			errMsg: "unsupported severity threshold",
+		},
+		{
+			name:   "missing advisory database",
+			config: Compose.Config{ScanDB: "missing.json"},
+			errMsg: "failed to read advisory database",
+		},
+		{
+			name:   "unknown secrets mode",
+			config: Compose.Config{Secrets: "block"},
+			errMsg: "unsupported secrets mode",
+		},
 	}
 
-	for _, expected := range expectedImages {
-		assert.True(t, imageMap[expected], "Expected image %s not found in collected images", expected)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := setupTestDependencies()
+			deps.NewDockerClient = func() (*client.Client, error) {
+				t.Fatal("Docker client must not be created for an invalid configuration")
+				return nil, nil
+			}
+			tt.config.OutputDir = t.TempDir()
+			client := &Compose.Client{
+				Config:  tt.config,
+				Project: &types.Project{Name: "test"},
+				Logger:  logrus.New(),
+				Deps:    deps,
+			}
+
+			err := client.SaveImages(context.Background())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
 	}
 }
 
-func TestSaveComposeFile_WriteError(t *testing.T) {
-	tempDir := setupTempDir(t)
-
-	// Create a file that will cause os.Create to return a file
-	// but then fail on Write by closing it immediately
+func TestPull_InvalidPolicy(t *testing.T) {
 	deps := setupTestDependencies()
-	deps.OSCreate = func(name string) (*os.File, error) {
-		file, err := os.Create(name)
-		if err != nil {
-			return nil, err
-		}
-		// Close the file immediately to cause write error
-		_ = file.Close() // Ignoring close error for test setup
-		// Return a closed file to simulate write error
-		return file, nil
-	}
-
-	deps.YAMLMarshal = func(_ interface{}) ([]byte, error) {
-		return []byte("test yaml content"), nil
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created for an invalid configuration")
+		return nil, nil
 	}
 
 	client := &Compose.Client{
-		Config: Compose.Config{
-			OutputDir: tempDir,
+		Config: Compose.Config{Pull: "sometimes"},
+		Project: &types.Project{
+			Name:     "test",
+			Services: types.Services{"web": {Name: "web", Image: "nginx:1.27"}},
 		},
-		Project: &types.Project{Name: "test"},
-		Logger:  logrus.New(),
-		Deps:    deps,
+		Logger: logrus.New(),
+		Deps:   deps,
 	}
 
-	_, err := client.SaveComposeFile(context.Background())
-	assert.Error(t, err, "Expected error from file write")
+	err := client.Pull(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported pull policy")
 }
 
-func TestBuild_WithExistingImages(t *testing.T) {
-	mockProject := &types.Project{
-		Name: "test-project",
-		Services: types.Services{
-			"web": types.ServiceConfig{
-				Name:  "web",
-				Image: "existing:latest",
-			},
-			"api": types.ServiceConfig{
-				Name: "api",
-				// No image, should get tagged
+func TestPull_SkipsBuiltServices(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when no image needs pulling")
+		return nil, nil
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{Pull: "always"},
+		Project: &types.Project{
+			Name: "test",
+			Services: types.Services{
+				"app":    {Name: "app", Build: &types.BuildConfig{Context: "."}},
+				"worker": {Name: "worker", Image: "worker:v1", Build: &types.BuildConfig{Context: "."}},
 			},
 		},
+		Logger: logrus.New(),
+		Deps:   deps,
+	}
+
+	require.NoError(t, client.Pull(context.Background()))
+}
+
+func TestPull_DockerClientError(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
 	}
 
 	client := &Compose.Client{
-		Config: Compose.Config{
-			Tag: "v2.0.0",
+		Project: &types.Project{
+			Name:     "test",
+			Services: types.Services{"web": {Name: "web", Image: "nginx:1.27"}},
 		},
-		Project: mockProject,
+		Logger: logrus.New(),
+		Deps:   deps,
 	}
 
-	// Test the image tagging logic before Docker operations
-	project := client.Project
-	for _, s := range project.Services {
-		if s.Image == "" {
-			s.Image = s.Name + ":" + client.Config.Tag
-			project.Services[s.Name] = s
-		}
-	}
+	err := client.Pull(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker client creation failed")
+}
 
-	// Verify only services without images got tagged
-	assert.Equal(t, "existing:latest", project.Services["web"].Image,
-		"Expected web service image to remain 'existing:latest'")
-	assert.Equal(t, "api:v2.0.0", project.Services["api"].Image,
-		"Expected api service image to be 'api:v2.0.0'")
+func TestPull_NilProject(t *testing.T) {
+	client := &Compose.Client{Logger: logrus.New(), Deps: setupTestDependencies()}
+
+	require.NoError(t, client.Pull(context.Background()))
 }
 
-func TestBuild_BuildConfigRemoval(t *testing.T) {
+func TestBuild_ServiceImageTagging(t *testing.T) {
 	mockProject := &types.Project{
 		Name: "test-project",
 		Services: types.Services{
@@ -497,53 +726,299 @@ func TestBuild_BuildConfigRemoval(t *testing.T) {
 		Project: mockProject,
 	}
 
-	// Test the build config removal logic
+	// Test the image tagging logic specifically (before Docker operations)
 	project := client.Project
 	for _, s := range project.Services {
-		if s.Build != nil {
-			s.Build = nil
-			project.Services[s.Name] = s
+		if s.Image == "" {
+			s.Image = s.Name + ":" + client.Config.Tag
+			project.Services[s.Name] = s
 		}
 	}
 
-	// Verify build config was removed
-	assert.Nil(t, project.Services["web"].Build, "Expected web service build config to be removed")
-	assert.Nil(t, project.Services["db"].Build, "Expected db service build config to remain nil")
+	// Verify tagging worked correctly
+	assert.Equal(t, "web:v1.0.0", project.Services["web"].Image, "Expected web service image to be 'web:v1.0.0'")
+	assert.Equal(t, "postgres:13", project.Services["db"].Image, "Expected db service image to remain 'postgres:13'")
 }
 
-func TestNewComposeClient_OutputDirExists(t *testing.T) {
-	tempDir := setupTempDir(t)
+func TestRenderImageName(t *testing.T) {
+	tmpl, err := Compose.ParseTagTemplate("{{.Registry}}/{{.Project}}/{{.Service}}:{{.GitTag}}-{{.GitSHA}}-{{.Date}}")
+	require.NoError(t, err)
 
-	// Create the output directory first
-	existingDir := filepath.Join(tempDir, "existing")
-	const testDirPermissions = 0750 // More restrictive permissions for test
-	err := os.MkdirAll(existingDir, testDirPermissions)
-	require.NoError(t, err, "Failed to create existing directory")
+	name, err := Compose.RenderImageName(tmpl, Compose.TagData{
+		Project:  "example",
+		Service:  "web",
+		GitSHA:   "abc1234",
+		GitTag:   "v1.2.0",
+		Date:     "20250102",
+		Registry: "registry.local",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "registry.local/example/web:v1.2.0-abc1234-20250102", name)
+}
 
-	// Create mock dependencies
+func TestRenderImageName_DefaultTemplate(t *testing.T) {
+	tmpl, err := Compose.ParseTagTemplate("")
+	require.NoError(t, err)
+
+	name, err := Compose.RenderImageName(tmpl, Compose.TagData{Service: "web", Tag: "latest"})
+	require.NoError(t, err)
+	assert.Equal(t, "web:latest", name)
+}
+
+func TestRenderImageName_InvalidName(t *testing.T) {
+	tmpl, err := Compose.ParseTagTemplate("{{.Service}}:{{.GitTag}}")
+	require.NoError(t, err)
+
+	_, err = Compose.RenderImageName(tmpl, Compose.TagData{Service: "web"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid image name")
+}
+
+func TestParseTagTemplate_Invalid(t *testing.T) {
+	_, err := Compose.ParseTagTemplate("{{.Service")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid tag template")
+}
+
+func TestBuild_TagTemplate(t *testing.T) {
+	for _, tagAll := range []bool{false, true} {
+		t.Run(fmt.Sprintf("tag-all-%t", tagAll), func(t *testing.T) {
+			deps := setupTestDependencies()
+			deps.GitOutput = func(_ context.Context, dir string, args ...string) (string, error) {
+				assert.Equal(t, "/src", dir)
+				if args[0] == "describe" {
+					return "v1.2.0", nil
+				}
+				return "abc1234", nil
+			}
+			deps.NewDockerClient = func() (*client.Client, error) {
+				return nil, errors.New("docker client creation failed")
+			}
+
+			client := &Compose.Client{
+				Config: Compose.Config{
+					WorkDir:     "/src",
+					Tag:         "latest",
+					TagTemplate: "registry.local/{{.Project}}/{{.Service}}:{{.GitTag}}",
+					TagAll:      tagAll,
+				},
+				Project: &types.Project{
+					Name: "example",
+					Services: types.Services{
+						"web": {Name: "web", Build: &types.BuildConfig{Context: "."}},
+						"db":  {Name: "db", Image: "postgres:13"},
+					},
+				},
+				Logger: logrus.New(),
+				Deps:   deps,
+			}
+
+			err := client.Build(context.Background())
+			require.Error(t, err)
+			assert.Equal(t, "registry.local/example/web:v1.2.0", client.Project.Services["web"].Image)
+			assert.Equal(t, "v1.2.0", client.Tag)
+			if tagAll {
+				assert.Equal(t, "registry.local/example/db:v1.2.0", client.Project.Services["db"].Image)
+			} else {
+				assert.Equal(t, "postgres:13", client.Project.Services["db"].Image)
+			}
+		})
+	}
+}
+
+func TestBuild_RegistryPrefix(t *testing.T) {
 	deps := setupTestDependencies()
-	mockProject := &types.Project{Name: "test-project"}
-	deps.ProjectFromOptions = func(_ context.Context, _ *cli.ProjectOptions) (*types.Project, error) {
-		return mockProject, nil
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
 	}
 
-	config := Compose.Config{
-		DockerComposePath: []string{"docker-compose.yml"},
-		WorkDir:           tempDir,
-		OutputDir:         existingDir, // Use existing directory
-		Tag:               "latest",
-		LogLevel:          "info",
+	client := &Compose.Client{
+		Config: Compose.Config{Tag: "v1", RegistryPrefix: "registry.site.local:5000"},
+		Project: &types.Project{
+			Name: "example",
+			Services: types.Services{
+				"web": {Name: "web", Build: &types.BuildConfig{Context: "."}},
+				"db":  {Name: "db", Image: "postgres:13"},
+			},
+		},
+		Logger: logrus.New(),
+		Deps:   deps,
 	}
 
-	client, err := Compose.NewComposeClientWithDeps(context.Background(), config, deps)
+	err := client.Build(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "registry.site.local:5000/web:v1", client.Project.Services["web"].Image)
+	assert.Equal(t, "registry.site.local:5000/postgres:13", client.Project.Services["db"].Image)
+}
 
-	require.NoError(t, err, "Expected no error when output directory exists")
-	assert.NotNil(t, client, "Expected client to be not nil")
+func TestBuild_ToRegistry(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{Tag: "v1", ToRegistry: "registry.site.local:5000"},
+		Project: &types.Project{
+			Name: "example",
+			Services: types.Services{
+				"web": {Name: "web", Build: &types.BuildConfig{Context: "."}},
+			},
+		},
+		Logger: logrus.New(),
+		Deps:   deps,
+	}
+
+	err := client.Build(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "registry.site.local:5000/web:v1", client.Project.Services["web"].Image)
 }
 
-func TestSaveImages_EmptyImagesList(t *testing.T) {
+func TestBuild_RegistryPrefixNotOnTargetRegistry(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when images cannot be named")
+		return nil, nil
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			RegistryPrefix: "registry.other.local/team",
+			ToRegistry:     "registry.site.local:5000",
+		},
+		Project: &types.Project{Name: "example"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	err := client.Build(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not on registry registry.site.local:5000")
+}
+
+func TestPush_WithoutRegistry(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created without a target registry")
+		return nil, nil
+	}
+
+	client := &Compose.Client{
+		Project: &types.Project{
+			Name:     "example",
+			Services: types.Services{"web": {Name: "web", Image: "web:v1"}},
+		},
+		Logger: logrus.New(),
+		Deps:   deps,
+	}
+
+	require.NoError(t, client.Push(context.Background()))
+}
+
+func TestPush_DeltaRejected(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created for an invalid configuration")
+		return nil, nil
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			ToRegistry: "registry.site.local:5000",
+			Base:       "previous/manifest.json",
+		},
+		Project: &types.Project{Name: "example"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	err := client.Push(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot be delivered as a delta")
+}
+
+func TestPush_DockerClientError(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{ToRegistry: "registry.site.local:5000"},
+		Project: &types.Project{
+			Name:     "example",
+			Services: types.Services{"web": {Name: "web", Image: "registry.site.local:5000/web:v1"}},
+		},
+		Logger: logrus.New(),
+		Deps:   deps,
+	}
+
+	err := client.Push(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error creating Docker client")
+}
+
+func TestBuild_TagTemplateGitError(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.GitOutput = func(context.Context, string, ...string) (string, error) {
+		return "", errors.New("not a git repository")
+	}
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when images cannot be named")
+		return nil, nil
+	}
+
+	client := &Compose.Client{
+		Config:  Compose.Config{TagTemplate: "{{.Service}}:{{.GitSHA}}"},
+		Project: &types.Project{Name: "example"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	err := client.Build(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a git repository")
+}
+
+func TestSaveImages_NoImagesSpecified(t *testing.T) {
+	mockProject := &types.Project{
+		Services: types.Services{
+			"web": types.ServiceConfig{
+				Name: "web",
+				// No image specified
+			},
+		},
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			OutputDir: "/tmp",
+		},
+		Project: mockProject,
+	}
+
+	// Test the logic that collects images
+	images := make([]string, 0, len(client.Project.Services))
+	for _, svc := range client.Project.Services {
+		if svc.Image != "" {
+			images = append(images, svc.Image)
+		}
+	}
+
+	assert.Empty(t, images, "Expected no images to be collected")
+}
+
+func TestSaveImages_ImageCollection(t *testing.T) {
 	mockProject := &types.Project{
 		Services: types.Services{
+			"web": types.ServiceConfig{
+				Name:  "web",
+				Image: "nginx:latest",
+			},
+			"db": types.ServiceConfig{
+				Name:  "db",
+				Image: "postgres:13",
+			},
 			"worker": types.ServiceConfig{
 				Name: "worker",
 				// No image specified
@@ -558,7 +1033,7 @@ func TestSaveImages_EmptyImagesList(t *testing.T) {
 		Project: mockProject,
 	}
 
-	// Test the logic that collects images - should return early when no images
+	// Test the logic that collects images
 	images := make([]string, 0, len(client.Project.Services))
 	for _, svc := range client.Project.Services {
 		if svc.Image != "" {
@@ -566,10 +1041,838 @@ func TestSaveImages_EmptyImagesList(t *testing.T) {
 		}
 	}
 
-	assert.Empty(t, images, "Expected no images to be collected")
+	expectedImages := []string{"nginx:latest", "postgres:13"}
+	assert.Len(t, images, len(expectedImages), "Expected same number of images")
 
-	// The actual SaveImages call would return early without Docker operations
-	// when no images are found, so we'll just test the collection logic here
+	// Check if all expected images are present
+	imageMap := make(map[string]bool)
+	for _, img := range images {
+		imageMap[img] = true
+	}
+
+	for _, expected := range expectedImages {
+		assert.True(t, imageMap[expected], "Expected image %s not found in collected images", expected)
+	}
+}
+
+func TestSaveComposeFile_WriteError(t *testing.T) {
+	tempDir := setupTempDir(t)
+
+	// Create a file that will cause os.Create to return a file
+	// but then fail on Write by closing it immediately
+	deps := setupTestDependencies()
+	deps.OSCreate = func(name string) (*os.File, error) {
+		file, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		// Close the file immediately to cause write error
+		_ = file.Close() // Ignoring close error for test setup
+		// Return a closed file to simulate write error
+		return file, nil
+	}
+
+	deps.YAMLMarshal = func(_ interface{}) ([]byte, error) {
+		return []byte("test yaml content"), nil
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			OutputDir: tempDir,
+		},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	_, err := client.SaveComposeFile(context.Background())
+	assert.Error(t, err, "Expected error from file write")
+}
+
+func TestBuild_WithExistingImages(t *testing.T) {
+	mockProject := &types.Project{
+		Name: "test-project",
+		Services: types.Services{
+			"web": types.ServiceConfig{
+				Name:  "web",
+				Image: "existing:latest",
+			},
+			"api": types.ServiceConfig{
+				Name: "api",
+				// No image, should get tagged
+			},
+		},
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			Tag: "v2.0.0",
+		},
+		Project: mockProject,
+	}
+
+	// Test the image tagging logic before Docker operations
+	project := client.Project
+	for _, s := range project.Services {
+		if s.Image == "" {
+			s.Image = s.Name + ":" + client.Config.Tag
+			project.Services[s.Name] = s
+		}
+	}
+
+	// Verify only services without images got tagged
+	assert.Equal(t, "existing:latest", project.Services["web"].Image,
+		"Expected web service image to remain 'existing:latest'")
+	assert.Equal(t, "api:v2.0.0", project.Services["api"].Image,
+		"Expected api service image to be 'api:v2.0.0'")
+}
+
+func TestBuild_BuildConfigRemoval(t *testing.T) {
+	mockProject := &types.Project{
+		Name: "test-project",
+		Services: types.Services{
+			"web": types.ServiceConfig{
+				Name: "web",
+				Build: &types.BuildConfig{
+					Context: ".",
+				},
+			},
+			"db": types.ServiceConfig{
+				Name:  "db",
+				Image: "postgres:13",
+			},
+		},
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			Tag: "v1.0.0",
+		},
+		Project: mockProject,
+	}
+
+	// Test the build config removal logic
+	project := client.Project
+	for _, s := range project.Services {
+		if s.Build != nil {
+			s.Build = nil
+			project.Services[s.Name] = s
+		}
+	}
+
+	// Verify build config was removed
+	assert.Nil(t, project.Services["web"].Build, "Expected web service build config to be removed")
+	assert.Nil(t, project.Services["db"].Build, "Expected db service build config to remain nil")
+}
+
+func TestNewComposeClient_OutputDirExists(t *testing.T) {
+	tempDir := setupTempDir(t)
+
+	// Create the output directory first
+	existingDir := filepath.Join(tempDir, "existing")
+	const testDirPermissions = 0750 // More restrictive permissions for test
+	err := os.MkdirAll(existingDir, testDirPermissions)
+	require.NoError(t, err, "Failed to create existing directory")
+
+	// Create mock dependencies
+	deps := setupTestDependencies()
+	mockProject := &types.Project{Name: "test-project"}
+	deps.ProjectFromOptions = func(_ context.Context, _ *cli.ProjectOptions) (*types.Project, error) {
+		return mockProject, nil
+	}
+
+	config := Compose.Config{
+		DockerComposePath: []string{"docker-compose.yml"},
+		WorkDir:           tempDir,
+		OutputDir:         existingDir, // Use existing directory
+		Tag:               "latest",
+		LogLevel:          "info",
+	}
+
+	client, err := Compose.NewComposeClientWithDeps(context.Background(), config, deps)
+
+	require.NoError(t, err, "Expected no error when output directory exists")
+	assert.NotNil(t, client, "Expected client to be not nil")
+}
+
+func TestSaveImages_EmptyImagesList(t *testing.T) {
+	mockProject := &types.Project{
+		Services: types.Services{
+			"worker": types.ServiceConfig{
+				Name: "worker",
+				// No image specified
+			},
+		},
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			OutputDir: "/tmp",
+		},
+		Project: mockProject,
+	}
+
+	// Test the logic that collects images - should return early when no images
+	images := make([]string, 0, len(client.Project.Services))
+	for _, svc := range client.Project.Services {
+		if svc.Image != "" {
+			images = append(images, svc.Image)
+		}
+	}
+
+	assert.Empty(t, images, "Expected no images to be collected")
+
+	// The actual SaveImages call would return early without Docker operations
+	// when no images are found, so we'll just test the collection logic here
+}
+
+func TestWriteManifest_Success(t *testing.T) {
+	tempDir := setupTempDir(t)
+
+	client := &Compose.Client{
+		Config: Compose.Config{
+			DockerComposePath: []string{"docker-compose.yml"},
+			OutputDir:         tempDir,
+			Tag:               "v1.0.0",
+		},
+		Project: &types.Project{
+			Name: "test-project",
+			Services: types.Services{
+				"web": types.ServiceConfig{
+					Name:  "web",
+					Image: "web:v1.0.0",
+				},
+			},
+		},
+		Images: map[string]image.InspectResponse{
+			"web": {
+				ID:           "sha256:1111",
+				RepoDigests:  []string{"registry.local/web@sha256:2222"},
+				Architecture: "amd64",
+				Os:           "linux",
+				Size:         2048,
+				RootFS: image.RootFS{
+					Type:   "layers",
+					Layers: []string{"sha256:aaaa", "sha256:bbbb"},
+				},
+			},
+		},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+
+	outPath, err := client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, bundle.ManifestFile), outPath)
+
+	manifest, err := bundle.ReadManifest(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, "test-project", manifest.Project)
+	assert.Equal(t, "v1.0.0", manifest.Tag)
+	assert.Equal(t, []string{"docker-compose.yml"}, manifest.ComposeFiles)
+	assert.NotEmpty(t, manifest.Version)
+
+	web := manifest.Services["web"]
+	assert.Equal(t, "web:v1.0.0", web.Image)
+	assert.Equal(t, "sha256:1111", web.ImageID)
+	assert.Equal(t, []string{"registry.local/web@sha256:2222"}, web.RepoDigests)
+	assert.Equal(t, "amd64", web.Architecture)
+	assert.Equal(t, "linux", web.OS)
+	assert.Equal(t, int64(2048), web.Size)
+	assert.Equal(t, []string{"sha256:aaaa", "sha256:bbbb"}, web.Layers)
+}
+
+func TestWriteManifest_ServiceArchives(t *testing.T) {
+	tempDir := setupTempDir(t)
+	layers := func(diffIDs ...string) image.InspectResponse {
+		return image.InspectResponse{RootFS: image.RootFS{Type: "layers", Layers: diffIDs}}
+	}
+
+	client := &Compose.Client{
+		Config: Compose.Config{OutputDir: tempDir, Tag: "v1"},
+		Project: &types.Project{
+			Name: "test-project",
+			Services: types.Services{
+				"web":    types.ServiceConfig{Name: "web", Image: "web:v1"},
+				"worker": types.ServiceConfig{Name: "worker", Image: "worker:v1"},
+			},
+		},
+		Images: map[string]image.InspectResponse{
+			"web":    layers("sha256:base", "sha256:web"),
+			"worker": layers("sha256:base", "sha256:worker"),
+		},
+		ServiceArchives: map[string]*bundle.Archive{
+			"web":    {Name: "images/web.tar", Format: bundle.FormatOCIArchive},
+			"worker": {Name: "images/worker.tar", Format: bundle.FormatOCIArchive},
+		},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+
+	outPath, err := client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(outPath)
+	require.NoError(t, err)
+
+	web := manifest.Services["web"]
+	require.NotNil(t, web.Archive)
+	assert.Equal(t, "images/web.tar", web.Archive.Name)
+	assert.Equal(t, []string{"sha256:base"}, web.SharedLayers)
+	assert.Equal(t, []string{"sha256:base"}, manifest.Services["worker"].SharedLayers)
+	assert.True(t, manifest.PerServiceOnly())
+	assert.Len(t, manifest.ImageArchives(), 2)
+}
+
+func TestWriteManifest_RenderedTag(t *testing.T) {
+	tempDir := setupTempDir(t)
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir, Tag: "latest", TagTemplate: "{{.Service}}:{{.GitTag}}"},
+		Project: &types.Project{Name: "test-project"},
+		Tag:     "v1.2.0",
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+
+	outPath, err := client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.2.0", manifest.Tag)
+}
+
+func TestWriteManifest_NilProject(t *testing.T) {
+	client := &Compose.Client{
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+
+	outPath, err := client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, outPath)
+}
+
+func TestWriteManifest_CreateFileError(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.OSCreate = func(_ string) (*os.File, error) {
+		return nil, errors.New("file creation failed")
+	}
+
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: "/tmp"},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	_, err := client.WriteManifest(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "file creation failed")
+}
+
+func TestWriteInstaller_Success(t *testing.T) {
+	tempDir := setupTempDir(t)
+	client := &Compose.Client{
+		Config: Compose.Config{OutputDir: tempDir, Tag: "v1"},
+		Project: &types.Project{
+			Name: "test-project",
+			Services: types.Services{
+				"web": types.ServiceConfig{Name: "web", Image: "web:v1"},
+			},
+		},
+		Images: map[string]image.InspectResponse{"web": {ID: "sha256:1111"}},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+	_, err := client.SaveComposeFile(context.Background())
+	require.NoError(t, err)
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+
+	outPath, err := client.WriteInstaller(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, bundle.InstallerFile), outPath)
+
+	info, err := os.Stat(outPath)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&0100, "installer is not executable")
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "#!/bin/sh\n")
+	assert.Contains(t, string(data), "docker load -i 'images.tar'")
+}
+
+func TestWriteInstaller_MissingManifest(t *testing.T) {
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: setupTempDir(t)},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+
+	_, err := client.WriteInstaller(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to open manifest")
+}
+
+func TestSignManifest_Success(t *testing.T) {
+	tempDir := setupTempDir(t)
+	key, err := bundle.GenerateEd25519Key()
+	require.NoError(t, err)
+	private, err := bundle.MarshalPrivateKey(key)
+	require.NoError(t, err)
+	keyPath := filepath.Join(tempDir, "release.key")
+	require.NoError(t, os.WriteFile(keyPath, private, 0600))
+
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir, Tag: "v1", SignKey: keyPath},
+		Project: &types.Project{Name: "test-project"},
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+	_, err = client.SaveComposeFile(context.Background())
+	require.NoError(t, err)
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+
+	outPath, err := client.SignManifest(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tempDir, bundle.SignatureFile), outPath)
+	public, _ := key.Public().(ed25519.PublicKey)
+	signature, err := bundle.VerifySignature(tempDir, []ed25519.PublicKey{public})
+	require.NoError(t, err)
+	assert.Equal(t, bundle.SigningKeyID(public), signature.KeyID)
+
+	// Rewriting the manifest drops the signature that no longer matches it
+	client.Config.SignKey = ""
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	assert.NoFileExists(t, outPath)
+}
+
+func TestSignManifest_InvalidKey(t *testing.T) {
+	tempDir := setupTempDir(t)
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	private, err := bundle.MarshalPrivateKey(identity)
+	require.NoError(t, err)
+	keyPath := filepath.Join(tempDir, "site.key")
+	require.NoError(t, os.WriteFile(keyPath, private, 0600))
+
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir, SignKey: keyPath},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+	_, err = client.SignManifest(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not an ed25519 key")
+}
+
+func TestWriteSBOMs_Success(t *testing.T) {
+	tempDir := setupTempDir(t)
+	client := &Compose.Client{
+		Config: Compose.Config{OutputDir: tempDir, SBOM: "cyclonedx"},
+		Project: &types.Project{
+			Name:     "test-project",
+			Services: types.Services{"web": types.ServiceConfig{Name: "web", Image: "web:v1"}},
+		},
+		Images: map[string]image.InspectResponse{"web": {ID: "sha256:1111"}},
+		Packages: map[string][]bundle.Package{"web": {
+			{Type: bundle.PackagePyPI, Name: "requests", Version: "2.31.0", Location: "METADATA"},
+		}},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+
+	paths, err := client.WriteSBOMs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(tempDir, "sbom", "web.cdx.json")}, paths)
+	assert.Equal(t, map[string]string{"web": "sbom/web.cdx.json"}, client.SBOMs)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, "sbom/web.cdx.json", client.Files[0].Name)
+	data, err := os.ReadFile(paths[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "pkg:pypi/requests@2.31.0")
+
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(filepath.Join(tempDir, bundle.ManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, "sbom/web.cdx.json", manifest.Services["web"].SBOM)
+}
+
+func TestWriteSBOMs_NotScanned(t *testing.T) {
+	client := &Compose.Client{
+		Config: Compose.Config{OutputDir: setupTempDir(t), SBOM: "spdx"},
+		Project: &types.Project{
+			Name:     "test",
+			Services: types.Services{"web": types.ServiceConfig{Name: "web", Image: "web:v1"}},
+		},
+		Images: map[string]image.InspectResponse{"web": {ID: "sha256:1111"}},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+
+	_, err := client.WriteSBOMs(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "was not scanned for packages")
+}
+
+// scanClient returns a client whose web service image holds an openssl
+// package affected by a high severity advisory of the database.
+func scanClient(t *testing.T, threshold string) *Compose.Client {
+	t.Helper()
+	tempDir := setupTempDir(t)
+	dbPath := filepath.Join(t.TempDir(), "osv.json")
+	require.NoError(t, os.WriteFile(dbPath, []byte(`[{"id":"DSA-5532-1","modified":"2024-05-01T00:00:00Z",
+		"database_specific":{"severity":"high"},
+		"affected":[{"package":{"ecosystem":"Debian:12","name":"openssl"},
+			"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.0.11-1~deb12u2"}]}]}]}]`), 0600))
+	return &Compose.Client{
+		Config: Compose.Config{OutputDir: tempDir, ScanDB: dbPath, ScanFailOn: threshold},
+		Project: &types.Project{
+			Name: "test-project",
+			Services: types.Services{
+				"web": types.ServiceConfig{Name: "web", Image: "web:v1"},
+				"db":  types.ServiceConfig{Name: "db", Image: "postgres:16"},
+			},
+		},
+		Images: map[string]image.InspectResponse{"web": {ID: "sha256:1111"}, "db": {ID: "sha256:2222"}},
+		Packages: map[string][]bundle.Package{
+			"web": {{
+				Type: bundle.PackageDeb, Name: "libssl3", Version: "3.0.11-1~deb12u1", Source: "openssl",
+				Distro: "debian", DistroVersion: "12", Location: "var/lib/dpkg/status",
+			}},
+			"db": {{
+				Type: bundle.PackageDeb, Name: "libssl3", Version: "3.0.11-1~deb12u2", Source: "openssl",
+				Distro: "debian", DistroVersion: "12", Location: "var/lib/dpkg/status",
+			}},
+		},
+		Logger: logrus.New(),
+		Deps:   setupTestDependencies(),
+	}
+}
+
+func TestScanVulnerabilities_BelowThreshold(t *testing.T) {
+	client := scanClient(t, "critical")
+
+	outPath, err := client.ScanVulnerabilities(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(client.Config.OutputDir, bundle.VulnerabilityReportFile), outPath)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, bundle.VulnerabilityReportFile, client.Files[0].Name)
+
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	var report bundle.VulnerabilityReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, bundle.SeverityCritical, report.Threshold)
+	assert.Equal(t, "osv.json", report.Database.Name)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, "web", report.Findings[0].Service)
+	assert.Equal(t, "web:v1", report.Findings[0].Image)
+	assert.Equal(t, "DSA-5532-1", report.Findings[0].Advisory)
+	assert.Equal(t, bundle.SeverityHigh, report.Findings[0].Severity)
+	assert.Equal(t, "3.0.11-1~deb12u2", report.Findings[0].Fixed)
+
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(filepath.Join(client.Config.OutputDir, bundle.ManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, bundle.VulnerabilityReportFile, manifest.VulnerabilityReport)
+}
+
+func TestScanVulnerabilities_AtThreshold(t *testing.T) {
+	client := scanClient(t, "")
+	archivePath := filepath.Join(client.Config.OutputDir, bundle.ImagesFile)
+	require.NoError(t, os.WriteFile(archivePath, []byte("images"), 0600))
+	client.Archive = &bundle.Archive{Name: bundle.ImagesFile}
+	client.Files = []bundle.File{{Name: bundle.ImagesFile}}
+	// Left by an earlier save to the same directory.
+	manifestPath := filepath.Join(client.Config.OutputDir, bundle.ManifestFile)
+	require.NoError(t, os.WriteFile(manifestPath, []byte("{}"), 0600))
+	signaturePath := filepath.Join(client.Config.OutputDir, bundle.SignatureFile)
+	require.NoError(t, os.WriteFile(signaturePath, []byte("signature"), 0600))
+
+	_, err := client.ScanVulnerabilities(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 vulnerabilities at or above high severity")
+	assert.FileExists(t, filepath.Join(client.Config.OutputDir, bundle.VulnerabilityReportFile))
+	assert.NoFileExists(t, archivePath)
+	assert.NoFileExists(t, manifestPath)
+	assert.NoFileExists(t, signaturePath)
+	assert.Nil(t, client.Archive)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, bundle.VulnerabilityReportFile, client.Files[0].Name)
+}
+
+func TestScanVulnerabilities_NotScanned(t *testing.T) {
+	client := scanClient(t, "none")
+	delete(client.Packages, "db")
+
+	_, err := client.ScanVulnerabilities(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "image of service db was not scanned for packages")
+}
+
+func TestScanSecrets_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Compose.Config
+		errMsg string
+	}{
+		{
+			name:   "unknown mode",
+			config: Compose.Config{Secrets: "block"},
+			errMsg: "unsupported secrets mode",
+		},
+		{
+			name:   "invalid ignore pattern",
+			config: Compose.Config{SecretsIgnore: []string{"["}},
+			errMsg: "invalid secret ignore pattern",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps := setupTestDependencies()
+			deps.NewDockerClient = func() (*client.Client, error) {
+				t.Fatal("Docker client must not be created for an invalid configuration")
+				return nil, nil
+			}
+			client := &Compose.Client{
+				Config:  tt.config,
+				Project: &types.Project{Name: "test", Services: types.Services{"web": {Name: "web", Image: "web:v1"}}},
+				Logger:  logrus.New(),
+				Deps:    deps,
+			}
+
+			err := client.ScanSecrets(context.Background())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestScanSecrets_Off(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when the secret scan is off")
+		return nil, nil
+	}
+	client := &Compose.Client{
+		Config:  Compose.Config{Secrets: Compose.SecretsOff},
+		Project: &types.Project{Name: "test", Services: types.Services{"web": {Name: "web", Image: "web:v1"}}},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	assert.NoError(t, client.ScanSecrets(context.Background()))
+}
+
+func TestScanSecrets_SavedImages(t *testing.T) {
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created, saved images are scanned by SaveImages")
+		return nil, nil
+	}
+	client := &Compose.Client{
+		Project: &types.Project{Name: "test", Services: types.Services{"web": {Name: "web", Image: "web:v1"}}},
+		Logger:  logrus.New(),
+		Deps:    deps,
+	}
+
+	require.NoError(t, client.ScanSecrets(context.Background()))
+	assert.NotNil(t, client.SecretScanner)
+}
+
+// policyClient returns a client checking a policy that forbids privileged
+// services and the host network, failing on the former only.
+func policyClient(t *testing.T, privileged bool) *Compose.Client {
+	t.Helper()
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`fail_on: critical
+rules:
+  - rule: no-privileged
+    severity: critical
+  - rule: no-host-network
+    severity: medium
+`), 0600))
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when no rule reads image configs")
+		return nil, nil
+	}
+	return &Compose.Client{
+		Config: Compose.Config{OutputDir: setupTempDir(t), Policy: policyPath},
+		Project: &types.Project{
+			Name: "test-project",
+			Services: types.Services{
+				"web":     {Name: "web", Image: "web:v1", Privileged: privileged},
+				"monitor": {Name: "monitor", Image: "node-exporter:1.8", NetworkMode: "host"},
+			},
+		},
+		Logger: logrus.New(),
+		Deps:   deps,
+	}
+}
+
+func TestCheckPolicy_BelowThreshold(t *testing.T) {
+	client := policyClient(t, false)
+
+	outPath, err := client.CheckPolicy(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(client.Config.OutputDir, policy.ReportFile), outPath)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, policy.ReportFile, client.Files[0].Name)
+
+	data, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	var report policy.Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "policy.yaml", report.Policy)
+	assert.Equal(t, bundle.SeverityCritical, report.Threshold)
+	require.Len(t, report.Violations, 1)
+	assert.Equal(t, policy.Violation{
+		Rule:     policy.RuleNoHostNetwork,
+		Severity: bundle.SeverityMedium,
+		Service:  "monitor",
+		Message:  "uses the host network",
+	}, report.Violations[0])
+
+	client.Images = map[string]image.InspectResponse{}
+	manifestPath, err := client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(manifestPath)
+	require.NoError(t, err)
+	assert.Equal(t, policy.ReportFile, manifest.PolicyReport)
+}
+
+func TestCheckPolicy_AtThreshold(t *testing.T) {
+	client := policyClient(t, true)
+
+	_, err := client.CheckPolicy(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 policy violations at or above critical severity")
+	assert.FileExists(t, filepath.Join(client.Config.OutputDir, policy.ReportFile))
+}
+
+func TestCheckPolicy_InvalidPolicy(t *testing.T) {
+	client := policyClient(t, false)
+	require.NoError(t, os.WriteFile(client.Config.Policy, []byte("rules:\n  - rule: no-root\n"), 0600))
+
+	_, err := client.CheckPolicy(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown rule")
+	assert.NoFileExists(t, filepath.Join(client.Config.OutputDir, policy.ReportFile))
+}
+
+func TestCheckPolicy_RegistryPrefix(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(policyPath, []byte(`rules:
+  - rule: approved-registries
+    registries: [docker.io/library]
+`), 0600))
+	deps := setupTestDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		t.Fatal("Docker client must not be created when no rule reads image configs")
+		return nil, nil
+	}
+	newClient := func(services types.Services) *Compose.Client {
+		return &Compose.Client{
+			Config: Compose.Config{
+				OutputDir:      setupTempDir(t),
+				Policy:         policyPath,
+				RegistryPrefix: "registry.site.local:5000",
+			},
+			Project: &types.Project{Name: "test-project", Services: services},
+			Logger:  logrus.New(),
+			Deps:    deps,
+		}
+	}
+
+	// The registries are checked against the images of the compose file, not
+	// the names they are delivered under.
+	client := newClient(types.Services{"db": {Name: "db", Image: "postgres:16"}})
+	require.NoError(t, client.CheckServicePolicy(context.Background()))
+	db := client.Project.Services["db"]
+	db.Image = "registry.site.local:5000/library/postgres:16"
+	client.Project.Services["db"] = db
+	outPath, err := client.CheckPolicy(context.Background())
+	require.NoError(t, err)
+	assert.FileExists(t, outPath)
+	assert.Empty(t, client.PolicyReport.Violations)
+
+	client = newClient(types.Services{"agent": {Name: "agent", Image: "ghcr.io/org/agent:2"}})
+	err = client.CheckServicePolicy(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 policy violations at or above high severity")
+	require.Len(t, client.PolicyReport.Violations, 1)
+	assert.Equal(t, "uses image ghcr.io/org/agent:2 from a registry that is not approved",
+		client.PolicyReport.Violations[0].Message)
+	assert.FileExists(t, filepath.Join(client.Config.OutputDir, policy.ReportFile))
+}
+
+func TestWriteProvenance_Signed(t *testing.T) {
+	tempDir := setupTempDir(t)
+	key, err := bundle.GenerateEd25519Key()
+	require.NoError(t, err)
+	private, err := bundle.MarshalPrivateKey(key)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "release.key")
+	require.NoError(t, os.WriteFile(keyPath, private, 0600))
+
+	statement := &bundle.Statement{
+		Type:          bundle.StatementType,
+		Subject:       []bundle.ResourceDescriptor{{Name: "web:v1", Digest: map[string]string{"sha256": "1111"}}},
+		PredicateType: bundle.ProvenancePredicateType,
+	}
+	client := &Compose.Client{
+		Config: Compose.Config{OutputDir: tempDir, SignKey: keyPath},
+		Project: &types.Project{
+			Name:     "test-project",
+			Services: types.Services{"web": types.ServiceConfig{Name: "web", Image: "web:v1"}},
+		},
+		Images:     map[string]image.InspectResponse{"web": {ID: "sha256:1111"}},
+		Provenance: map[string]*bundle.Statement{"web": statement},
+		Logger:     logrus.New(),
+		Deps:       setupTestDependencies(),
+	}
+
+	paths, err := client.WriteProvenance(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(tempDir, "provenance", "web.intoto.json")}, paths)
+	require.Len(t, client.Files, 1)
+	assert.Equal(t, "provenance/web.intoto.json", client.Files[0].Name)
+
+	envelope, err := bundle.ReadEnvelope(paths[0])
+	require.NoError(t, err)
+	public, _ := key.Public().(ed25519.PublicKey)
+	verified, err := envelope.Verify([]ed25519.PublicKey{public})
+	require.NoError(t, err)
+	assert.Equal(t, statement, verified)
+
+	_, err = client.WriteManifest(context.Background())
+	require.NoError(t, err)
+	manifest, err := bundle.ReadManifest(filepath.Join(tempDir, bundle.ManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, "provenance/web.intoto.json", manifest.Services["web"].Provenance)
+}
+
+func TestWriteProvenance_NothingBuilt(t *testing.T) {
+	tempDir := setupTempDir(t)
+	client := &Compose.Client{
+		Config:  Compose.Config{OutputDir: tempDir, Provenance: true},
+		Project: &types.Project{Name: "test"},
+		Logger:  logrus.New(),
+		Deps:    setupTestDependencies(),
+	}
+
+	paths, err := client.WriteProvenance(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+	assert.NoDirExists(t, filepath.Join(tempDir, bundle.ProvenanceDir))
 }
 
 // Benchmark for SaveComposeFile
@@ -0,0 +1,57 @@
+package compose
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// loadKeys loads the keys the bundle is encrypted to when encryption is enabled.
+// The passphrase of PassphraseEnv is ignored when recipients are given.
+func (c *Client) loadKeys() error {
+	if !c.Config.Encrypt {
+		if len(c.Config.Recipients) > 0 || c.Config.PassphraseFile != "" {
+			return errors.New("recipients and passphrase files only apply when the bundle is encrypted")
+		}
+		return nil
+	}
+	if c.Keys != nil {
+		return nil
+	}
+	if c.Config.Installer {
+		return errors.New("an installer cannot decrypt the bundle, it cannot be combined with encryption")
+	}
+	keys, err := bundle.LoadKeys(c.Config.Recipients, nil, c.Config.PassphraseFile)
+	if err != nil {
+		return err
+	}
+	if len(keys.Recipients) > 0 && len(keys.Passphrase) > 0 {
+		// As in age, a file is encrypted either to recipients or to a passphrase.
+		if c.Config.PassphraseFile != "" {
+			return errors.New("encrypting to recipients cannot be combined with a passphrase file")
+		}
+		keys.Passphrase = nil
+	}
+	if len(keys.Recipients) == 0 && len(keys.Passphrase) == 0 {
+		return errors.Errorf("encrypting the bundle needs a recipient or a passphrase, from a file or %s",
+			bundle.PassphraseEnv)
+	}
+	c.Keys = keys
+	return nil
+}
+
+// encryptWriter returns a writer encrypting into w to the keys of the bundle,
+// or passing writes through when the bundle is not encrypted.
+func (c *Client) encryptWriter(w io.Writer) (io.WriteCloser, error) {
+	if c.Keys == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return bundle.NewEncryptWriter(w, c.Keys)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
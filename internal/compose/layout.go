@@ -0,0 +1,133 @@
+package compose
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// archiveFormat validates the configured image archive format against the
+// other archive options.
+func (c *Client) archiveFormat(compression bundle.Compression) (bundle.Format, error) {
+	format, err := bundle.ParseFormat(c.Config.Format)
+	if err != nil {
+		return "", err
+	}
+	if c.Config.SkipCombined && !c.Config.PerService {
+		return "", errors.New("the combined image archive can only be skipped when writing per-service archives")
+	}
+	if c.Config.SkipCombined && (c.Config.Base != "" || c.Config.Inventory != "") {
+		return "", errors.New("per-service archives hold every layer, a delta needs the combined image archive")
+	}
+	if c.Config.PerService && c.Config.ToRegistry != "" {
+		return "", errors.New("images pushed to a registry are not saved, per-service archives do not apply")
+	}
+	if c.Config.Encrypt && c.Config.ToRegistry != "" {
+		return "", errors.New("images pushed to a registry are not saved, encryption does not apply")
+	}
+	if _, err = bundle.ParseSBOMFormat(c.Config.SBOM); err != nil {
+		return "", err
+	}
+	if c.Config.SBOM != "" && c.Config.ToRegistry != "" {
+		return "", errors.New("images pushed to a registry are not saved, SBOMs are generated from the image archive")
+	}
+	if c.Config.ScanDB != "" && c.Config.ToRegistry != "" {
+		return "", errors.New("images pushed to a registry are not saved, vulnerability scans read the image archive")
+	}
+	if format == bundle.FormatDocker {
+		return format, nil
+	}
+	if c.Config.ToRegistry != "" {
+		return "", errors.Errorf("images pushed to a registry are not saved, the %s format does not apply", format)
+	}
+	if c.Config.Base != "" || c.Config.Inventory != "" {
+		return "", errors.Errorf("%s archives cannot be delivered as a delta against a base or inventory", format)
+	}
+	if format == bundle.FormatOCI &&
+		(compression != bundle.CompressionNone || c.Config.SplitSize > 0 || c.Config.Encrypt) {
+		return "", errors.New("an OCI image layout directory cannot be compressed, split or encrypted, " +
+			"use the oci-archive format")
+	}
+	return format, nil
+}
+
+// writeOCILayout converts the `docker save` stream into an OCI image layout,
+// written to the oci directory of the output directory or, for the oci-archive
+// format, to the image archive through a temporary directory.
+func (c *Client) writeOCILayout(src io.Reader, format bundle.Format, compression bundle.Compression) error {
+	if format == bundle.FormatOCIArchive {
+		staging, err := os.MkdirTemp(c.Config.OutputDir, ".oci-layout-*")
+		if err != nil {
+			return errors.Wrap(err, "failed to create OCI layout directory")
+		}
+		defer func() { _ = os.RemoveAll(staging) }()
+		if _, err = bundle.WriteOCILayout(src, staging, c.layoutImages()); err != nil {
+			return err
+		}
+		layout := bundle.TarDirectory(staging)
+		defer layout.Close()
+		if err = c.writeImageArchive(layout, compression); err != nil {
+			return err
+		}
+		c.Archive.Format = format
+		return nil
+	}
+
+	dir := filepath.Join(c.Config.OutputDir, bundle.LayoutDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrap(err, "failed to remove previous OCI layout")
+	}
+	files, err := bundle.WriteOCILayout(src, dir, c.layoutImages())
+	if err != nil {
+		return err
+	}
+	archive := bundle.Archive{Name: bundle.LayoutDir, Format: format, Compression: bundle.CompressionNone}
+	for _, file := range files {
+		file.Name = path.Join(bundle.LayoutDir, file.Name)
+		c.recordFile(file)
+		archive.Size += file.Size
+	}
+	c.Archive = &archive
+
+	const bytesToGB = 1024 * 1024 * 1024
+	c.Logger.Infof("Saved images as an OCI image layout to %s (%.2f GB)", dir, float64(archive.Size)/bytesToGB)
+	return nil
+}
+
+// layoutImages returns the images to index in the OCI layout, one per service,
+// annotated with the project and service they are delivered for.
+func (c *Client) layoutImages() []bundle.LayoutImage {
+	services := c.serviceNames()
+	images := make([]bundle.LayoutImage, 0, len(services))
+	for _, name := range services {
+		images = append(images, c.layoutImage(name))
+	}
+	return images
+}
+
+func (c *Client) layoutImage(service string) bundle.LayoutImage {
+	return bundle.LayoutImage{
+		Ref: c.Project.Services[service].Image,
+		ID:  c.Images[service].ID,
+		Annotations: map[string]string{
+			api.ProjectLabel: c.Project.Name,
+			api.ServiceLabel: service,
+		},
+	}
+}
+
+// serviceNames returns the sorted names of the services with an inspected image.
+func (c *Client) serviceNames() []string {
+	services := make([]string, 0, len(c.Images))
+	for name := range c.Images {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	return services
+}
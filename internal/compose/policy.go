@@ -0,0 +1,148 @@
+package compose
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/policy"
+)
+
+// loadPolicy reads the policy file when Policy is set, so that a missing or
+// invalid policy fails before anything is pulled or built.
+func (c *Client) loadPolicy() error {
+	if c.Config.Policy == "" || c.Policy != nil {
+		return nil
+	}
+	p, err := policy.ReadPolicy(c.Config.Policy)
+	if err != nil {
+		return err
+	}
+	c.Policy = p
+	c.Logger.Infof("Loaded %d policy rules from %s", len(p.Rules), c.Config.Policy)
+	return nil
+}
+
+// CheckServicePolicy checks the services of the project, as loaded, against
+// the rules of the policy reading only the compose file, before anything is
+// pulled or built: approved-registries sees the image references of the
+// compose file, not the names the images are delivered under. Its violations
+// are reported by CheckPolicy; one at or above the fail_on severity of the
+// policy fails the check right away, writing the policy report.
+func (c *Client) CheckServicePolicy(_ context.Context) error {
+	if c.Project == nil || c.Config.Policy == "" {
+		return nil
+	}
+	if err := c.loadPolicy(); err != nil {
+		return err
+	}
+	report := c.Policy.CheckServices(c.Project)
+	c.PolicyReport = report
+	if report.Failed == 0 {
+		return nil
+	}
+	_, err := c.reportPolicy(report)
+	return err
+}
+
+// CheckPolicy checks the config of the service images, once built and pulled,
+// against the rules of the policy reading it, writes the violations of both
+// checks to the policy report of the output directory and returns its path.
+// The services are first checked by CheckServicePolicy unless it already ran.
+// The check fails when a violation is at or above the fail_on severity of the
+// policy, before any image is pushed or saved.
+func (c *Client) CheckPolicy(ctx context.Context) (string, error) {
+	if c.Project == nil || c.Config.Policy == "" {
+		return "", nil
+	}
+	if c.PolicyReport == nil {
+		if err := c.CheckServicePolicy(ctx); err != nil {
+			return "", err
+		}
+	}
+	images, err := c.policyImages(ctx)
+	if err != nil {
+		return "", err
+	}
+	violations := append([]policy.Violation{}, c.PolicyReport.Violations...)
+	violations = append(violations, c.Policy.CheckImages(c.Project, images).Violations...)
+	return c.reportPolicy(policy.NewReport(c.Policy.Name, c.Policy.FailOn, violations))
+}
+
+// reportPolicy writes the report, logs its violations and fails when one is at
+// or above the threshold of the report.
+func (c *Client) reportPolicy(report *policy.Report) (string, error) {
+	outPath, err := c.writePolicyReport(report)
+	if err != nil {
+		return "", err
+	}
+	c.PolicyReport = report
+	c.Logger.Infof("Found %d policy violations, %d at or above %s severity, see %s",
+		len(report.Violations), report.Failed, report.Threshold, outPath)
+
+	for _, violation := range report.Violations {
+		logf := c.Logger.Warnf
+		if violation.Severity.AtLeast(report.Threshold) {
+			logf = c.Logger.Errorf
+		}
+		logf("Service %s %s (%s, %s)", violation.Service, violation.Message, violation.Rule, violation.Severity)
+	}
+	if report.Failed > 0 {
+		return "", errors.Errorf("%d policy violations at or above %s severity, see %s",
+			report.Failed, report.Threshold, outPath)
+	}
+	return outPath, nil
+}
+
+// policyImages inspects the image of every service when a rule of the policy reads image configs.
+func (c *Client) policyImages(ctx context.Context) (map[string]policy.Image, error) {
+	images := make(map[string]policy.Image, len(c.Project.Services))
+	if !c.Policy.NeedsImages() {
+		return images, nil
+	}
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	for _, name := range c.Project.ServiceNames() {
+		ref := c.Project.Services[name].Image
+		if ref == "" {
+			continue
+		}
+		inspect, inspectErr := cli.ImageInspect(ctx, ref)
+		if inspectErr != nil {
+			return nil, errors.Wrapf(inspectErr, "failed to inspect image %s", ref)
+		}
+		var img policy.Image
+		if config := inspect.Config; config != nil {
+			img.User = config.User
+			img.Healthcheck = config.Healthcheck != nil &&
+				len(config.Healthcheck.Test) > 0 && config.Healthcheck.Test[0] != "NONE"
+		}
+		images[name] = img
+	}
+	return images, nil
+}
+
+// writePolicyReport writes the report and records its checksum.
+func (c *Client) writePolicyReport(report *policy.Report) (string, error) {
+	outPath := filepath.Join(c.Config.OutputDir, policy.ReportFile)
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create policy report")
+	}
+	defer file.Close()
+
+	hw := bundle.NewHashWriter(file)
+	if encodeErr := report.Encode(hw); encodeErr != nil {
+		return "", errors.Wrap(encodeErr, "failed to write policy report")
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		return "", errors.Wrap(closeErr, "failed to write policy report")
+	}
+	c.recordFile(hw.File(policy.ReportFile))
+	return outPath, nil
+}
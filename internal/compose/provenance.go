@@ -0,0 +1,439 @@
+package compose
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/version"
+)
+
+const (
+	dockerImageScheme = "docker-image://"
+	serviceScheme     = "service:"
+	fileScheme        = "file://"
+)
+
+// provenanceStatements records the inputs of every service Build is about to
+// build: compose files, build context, Dockerfile, build args, additional
+// contexts, base images and the git commit of the context. The built images
+// and the digests of the images the build starts from are filled in by
+// completeProvenance once the build is done.
+func (c *Client) provenanceStatements(ctx context.Context) (map[string]*bundle.Statement, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine builder host")
+	}
+	composeFiles, err := c.composeFileDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make(map[string]*bundle.Statement)
+	for name, s := range c.Project.Services {
+		if s.Build == nil {
+			continue
+		}
+		statement, statementErr := c.provenanceStatement(ctx, s, host)
+		if statementErr != nil {
+			return nil, errors.Wrapf(statementErr, "failed to record the provenance of service %s", name)
+		}
+		deps := &statement.Predicate.BuildDefinition.ResolvedDependencies
+		*deps = append(append([]bundle.ResourceDescriptor{}, composeFiles...), *deps...)
+		statements[name] = statement
+	}
+	return statements, nil
+}
+
+// composeFileDependencies describes the compose files of the project by path and checksum.
+func (c *Client) composeFileDependencies() ([]bundle.ResourceDescriptor, error) {
+	files := c.Project.ComposeFiles
+	if len(files) == 0 {
+		files = c.Config.DockerComposePath
+	}
+	deps := make([]bundle.ResourceDescriptor, 0, len(files))
+	for _, file := range files {
+		hashed, err := bundle.HashFile(file, file)
+		if err != nil {
+			return nil, err
+		}
+		deps = append(deps, bundle.ResourceDescriptor{
+			Name:   "compose-file",
+			URI:    fileURI(file),
+			Digest: map[string]string{"sha256": hashed.SHA256},
+		})
+	}
+	return deps, nil
+}
+
+// provenanceStatement records the inputs of the build of service s.
+func (c *Client) provenanceStatement(
+	ctx context.Context, s types.ServiceConfig, host string,
+) (*bundle.Statement, error) {
+	build := s.Build
+	params := bundle.BuildParameters{
+		ComposeFiles:       c.Config.DockerComposePath,
+		Project:            c.Project.Name,
+		Service:            s.Name,
+		Context:            build.Context,
+		Dockerfile:         build.Dockerfile,
+		Target:             build.Target,
+		Args:               make(map[string]string),
+		AdditionalContexts: build.AdditionalContexts,
+		Platforms:          build.Platforms,
+	}
+	for name, value := range build.Args {
+		if value != nil {
+			params.Args[name] = *value
+		}
+	}
+
+	deps, err := c.contextDependencies(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile, dockerfileDep, err := readDockerfile(build)
+	if err != nil {
+		return nil, err
+	}
+	if dockerfileDep != nil {
+		params.Dockerfile = dockerfileDep.URI
+		deps = append(deps, *dockerfileDep)
+	}
+	for _, ref := range bundle.BaseImages(dockerfile, params.Args) {
+		if _, isContext := build.AdditionalContexts[ref]; isContext {
+			continue
+		}
+		deps = append(deps, bundle.ResourceDescriptor{Name: "base-image", URI: dockerImageScheme + ref})
+	}
+	names := make([]string, 0, len(build.AdditionalContexts))
+	for name := range build.AdditionalContexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dep, contextErr := additionalContext(name, build.AdditionalContexts[name])
+		if contextErr != nil {
+			return nil, contextErr
+		}
+		deps = append(deps, dep)
+	}
+
+	return &bundle.Statement{
+		Type:          bundle.StatementType,
+		PredicateType: bundle.ProvenancePredicateType,
+		Predicate: bundle.Provenance{
+			BuildDefinition: bundle.BuildDefinition{
+				BuildType:            bundle.ProvenanceBuildType,
+				ExternalParameters:   params,
+				InternalParameters:   bundle.BuilderParameters{Host: host},
+				ResolvedDependencies: deps,
+			},
+			RunDetails: bundle.RunDetails{
+				Builder: bundle.Builder{
+					ID:      bundle.BuilderID,
+					Version: map[string]string{"docker-deliver": version.Get()},
+				},
+				Metadata: bundle.BuildMetadata{InvocationID: uuid.NewString()},
+			},
+		},
+	}, nil
+}
+
+// contextDependencies describes the build context by its content digest and,
+// when it is in a git repository, the commit checked out. Remote contexts are
+// only recorded by URL.
+func (c *Client) contextDependencies(
+	ctx context.Context, build *types.BuildConfig,
+) ([]bundle.ResourceDescriptor, error) {
+	info, statErr := os.Stat(build.Context)
+	if statErr != nil || !info.IsDir() {
+		return []bundle.ResourceDescriptor{{Name: "context", URI: build.Context}}, nil
+	}
+	dgst, err := bundle.ContextDigest(build.Context, dockerfilePath(build))
+	if err != nil {
+		return nil, err
+	}
+	deps := []bundle.ResourceDescriptor{{Name: "context", URI: fileURI(build.Context), Digest: digestMap(dgst)}}
+
+	commit, gitErr := c.Deps.GitOutput(ctx, build.Context, "rev-parse", "HEAD")
+	if gitErr != nil {
+		c.Logger.Debugf("Build context %s is not in a git repository: %v", build.Context, gitErr)
+		return deps, nil
+	}
+	source := bundle.ResourceDescriptor{
+		Name:   "git",
+		URI:    fileURI(build.Context),
+		Digest: map[string]string{"gitCommit": commit},
+	}
+	if remote, remoteErr := c.Deps.GitOutput(ctx, build.Context, "remote", "get-url", "origin"); remoteErr == nil {
+		source.URI = "git+" + remote
+	}
+	status, statusErr := c.Deps.GitOutput(ctx, build.Context, "status", "--porcelain")
+	if statusErr == nil && status != "" {
+		source.Annotations = map[string]string{"dirty": "true"}
+		c.Logger.Warnf("Build context %s has uncommitted changes, its provenance records commit %s", build.Context, commit)
+	}
+	return append(deps, source), nil
+}
+
+// dockerfilePath returns the path of the Dockerfile of a local build context.
+func dockerfilePath(build *types.BuildConfig) string {
+	if build.DockerfileInline != "" {
+		return ""
+	}
+	dockerfile := build.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if filepath.IsAbs(dockerfile) {
+		return dockerfile
+	}
+	return filepath.Join(build.Context, dockerfile)
+}
+
+// readDockerfile returns the Dockerfile of a build and describes it by digest.
+// Dockerfiles of remote contexts are not available and return nothing.
+func readDockerfile(build *types.BuildConfig) ([]byte, *bundle.ResourceDescriptor, error) {
+	if build.DockerfileInline != "" {
+		data := []byte(build.DockerfileInline)
+		return data, &bundle.ResourceDescriptor{
+			Name: "dockerfile", URI: "inline", Digest: digestMap(digest.FromBytes(data)),
+		}, nil
+	}
+	if info, err := os.Stat(build.Context); err != nil || !info.IsDir() {
+		return nil, nil, nil
+	}
+	file := dockerfilePath(build)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read Dockerfile")
+	}
+	return data, &bundle.ResourceDescriptor{
+		Name: "dockerfile", URI: fileURI(file), Digest: digestMap(digest.FromBytes(data)),
+	}, nil
+}
+
+// additionalContext describes an additional build context: images and other
+// services are resolved after the build, local directories by content digest.
+func additionalContext(name, value string) (bundle.ResourceDescriptor, error) {
+	dep := bundle.ResourceDescriptor{Name: "additional-context:" + name, URI: value}
+	if strings.HasPrefix(value, dockerImageScheme) || strings.HasPrefix(value, serviceScheme) ||
+		strings.Contains(value, "://") {
+		return dep, nil
+	}
+	if info, statErr := os.Stat(value); statErr != nil || !info.IsDir() {
+		return dep, nil
+	}
+	dgst, err := bundle.ContextDigest(value, "")
+	if err != nil {
+		return dep, err
+	}
+	dep.URI = fileURI(value)
+	dep.Digest = digestMap(dgst)
+	return dep, nil
+}
+
+// completeProvenance records the built images as the subjects of the
+// statements and resolves the digests of the images the builds started from.
+// Base images the builder did not store in the image store of the daemon, as
+// BuildKit does not, are resolved through their registry. Images neither
+// knows are logged as warnings and their dependencies annotated as unresolved,
+// so that the attestation does not look complete.
+func (c *Client) completeProvenance(
+	ctx context.Context, statements map[string]*bundle.Statement, started time.Time,
+) error {
+	finished := time.Now().UTC()
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+	resolver := &imageResolver{ctx: ctx, cli: cli, inspected: make(map[string]*image.InspectResponse)}
+
+	for name, statement := range statements {
+		ref := c.Project.Services[name].Image
+		built, inspectErr := resolver.inspect(ref)
+		if inspectErr != nil {
+			return errors.Wrapf(inspectErr, "failed to inspect built image %s", ref)
+		}
+		statement.Subject = []bundle.ResourceDescriptor{{Name: ref, Digest: digestMap(digest.Digest(built.ID))}}
+		statement.Predicate.RunDetails.Metadata.StartedOn = started
+		statement.Predicate.RunDetails.Metadata.FinishedOn = finished
+
+		deps := statement.Predicate.BuildDefinition.ResolvedDependencies
+		for i := range deps {
+			imageRef := c.dependencyImage(deps[i].URI)
+			if imageRef == "" {
+				continue
+			}
+			dgst, resolveErr := resolver.digest(imageRef)
+			if resolveErr != nil {
+				c.Logger.Warnf("Provenance of service %s records image %s without digest: %v", name, imageRef, resolveErr)
+				deps[i].Annotations = map[string]string{"digest": "unresolved"}
+				continue
+			}
+			deps[i].Digest = digestMap(dgst)
+		}
+	}
+	c.Provenance = statements
+	return nil
+}
+
+// imageResolver resolves the digests of images through the Docker daemon.
+type imageResolver struct {
+	ctx       context.Context
+	cli       *client.Client
+	inspected map[string]*image.InspectResponse
+}
+
+// inspect inspects the image ref in the image store of the daemon, once per image.
+func (r *imageResolver) inspect(ref string) (*image.InspectResponse, error) {
+	if result, ok := r.inspected[ref]; ok {
+		return result, nil
+	}
+	result, err := r.cli.ImageInspect(r.ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	r.inspected[ref] = &result
+	return &result, nil
+}
+
+// digest returns the digest of the image ref, from the image store of the
+// daemon or, when the image is not stored, from the manifest of its registry.
+func (r *imageResolver) digest(ref string) (digest.Digest, error) {
+	stored, err := r.inspect(ref)
+	if err == nil {
+		return imageDigest(ref, stored), nil
+	}
+	if !cerrdefs.IsNotFound(err) {
+		return "", errors.Wrapf(err, "failed to inspect image %s", ref)
+	}
+	auth, err := RegistryAuth(ref)
+	if err != nil {
+		return "", err
+	}
+	distribution, err := r.cli.DistributionInspect(r.ctx, ref, auth)
+	if err != nil {
+		return "", errors.Wrapf(err, "image %s is not stored locally and its registry did not resolve it", ref)
+	}
+	return distribution.Descriptor.Digest, nil
+}
+
+// dependencyImage returns the image a dependency URI refers to, if any.
+func (c *Client) dependencyImage(uri string) string {
+	if ref, ok := strings.CutPrefix(uri, dockerImageScheme); ok {
+		return ref
+	}
+	if service, ok := strings.CutPrefix(uri, serviceScheme); ok {
+		return c.Project.Services[service].Image
+	}
+	return ""
+}
+
+// imageDigest returns the registry digest of the image ref, falling back to
+// the image ID for images that were not pulled from a registry.
+func imageDigest(ref string, inspect *image.InspectResponse) digest.Digest {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err == nil {
+		for _, repoDigest := range inspect.RepoDigests {
+			canonical, parseErr := reference.ParseNormalizedNamed(repoDigest)
+			if parseErr != nil || canonical.Name() != named.Name() {
+				continue
+			}
+			if digested, ok := canonical.(reference.Canonical); ok {
+				return digested.Digest()
+			}
+		}
+	}
+	return digest.Digest(inspect.ID)
+}
+
+// digestMap returns a digest keyed by its algorithm, as in-toto resource descriptors record it.
+func digestMap(dgst digest.Digest) map[string]string {
+	if dgst.Validate() != nil {
+		return nil
+	}
+	return map[string]string{dgst.Algorithm().String(): dgst.Encoded()}
+}
+
+func fileURI(file string) string {
+	return fileScheme + filepath.ToSlash(file)
+}
+
+// WriteProvenance writes the provenance attestation of every service built by
+// Build to the provenance directory of the output directory, as a DSSE
+// envelope signed with SignKey when it is set, and returns their paths.
+func (c *Client) WriteProvenance(_ context.Context) ([]string, error) {
+	if c.Project == nil || len(c.Provenance) == 0 {
+		return nil, nil
+	}
+	if err := c.loadSigningKey(); err != nil {
+		return nil, err
+	}
+	const dirPermissions = 0755
+	if err := c.Deps.OSMkdirAll(filepath.Join(c.Config.OutputDir, bundle.ProvenanceDir), dirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create provenance directory")
+	}
+
+	services := make([]string, 0, len(c.Provenance))
+	for name := range c.Provenance {
+		services = append(services, name)
+	}
+	sort.Strings(services)
+	c.Attestations = make(map[string]string, len(services))
+	paths := make([]string, 0, len(services))
+	for _, service := range services {
+		outPath, err := c.writeAttestation(service)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, outPath)
+	}
+	return paths, nil
+}
+
+// writeAttestation writes the provenance attestation of a service and records its checksum.
+func (c *Client) writeAttestation(service string) (string, error) {
+	envelope, err := bundle.NewEnvelope(c.Provenance[service], c.SigningKey)
+	if err != nil {
+		return "", err
+	}
+	name := path.Join(bundle.ProvenanceDir, service+bundle.ProvenanceExtension)
+	outPath := filepath.Join(c.Config.OutputDir, filepath.FromSlash(name))
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create the provenance of service %s", service)
+	}
+	defer file.Close()
+
+	hw := bundle.NewHashWriter(file)
+	if encodeErr := envelope.Encode(hw); encodeErr != nil {
+		return "", errors.Wrapf(encodeErr, "failed to write the provenance of service %s", service)
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		return "", errors.Wrapf(closeErr, "failed to write the provenance of service %s", service)
+	}
+	c.recordFile(hw.File(name))
+	c.Attestations[service] = name
+	if len(envelope.Signatures) > 0 {
+		c.Logger.Infof("Wrote provenance of service %s signed by %s to %s", service, envelope.Signatures[0].KeyID, outPath)
+	} else {
+		c.Logger.Infof("Wrote provenance of service %s to %s", service, outPath)
+	}
+	return outPath, nil
+}
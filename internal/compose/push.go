@@ -0,0 +1,89 @@
+package compose
+
+import (
+	"context"
+	"sort"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	registrytypes "github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/registry"
+)
+
+// Push pushes the images of the project to ToRegistry, under which Build named
+// them. Nothing is pushed when ToRegistry is not set. Registry credentials are
+// read from the Docker client configuration.
+func (c *Client) Push(ctx context.Context) error {
+	if c.Project == nil || c.Config.ToRegistry == "" {
+		return nil
+	}
+	if c.Config.Base != "" || c.Config.Inventory != "" {
+		return errors.New("images pushed to a registry cannot be delivered as a delta against a base or inventory")
+	}
+
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	seen := make(map[string]bool, len(c.Project.Services))
+	images := make([]string, 0, len(c.Project.Services))
+	for _, s := range c.Project.Services {
+		if s.Image != "" && !seen[s.Image] {
+			seen[s.Image] = true
+			images = append(images, s.Image)
+		}
+	}
+	sort.Strings(images)
+	for _, img := range images {
+		if pushErr := c.pushImage(ctx, cli, img); pushErr != nil {
+			return pushErr
+		}
+	}
+	return nil
+}
+
+func (c *Client) pushImage(ctx context.Context, cli *client.Client, ref string) error {
+	auth, err := RegistryAuth(ref)
+	if err != nil {
+		return err
+	}
+	stream, err := cli.ImagePush(ctx, ref, image.PushOptions{RegistryAuth: auth})
+	if err != nil {
+		return errors.Wrapf(err, "failed to push image %s", ref)
+	}
+	defer stream.Close()
+
+	fd, isTerminal := term.GetFdInfo(c.Logger.Out)
+	if displayErr := jsonmessage.DisplayJSONMessagesStream(stream, c.Logger.Out, fd, isTerminal, nil); displayErr != nil {
+		return errors.Wrapf(displayErr, "failed to push image %s", ref)
+	}
+	c.Logger.Infof("Pushed image %s", ref)
+	return nil
+}
+
+// RegistryAuth returns the credentials stored by `docker login` for the
+// registry of ref, encoded for the RegistryAuth option of the Docker API.
+func RegistryAuth(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image name %q", ref)
+	}
+	auth, err := registry.DockerCredentials(reference.Domain(named))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read credentials of registry %s", reference.Domain(named))
+	}
+	return registrytypes.EncodeAuthConfig(registrytypes.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		Auth:          auth.Auth,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	})
+}
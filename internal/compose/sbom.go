@@ -0,0 +1,113 @@
+package compose
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/version"
+)
+
+// scanPackages passes src to write while indexing the packages installed in
+// the images of services when an SBOM or a vulnerability scan is configured. Services whose packages
+// were already found by a previous scan are not scanned again.
+func (c *Client) scanPackages(src io.Reader, services []string, write func(io.Reader) error) error {
+	var pending []string
+	for _, name := range services {
+		if _, scanned := c.Packages[name]; !scanned {
+			pending = append(pending, name)
+		}
+	}
+	if c.Config.SBOM == "" && c.Config.ScanDB == "" || len(pending) == 0 {
+		return write(src)
+	}
+
+	scanner := bundle.NewPackageScanner(src)
+	defer scanner.Close()
+	if err := write(scanner); err != nil {
+		return err
+	}
+	index, err := scanner.Index()
+	if err != nil {
+		return errors.Wrap(err, "failed to scan images for packages")
+	}
+	if c.Packages == nil {
+		c.Packages = make(map[string][]bundle.Package, len(c.Images))
+	}
+	for _, name := range pending {
+		packages, findErr := index.Packages(c.Images[name].ID, c.Project.Services[name].Image)
+		if findErr != nil {
+			return errors.Wrapf(findErr, "failed to list the packages of service %s", name)
+		}
+		c.Packages[name] = packages
+	}
+	return nil
+}
+
+// WriteSBOMs writes a software bill of materials per service to the sbom
+// directory of the output directory and returns their paths. It relies on the
+// packages SaveImages found in the layers of the image archive.
+func (c *Client) WriteSBOMs(_ context.Context) ([]string, error) {
+	if c.Project == nil || c.Config.SBOM == "" {
+		return nil, nil
+	}
+	format, err := bundle.ParseSBOMFormat(c.Config.SBOM)
+	if err != nil {
+		return nil, err
+	}
+	const dirPermissions = 0755
+	if mkdirErr := c.Deps.OSMkdirAll(filepath.Join(c.Config.OutputDir, bundle.SBOMDir), dirPermissions); mkdirErr != nil {
+		return nil, errors.Wrap(mkdirErr, "failed to create SBOM directory")
+	}
+
+	created := time.Now().UTC()
+	c.SBOMs = make(map[string]string, len(c.Images))
+	paths := make([]string, 0, len(c.Images))
+	for _, service := range c.serviceNames() {
+		outPath, writeErr := c.writeSBOM(format, service, created)
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		paths = append(paths, outPath)
+	}
+	return paths, nil
+}
+
+// writeSBOM writes the SBOM of a service and records its checksum.
+func (c *Client) writeSBOM(format bundle.SBOMFormat, service string, created time.Time) (string, error) {
+	packages, ok := c.Packages[service]
+	if !ok {
+		return "", errors.Errorf("the image of service %s was not scanned for packages", service)
+	}
+	name := path.Join(bundle.SBOMDir, service+format.Extension())
+	outPath := filepath.Join(c.Config.OutputDir, filepath.FromSlash(name))
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create the SBOM of service %s", service)
+	}
+	defer file.Close()
+
+	hw := bundle.NewHashWriter(file)
+	subject := bundle.SBOMSubject{
+		Project:     c.Project.Name,
+		Service:     service,
+		Image:       c.Project.Services[service].Image,
+		ImageID:     c.Images[service].ID,
+		Created:     created,
+		ToolVersion: version.Get(),
+	}
+	if writeErr := bundle.WriteSBOM(hw, format, subject, packages); writeErr != nil {
+		return "", errors.Wrapf(writeErr, "failed to write the SBOM of service %s", service)
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		return "", errors.Wrapf(closeErr, "failed to write the SBOM of service %s", service)
+	}
+	c.recordFile(hw.File(name))
+	c.SBOMs[service] = name
+	c.Logger.Infof("Wrote %s SBOM of service %s listing %d packages to %s", format, service, len(packages), outPath)
+	return outPath, nil
+}
@@ -0,0 +1,105 @@
+package compose
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// loadAdvisories loads the advisory database when ScanDB is set, so that a
+// missing or invalid database fails the save before any image is written.
+func (c *Client) loadAdvisories() error {
+	if c.Config.ScanDB == "" || c.Advisories != nil {
+		return nil
+	}
+	if _, err := bundle.ParseSeverityThreshold(c.Config.ScanFailOn); err != nil {
+		return err
+	}
+	db, err := bundle.ReadAdvisories(c.Config.ScanDB)
+	if err != nil {
+		return err
+	}
+	c.Advisories = db
+	c.Logger.Infof("Loaded %d advisories from %s, last modified %s",
+		db.Len(), c.Config.ScanDB, db.Modified.Format("2006-01-02"))
+	return nil
+}
+
+// ScanVulnerabilities matches the packages SaveImages found in every service
+// image against the advisory database, writes the findings to the
+// vulnerability report of the output directory and returns its path. The
+// scan fails when a finding is at or above the ScanFailOn severity, removing
+// the image archives SaveImages wrote.
+func (c *Client) ScanVulnerabilities(_ context.Context) (string, error) {
+	if c.Project == nil || c.Config.ScanDB == "" {
+		return "", nil
+	}
+	threshold, err := bundle.ParseSeverityThreshold(c.Config.ScanFailOn)
+	if err != nil {
+		return "", err
+	}
+	if err = c.loadAdvisories(); err != nil {
+		return "", err
+	}
+
+	var findings []bundle.Finding
+	for _, service := range c.serviceNames() {
+		packages, ok := c.Packages[service]
+		if !ok {
+			return "", errors.Errorf("the image of service %s was not scanned for packages", service)
+		}
+		for _, pkg := range packages {
+			for _, finding := range c.Advisories.Match(pkg) {
+				finding.Service = service
+				finding.Image = c.Project.Services[service].Image
+				findings = append(findings, finding)
+			}
+		}
+	}
+	report := bundle.NewVulnerabilityReport(c.Advisories, threshold, findings)
+	outPath, err := c.writeVulnerabilityReport(report)
+	if err != nil {
+		return "", err
+	}
+	c.Vulnerabilities = report
+	c.Logger.Infof("Found %d vulnerabilities in the service images, %d at or above %s severity, see %s",
+		len(report.Findings), report.Failed, threshold, outPath)
+
+	failing := report.Failing()
+	if len(failing) == 0 {
+		return outPath, nil
+	}
+	for _, finding := range failing {
+		fixed := "no fix known"
+		if finding.Fixed != "" {
+			fixed = "fixed in " + finding.Fixed
+		}
+		c.Logger.Errorf("Service %s: %s %s is affected by %s (%s), %s",
+			finding.Service, finding.Package, finding.Version, finding.Advisory, finding.Severity, fixed)
+	}
+	c.removeImageArchives()
+	return "", errors.Errorf("%d vulnerabilities at or above %s severity found in the service images, see %s",
+		len(failing), threshold, outPath)
+}
+
+// writeVulnerabilityReport writes the report and records its checksum.
+func (c *Client) writeVulnerabilityReport(report *bundle.VulnerabilityReport) (string, error) {
+	outPath := filepath.Join(c.Config.OutputDir, bundle.VulnerabilityReportFile)
+	file, err := c.Deps.OSCreate(outPath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create vulnerability report")
+	}
+	defer file.Close()
+
+	hw := bundle.NewHashWriter(file)
+	if encodeErr := report.Encode(hw); encodeErr != nil {
+		return "", errors.Wrap(encodeErr, "failed to write vulnerability report")
+	}
+	if closeErr := file.Close(); closeErr != nil {
+		return "", errors.Wrap(closeErr, "failed to write vulnerability report")
+	}
+	c.recordFile(hw.File(bundle.VulnerabilityReportFile))
+	return outPath, nil
+}
@@ -0,0 +1,169 @@
+package compose
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// validateSecrets checks the secret scan mode of the configuration.
+func validateSecrets(mode string) error {
+	switch mode {
+	case "", SecretsFail, SecretsWarn, SecretsOff:
+		return nil
+	default:
+		return errors.Errorf("unsupported secrets mode %q, expected fail, warn or off", mode)
+	}
+}
+
+// loadSecretScanner creates the secret scanner unless Secrets is "off", so
+// that an invalid configuration fails the save before any image is pushed or
+// written.
+func (c *Client) loadSecretScanner() error {
+	if err := validateSecrets(c.Config.Secrets); err != nil {
+		return err
+	}
+	if c.Config.Secrets == SecretsOff || c.SecretScanner != nil {
+		return nil
+	}
+	scanner, err := bundle.NewSecretScanner(c.Config.SecretsIgnore)
+	if err != nil {
+		return err
+	}
+	c.SecretScanner = scanner
+	return nil
+}
+
+// ScanSecrets searches the layers of the service images for files likely
+// holding secrets, see bundle.SecretScanner, before they are pushed with
+// ToRegistry. Otherwise SaveImages searches the layers as it writes them to
+// the image archives, so the images are exported only once, and ScanSecrets
+// only checks the configuration.
+func (c *Client) ScanSecrets(ctx context.Context) error {
+	if c.Project == nil {
+		return nil
+	}
+	if err := c.loadSecretScanner(); err != nil {
+		return err
+	}
+	if c.SecretScanner == nil || c.Config.ToRegistry == "" {
+		return nil
+	}
+	images := make([]string, 0, len(c.Project.Services))
+	for _, name := range c.Project.ServiceNames() {
+		if img := c.Project.Services[name].Image; img != "" {
+			images = append(images, img)
+		}
+	}
+	if len(images) == 0 {
+		return nil
+	}
+
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+	reader, err := cli.ImageSave(ctx, images)
+	if err != nil {
+		return errors.Wrap(err, "failed to export images for the secret scan")
+	}
+	defer reader.Close()
+	findings, err := c.SecretScanner.Scan(reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to scan images for secrets")
+	}
+	c.Secrets = findings
+	return c.reportSecrets(len(images))
+}
+
+// scanSecrets passes src to write while searching the layers it holds for
+// secrets, adding the findings to Secrets.
+func (c *Client) scanSecrets(src io.Reader, write func(io.Reader) error) error {
+	if c.SecretScanner == nil {
+		return write(src)
+	}
+	stream := c.SecretScanner.Stream(src)
+	defer stream.Close()
+	if err := write(stream); err != nil {
+		return err
+	}
+	// Writers may stop reading at the end of the tar archive, before its padding.
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return errors.Wrap(err, "failed to read image archive")
+	}
+	findings, err := stream.Findings()
+	if err != nil {
+		return errors.Wrap(err, "failed to scan images for secrets")
+	}
+	for _, finding := range findings {
+		c.addSecret(finding)
+	}
+	return nil
+}
+
+// addSecret adds a finding to Secrets, merging the images holding its layer
+// when the layer was already searched in another archive.
+func (c *Client) addSecret(finding bundle.SecretFinding) {
+	for i, found := range c.Secrets {
+		if found.Layer == finding.Layer && found.Path == finding.Path {
+			images := append(append([]string{}, found.Images...), finding.Images...)
+			sort.Strings(images)
+			c.Secrets[i].Images = slices.Compact(images)
+			return
+		}
+	}
+	c.Secrets = append(c.Secrets, finding)
+}
+
+// checkSecrets reports the secrets SaveImages found in the layers it wrote,
+// removing the image archives when they fail the scan.
+func (c *Client) checkSecrets(images int) error {
+	if c.SecretScanner == nil {
+		return nil
+	}
+	sort.SliceStable(c.Secrets, func(i, j int) bool { return c.Secrets[i].Path < c.Secrets[j].Path })
+	if err := c.reportSecrets(images); err != nil {
+		c.removeImageArchives()
+		return err
+	}
+	return nil
+}
+
+// reportSecrets logs every finding of Secrets with its path and the diff ID of
+// its layer. Findings fail the scan unless Secrets is "warn" or they are
+// warnings, such as private keys under a package directory.
+func (c *Client) reportSecrets(images int) error {
+	if len(c.Secrets) == 0 {
+		c.Logger.Infof("Found no likely secrets in the layers of %d images", images)
+		return nil
+	}
+	failing := 0
+	for _, finding := range c.Secrets {
+		kind := finding.Kind
+		if finding.Detail != "" {
+			kind += " (" + finding.Detail + ")"
+		}
+		logf := c.Logger.Warnf
+		switch {
+		case finding.Warning:
+			kind += " under a package directory"
+		case c.Config.Secrets != SecretsWarn:
+			logf = c.Logger.Errorf
+			failing++
+		}
+		logf("Likely secret in %s: %s, layer %s of %s",
+			finding.Path, kind, finding.Layer, strings.Join(finding.Images, ", "))
+	}
+	if failing == 0 {
+		c.Logger.Warnf("Found %d likely secrets in the image layers", len(c.Secrets))
+		return nil
+	}
+	return errors.Errorf("%d likely secrets found in the image layers, "+
+		"remove them from the images or ignore their paths", failing)
+}
@@ -0,0 +1,74 @@
+package compose
+
+import (
+	"io"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// writeServiceArchives writes an image archive per service under the images
+// directory of the output directory, holding every layer of the service image.
+// Every layer written is searched for secrets.
+func (c *Client) writeServiceArchives(cache *bundle.LayerCache, compression bundle.Compression) error {
+	const dirPermissions = 0755
+	if err := c.Deps.OSMkdirAll(filepath.Join(c.Config.OutputDir, bundle.ServiceArchiveDir), dirPermissions); err != nil {
+		return errors.Wrap(err, "failed to create per-service archive directory")
+	}
+	c.ServiceArchives = make(map[string]*bundle.Archive, len(c.Images))
+	for _, name := range c.serviceNames() {
+		var archive *bundle.Archive
+		reader := cachedArchive(cache, []bundle.LayoutImage{c.layoutImage(name)})
+		err := c.scanPackages(reader, []string{name}, func(r io.Reader) error {
+			return c.scanSecrets(r, func(scanned io.Reader) error {
+				var writeErr error
+				archive, writeErr = c.writeArchive(scanned, path.Join(bundle.ServiceArchiveDir, name+".tar"), compression)
+				return writeErr
+			})
+		})
+		_ = reader.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to write the image archive of service %s", name)
+		}
+		archive.Format = bundle.FormatOCIArchive
+		c.ServiceArchives[name] = archive
+	}
+	return nil
+}
+
+// recordSharedLayers records in the manifest the layers each per-service
+// archive shares with the image of another service.
+func (c *Client) recordSharedLayers(manifest *bundle.Manifest) {
+	users := make(map[string]int)
+	for _, inspect := range c.Images {
+		for _, layer := range uniqueLayers(inspect.RootFS.Layers) {
+			users[layer]++
+		}
+	}
+	for name, service := range manifest.Services {
+		if service.Archive == nil {
+			continue
+		}
+		service.SharedLayers = nil
+		for _, layer := range uniqueLayers(service.Layers) {
+			if users[layer] > 1 {
+				service.SharedLayers = append(service.SharedLayers, layer)
+			}
+		}
+		manifest.Services[name] = service
+	}
+}
+
+func uniqueLayers(layers []string) []string {
+	seen := make(map[string]bool, len(layers))
+	unique := make([]string, 0, len(layers))
+	for _, layer := range layers {
+		if !seen[layer] {
+			seen[layer] = true
+			unique = append(unique, layer)
+		}
+	}
+	return unique
+}
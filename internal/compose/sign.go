@@ -0,0 +1,50 @@
+package compose
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// loadSigningKey loads the key signing the manifest when SignKey is set, so
+// that a missing or invalid key fails the save before any image is written.
+func (c *Client) loadSigningKey() error {
+	if c.Config.SignKey == "" || c.SigningKey != nil {
+		return nil
+	}
+	key, err := bundle.ReadSigningKey(c.Config.SignKey)
+	if err != nil {
+		return err
+	}
+	c.SigningKey = key
+	return nil
+}
+
+// SignManifest signs the manifest written by WriteManifest with SignKey and
+// returns the path of the signature.
+func (c *Client) SignManifest(_ context.Context) (string, error) {
+	if c.Project == nil || c.Config.SignKey == "" {
+		return "", nil
+	}
+	if err := c.loadSigningKey(); err != nil {
+		return "", err
+	}
+	signature, err := bundle.SignManifest(c.Config.OutputDir, c.SigningKey)
+	if err != nil {
+		return "", err
+	}
+	c.Logger.Infof("Signed manifest with %s", signature.KeyID)
+	return filepath.Join(c.Config.OutputDir, bundle.SignatureFile), nil
+}
+
+// removeSignature removes the signature of a previous manifest, which no longer matches.
+func (c *Client) removeSignature() error {
+	err := os.Remove(filepath.Join(c.Config.OutputDir, bundle.SignatureFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to remove previous signature")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+)
+
+// DefaultTagTemplate names the images of services that do not declare one.
+const DefaultTagTemplate = "{{.Service}}:{{.Tag}}"
+
+// TagData holds the fields available to image tag templates.
+type TagData struct {
+	Project  string
+	Service  string
+	Tag      string // Default tag of the configuration
+	GitSHA   string // Abbreviated commit of the working directory
+	GitTag   string // `git describe --tags --always` of the working directory
+	Date     string // Build date as YYYYMMDD
+	Registry string
+}
+
+// ParseTagTemplate parses an image tag template, an empty template selects DefaultTagTemplate.
+func ParseTagTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTagTemplate
+	}
+	tmpl, err := template.New("tag").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid tag template")
+	}
+	return tmpl, nil
+}
+
+// RenderImageName executes the tag template and checks that the result is a valid image reference.
+func RenderImageName(tmpl *template.Template, data TagData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "failed to render image name of service %s", data.Service)
+	}
+	name := strings.TrimSpace(buf.String())
+	if _, err := reference.ParseNormalizedNamed(name); err != nil {
+		return "", errors.Wrapf(err, "invalid image name %q rendered for service %s", name, data.Service)
+	}
+	return name, nil
+}
+
+// tagData collects the template fields shared by all services. Git is only
+// queried when the template refers to it.
+func (c *Client) tagData(ctx context.Context) (TagData, error) {
+	data := TagData{
+		Project:  c.Project.Name,
+		Tag:      c.Config.Tag,
+		Date:     time.Now().UTC().Format("20060102"),
+		Registry: c.Config.Registry,
+	}
+	if !strings.Contains(c.Config.TagTemplate, ".Git") {
+		return data, nil
+	}
+
+	dir := c.Config.WorkDir
+	if dir == "" {
+		dir = c.Project.WorkingDir
+	}
+	sha, err := c.Deps.GitOutput(ctx, dir, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return data, errors.Wrap(err, "failed to determine git commit for tag template")
+	}
+	tag, err := c.Deps.GitOutput(ctx, dir, "describe", "--tags", "--always")
+	if err != nil {
+		return data, errors.Wrap(err, "failed to determine git tag for tag template")
+	}
+	data.GitSHA = sha
+	data.GitTag = tag
+	return data, nil
+}
+
+// gitOutput runs git with args in dir and returns its trimmed output.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", errors.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", errors.Wrapf(err, "git %s", strings.Join(args, " "))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
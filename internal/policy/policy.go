@@ -0,0 +1,377 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"gopkg.in/yaml.v3"
+)
+
+// ReportFile is the name of the policy report inside the output directory.
+const ReportFile = "policy.json"
+
+const (
+	// RuleNoPrivileged forbids privileged containers.
+	RuleNoPrivileged = "no-privileged"
+	// RuleNoDockerSocket forbids bind mounts of the Docker socket or a directory holding it.
+	RuleNoDockerSocket = "no-docker-socket"
+	// RuleNoHostNetwork forbids the host network mode.
+	RuleNoHostNetwork = "no-host-network"
+	// RuleApprovedRegistries requires images from the registries of the rule.
+	RuleApprovedRegistries = "approved-registries"
+	// RuleRequireHealthcheck requires a healthcheck, from the service or its image.
+	RuleRequireHealthcheck = "require-healthcheck"
+	// RuleNonRootUser forbids running as root, as the service or its image config sets the user.
+	RuleNonRootUser = "non-root-user"
+)
+
+// dockerSockets are the paths of the Docker socket on the host.
+var dockerSockets = []string{"/var/run/docker.sock", "/run/docker.sock"}
+
+// Policy is a set of rules the services of a project must follow to be delivered.
+type Policy struct {
+	Name   string          `yaml:"-"`       // Base name of the file the policy was read from
+	FailOn bundle.Severity `yaml:"fail_on"` // Lowest severity failing the check, see bundle.ParseSeverityThreshold
+	Rules  []Rule          `yaml:"rules"`
+}
+
+// Rule enables one of the rules of the engine.
+type Rule struct {
+	ID         string          `yaml:"rule"`
+	Severity   bundle.Severity `yaml:"severity"`             // Severity of the violations, high when empty
+	Registries []string        `yaml:"registries,omitempty"` // Registries or repository prefixes approved by the rule
+	Except     []string        `yaml:"except,omitempty"`     // Services the rule does not apply to
+}
+
+// Image describes the image config of a service.
+type Image struct {
+	User        string // User the image runs as, empty for root
+	Healthcheck bool   // Whether the image defines a healthcheck
+}
+
+// ReadPolicy reads a YAML policy file.
+func ReadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy")
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid policy %s", file)
+	}
+	policy.Name = filepath.Base(file)
+	return policy, nil
+}
+
+// ParsePolicy decodes and validates a YAML policy. Unknown fields are rejected
+// so that a misspelled setting cannot silently weaken the policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var policy Policy
+	if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "failed to decode policy")
+	}
+	threshold, err := bundle.ParseSeverityThreshold(string(policy.FailOn))
+	if err != nil {
+		return nil, err
+	}
+	policy.FailOn = threshold
+	if len(policy.Rules) == 0 {
+		return nil, errors.New("the policy has no rules")
+	}
+	for i := range policy.Rules {
+		if err = policy.Rules[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
+
+// validate checks the rule and defaults its severity to high.
+func (r *Rule) validate() error {
+	switch r.ID {
+	case RuleNoPrivileged, RuleNoDockerSocket, RuleNoHostNetwork, RuleRequireHealthcheck, RuleNonRootUser:
+		if len(r.Registries) > 0 {
+			return errors.Errorf("rule %s: registries only apply to %s", r.ID, RuleApprovedRegistries)
+		}
+	case RuleApprovedRegistries:
+		if len(r.Registries) == 0 {
+			return errors.Errorf("rule %s needs at least one registry", r.ID)
+		}
+	default:
+		return errors.Errorf("unknown rule %q, expected %s", r.ID, strings.Join([]string{
+			RuleNoPrivileged, RuleNoDockerSocket, RuleNoHostNetwork,
+			RuleApprovedRegistries, RuleRequireHealthcheck, RuleNonRootUser,
+		}, ", "))
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = bundle.SeverityHigh
+		return nil
+	case bundle.SeverityLow, bundle.SeverityMedium, bundle.SeverityHigh, bundle.SeverityCritical:
+		return nil
+	case bundle.SeverityUnknown, bundle.SeverityNone:
+	}
+	return errors.Errorf("rule %s: unsupported severity %q, expected low, medium, high or critical", r.ID, r.Severity)
+}
+
+// NeedsImages reports whether a rule of the policy reads the image config of the services.
+func (p *Policy) NeedsImages() bool {
+	for _, rule := range p.Rules {
+		if rule.readsImage() {
+			return true
+		}
+	}
+	return false
+}
+
+// Check evaluates the rules of the policy against the services of project.
+// images describes the image config of each service by name; rules reading it
+// are violated by services whose image is missing from images.
+func (p *Policy) Check(project *types.Project, images map[string]Image) *Report {
+	violations := p.check(project, nil, false)
+	violations = append(violations, p.check(project, images, true)...)
+	return NewReport(p.Name, p.FailOn, violations)
+}
+
+// CheckServices evaluates the rules of the policy reading only the compose
+// file against the services of project, such as before its images are built
+// and renamed.
+func (p *Policy) CheckServices(project *types.Project) *Report {
+	return NewReport(p.Name, p.FailOn, p.check(project, nil, false))
+}
+
+// CheckImages evaluates the rules of the policy reading the image config of
+// the services, described by images as for Check, against the services of
+// project.
+func (p *Policy) CheckImages(project *types.Project, images map[string]Image) *Report {
+	return NewReport(p.Name, p.FailOn, p.check(project, images, true))
+}
+
+// check returns the violations of the rules reading the image config of the
+// services, or of the other rules.
+func (p *Policy) check(project *types.Project, images map[string]Image, imageRules bool) []Violation {
+	var violations []Violation
+	for _, rule := range p.Rules {
+		if rule.readsImage() != imageRules {
+			continue
+		}
+		for _, name := range project.ServiceNames() {
+			if contains(rule.Except, name) {
+				continue
+			}
+			var img *Image
+			if found, ok := images[name]; ok {
+				img = &found
+			}
+			if message := rule.check(project.Services[name], img); message != "" {
+				violations = append(violations, Violation{
+					Rule: rule.ID, Severity: rule.Severity, Service: name, Message: message,
+				})
+			}
+		}
+	}
+	return violations
+}
+
+// readsImage reports whether the rule reads the image config of the services.
+func (r *Rule) readsImage() bool {
+	return r.ID == RuleRequireHealthcheck || r.ID == RuleNonRootUser
+}
+
+// check returns how service violates the rule, or an empty string when it follows it.
+func (r *Rule) check(service types.ServiceConfig, img *Image) string {
+	switch r.ID {
+	case RuleNoPrivileged:
+		if service.Privileged {
+			return "runs privileged"
+		}
+	case RuleNoDockerSocket:
+		for _, volume := range service.Volumes {
+			if volume.Type == types.VolumeTypeBind && exposesDockerSocket(volume.Source) {
+				return "mounts the Docker socket " + volume.Source
+			}
+		}
+	case RuleNoHostNetwork:
+		if service.NetworkMode == "host" {
+			return "uses the host network"
+		}
+	case RuleApprovedRegistries:
+		if service.Build != nil {
+			// Built images are not pulled from a registry.
+			return ""
+		}
+		return r.checkRegistry(service.Image)
+	case RuleRequireHealthcheck:
+		return checkHealthcheck(service, img)
+	case RuleNonRootUser:
+		return checkUser(service, img)
+	}
+	return ""
+}
+
+// exposesDockerSocket reports whether a bind mount of source exposes the
+// Docker socket: a docker.sock file or a directory holding the socket, such as
+// /var/run or the root of the host.
+func exposesDockerSocket(source string) bool {
+	source = path.Clean(source)
+	if path.Base(source) == "docker.sock" || source == "/" {
+		return true
+	}
+	for _, socket := range dockerSockets {
+		if strings.HasPrefix(socket, source+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRegistry returns a violation unless the image is under an approved registry or repository prefix.
+func (r *Rule) checkRegistry(image string) string {
+	if image == "" {
+		return ""
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "has an invalid image name " + image
+	}
+	for _, registry := range r.Registries {
+		registry = strings.TrimSuffix(registry, "/")
+		if named.Name() == registry || strings.HasPrefix(named.Name(), registry+"/") {
+			return ""
+		}
+	}
+	return "uses image " + image + " from a registry that is not approved"
+}
+
+// checkHealthcheck returns a violation unless the service or its image defines a healthcheck.
+func checkHealthcheck(service types.ServiceConfig, img *Image) string {
+	if check := service.HealthCheck; check != nil {
+		if check.Disable || len(check.Test) > 0 && check.Test[0] == "NONE" {
+			return "disables its healthcheck"
+		}
+		if len(check.Test) > 0 {
+			return ""
+		}
+	}
+	switch {
+	case img == nil:
+		return "has no healthcheck and its image was not inspected"
+	case !img.Healthcheck:
+		return "has no healthcheck"
+	default:
+		return ""
+	}
+}
+
+// checkUser returns a violation when the service runs as root, as its user
+// or, when it sets none, the user of its image says.
+func checkUser(service types.ServiceConfig, img *Image) string {
+	if service.User != "" {
+		if isRoot(service.User) {
+			return "runs as root (user " + service.User + ")"
+		}
+		return ""
+	}
+	switch {
+	case img == nil:
+		return "sets no user and its image was not inspected"
+	case img.User == "":
+		return "runs as root, neither the service nor its image sets a user"
+	case isRoot(img.User):
+		return "runs as root (image user " + img.User + ")"
+	default:
+		return ""
+	}
+}
+
+// isRoot reports whether a user, in the user[:group] form of compose files and image configs, is root.
+func isRoot(user string) bool {
+	name, _, _ := strings.Cut(user, ":")
+	return name == "" || name == "root" || name == "0"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Violation is a service breaking a rule of the policy.
+type Violation struct {
+	Rule     string          `json:"rule"`
+	Severity bundle.Severity `json:"severity"`
+	Service  string          `json:"service"`
+	Message  string          `json:"message"`
+}
+
+// Report lists the violations of a policy check.
+type Report struct {
+	CreatedAt  time.Time               `json:"created_at"`
+	Policy     string                  `json:"policy"`
+	Threshold  bundle.Severity         `json:"threshold"` // Lowest severity failing the check
+	Failed     int                     `json:"failed"`    // Violations at or above the threshold
+	Summary    map[bundle.Severity]int `json:"summary"`   // Violations by severity
+	Violations []Violation             `json:"violations"`
+}
+
+// NewReport reports the violations of policy name, the most severe first.
+func NewReport(name string, threshold bundle.Severity, violations []Violation) *Report {
+	sort.SliceStable(violations, func(i, j int) bool {
+		a, b := violations[i], violations[j]
+		if a.Severity != b.Severity {
+			return !b.Severity.AtLeast(a.Severity)
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Rule < b.Rule
+	})
+	report := &Report{
+		CreatedAt:  time.Now().UTC(),
+		Policy:     name,
+		Threshold:  threshold,
+		Summary:    make(map[bundle.Severity]int),
+		Violations: append([]Violation{}, violations...),
+	}
+	for _, violation := range violations {
+		report.Summary[violation.Severity]++
+		if violation.Severity.AtLeast(threshold) {
+			report.Failed++
+		}
+	}
+	return report
+}
+
+// Failing returns the violations at or above the threshold of the report.
+func (r *Report) Failing() []Violation {
+	var failing []Violation
+	for _, violation := range r.Violations {
+		if violation.Severity.AtLeast(r.Threshold) {
+			failing = append(failing, violation)
+		}
+	}
+	return failing
+}
+
+// Encode writes the report as indented JSON.
+func (r *Report) Encode(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
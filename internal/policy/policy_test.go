@@ -0,0 +1,242 @@
+package policy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/policy"
+)
+
+const testPolicy = `fail_on: high
+rules:
+  - rule: no-privileged
+    severity: critical
+  - rule: no-docker-socket
+    severity: critical
+    except: [agent]
+  - rule: no-host-network
+  - rule: approved-registries
+    severity: high
+    registries: [registry.site.local:5000, docker.io/library]
+  - rule: require-healthcheck
+    severity: medium
+  - rule: non-root-user
+`
+
+// testProject returns a project whose services break some rules of testPolicy.
+func testProject() *types.Project {
+	return &types.Project{
+		Name: "test",
+		Services: types.Services{
+			"web": {
+				Name:        "web",
+				Image:       "registry.site.local:5000/web:v1",
+				User:        "app",
+				HealthCheck: &types.HealthCheckConfig{Test: types.HealthCheckTest{"CMD", "true"}},
+			},
+			"db": {Name: "db", Image: "postgres:16", Privileged: true, NetworkMode: "host"},
+			"agent": {
+				Name:  "agent",
+				Image: "ghcr.io/org/agent:2",
+				User:  "0:0",
+				Volumes: []types.ServiceVolumeConfig{
+					{Type: types.VolumeTypeBind, Source: "/var/run/docker.sock", Target: "/var/run/docker.sock"},
+				},
+			},
+			"proxy": {
+				Name:  "proxy",
+				Image: "nginx:1.27",
+				Volumes: []types.ServiceVolumeConfig{
+					{Type: types.VolumeTypeBind, Source: "/run/docker.sock", Target: "/tmp/docker.sock"},
+				},
+			},
+		},
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := policy.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	assert.Equal(t, bundle.SeverityHigh, p.FailOn)
+	require.Len(t, p.Rules, 6)
+	assert.Equal(t, bundle.SeverityHigh, p.Rules[2].Severity)
+	assert.True(t, p.NeedsImages())
+
+	p, err = policy.ParsePolicy([]byte("rules:\n  - rule: no-privileged\n"))
+	require.NoError(t, err)
+	assert.Equal(t, bundle.SeverityHigh, p.FailOn)
+	assert.False(t, p.NeedsImages())
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		errMsg string
+	}{
+		{name: "empty", policy: "", errMsg: "has no rules"},
+		{name: "unknown rule", policy: "rules:\n  - rule: no-root\n", errMsg: `unknown rule "no-root"`},
+		{name: "unknown field", policy: "rules:\n  - rule: no-privileged\n    severty: low\n", errMsg: "not found"},
+		{
+			name:   "unknown severity",
+			policy: "rules:\n  - rule: no-privileged\n    severity: severe\n",
+			errMsg: "unsupported severity",
+		},
+		{name: "unknown threshold", policy: "fail_on: severe\nrules:\n  - rule: no-privileged\n", errMsg: "threshold"},
+		{name: "no registries", policy: "rules:\n  - rule: approved-registries\n", errMsg: "at least one registry"},
+		{
+			name:   "misplaced registries",
+			policy: "rules:\n  - rule: no-privileged\n    registries: [ghcr.io]\n",
+			errMsg: "registries only apply to approved-registries",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := policy.ParsePolicy([]byte(tt.policy))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testPolicy), 0o600))
+
+	p, err := policy.ReadPolicy(path)
+	require.NoError(t, err)
+	assert.Equal(t, "policy.yaml", p.Name)
+
+	_, err = policy.ReadPolicy(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.ErrorContains(t, err, "failed to read policy")
+}
+
+func TestPolicy_Check(t *testing.T) {
+	p, err := policy.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	images := map[string]policy.Image{
+		"web":   {User: "root"},
+		"db":    {User: "postgres", Healthcheck: true},
+		"agent": {Healthcheck: true},
+	}
+
+	report := p.Check(testProject(), images)
+	var found []string
+	for _, violation := range report.Violations {
+		found = append(found, string(violation.Severity)+" "+violation.Service+" "+violation.Rule)
+	}
+	assert.Equal(t, []string{
+		"critical db no-privileged",
+		"critical proxy no-docker-socket",
+		"high agent approved-registries",
+		"high agent non-root-user",
+		"high db no-host-network",
+		"high proxy non-root-user",
+		"medium proxy require-healthcheck",
+	}, found)
+	assert.Equal(t, 6, report.Failed)
+	assert.Len(t, report.Failing(), 6)
+	assert.Equal(t, map[bundle.Severity]int{
+		bundle.SeverityCritical: 2, bundle.SeverityHigh: 4, bundle.SeverityMedium: 1,
+	}, report.Summary)
+	assert.Equal(t, "runs as root (user 0:0)", report.Violations[3].Message)
+	assert.Equal(t, "sets no user and its image was not inspected", report.Violations[5].Message)
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Encode(&buf))
+	var decoded policy.Report
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, bundle.SeverityHigh, decoded.Threshold)
+	assert.Len(t, decoded.Violations, 7)
+}
+
+func TestPolicy_CheckCompliant(t *testing.T) {
+	p, err := policy.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	project := &types.Project{
+		Name: "test",
+		Services: types.Services{
+			"web": {Name: "web", Image: "registry.site.local:5000/team/web:v1"},
+			"db":  {Name: "db", Image: "postgres:16", User: "999"},
+			"app": {Name: "app", Image: "app:dev", Build: &types.BuildConfig{Context: "."}},
+		},
+	}
+	images := map[string]policy.Image{
+		"web": {User: "app:app", Healthcheck: true},
+		"db":  {User: "root", Healthcheck: true},
+		"app": {User: "app", Healthcheck: true},
+	}
+
+	report := p.Check(project, images)
+	assert.Empty(t, report.Violations)
+	assert.Zero(t, report.Failed)
+	assert.NotNil(t, report.Violations)
+}
+
+func TestPolicy_CheckServicesAndImages(t *testing.T) {
+	p, err := policy.ParsePolicy([]byte(testPolicy))
+	require.NoError(t, err)
+	project := testProject()
+
+	var services []string
+	for _, violation := range p.CheckServices(project).Violations {
+		services = append(services, violation.Service+" "+violation.Rule)
+	}
+	assert.Equal(t, []string{
+		"db no-privileged", "proxy no-docker-socket", "agent approved-registries", "db no-host-network",
+	}, services)
+
+	var images []string
+	for _, violation := range p.CheckImages(project, map[string]policy.Image{"web": {User: "root"}}).Violations {
+		images = append(images, violation.Service+" "+violation.Rule)
+	}
+	assert.Equal(t, []string{
+		"agent non-root-user", "db non-root-user", "proxy non-root-user",
+		"agent require-healthcheck", "db require-healthcheck", "proxy require-healthcheck",
+	}, images)
+}
+
+func TestPolicy_DockerSocket(t *testing.T) {
+	p, err := policy.ParsePolicy([]byte("rules:\n  - rule: no-docker-socket\n"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		source  string
+		exposed bool
+	}{
+		{source: "/var/run/docker.sock", exposed: true},
+		{source: "/run/docker.sock", exposed: true},
+		{source: "/home/user/.docker/run/docker.sock", exposed: true},
+		{source: "/var/run", exposed: true},
+		{source: "/var/run/", exposed: true},
+		{source: "/run", exposed: true},
+		{source: "/var", exposed: true},
+		{source: "/", exposed: true},
+		{source: "/var/lib/app", exposed: false},
+		{source: "/srv/run", exposed: false},
+		{source: "/var/run/app", exposed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			project := &types.Project{Name: "test", Services: types.Services{"web": {
+				Name:    "web",
+				Volumes: []types.ServiceVolumeConfig{{Type: types.VolumeTypeBind, Source: tt.source, Target: "/host"}},
+			}}}
+			report := p.CheckServices(project)
+			if !tt.exposed {
+				assert.Empty(t, report.Violations)
+				return
+			}
+			require.Len(t, report.Violations, 1)
+			assert.Equal(t, "mounts the Docker socket "+tt.source, report.Violations[0].Message)
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/config/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Credentials returns the credentials to use for a registry host.
+type Credentials func(host string) (types.AuthConfig, error)
+
+// Client talks to a registry implementing the OCI distribution API, such as registry:2.
+// It answers Basic and Bearer token authentication challenges with Credentials.
+type Client struct {
+	Host        string // Registry host and optional port
+	PlainHTTP   bool   // Talk to the registry over HTTP instead of HTTPS
+	Credentials Credentials
+	HTTPClient  *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // Bearer tokens by repository
+	basic  bool              // Whether the registry asked for Basic authentication
+}
+
+// NewClient creates a registry client for host.
+func NewClient(host string, plainHTTP bool, credentials Credentials) *Client {
+	return &Client{
+		Host:        host,
+		PlainHTTP:   plainHTTP,
+		Credentials: credentials,
+		HTTPClient:  http.DefaultClient,
+		tokens:      make(map[string]string),
+	}
+}
+
+// BlobExists reports whether repository holds the blob dgst.
+func (c *Client) BlobExists(ctx context.Context, repository string, dgst digest.Digest) (bool, error) {
+	resp, err := c.do(ctx, repository, http.MethodHead, c.url(repository, "blobs", dgst.String()), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, responseError(resp, "failed to check blob %s in %s", dgst, repository)
+	}
+}
+
+// UploadBlob uploads the blob desc read from r to repository in a single request.
+func (c *Client) UploadBlob(ctx context.Context, repository string, desc ocispec.Descriptor, r io.Reader) error {
+	location, _, err := c.startUpload(ctx, repository, url.Values{})
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest.String())
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := c.do(ctx, repository, http.MethodPut, location.String(), header, &body{r: r, size: desc.Size})
+	if err != nil {
+		return err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "failed to upload blob %s to %s", desc.Digest, repository)
+	}
+	return nil
+}
+
+// MountBlob asks the registry to link the blob dgst of repository from into
+// repository without uploading it again. It reports false when the registry
+// does not mount the blob, in which case it must be uploaded.
+func (c *Client) MountBlob(ctx context.Context, repository, from string, dgst digest.Digest) (bool, error) {
+	location, mounted, err := c.startUpload(ctx, repository, url.Values{"mount": {dgst.String()}, "from": {from}})
+	if err != nil || mounted {
+		return mounted, err
+	}
+	// Abandon the upload session the registry opened instead.
+	if resp, cancelErr := c.do(ctx, repository, http.MethodDelete, location.String(), nil, nil); cancelErr == nil {
+		drain(resp)
+	}
+	return false, nil
+}
+
+// PutManifest stores a manifest under reference, a tag or digest, of repository
+// and returns the digest of the manifest.
+func (c *Client) PutManifest(
+	ctx context.Context, repository, reference, mediaType string, manifest []byte,
+) (digest.Digest, error) {
+	header := http.Header{"Content-Type": {mediaType}}
+	resp, err := c.do(ctx, repository, http.MethodPut, c.url(repository, "manifests", reference), header,
+		&body{r: bytes.NewReader(manifest), size: int64(len(manifest)), data: manifest})
+	if err != nil {
+		return "", err
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return "", responseError(resp, "failed to push manifest %s:%s", repository, reference)
+	}
+	if dgst, parseErr := digest.Parse(resp.Header.Get("Docker-Content-Digest")); parseErr == nil {
+		return dgst, nil
+	}
+	return digest.FromBytes(manifest), nil
+}
+
+// startUpload opens an upload session, or mounts a blob when query asks for it.
+func (c *Client) startUpload(ctx context.Context, repository string, query url.Values) (*url.URL, bool, error) {
+	target := c.url(repository, "blobs", "uploads/")
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	resp, err := c.do(ctx, repository, http.MethodPost, target, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer drain(resp)
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil, true, nil
+	case http.StatusAccepted:
+		location, parseErr := resp.Request.URL.Parse(resp.Header.Get("Location"))
+		if parseErr != nil {
+			return nil, false, errors.Wrap(parseErr, "invalid upload location")
+		}
+		return location, false, nil
+	default:
+		return nil, false, responseError(resp, "failed to start blob upload to %s", repository)
+	}
+}
+
+func (c *Client) url(repository, kind, reference string) string {
+	scheme := "https"
+	if c.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, c.Host, repository, kind, reference)
+}
+
+// body is a request body of known size. Bodies holding their data can be sent
+// again after an authentication challenge, streamed bodies cannot.
+type body struct {
+	r    io.Reader
+	size int64
+	data []byte
+}
+
+// do sends a request to the registry, answering one authentication challenge.
+func (c *Client) do(
+	ctx context.Context, repository, method, target string, header http.Header, b *body,
+) (*http.Response, error) {
+	send := func(payload *body) (*http.Response, error) {
+		var reader io.Reader
+		if payload != nil && payload.size > 0 {
+			reader = payload.r
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid registry request")
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if payload != nil {
+			req.ContentLength = payload.size
+		}
+		if authErr := c.authorize(req, repository); authErr != nil {
+			return nil, authErr
+		}
+		resp, err := c.HTTPClient.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to reach registry %s", c.Host)
+		}
+		return resp, nil
+	}
+
+	resp, err := send(b)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drain(resp)
+	if authErr := c.authenticate(ctx, repository, challenge); authErr != nil {
+		return nil, authErr
+	}
+	if b != nil {
+		if b.data == nil {
+			return nil, errors.Errorf("registry %s asked for authentication during an upload", c.Host)
+		}
+		b = &body{r: bytes.NewReader(b.data), size: b.size, data: b.data}
+	}
+	return send(b)
+}
+
+// authorize adds the credentials obtained for repository to req.
+func (c *Client) authorize(req *http.Request, repository string) error {
+	c.mu.Lock()
+	token, basic := c.tokens[repository], c.basic
+	c.mu.Unlock()
+	switch {
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case basic:
+		auth, err := c.credentials()
+		if err != nil {
+			return err
+		}
+		if auth.Username != "" {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+	}
+	return nil
+}
+
+// authenticate answers an authentication challenge of the registry for repository.
+func (c *Client) authenticate(ctx context.Context, repository, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		auth, err := c.credentials()
+		if err != nil {
+			return err
+		}
+		if auth.Username == "" {
+			return errors.Errorf("registry %s requires authentication, log in with `docker login %s`", c.Host, c.Host)
+		}
+		c.mu.Lock()
+		c.basic = true
+		c.mu.Unlock()
+		return nil
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, "repository:"+repository+":pull,push")
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.tokens[repository] = token
+		c.mu.Unlock()
+		return nil
+	default:
+		return errors.Errorf("registry %s asked for unsupported authentication %q", c.Host, challenge)
+	}
+}
+
+// fetchToken requests a bearer token for scope from the token service named in a challenge.
+func (c *Client) fetchToken(ctx context.Context, params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", errors.Errorf("registry %s sent an invalid token realm %q", c.Host, params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid token request")
+	}
+	auth, err := c.credentials()
+	if err != nil {
+		return "", err
+	}
+	if auth.Username != "" {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to reach token service of registry %s", c.Host)
+	}
+	defer drain(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "failed to obtain a token for %s", scope)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&token); decodeErr != nil {
+		return "", errors.Wrap(decodeErr, "failed to decode registry token")
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", errors.Errorf("token service of registry %s returned no token", c.Host)
+	}
+	return token.Token, nil
+}
+
+func (c *Client) credentials() (types.AuthConfig, error) {
+	if c.Credentials == nil {
+		return types.AuthConfig{}, nil
+	}
+	auth, err := c.Credentials(c.Host)
+	if err != nil {
+		return types.AuthConfig{}, errors.Wrapf(err, "failed to read credentials of registry %s", c.Host)
+	}
+	return auth, nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry"` into its
+// scheme and parameters.
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			params[key] = value
+		}
+	}
+	return scheme, params
+}
+
+// responseError describes an unexpected registry response, including the
+// error codes of the distribution API when present.
+func responseError(resp *http.Response, format string, args ...interface{}) error {
+	var payload struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	const maxErrorBody = 64 << 10
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	detail := strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	if json.Unmarshal(data, &payload) == nil && len(payload.Errors) > 0 {
+		messages := make([]string, 0, len(payload.Errors))
+		for _, e := range payload.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		detail = strings.Join(messages, "; ")
+	}
+	return errors.Errorf("%s: %s", fmt.Sprintf(format, args...), detail)
+}
+
+// drain reads the rest of a response body so the connection can be reused, and closes it.
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
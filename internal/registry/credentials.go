@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"io"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	// dockerHubAuthKey is the key Docker Hub credentials are stored under in the Docker client configuration.
+	dockerHubAuthKey = "https://index.docker.io/v1/"
+)
+
+// Host returns the host of the registry holding the repositories below prefix,
+// a registry host with an optional path such as "registry.site.local:5000/team".
+func Host(prefix string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(strings.TrimSuffix(prefix, "/") + "/image")
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid registry %q", prefix)
+	}
+	if domain := reference.Domain(named); domain != dockerHubDomain {
+		return domain, nil
+	}
+	return dockerHubRegistry, nil
+}
+
+// DockerCredentials returns the credentials stored for host by `docker login`,
+// including those kept by credential helpers. Unknown hosts have empty credentials.
+func DockerCredentials(host string) (types.AuthConfig, error) {
+	if host == dockerHubDomain || host == dockerHubRegistry {
+		host = dockerHubAuthKey
+	}
+	return config.LoadDefaultConfigFile(io.Discard).GetAuthConfig(host)
+}
@@ -0,0 +1,295 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// PushedImage is an image of the archive tagged in the registry.
+type PushedImage struct {
+	Source string        // Tag of the image in the archive
+	Target string        // Reference the image was pushed as
+	Digest digest.Digest // Digest of the pushed manifest
+}
+
+// Pusher uploads the images of a `docker save` archive to a registry without a
+// Docker daemon. Images are pushed under Prefix, see bundle.PrefixReference.
+type Pusher struct {
+	Client *Client
+	Prefix string // Registry host and optional path, such as "registry.site.local:5000/team"
+	Logger *logrus.Logger
+}
+
+// NewPusher creates a Pusher for prefix.
+func NewPusher(prefix string, plainHTTP bool, credentials Credentials, logger *logrus.Logger) (*Pusher, error) {
+	host, err := Host(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &Pusher{
+		Client: NewClient(host, plainHTTP, credentials),
+		Prefix: prefix,
+		Logger: logger,
+	}, nil
+}
+
+// PushBundle pushes the images of the bundle stored in dir, reading every
+// per-service archive of bundles saved without a combined image archive.
+func (p *Pusher) PushBundle(ctx context.Context, dir string) ([]PushedImage, error) {
+	manifest, err := bundle.ReadManifest(filepath.Join(dir, bundle.ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading bundle")
+	}
+	if manifest.Pushed() {
+		return nil, errors.Errorf("bundle %s has no image archive, its images were pushed to %s",
+			manifest.Name(), manifest.Registry)
+	}
+	if manifest.Encryption != nil {
+		return nil, errors.Errorf("bundle %s is encrypted, decrypt it with 'docker-deliver decrypt' first", manifest.Name())
+	}
+	if len(manifest.ExcludedLayers) > 0 {
+		p.Logger.Infof("Bundle %s is a delta against %s, the registry must already hold the layers left out",
+			manifest.Name(), manifest.Base)
+	}
+	var pushed []PushedImage
+	for _, archive := range manifest.ImageArchives() {
+		images, pushErr := p.Push(ctx, func() (io.ReadCloser, error) {
+			return bundle.OpenArchive(dir, archive)
+		})
+		if pushErr != nil {
+			return nil, pushErr
+		}
+		pushed = append(pushed, images...)
+	}
+	return pushed, nil
+}
+
+// Push pushes every tagged image of the archive returned by open. The archive
+// is read twice: once to index it and once to upload the blobs the registry lacks.
+func (p *Pusher) Push(ctx context.Context, open func() (io.ReadCloser, error)) ([]PushedImage, error) {
+	index, err := indexArchiveFile(open)
+	if err != nil {
+		return nil, err
+	}
+	images, err := p.images(index)
+	if err != nil {
+		return nil, err
+	}
+
+	missing, err := p.missingBlobs(ctx, images, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		if uploadErr := p.uploadBlobs(ctx, open, index, missing); uploadErr != nil {
+			return nil, uploadErr
+		}
+	}
+
+	var pushed []PushedImage
+	for _, img := range images {
+		for _, target := range img.targets {
+			dgst, putErr := p.Client.PutManifest(
+				ctx, target.repository, target.tag, img.Descriptor.MediaType, img.Manifest)
+			if putErr != nil {
+				return nil, putErr
+			}
+			p.Logger.Infof("Pushed %s as %s", target.source, target.ref)
+			pushed = append(pushed, PushedImage{Source: target.source, Target: target.ref, Digest: dgst})
+		}
+	}
+	return pushed, nil
+}
+
+func indexArchiveFile(open func() (io.ReadCloser, error)) (*bundle.ArchiveIndex, error) {
+	reader, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return bundle.IndexArchive(reader)
+}
+
+// pushTarget is a tag an image is pushed as.
+type pushTarget struct {
+	source     string // Tag of the image in the archive
+	ref        string // Full reference in the registry
+	repository string
+	tag        string
+}
+
+// image is an image of the archive with the tags it is pushed as.
+type image struct {
+	*bundle.ArchiveImage
+	targets []pushTarget
+}
+
+// images returns the tagged images of the archive.
+func (p *Pusher) images(index *bundle.ArchiveIndex) ([]image, error) {
+	images := make([]image, 0, len(index.Manifest))
+	for _, entry := range index.Manifest {
+		if len(entry.RepoTags) == 0 {
+			p.Logger.Warnf("Skipping untagged image %s", entry.Config)
+			continue
+		}
+		archiveImage, err := index.Image(entry)
+		if err != nil {
+			return nil, err
+		}
+		img := image{ArchiveImage: archiveImage}
+		for _, tag := range entry.RepoTags {
+			target, prefixErr := p.target(tag)
+			if prefixErr != nil {
+				return nil, prefixErr
+			}
+			img.targets = append(img.targets, target)
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		return nil, errors.New("image archive holds no tagged images")
+	}
+	return images, nil
+}
+
+// target returns the repository and tag of the registry an archive tag is pushed as.
+func (p *Pusher) target(tag string) (pushTarget, error) {
+	ref, err := bundle.PrefixReference(p.Prefix, tag)
+	if err != nil {
+		return pushTarget{}, err
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return pushTarget{}, errors.Wrapf(err, "invalid image name %q", ref)
+	}
+	tagged, _ := reference.TagNameOnly(named).(reference.Tagged)
+	return pushTarget{source: tag, ref: ref, repository: reference.Path(named), tag: tagged.Tag()}, nil
+}
+
+// missingBlobs returns the repositories lacking each blob of the images.
+// Blobs the archive leaves out must already be present in the registry.
+func (p *Pusher) missingBlobs(
+	ctx context.Context, images []image, index *bundle.ArchiveIndex,
+) (map[digest.Digest][]string, error) {
+	missing := make(map[digest.Digest][]string)
+	checked := make(map[string]bool)
+	for _, img := range images {
+		for _, target := range img.targets {
+			for _, desc := range img.Blobs {
+				key := target.repository + "@" + desc.Digest.String()
+				if checked[key] {
+					continue
+				}
+				checked[key] = true
+				exists, err := p.Client.BlobExists(ctx, target.repository, desc.Digest)
+				if err != nil {
+					return nil, err
+				}
+				if exists {
+					continue
+				}
+				if _, inArchive := index.Blobs[desc.Digest]; !inArchive {
+					return nil, errors.Errorf("blob %s of %s is neither in the image archive nor in the registry",
+						desc.Digest, target.source)
+				}
+				missing[desc.Digest] = append(missing[desc.Digest], target.repository)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// uploadBlobs reads the archive again and uploads each missing blob to the
+// repositories lacking it.
+func (p *Pusher) uploadBlobs(
+	ctx context.Context,
+	open func() (io.ReadCloser, error),
+	index *bundle.ArchiveIndex,
+	missing map[digest.Digest][]string,
+) error {
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for len(missing) > 0 {
+		header, nextErr := tr.Next()
+		if errors.Is(nextErr, io.EOF) {
+			break
+		}
+		if nextErr != nil {
+			return errors.Wrap(nextErr, "failed to read image archive")
+		}
+		entry, ok := index.Entries[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		repositories := missing[entry.Descriptor.Digest]
+		if len(repositories) == 0 {
+			continue
+		}
+		delete(missing, entry.Descriptor.Digest)
+		sort.Strings(repositories)
+		if uploadErr := p.uploadBlob(ctx, entry.Descriptor, repositories, tr); uploadErr != nil {
+			return uploadErr
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("image archive changed while pushing")
+	}
+	return nil
+}
+
+// uploadBlob uploads a blob read from r to repositories. A blob needed by
+// several repositories is spooled to a temporary file, uploaded once and
+// mounted into the other repositories, or uploaded again where mounting fails.
+func (p *Pusher) uploadBlob(ctx context.Context, desc ocispec.Descriptor, repositories []string, r io.Reader) error {
+	p.Logger.Debugf("Uploading blob %s (%d bytes) to %v", desc.Digest, desc.Size, repositories)
+	if len(repositories) == 1 {
+		return p.Client.UploadBlob(ctx, repositories[0], desc, r)
+	}
+
+	spool, err := os.CreateTemp("", "docker-deliver-blob-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+	if _, err = io.Copy(spool, r); err != nil {
+		return errors.Wrap(err, "failed to spool blob")
+	}
+
+	for i, repository := range repositories {
+		if i > 0 {
+			mounted, mountErr := p.Client.MountBlob(ctx, repository, repositories[0], desc.Digest)
+			if mountErr != nil {
+				return mountErr
+			}
+			if mounted {
+				continue
+			}
+		}
+		if _, err = spool.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrap(err, "failed to rewind spooled blob")
+		}
+		if err = p.Client.UploadBlob(ctx, repository, desc, spool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
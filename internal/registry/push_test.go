@@ -0,0 +1,227 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/cli/cli/config/types"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/registry"
+)
+
+// legacyArchive returns an archive in the format written by Docker before 25,
+// with the layer of web:v1 stored as layer.tar.
+func legacyArchive(t *testing.T) []byte {
+	t.Helper()
+	b := newArchiveBuilder()
+	b.add(t, "layer1/layer.tar", []byte("layer one"))
+	b.add(t, "cfg.json", []byte(`{"architecture":"amd64","os":"linux"}`))
+	b.addJSON(t, "manifest.json", []bundle.ArchiveManifestEntry{
+		{Config: "cfg.json", RepoTags: []string{"web:v1"}, Layers: []string{"layer1/layer.tar"}},
+	})
+	return b.bytes(t)
+}
+
+// ociArchive returns an archive in the OCI layout written by Docker 25 and later.
+// Both images share their first layer. When skipLayer is set the second layer
+// of web is left out, as in a delta bundle.
+func ociArchive(t *testing.T, skipLayer bool) ([]byte, map[string]ocispec.Descriptor) {
+	t.Helper()
+	b := newArchiveBuilder()
+	manifests := make(map[string]ocispec.Descriptor)
+	var index ocispec.Index
+	var entries []bundle.ArchiveManifestEntry
+
+	_, shared := b.addBlob(t, ocispec.MediaTypeImageLayer, []byte("shared layer"))
+	own := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromString("web layer"), Size: 9}
+	if !skipLayer {
+		_, own = b.addBlob(t, ocispec.MediaTypeImageLayer, []byte("web layer"))
+	}
+	images := map[string][]ocispec.Descriptor{
+		"web:v1":          {shared, own},
+		"ghcr.io/org/api": {shared},
+	}
+	for _, tag := range []string{"web:v1", "ghcr.io/org/api"} {
+		configPath, config := b.addBlob(t, ocispec.MediaTypeImageConfig, []byte(`{"os":"linux","tag":"`+tag+`"}`))
+		manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: images[tag]}
+		manifest.SchemaVersion = 2
+		data, err := json.Marshal(manifest)
+		require.NoError(t, err)
+		_, desc := b.addBlob(t, ocispec.MediaTypeImageManifest, data)
+		manifests[tag] = desc
+		index.Manifests = append(index.Manifests, desc)
+
+		layers := make([]string, 0, len(images[tag]))
+		for _, layer := range images[tag] {
+			layers = append(layers, "blobs/sha256/"+layer.Digest.Encoded())
+		}
+		entries = append(entries, bundle.ArchiveManifestEntry{Config: configPath, RepoTags: []string{tag}, Layers: layers})
+	}
+	b.addJSON(t, "index.json", index)
+	b.addJSON(t, "manifest.json", entries)
+	return b.bytes(t), manifests
+}
+
+func newPusher(t *testing.T, reg *fakeRegistry, prefix string, credentials registry.Credentials) *registry.Pusher {
+	t.Helper()
+	pusher, err := registry.NewPusher(reg.Host()+prefix, true, credentials, logrus.New())
+	require.NoError(t, err)
+	return pusher
+}
+
+func TestPush_LegacyArchive(t *testing.T) {
+	reg := newFakeRegistry(t)
+	pusher := newPusher(t, reg, "/team", nil)
+
+	pushed, err := pusher.Push(context.Background(), opener(legacyArchive(t)))
+	require.NoError(t, err)
+
+	require.Len(t, pushed, 1)
+	assert.Equal(t, "web:v1", pushed[0].Source)
+	assert.Equal(t, reg.Host()+"/team/web:v1", pushed[0].Target)
+
+	data, manifest := reg.manifest(t, "team/web:v1")
+	assert.Equal(t, digest.FromBytes(data), pushed[0].Digest)
+	assert.Equal(t, ocispec.MediaTypeImageManifest, manifest.MediaType)
+	assert.Equal(t, digest.FromString(`{"architecture":"amd64","os":"linux"}`), manifest.Config.Digest)
+	require.Len(t, manifest.Layers, 1)
+	assert.Equal(t, ocispec.MediaTypeImageLayer, manifest.Layers[0].MediaType)
+	assert.Equal(t, digest.FromString("layer one"), manifest.Layers[0].Digest)
+	assert.Equal(t, 2, reg.uploads)
+}
+
+func TestPush_OCIArchiveKeepsManifests(t *testing.T) {
+	reg := newFakeRegistry(t)
+	pusher := newPusher(t, reg, "", nil)
+	archive, manifests := ociArchive(t, false)
+
+	pushed, err := pusher.Push(context.Background(), opener(archive))
+	require.NoError(t, err)
+
+	require.Len(t, pushed, 2)
+	digests := map[string]digest.Digest{}
+	for _, image := range pushed {
+		digests[image.Source] = image.Digest
+	}
+	assert.Equal(t, manifests["web:v1"].Digest, digests["web:v1"])
+	assert.Equal(t, manifests["ghcr.io/org/api"].Digest, digests["ghcr.io/org/api"])
+	reg.manifest(t, "web:v1")
+	reg.manifest(t, "org/api:latest")
+
+	// The shared layer is uploaded once and mounted into the second repository.
+	assert.Equal(t, 4, reg.uploads)
+	assert.Equal(t, 1, reg.mounts)
+}
+
+func TestPush_SkipsBlobsInRegistry(t *testing.T) {
+	reg := newFakeRegistry(t)
+	pusher := newPusher(t, reg, "", nil)
+	archive, _ := ociArchive(t, false)
+
+	_, err := pusher.Push(context.Background(), opener(archive))
+	require.NoError(t, err)
+	uploads := reg.uploads
+
+	pushed, err := pusher.Push(context.Background(), opener(archive))
+	require.NoError(t, err)
+	assert.Len(t, pushed, 2)
+	assert.Equal(t, uploads, reg.uploads)
+}
+
+func TestPush_DeltaArchive(t *testing.T) {
+	reg := newFakeRegistry(t)
+	pusher := newPusher(t, reg, "", nil)
+
+	delta, _ := ociArchive(t, true)
+	_, err := pusher.Push(context.Background(), opener(delta))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "neither in the image archive nor in the registry")
+
+	full, _ := ociArchive(t, false)
+	_, err = pusher.Push(context.Background(), opener(full))
+	require.NoError(t, err)
+	_, err = pusher.Push(context.Background(), opener(delta))
+	require.NoError(t, err)
+}
+
+func TestPush_BearerToken(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.Username, reg.Password = "deliver", "secret"
+
+	_, err := newPusher(t, reg, "", nil).Push(context.Background(), opener(legacyArchive(t)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to obtain a token")
+
+	credentials := func(host string) (types.AuthConfig, error) {
+		assert.Equal(t, reg.Host(), host)
+		return types.AuthConfig{Username: "deliver", Password: "secret"}, nil
+	}
+	_, err = newPusher(t, reg, "", credentials).Push(context.Background(), opener(legacyArchive(t)))
+	require.NoError(t, err)
+	reg.manifest(t, "web:v1")
+}
+
+func TestPush_NoManifest(t *testing.T) {
+	b := newArchiveBuilder()
+	b.add(t, "layer.tar", []byte("layer"))
+
+	_, err := newPusher(t, newFakeRegistry(t), "", nil).Push(context.Background(), opener(b.bytes(t)))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no manifest.json")
+}
+
+func TestPushBundle(t *testing.T) {
+	reg := newFakeRegistry(t)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ImagesFile), legacyArchive(t), 0o600))
+	writeManifest(t, dir, &bundle.Manifest{SchemaVersion: bundle.SchemaVersion, Project: "example", Tag: "v1"})
+
+	pushed, err := newPusher(t, reg, "", nil).PushBundle(context.Background(), dir)
+	require.NoError(t, err)
+	require.Len(t, pushed, 1)
+	assert.Equal(t, reg.Host()+"/web:v1", pushed[0].Target)
+}
+
+func TestPushBundle_PushedBundle(t *testing.T) {
+	dir := t.TempDir()
+	writeManifest(t, dir, &bundle.Manifest{
+		SchemaVersion: bundle.SchemaVersion,
+		Project:       "example",
+		Tag:           "v1",
+		Registry:      "registry.site.local:5000",
+	})
+
+	_, err := newPusher(t, newFakeRegistry(t), "", nil).PushBundle(context.Background(), dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "were pushed to registry.site.local:5000")
+}
+
+func TestHost(t *testing.T) {
+	host, err := registry.Host("registry.site.local:5000/team")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.site.local:5000", host)
+
+	host, err = registry.Host("docker.io/org")
+	require.NoError(t, err)
+	assert.Equal(t, "registry-1.docker.io", host)
+
+	_, err = registry.Host("Not A Registry")
+	require.Error(t, err)
+}
+
+func writeManifest(t *testing.T, dir string, manifest *bundle.Manifest) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, manifest.Encode(file))
+}
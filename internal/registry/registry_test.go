@@ -0,0 +1,210 @@
+package registry_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry is an in-memory stand-in for registry:2 implementing the parts
+// of the OCI distribution API used to push images.
+type fakeRegistry struct {
+	*httptest.Server
+
+	Username string // Credentials required through a bearer token when set
+	Password string
+
+	mu        sync.Mutex
+	blobs     map[string]map[digest.Digest][]byte // By repository
+	manifests map[string][]byte                   // By repository:tag
+	uploads   int
+	mounts    int
+	nextID    int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{
+		blobs:     make(map[string]map[digest.Digest][]byte),
+		manifests: make(map[string][]byte),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// Host returns the host and port of the registry.
+func (r *fakeRegistry) Host() string {
+	u, _ := url.Parse(r.URL)
+	return u.Host
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if r.Username != "" && req.Header.Get("Authorization") != "Bearer t0k3n" {
+		w.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, r.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		repo, id, _ := strings.Cut(path, "/blobs/uploads/")
+		r.serveUpload(w, req, repo, id)
+	case strings.Contains(path, "/blobs/"):
+		repo, dgst, _ := strings.Cut(path, "/blobs/")
+		if _, ok := r.blobs[repo][digest.Digest(dgst)]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case strings.Contains(path, "/manifests/") && req.Method == http.MethodPut:
+		repo, tag, _ := strings.Cut(path, "/manifests/")
+		r.serveManifest(w, req, repo, tag)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	username, password, _ := req.BasicAuth()
+	if username != r.Username || password != r.Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"token": "t0k3n"})
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		mount := digest.Digest(req.URL.Query().Get("mount"))
+		if data, ok := r.blobs[req.URL.Query().Get("from")][mount]; ok && mount != "" {
+			r.store(repo, mount, data)
+			r.mounts++
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		r.nextID++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%d?state=x", repo, r.nextID))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		dgst := digest.Digest(req.URL.Query().Get("digest"))
+		if id == "" || req.URL.Query().Get("state") != "x" || digest.FromBytes(data) != dgst {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`)
+			return
+		}
+		r.store(repo, dgst, data)
+		r.uploads++
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, tag string) {
+	data, _ := io.ReadAll(req.Body)
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		if _, ok := r.blobs[repo][desc.Digest]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"errors":[{"code":"MANIFEST_BLOB_UNKNOWN","message":"blob unknown"}]}`)
+			return
+		}
+	}
+	r.manifests[repo+":"+tag] = data
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *fakeRegistry) store(repo string, dgst digest.Digest, data []byte) {
+	if r.blobs[repo] == nil {
+		r.blobs[repo] = make(map[digest.Digest][]byte)
+	}
+	r.blobs[repo][dgst] = data
+}
+
+// manifest returns the manifest pushed as repository:tag.
+func (r *fakeRegistry) manifest(t *testing.T, ref string) ([]byte, ocispec.Manifest) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.manifests[ref]
+	require.True(t, ok, "manifest %s was not pushed", ref)
+	var manifest ocispec.Manifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	return data, manifest
+}
+
+// archiveBuilder writes a `docker save` style tar stream.
+type archiveBuilder struct {
+	buf bytes.Buffer
+	tw  *tar.Writer
+}
+
+func newArchiveBuilder() *archiveBuilder {
+	b := &archiveBuilder{}
+	b.tw = tar.NewWriter(&b.buf)
+	return b
+}
+
+func (b *archiveBuilder) add(t *testing.T, name string, data []byte) {
+	t.Helper()
+	require.NoError(t, b.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}))
+	_, err := b.tw.Write(data)
+	require.NoError(t, err)
+}
+
+// addBlob stores data under blobs/sha256 and returns its path and descriptor.
+func (b *archiveBuilder) addBlob(t *testing.T, mediaType string, data []byte) (string, ocispec.Descriptor) {
+	t.Helper()
+	dgst := digest.FromBytes(data)
+	path := "blobs/sha256/" + dgst.Encoded()
+	b.add(t, path, data)
+	return path, ocispec.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+func (b *archiveBuilder) addJSON(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	b.add(t, name, data)
+}
+
+func (b *archiveBuilder) bytes(t *testing.T) []byte {
+	t.Helper()
+	require.NoError(t, b.tw.Close())
+	return b.buf.Bytes()
+}
+
+// opener returns an archive opener over data.
+func opener(data []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
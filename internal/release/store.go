@@ -0,0 +1,283 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+const (
+	// DefaultRoot is the default directory holding the release history of the host.
+	DefaultRoot = "/var/lib/docker-deliver"
+
+	releasesDir = "releases"
+	currentFile = "current"
+	releaseFile = "release.json"
+
+	dirPermissions  = 0o755
+	filePermissions = 0o644
+)
+
+// Release is a bundle that was deployed on this host.
+type Release struct {
+	Name       string    `json:"name"`
+	Project    string    `json:"project"`
+	Tag        string    `json:"tag"`
+	DeployedAt time.Time `json:"deployed_at"`
+	Dir        string    `json:"-"` // Directory holding the manifest and compose file of the release
+	Current    bool      `json:"-"` // Whether the release is the one currently deployed
+}
+
+// Store records deployed bundles below Root as <project>/releases/<name>.
+type Store struct {
+	Root string
+	Now  func() time.Time
+}
+
+// NewStore creates a release store rooted at root.
+func NewStore(root string) *Store {
+	return &Store{Root: root, Now: time.Now}
+}
+
+// UserRoot returns the directory holding the release history of a user who
+// cannot write DefaultRoot: $XDG_STATE_HOME/docker-deliver, or
+// ~/.local/state/docker-deliver when XDG_STATE_HOME is not set.
+func UserRoot() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "docker-deliver"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to locate the user release store")
+	}
+	return filepath.Join(home, ".local", "state", "docker-deliver"), nil
+}
+
+// Writable checks that releases can be recorded below the root of the store,
+// creating the root when it does not exist yet.
+func (s *Store) Writable() error {
+	if err := os.MkdirAll(s.Root, dirPermissions); err != nil {
+		return errors.Wrapf(err, "release store %s is not writable", s.Root)
+	}
+	probe, err := os.CreateTemp(s.Root, ".write-check-*")
+	if err != nil {
+		return errors.Wrapf(err, "release store %s is not writable", s.Root)
+	}
+	_ = probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Record copies the manifest and generated compose file of the bundle in
+// bundleDir into the store and marks it as the current release of its project.
+// The release is named after the bundle tag; a different bundle delivered under
+// an already recorded tag gets a numbered suffix.
+func (s *Store) Record(bundleDir string) (*Release, error) {
+	manifestData, err := os.ReadFile(filepath.Join(bundleDir, bundle.ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read bundle manifest")
+	}
+	manifest, err := bundle.DecodeManifest(bytes.NewReader(manifestData))
+	if err != nil {
+		return nil, err
+	}
+	if err = validateName(manifest.Project); err != nil {
+		return nil, errors.Wrap(err, "invalid project name")
+	}
+	if err = validateName(manifest.Tag); err != nil {
+		return nil, errors.Wrap(err, "invalid tag")
+	}
+
+	name := s.releaseName(manifest.Project, manifest.Tag, manifestData)
+	rel := &Release{
+		Name:       name,
+		Project:    manifest.Project,
+		Tag:        manifest.Tag,
+		DeployedAt: s.Now().UTC(),
+		Dir:        filepath.Join(s.Root, manifest.Project, releasesDir, name),
+		Current:    true,
+	}
+	if err = os.MkdirAll(rel.Dir, dirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create release directory")
+	}
+	if err = os.WriteFile(filepath.Join(rel.Dir, bundle.ManifestFile), manifestData, filePermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to record manifest")
+	}
+	if err = copyFile(filepath.Join(bundleDir, bundle.ComposeFile), filepath.Join(rel.Dir, bundle.ComposeFile)); err != nil {
+		return nil, errors.Wrap(err, "failed to record compose file")
+	}
+	data, err := json.MarshalIndent(rel, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode release")
+	}
+	if err = os.WriteFile(filepath.Join(rel.Dir, releaseFile), data, filePermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to record release")
+	}
+	if err = s.SetCurrent(rel.Project, rel.Name); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// releaseName returns the tag if it is unused or holds the same manifest,
+// otherwise the first free "<tag>-<n>".
+func (s *Store) releaseName(project, tag string, manifestData []byte) string {
+	name := tag
+	for n := 2; ; n++ {
+		existing, err := os.ReadFile(filepath.Join(s.Root, project, releasesDir, name, bundle.ManifestFile))
+		if err != nil || bytes.Equal(existing, manifestData) {
+			return name
+		}
+		name = tag + "-" + strconv.Itoa(n)
+	}
+}
+
+// Projects returns the sorted names of the projects with recorded releases.
+func (s *Store) Projects() ([]string, error) {
+	entries, err := os.ReadDir(s.Root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read release store")
+	}
+	var projects []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			projects = append(projects, entry.Name())
+		}
+	}
+	return projects, nil
+}
+
+// List returns the releases of project, oldest deployment first.
+func (s *Store) List(project string) ([]Release, error) {
+	dir := filepath.Join(s.Root, project, releasesDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("no releases recorded for project %q", project)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read releases")
+	}
+	current, err := s.Current(project)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := make([]Release, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		rel, readErr := readRelease(filepath.Join(dir, entry.Name()))
+		if readErr != nil {
+			return nil, readErr
+		}
+		rel.Current = rel.Name == current
+		releases = append(releases, *rel)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].DeployedAt.Before(releases[j].DeployedAt)
+	})
+	return releases, nil
+}
+
+// Get returns the release name of project.
+func (s *Store) Get(project, name string) (*Release, error) {
+	releases, err := s.List(project)
+	if err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		if releases[i].Name == name {
+			return &releases[i], nil
+		}
+	}
+	return nil, errors.Errorf("release %q of project %q not found", name, project)
+}
+
+// Previous returns the release deployed before the current release of project.
+func (s *Store) Previous(project string) (*Release, error) {
+	releases, err := s.List(project)
+	if err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		if releases[i].Current {
+			if i == 0 {
+				return nil, errors.Errorf("release %q is the oldest release of project %q", releases[i].Name, project)
+			}
+			return &releases[i-1], nil
+		}
+	}
+	return nil, errors.Errorf("no current release recorded for project %q", project)
+}
+
+// Current returns the name of the current release of project, or an empty string if none is marked.
+func (s *Store) Current(project string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.Root, project, currentFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read current release")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetCurrent marks release name as the current release of project.
+func (s *Store) SetCurrent(project, name string) error {
+	path := filepath.Join(s.Root, project, currentFile)
+	if err := os.WriteFile(path, []byte(name+"\n"), filePermissions); err != nil {
+		return errors.Wrap(err, "failed to update current release")
+	}
+	return nil
+}
+
+func readRelease(dir string) (*Release, error) {
+	data, err := os.ReadFile(filepath.Join(dir, releaseFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read release")
+	}
+	var rel Release
+	if err = json.Unmarshal(data, &rel); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode release %s", dir)
+	}
+	rel.Dir = dir
+	return &rel, nil
+}
+
+// validateName rejects names that cannot be used as a single path element.
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return errors.Errorf("%q cannot be used as a release directory name", name)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,200 @@
+package release_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/release"
+)
+
+// writeBundle writes a bundle directory holding a manifest and compose file.
+func writeBundle(t *testing.T, project, tag, compose string) string {
+	t.Helper()
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	defer file.Close()
+	manifest := &bundle.Manifest{SchemaVersion: bundle.SchemaVersion, Project: project, Tag: tag}
+	require.NoError(t, manifest.Encode(file))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, bundle.ComposeFile), []byte(compose), 0o600))
+	return dir
+}
+
+// newTestStore returns a store whose clock advances one minute per call.
+func newTestStore(t *testing.T) *release.Store {
+	t.Helper()
+	store := release.NewStore(t.TempDir())
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.Now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return store
+}
+
+func TestRecord_CopiesBundleFiles(t *testing.T) {
+	store := newTestStore(t)
+
+	rel, err := store.Record(writeBundle(t, "example", "v1", "services: {}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", rel.Name)
+	assert.Equal(t, filepath.Join(store.Root, "example", "releases", "v1"), rel.Dir)
+
+	data, err := os.ReadFile(filepath.Join(rel.Dir, bundle.ComposeFile))
+	require.NoError(t, err)
+	assert.Equal(t, "services: {}\n", string(data))
+	manifest, err := bundle.ReadManifest(filepath.Join(rel.Dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", manifest.Tag)
+
+	current, err := store.Current("example")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", current)
+}
+
+func TestRecord_SameTagDifferentBundle(t *testing.T) {
+	store := newTestStore(t)
+	first := writeBundle(t, "example", "latest", "a")
+
+	rel, err := store.Record(first)
+	require.NoError(t, err)
+	assert.Equal(t, "latest", rel.Name)
+
+	// Redeploying the same bundle reuses its release.
+	rel, err = store.Record(first)
+	require.NoError(t, err)
+	assert.Equal(t, "latest", rel.Name)
+
+	second := writeBundle(t, "example", "latest", "b")
+	require.NoError(t, os.WriteFile(filepath.Join(second, bundle.ManifestFile),
+		[]byte(`{"schema_version":1,"project":"example","tag":"latest","compose_files":["other.yml"]}`), 0o600))
+	rel, err = store.Record(second)
+	require.NoError(t, err)
+	assert.Equal(t, "latest-2", rel.Name)
+}
+
+func TestRecord_InvalidTag(t *testing.T) {
+	store := newTestStore(t)
+
+	_, err := store.Record(writeBundle(t, "example", "..", ""))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid tag")
+}
+
+func TestRecord_MissingManifest(t *testing.T) {
+	store := newTestStore(t)
+
+	_, err := store.Record(t.TempDir())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read bundle manifest")
+}
+
+func TestList_OrderAndCurrent(t *testing.T) {
+	store := newTestStore(t)
+	for _, tag := range []string{"v2", "v1", "v3"} {
+		_, err := store.Record(writeBundle(t, "example", tag, tag))
+		require.NoError(t, err)
+	}
+
+	releases, err := store.List("example")
+	require.NoError(t, err)
+	require.Len(t, releases, 3)
+	assert.Equal(t, "v2", releases[0].Name)
+	assert.Equal(t, "v1", releases[1].Name)
+	assert.Equal(t, "v3", releases[2].Name)
+	assert.False(t, releases[1].Current)
+	assert.True(t, releases[2].Current)
+}
+
+func TestList_UnknownProject(t *testing.T) {
+	store := newTestStore(t)
+
+	_, err := store.List("missing")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `no releases recorded for project "missing"`)
+}
+
+func TestPrevious(t *testing.T) {
+	store := newTestStore(t)
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		_, err := store.Record(writeBundle(t, "example", tag, tag))
+		require.NoError(t, err)
+	}
+
+	rel, err := store.Previous("example")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", rel.Name)
+
+	// Rolling back repeatedly walks further back in history.
+	require.NoError(t, store.SetCurrent("example", "v2"))
+	rel, err = store.Previous("example")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", rel.Name)
+
+	require.NoError(t, store.SetCurrent("example", "v1"))
+	_, err = store.Previous("example")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "oldest release")
+}
+
+func TestGet(t *testing.T) {
+	store := newTestStore(t)
+	_, err := store.Record(writeBundle(t, "example", "v1", ""))
+	require.NoError(t, err)
+
+	rel, err := store.Get("example", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", rel.Tag)
+
+	_, err = store.Get("example", "v9")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestProjects(t *testing.T) {
+	store := release.NewStore(filepath.Join(t.TempDir(), "missing"))
+	projects, err := store.Projects()
+	require.NoError(t, err)
+	assert.Empty(t, projects)
+
+	store = newTestStore(t)
+	for _, project := range []string{"web", "api"} {
+		_, err = store.Record(writeBundle(t, project, "v1", ""))
+		require.NoError(t, err)
+	}
+	projects, err = store.Projects()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api", "web"}, projects)
+}
+
+func TestWritable(t *testing.T) {
+	store := release.NewStore(filepath.Join(t.TempDir(), "state"))
+	require.NoError(t, store.Writable())
+	entries, err := os.ReadDir(store.Root)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	err = release.NewStore(filepath.Join(file, "state")).Writable()
+	assert.ErrorContains(t, err, "is not writable")
+}
+
+func TestUserRoot(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/home/ops/.state")
+	root, err := release.UserRoot()
+	require.NoError(t, err)
+	assert.Equal(t, "/home/ops/.state/docker-deliver", root)
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/ops")
+	root, err = release.UserRoot()
+	require.NoError(t, err)
+	assert.Equal(t, "/home/ops/.local/state/docker-deliver", root)
+}
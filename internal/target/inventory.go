@@ -0,0 +1,53 @@
+package target
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/pkg/errors"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+)
+
+// Inventory lists the images present in the local Docker daemon together with
+// their layer diff IDs, so that bundles for this host can leave those layers out.
+func Inventory(ctx context.Context, deps *Dependencies) (*bundle.Inventory, error) {
+	cli, err := deps.NewDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	summaries, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list images")
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine host name")
+	}
+	inventory := &bundle.Inventory{
+		SchemaVersion: bundle.InventorySchemaVersion,
+		Host:          host,
+		CreatedAt:     time.Now().UTC(),
+		Images:        make([]bundle.InventoryImage, 0, len(summaries)),
+	}
+	for _, summary := range summaries {
+		inspect, inspectErr := cli.ImageInspect(ctx, summary.ID)
+		if inspectErr != nil {
+			return nil, errors.Wrapf(inspectErr, "failed to inspect image %s", summary.ID)
+		}
+		inventory.Images = append(inventory.Images, bundle.InventoryImage{
+			ID:       inspect.ID,
+			RepoTags: inspect.RepoTags,
+			Layers:   inspect.RootFS.Layers,
+		})
+	}
+	sort.Slice(inventory.Images, func(i, j int) bool {
+		return inventory.Images[i].ID < inventory.Images[j].ID
+	})
+	return inventory, nil
+}
@@ -0,0 +1,416 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/docker/compose/v2/cmd/formatter"
+	"github.com/docker/compose/v2/pkg/api"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/compose"
+)
+
+// Config holds configuration for the target Client.
+type Config struct {
+	BundleDir   string        `json:"bundle_dir"`
+	LogLevel    string        `json:"loglevel"`               // Log level: "debug", "info", "warn", "error"
+	Detach      bool          `json:"detach,omitempty"`       // Run containers in the background
+	Wait        bool          `json:"wait,omitempty"`         // Wait for services to be running and healthy, implies Detach
+	WaitTimeout time.Duration `json:"wait_timeout,omitempty"` // Maximum time to wait, 0 waits forever
+}
+
+// Interface defines the actions run on the destination host.
+type Interface interface {
+	Load(ctx context.Context) (*LoadResult, error)
+	MissingImages(ctx context.Context) ([]string, error)
+	Up(ctx context.Context) error
+}
+
+// Dependencies holds all external dependencies for the target Client.
+// They are shared with the compose client that produced the bundle.
+type Dependencies = compose.Dependencies
+
+// DefaultDependencies returns the default production dependencies.
+func DefaultDependencies() *Dependencies {
+	return compose.DefaultDependencies()
+}
+
+// Client installs a delivered bundle on the destination host.
+type Client struct {
+	Interface
+
+	Config   Config
+	Manifest *bundle.Manifest
+	Keys     *bundle.Keys // Identities or passphrase decrypting an encrypted bundle
+	Out      io.Writer    // Receives progress output, defaults to os.Stdout
+	Logger   *logrus.Logger
+	Deps     *Dependencies
+}
+
+// LoadResult reports which images of a bundle were loaded.
+type LoadResult struct {
+	Loaded  []string // Images imported from the archive, or pulled from the registry the bundle was pushed to
+	Present []string // Images that were already present with the expected ID
+}
+
+// Write prints one line per image to w.
+func (r *LoadResult) Write(w io.Writer) {
+	for _, image := range r.Loaded {
+		fmt.Fprintf(w, "Loaded   %s\n", image)
+	}
+	for _, image := range r.Present {
+		fmt.Fprintf(w, "Present  %s\n", image)
+	}
+}
+
+// NewClient creates a target Client for the bundle in config.BundleDir.
+func NewClient(ctx context.Context, config Config) (*Client, error) {
+	return NewClientWithDeps(ctx, config, DefaultDependencies())
+}
+
+// NewClientWithDeps creates a target Client with custom dependencies for testing.
+func NewClientWithDeps(_ context.Context, config Config, deps *Dependencies) (*Client, error) {
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := bundle.ReadManifest(filepath.Join(config.BundleDir, bundle.ManifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading bundle")
+	}
+
+	c := &Client{
+		Config:   config,
+		Manifest: manifest,
+		Out:      os.Stdout,
+		Logger:   logrus.New(),
+		Deps:     deps,
+	}
+	c.Logger.SetLevel(level)
+	return c, nil
+}
+
+// Load imports the images of the bundle into the local Docker daemon and
+// checks that every image resolves to the ID recorded when the bundle was saved.
+// The archive is not read when every image is already present. The images of a
+// bundle pushed to a registry are pulled from it instead.
+func (c *Client) Load(ctx context.Context) (*LoadResult, error) {
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	result := &LoadResult{}
+	for _, image := range c.images() {
+		if c.imageID(ctx, cli, image) == c.expectedID(image) {
+			result.Present = append(result.Present, image)
+		} else {
+			result.Loaded = append(result.Loaded, image)
+		}
+	}
+	if len(result.Loaded) == 0 {
+		c.Logger.Info("All images are already present, skipping image archive")
+		return result, nil
+	}
+
+	if c.Manifest.Pushed() {
+		if pullErr := c.pullImages(ctx, cli, result.Loaded); pullErr != nil {
+			return nil, pullErr
+		}
+	} else if loadErr := c.loadArchives(ctx, cli, result.Loaded); loadErr != nil {
+		if len(c.Manifest.ExcludedLayers) > 0 {
+			return nil, errors.Wrapf(loadErr, "delta bundle requires the layers of %s to be present", c.Manifest.Base)
+		}
+		return nil, loadErr
+	}
+
+	var mismatched []string
+	for _, image := range result.Loaded {
+		if actual, expected := c.imageID(ctx, cli, image), c.expectedID(image); actual != expected {
+			mismatched = append(mismatched, fmt.Sprintf("%s is %q, expected %q", image, actual, expected))
+		}
+	}
+	if len(mismatched) > 0 {
+		return nil, errors.Errorf("loaded images do not match the bundle manifest: %s",
+			strings.Join(mismatched, "; "))
+	}
+	return result, nil
+}
+
+// MissingImages returns the images of the bundle that are not present in the
+// local Docker daemon with the ID recorded when the bundle was saved.
+func (c *Client) MissingImages(ctx context.Context) ([]string, error) {
+	cli, err := c.Deps.NewDockerClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating Docker client")
+	}
+	defer cli.Close()
+
+	var missing []string
+	for _, image := range c.images() {
+		if c.imageID(ctx, cli, image) != c.expectedID(image) {
+			missing = append(missing, image)
+		}
+	}
+	return missing, nil
+}
+
+// loadArchives loads the image archive of the bundle or, for bundles holding
+// per-service archives only, the archives of the services using images.
+func (c *Client) loadArchives(ctx context.Context, cli *client.Client, images []string) error {
+	if !c.Manifest.PerServiceOnly() {
+		return c.loadArchive(ctx, cli, c.Manifest.ImageArchive())
+	}
+	needed := make(map[string]bool, len(images))
+	for _, image := range images {
+		needed[image] = true
+	}
+	names := make([]string, 0, len(c.Manifest.Services))
+	for name, service := range c.Manifest.Services {
+		if needed[service.Image] && service.Archive != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	loaded := make(map[string]bool, len(names))
+	for _, name := range names {
+		service := c.Manifest.Services[name]
+		if loaded[service.Image] {
+			continue
+		}
+		loaded[service.Image] = true
+		if err := c.loadArchive(ctx, cli, *service.Archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadArchive streams a (possibly compressed, split or encrypted) image archive into the daemon.
+func (c *Client) loadArchive(ctx context.Context, cli *client.Client, archive bundle.Archive) error {
+	reader, err := bundle.OpenArchiveWithKeys(c.Config.BundleDir, archive, c.Keys)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	c.Logger.Infof("Loading images from %s", filepath.Join(c.Config.BundleDir, archive.Name))
+	response, err := cli.ImageLoad(ctx, reader, client.ImageLoadWithQuiet(false))
+	if err != nil {
+		return errors.Wrap(err, "failed to load images")
+	}
+	defer response.Body.Close()
+
+	if !response.JSON {
+		if _, copyErr := io.Copy(c.Out, response.Body); copyErr != nil {
+			return errors.Wrap(copyErr, "failed to read load response")
+		}
+		return nil
+	}
+	fd, isTerminal := term.GetFdInfo(c.Out)
+	if displayErr := jsonmessage.DisplayJSONMessagesStream(response.Body, c.Out, fd, isTerminal, nil); displayErr != nil {
+		return errors.Wrap(displayErr, "failed to load images")
+	}
+	return nil
+}
+
+// pullImages pulls images from the registry the bundle was pushed to.
+func (c *Client) pullImages(ctx context.Context, cli *client.Client, images []string) error {
+	for _, ref := range images {
+		auth, err := compose.RegistryAuth(ref)
+		if err != nil {
+			return err
+		}
+		c.Logger.Infof("Pulling %s", ref)
+		response, err := cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: auth})
+		if err != nil {
+			return errors.Wrapf(err, "failed to pull image %s", ref)
+		}
+		fd, isTerminal := term.GetFdInfo(c.Out)
+		displayErr := jsonmessage.DisplayJSONMessagesStream(response, c.Out, fd, isTerminal, nil)
+		_ = response.Close()
+		if displayErr != nil {
+			return errors.Wrapf(displayErr, "failed to pull image %s", ref)
+		}
+	}
+	return nil
+}
+
+// Up starts the delivered project from the generated compose file of the bundle.
+// When Wait is set it returns an *UnhealthyError naming the services that did not
+// become running and healthy within WaitTimeout.
+func (c *Client) Up(ctx context.Context) error {
+	opts, err := cli.NewProjectOptions(
+		[]string{filepath.Join(c.Config.BundleDir, bundle.ComposeFile)},
+		cli.WithOsEnv,
+		cli.WithWorkingDirectory(c.Config.BundleDir),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to apply OS environment variables")
+	}
+	project, err := c.Deps.ProjectFromOptions(ctx, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to read bundle compose file")
+	}
+
+	backend, closer, err := compose.NewBackend(c.Deps)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	services := project.ServiceNames()
+	start := api.StartOptions{
+		Project:     project,
+		Services:    services,
+		Wait:        c.Config.Wait,
+		WaitTimeout: c.Config.WaitTimeout,
+	}
+	if !c.Config.Detach && !c.Config.Wait {
+		start.Attach = formatter.NewLogConsumer(ctx, c.Out, os.Stderr, true, true, false)
+		start.AttachTo = services
+	}
+
+	c.Logger.Infof("Starting project %s", project.Name)
+	upErr := backend.Up(ctx, project, api.UpOptions{
+		Create: api.CreateOptions{
+			Services:             services,
+			RemoveOrphans:        true,
+			Recreate:             api.RecreateDiverged,
+			RecreateDependencies: api.RecreateDiverged,
+			Inherit:              true,
+		},
+		Start: start,
+	})
+	if upErr == nil {
+		return nil
+	}
+
+	containers, psErr := backend.Ps(ctx, project.Name, api.PsOptions{Project: project, All: true})
+	if psErr != nil {
+		c.Logger.Debugf("Failed to list containers of project %s: %v", project.Name, psErr)
+		return errors.Wrap(upErr, "failed to start project")
+	}
+	failed := unhealthyServices(services, containers)
+	if len(failed) == 0 {
+		return errors.Wrap(upErr, "failed to start project")
+	}
+	return &UnhealthyError{Services: failed, Err: upErr}
+}
+
+// ServiceStatus describes a service that did not become running and healthy.
+type ServiceStatus struct {
+	Service  string
+	State    string // Container state, empty if the service has no container
+	Health   string // Container health, empty if the service has no healthcheck
+	ExitCode int
+}
+
+// UnhealthyError is returned by Up when services failed to start or become healthy.
+type UnhealthyError struct {
+	Services []ServiceStatus
+	Err      error
+}
+
+func (e *UnhealthyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d service(s) did not become healthy: %v", len(e.Services), e.Err)
+	for _, s := range e.Services {
+		switch {
+		case s.State == "":
+			fmt.Fprintf(&b, "\n  %s: no container", s.Service)
+		case s.State == "exited" || s.State == "dead":
+			fmt.Fprintf(&b, "\n  %s: %s (exit code %d)", s.Service, s.State, s.ExitCode)
+		case s.Health != "":
+			fmt.Fprintf(&b, "\n  %s: %s, %s", s.Service, s.State, s.Health)
+		default:
+			fmt.Fprintf(&b, "\n  %s: %s", s.Service, s.State)
+		}
+	}
+	return b.String()
+}
+
+func (e *UnhealthyError) Unwrap() error {
+	return e.Err
+}
+
+// unhealthyServices returns the services without a running container or with a
+// container whose healthcheck is not passing, in the order of services.
+func unhealthyServices(services []string, containers []api.ContainerSummary) []ServiceStatus {
+	byService := make(map[string][]api.ContainerSummary, len(services))
+	for _, container := range containers {
+		byService[container.Service] = append(byService[container.Service], container)
+	}
+
+	var failed []ServiceStatus
+	for _, service := range services {
+		if len(byService[service]) == 0 {
+			failed = append(failed, ServiceStatus{Service: service})
+			continue
+		}
+		for _, container := range byService[service] {
+			if container.State == "running" && (container.Health == "" || container.Health == "healthy") {
+				continue
+			}
+			failed = append(failed, ServiceStatus{
+				Service:  service,
+				State:    container.State,
+				Health:   container.Health,
+				ExitCode: container.ExitCode,
+			})
+			break
+		}
+	}
+	return failed
+}
+
+// images returns the sorted, de-duplicated image references of the bundle.
+func (c *Client) images() []string {
+	seen := make(map[string]bool, len(c.Manifest.Services))
+	images := make([]string, 0, len(c.Manifest.Services))
+	for _, service := range c.Manifest.Services {
+		if !seen[service.Image] {
+			seen[service.Image] = true
+			images = append(images, service.Image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// expectedID returns the image ID recorded in the manifest for an image reference.
+func (c *Client) expectedID(image string) string {
+	for _, service := range c.Manifest.Services {
+		if service.Image == image {
+			return service.ImageID
+		}
+	}
+	return ""
+}
+
+// imageID returns the ID the local daemon resolves image to, or an empty string if it is not present.
+func (c *Client) imageID(ctx context.Context, cli *client.Client, image string) string {
+	inspect, err := cli.ImageInspect(ctx, image)
+	if err != nil {
+		c.Logger.Debugf("Image %s is not present: %v", image, err)
+		return ""
+	}
+	return inspect.ID
+}
+
+var _ Interface = (*Client)(nil)
@@ -0,0 +1,156 @@
+package target_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compose-spec/compose-go/v2/cli"
+	"github.com/compose-spec/compose-go/v2/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sunpia/docker-deliver/internal/bundle"
+	"github.com/sunpia/docker-deliver/internal/target"
+)
+
+// writeManifest writes a bundle manifest into a temporary bundle directory.
+func writeManifest(t *testing.T, manifest *bundle.Manifest) string {
+	t.Helper()
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, bundle.ManifestFile))
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, manifest.Encode(file))
+	return dir
+}
+
+func newTestManifest() *bundle.Manifest {
+	return &bundle.Manifest{
+		SchemaVersion: bundle.SchemaVersion,
+		Project:       "example",
+		Tag:           "v1",
+		Services: map[string]bundle.Service{
+			"web": {Image: "web:v1", ImageID: "sha256:1111"},
+			"db":  {Image: "postgres:13", ImageID: "sha256:2222"},
+		},
+	}
+}
+
+func TestNewClient_InvalidLogLevel(t *testing.T) {
+	dir := writeManifest(t, newTestManifest())
+
+	client, err := target.NewClient(context.Background(), target.Config{BundleDir: dir, LogLevel: "invalid"})
+	require.Error(t, err)
+	assert.Nil(t, client)
+}
+
+func TestNewClient_MissingManifest(t *testing.T) {
+	client, err := target.NewClient(context.Background(), target.Config{BundleDir: t.TempDir(), LogLevel: "info"})
+	require.Error(t, err)
+	assert.Nil(t, client)
+	assert.Contains(t, err.Error(), "error reading bundle")
+}
+
+func TestNewClient_ReadsManifest(t *testing.T) {
+	dir := writeManifest(t, newTestManifest())
+
+	client, err := target.NewClient(context.Background(), target.Config{BundleDir: dir, LogLevel: "debug"})
+	require.NoError(t, err)
+	assert.Equal(t, "example", client.Manifest.Project)
+	assert.Len(t, client.Manifest.Services, 2)
+}
+
+func TestLoad_DockerClientError(t *testing.T) {
+	dir := writeManifest(t, newTestManifest())
+	deps := target.DefaultDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
+	}
+
+	c, err := target.NewClientWithDeps(context.Background(), target.Config{BundleDir: dir, LogLevel: "info"}, deps)
+	require.NoError(t, err)
+
+	_, err = c.Load(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker client creation failed")
+}
+
+func TestLoadResult_Write(t *testing.T) {
+	result := &target.LoadResult{
+		Loaded:  []string{"web:v1"},
+		Present: []string{"postgres:13"},
+	}
+
+	var buf bytes.Buffer
+	result.Write(&buf)
+	assert.Equal(t, "Loaded   web:v1\nPresent  postgres:13\n", buf.String())
+}
+
+func TestUp_ComposeFileError(t *testing.T) {
+	dir := writeManifest(t, newTestManifest())
+	deps := target.DefaultDependencies()
+	deps.ProjectFromOptions = func(context.Context, *cli.ProjectOptions) (*types.Project, error) {
+		return nil, errors.New("compose file not found")
+	}
+
+	c, err := target.NewClientWithDeps(context.Background(), target.Config{BundleDir: dir, LogLevel: "info"}, deps)
+	require.NoError(t, err)
+
+	err = c.Up(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read bundle compose file")
+	assert.Contains(t, err.Error(), "compose file not found")
+}
+
+func TestUp_DockerClientError(t *testing.T) {
+	dir := writeManifest(t, newTestManifest())
+	deps := target.DefaultDependencies()
+	deps.ProjectFromOptions = func(_ context.Context, opts *cli.ProjectOptions) (*types.Project, error) {
+		assert.Equal(t, []string{filepath.Join(dir, bundle.ComposeFile)}, opts.ConfigPaths)
+		return &types.Project{Name: "example"}, nil
+	}
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
+	}
+
+	c, err := target.NewClientWithDeps(context.Background(), target.Config{BundleDir: dir, LogLevel: "info"}, deps)
+	require.NoError(t, err)
+
+	err = c.Up(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker client creation failed")
+}
+
+func TestUnhealthyError_Error(t *testing.T) {
+	cause := errors.New("container example-web-1 is unhealthy")
+	err := &target.UnhealthyError{
+		Services: []target.ServiceStatus{
+			{Service: "web", State: "running", Health: "unhealthy"},
+			{Service: "worker", State: "exited", ExitCode: 1},
+			{Service: "cache"},
+		},
+		Err: cause,
+	}
+
+	assert.Equal(t, "3 service(s) did not become healthy: container example-web-1 is unhealthy\n"+
+		"  web: running, unhealthy\n"+
+		"  worker: exited (exit code 1)\n"+
+		"  cache: no container", err.Error())
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestInventory_DockerClientError(t *testing.T) {
+	deps := target.DefaultDependencies()
+	deps.NewDockerClient = func() (*client.Client, error) {
+		return nil, errors.New("docker client creation failed")
+	}
+
+	_, err := target.Inventory(context.Background(), deps)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker client creation failed")
+}
@@ -0,0 +1,19 @@
+package version
+
+import "runtime/debug"
+
+// Version is the docker-deliver release version.
+// It is set at build time with -ldflags "-X github.com/sunpia/docker-deliver/internal/version.Version=<version>".
+var Version = ""
+
+// Get returns the docker-deliver version, falling back to the module version
+// recorded by `go install` and finally to "dev".
+func Get() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}